@@ -0,0 +1,577 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/chat"
+	"github.com/yannh/arpicee/pkg/completion"
+	"github.com/yannh/arpicee/pkg/config"
+	"github.com/yannh/arpicee/pkg/execution"
+	"github.com/yannh/arpicee/pkg/fanout"
+	"github.com/yannh/arpicee/pkg/mcp"
+	"github.com/yannh/arpicee/pkg/registry"
+)
+
+// Completion snapshots and cached RPCs older than this are refreshed in the background
+const snapshotTTL = 1 * time.Hour
+
+func usage(progName string) string {
+	return fmt.Sprintf(`Usage: %s [-config FILE] COMMAND [ARGS]...
+
+Commands:
+  list [WORD]...        list discovered remote procedures, matching all words if any
+  run RPC [OPTION]...   run a remote procedure, use "run RPC -h" for its parameters
+  fanout [OPTION]... RPC [PARAM=VALUE]...
+                        run a remote procedure on several targets, use "fanout -h" for options
+  describe RPC          describe a remote procedure, from the discovery cache if possible
+  refresh               discover remote procedures again, refreshing the cache and completion
+  config validate       check the configuration file and manifests, without discovering remote procedures
+  completion SHELL      output the completion script for bash, zsh or fish
+  mcp                   serve the tools allowed in the mcp configuration over stdio
+`, progName)
+}
+
+// discoveryMode tells how cached RPCs are used
+type discoveryMode int
+
+const (
+	// discoverCached uses the RPCs cached less than the cache TTL ago, and refreshes
+	// those older than snapshotTTL in the background
+	discoverCached discoveryMode = iota
+	// discoverOffline uses cached RPCs of any age, and only discovers the sources
+	// missing from the cache
+	discoverOffline
+	// discoverRefresh discovers all sources
+	discoverRefresh
+)
+
+// defaultCachePath returns the location of the discovery cache in the user cache directory
+func defaultCachePath() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "discovery-cache.json"
+	}
+	return filepath.Join(dir, "arpicee", "discovery.json")
+}
+
+func discover(cfgFile string, mode discoveryMode) ([]arpicee.RemoteCall, error) {
+	c, err := config.Load(cfgFile)
+	if err != nil {
+		return nil, err
+	}
+	return discoverWithConfig(cfgFile, c, mode)
+}
+
+func discoverWithConfig(cfgFile string, c *config.Config, mode discoveryMode) ([]arpicee.RemoteCall, error) {
+	sources, err := c.Sources(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	reg := registry.New()
+	if dc, ttl, err := c.Cache.Open(defaultCachePath()); err != nil {
+		log.Printf("WARN: ignoring the discovery cache: %s", err)
+	} else if dc != nil {
+		if mode == discoverOffline {
+			ttl = 0
+		}
+		reg.SetCache(dc, ttl)
+	}
+	// Failing sources are reported on stderr, the RPCs of the other sources are usable
+	if mode == discoverRefresh {
+		err = reg.SetSources(sources)
+	} else {
+		reg.Restore(sources)
+		err = reg.ReloadMissing()
+	}
+	if err != nil {
+		log.Printf("WARN: failed discovering RPCs: %s", err)
+	}
+	if mode == discoverCached {
+		for _, st := range reg.Status() {
+			if st.Cached && time.Since(*st.LastSuccess) > snapshotTTL {
+				refreshInBackground(cfgFile)
+				break
+			}
+		}
+	}
+
+	// Pipelines and overrides run the RPCs discovered, and each other
+	rpcs := reg.RPCs()
+	lookup := func(name string) arpicee.RemoteCall {
+		for _, rpc := range rpcs {
+			if rpc.Name() == name {
+				return rpc
+			}
+		}
+		return nil
+	}
+	pipelines, err := c.PipelineRPCs(lookup)
+	if err != nil {
+		return nil, err
+	}
+	overrides, err := c.OverrideRPCs(lookup)
+	if err != nil {
+		return nil, err
+	}
+	rpcs = append(rpcs, pipelines...)
+	rpcs = append(rpcs, overrides...)
+
+	// Every discovery refreshes the completion snapshot
+	if p, err := completion.DefaultPath(); err == nil {
+		if err := completion.NewSnapshot(rpcs).Save(p); err != nil {
+			log.Printf("WARN: failed saving completion snapshot: %s", err)
+		}
+	}
+
+	return rpcs, nil
+}
+
+// refreshInBackground starts the discovery of all sources in a separate process,
+// refreshing the discovery cache and the completion snapshot
+func refreshInBackground(cfgFile string) {
+	// The working directory of the process may differ from the one of the shell
+	if abs, err := filepath.Abs(cfgFile); err == nil {
+		cfgFile = abs
+	}
+	cmd := exec.Command(os.Args[0], "-config", cfgFile, "refresh")
+	if cmd.Start() == nil {
+		cmd.Process.Release()
+	}
+}
+
+// list prints the RPCs matching the words of query, see arpicee.Search
+func list(cfgFile string, query []string) error {
+	rpcs, err := discover(cfgFile, discoverCached)
+	if err != nil {
+		return err
+	}
+	rpcs = arpicee.Search(rpcs, strings.Join(query, " "))
+	sort.Slice(rpcs, func(i, j int) bool {
+		return rpcs[i].Name() < rpcs[j].Name()
+	})
+	for _, rpc := range rpcs {
+		fmt.Printf("%s\t%s\n", rpc.Name(), rpc.Description())
+	}
+	return nil
+}
+
+// describe prints the description and parameters of an RPC. Cached RPCs of any age
+// are used, so that it works without network access once the RPCs were discovered.
+func describe(progName, cfgFile string, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("missing RPC name\n%s", usage(progName))
+	}
+	rpcs, err := discover(cfgFile, discoverOffline)
+	if err != nil {
+		return err
+	}
+	var rpc arpicee.RemoteCall
+	for _, r := range rpcs {
+		if r.Name() == args[0] {
+			rpc = r
+		}
+	}
+	if rpc == nil {
+		return fmt.Errorf("no remote procedure named %s", args[0])
+	}
+
+	fmt.Printf("%s (%s)\n%s\n", rpc.Name(), arpicee.Provider(rpc), rpc.Description())
+	m := arpicee.MetadataOf(rpc)
+	if m.Category != "" {
+		fmt.Printf("Category: %s\n", m.Category)
+	}
+	if len(m.Tags) > 0 {
+		fmt.Printf("Tags: %s\n", strings.Join(m.Tags, ", "))
+	}
+	for _, line := range m.Summary() {
+		fmt.Println(line)
+	}
+	if len(rpc.Params()) > 0 {
+		fmt.Printf("\nParameters:\n")
+	}
+	for _, p := range rpc.Params() {
+		var details []string
+		if p.Required {
+			details = append(details, "required")
+		}
+		if p.Default != "" {
+			details = append(details, "default "+p.Default)
+		}
+		if len(p.AllowedValues) > 0 {
+			details = append(details, "one of "+strings.Join(p.AllowedValues, ", "))
+		}
+		line := fmt.Sprintf("  -%s %s", p.Name, p.Type)
+		if len(details) > 0 {
+			line += " (" + strings.Join(details, ", ") + ")"
+		}
+		if p.Description != "" {
+			line += "\n      " + p.Description
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
+
+func run(progName, cfgFile string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing RPC name\n%s", usage(progName))
+	}
+	c, err := config.Load(cfgFile)
+	if err != nil {
+		return err
+	}
+	confirmations, err := c.Confirmations()
+	if err != nil {
+		return err
+	}
+	rpcs, err := discoverWithConfig(cfgFile, c, discoverCached)
+	if err != nil {
+		return err
+	}
+
+	var rpc arpicee.RemoteCall
+	for _, r := range rpcs {
+		if r.Name() == args[0] {
+			rpc = r
+		}
+	}
+	if rpc == nil {
+		return fmt.Errorf("no remote procedure named %s", args[0])
+	}
+
+	flags := append([]string{progName + " run " + rpc.Name()}, args[1:]...)
+	cliArgs, o, err := arpicee.ArgsFromFlagsOrPrompt(rpc, confirmations.Required(rpc), flags)
+	if err != nil {
+		if o == "" {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "%s", o)
+		return nil
+	}
+
+	// Progress, such as the steps of pipelines, is reported on stderr
+	ctx := arpicee.WithProgress(context.Background(), func(msg string) {
+		fmt.Fprintln(os.Stderr, msg)
+	})
+	res, err := arpicee.Run(ctx, rpc, cliArgs)
+	if err != nil {
+		return err
+	}
+	output, err := arpicee.Output(res, arpicee.OutputFormat(cliArgs))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s", output)
+	return nil
+}
+
+// fanoutRun runs an RPC once per target, and prints a summary table
+func fanoutRun(progName, cfgFile string, args []string) error {
+	fset := flag.NewFlagSet(progName+" fanout", flag.ContinueOnError)
+	over := fset.String("over", "", "run once per value of a parameter, for example region=eu-west-1,us-east-1")
+	group := fset.String("targets", "", "run once per target of the named group of targets in the configuration")
+	concurrency := fset.Int("concurrency", fanout.DefaultConcurrency, "maximum number of targets running in parallel")
+	canary := fset.Int("canary", 0, "number of targets to run one at a time first, stopping if one fails")
+	maxFailures := fset.Int("max-failures", 0, "number of failed targets tolerated before skipping the remaining ones")
+	yes := fset.Bool("yes", false, "run without asking for confirmation")
+	fset.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s fanout [OPTION]... RPC [PARAM=VALUE]...\n", progName)
+		fset.PrintDefaults()
+	}
+	if err := fset.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+	if fset.NArg() == 0 {
+		fset.Usage()
+		return fmt.Errorf("missing RPC name")
+	}
+
+	c, err := config.Load(cfgFile)
+	if err != nil {
+		return err
+	}
+	targets, err := fanout.ResolveTargets(c.Targets, *group, *over)
+	if err != nil {
+		return fmt.Errorf("%w, use -over or -targets", err)
+	}
+	values, err := chat.KeyValues(fset.Args()[1:])
+	if err != nil {
+		return err
+	}
+	rpcs, err := discoverWithConfig(cfgFile, c, discoverCached)
+	if err != nil {
+		return err
+	}
+	var rpc arpicee.RemoteCall
+	for _, r := range rpcs {
+		if r.Name() == fset.Arg(0) {
+			rpc = r
+		}
+	}
+	if rpc == nil {
+		return fmt.Errorf("no remote procedure named %s", fset.Arg(0))
+	}
+	confirmations, err := c.Confirmations()
+	if err != nil {
+		return err
+	}
+	if confirmation := confirmations.Required(rpc); confirmation != arpicee.ConfirmNone && !*yes {
+		if err := confirmFanout(rpc, confirmation, values, targets); err != nil {
+			return err
+		}
+	}
+
+	// Targets are reported on stderr as they finish
+	reported := map[string]bool{}
+	summary, err := fanout.Run(context.Background(), rpc, values, targets, fanout.Options{
+		Concurrency: *concurrency,
+		Canary:      *canary,
+		MaxFailures: *maxFailures,
+	}, fanout.RPCRunner(rpc), func(s fanout.Summary) {
+		for _, r := range s.Results {
+			if (r.Status == fanout.StatusSucceeded || r.Status == fanout.StatusFailed) && !reported[r.Target] {
+				reported[r.Target] = true
+				fmt.Fprintf(os.Stderr, "%s: %s\n", r.Target, r.Status)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Print(summary.Table())
+	if summary.Status() != fanout.StatusSucceeded {
+		return fmt.Errorf("%s failed on %d of %d targets", rpc.Name(), len(summary.Results)-summary.Count(fanout.StatusSucceeded), len(summary.Results))
+	}
+	return nil
+}
+
+// confirmFanout asks for the confirmation required before running rpc with values on
+// targets. The values of the targets are not shown.
+func confirmFanout(rpc arpicee.RemoteCall, confirmation arpicee.Confirmation, values map[string]string, targets []fanout.Target) error {
+	if !arpicee.Interactive() {
+		return fmt.Errorf("%s requires confirmation, pass -yes to run it non-interactively", rpc.Name())
+	}
+	var args []arpicee.Argument
+	for _, p := range rpc.Params() {
+		if v, ok := values[p.Name]; ok {
+			// Invalid values are reported by every target
+			if arg, err := arpicee.ParseArgument(p, v); err == nil {
+				args = append(args, arg)
+			}
+		}
+	}
+	var names []string
+	for _, t := range targets {
+		names = append(names, t.Name)
+	}
+
+	p := arpicee.NewTerminalPrompter()
+	fmt.Fprintf(os.Stderr, "Targets: %s\n", strings.Join(names, ", "))
+	ask := p.Confirm
+	if confirmation == arpicee.ConfirmName {
+		ask = p.ConfirmName
+	}
+	ok, err := ask(rpc.Name(), arpicee.MetadataOf(rpc), rpc.Params(), args)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return arpicee.ErrAborted
+	}
+	return nil
+}
+
+// configCommand runs the config subcommands
+func configCommand(cfgFile string, args []string) error {
+	if len(args) != 1 || args[0] != "validate" {
+		return fmt.Errorf("usage: config validate")
+	}
+	c, err := config.Load(cfgFile)
+	if err != nil {
+		return err
+	}
+	if _, err := c.Policy(); err != nil {
+		return fmt.Errorf("%s: %w", cfgFile, err)
+	}
+	if _, err := c.Confirmations(); err != nil {
+		return fmt.Errorf("%s: %w", cfgFile, err)
+	}
+	var errs []string
+	for _, d := range c.Manifests {
+		_, err := config.LoadManifests(d.Dir)
+		if itemErrs, ok := err.(arpicee.DiscoveryErrors); ok {
+			for _, ie := range itemErrs {
+				if _, ok := ie.Err.(*config.ValidationError); ok {
+					errs = append(errs, ie.Err.Error())
+				} else {
+					errs = append(errs, fmt.Sprintf("%s: %s", ie.Item, ie.Err))
+				}
+			}
+		} else if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", d.Dir, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	fmt.Printf("%s is valid\n", cfgFile)
+	return nil
+}
+
+// serveMCP serves the Model Context Protocol over stdin/stdout; logs go to stderr
+func serveMCP(cfgFile string) error {
+	c, err := config.Load(cfgFile)
+	if err != nil {
+		return err
+	}
+	if len(c.MCP.Tools) == 0 {
+		return fmt.Errorf("no tools allowed in the mcp configuration")
+	}
+	pol, err := c.Policy()
+	if err != nil {
+		return err
+	}
+	confirmations, err := c.Confirmations()
+	if err != nil {
+		return err
+	}
+	rpcs, err := discoverWithConfig(cfgFile, c, discoverCached)
+	if err != nil {
+		return err
+	}
+
+	reg := registry.New()
+	reg.AddDiscoveryFunction(func() ([]arpicee.RemoteCall, error) {
+		return rpcs, nil
+	})
+	reg.Reload()
+	executions := execution.NewManager(100)
+	executions.SetAuthorizer(pol)
+
+	s, err := mcp.New(reg, executions, c.MCP.Tools)
+	if err != nil {
+		return err
+	}
+	s.SetConfirmations(confirmations)
+	user := c.MCP.User
+	if user == "" {
+		user = "mcp"
+	}
+	return s.ServeStdio(context.Background(), os.Stdin, os.Stdout, user)
+}
+
+// complete is called by the completion scripts and must be fast: it only reads the
+// snapshot, and refreshes it in the background when it is stale
+func complete(cfgFile string, words []string) {
+	p, err := completion.DefaultPath()
+	if err != nil {
+		return
+	}
+	// Global flags are not completed, but the configuration file typed on the command
+	// line is the one refreshed. The last word is the one being completed.
+	for len(words) > 1 {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(words[0], "-"), "=")
+		if !strings.HasPrefix(words[0], "-") || name != "config" {
+			break
+		}
+		if hasValue {
+			words = words[1:]
+		} else if len(words) > 2 {
+			value, words = words[1], words[2:]
+		} else {
+			break
+		}
+		cfgFile = value
+	}
+	s, err := completion.Load(p)
+	if err != nil || s.Stale(snapshotTTL) {
+		refreshInBackground(cfgFile)
+	}
+	if s == nil {
+		s = &completion.Snapshot{}
+	}
+	for _, c := range s.Complete(words) {
+		fmt.Println(c)
+	}
+}
+
+func realMain() error {
+	progName := path.Base(os.Args[0])
+
+	fset := flag.NewFlagSet(progName, flag.ContinueOnError)
+	defaultCfgFile := "config.json"
+	if f := os.Getenv("ARPICEE_CONFIG"); f != "" {
+		defaultCfgFile = f
+	}
+	cfgFile := fset.String("config", defaultCfgFile, "path to the configuration file, in JSON or YAML")
+	fset.Usage = func() {
+		fmt.Fprint(os.Stderr, usage(progName))
+	}
+	if err := fset.Parse(os.Args[1:]); err != nil {
+		if err == flag.ErrHelp {
+			return nil
+		}
+		return err
+	}
+
+	args := fset.Args()
+	if len(args) == 0 {
+		fset.Usage()
+		return fmt.Errorf("missing command")
+	}
+
+	switch args[0] {
+	case "list":
+		return list(*cfgFile, args[1:])
+	case "run":
+		return run(progName, *cfgFile, args[1:])
+	case "fanout":
+		return fanoutRun(progName, *cfgFile, args[1:])
+	case "refresh":
+		_, err := discover(*cfgFile, discoverRefresh)
+		return err
+	case "describe":
+		return describe(progName, *cfgFile, args[1:])
+	case "config":
+		return configCommand(*cfgFile, args[1:])
+	case "completion":
+		if len(args) < 2 {
+			return fmt.Errorf("missing shell, supported shells: %s", strings.Join(completion.Shells, ", "))
+		}
+		script, err := completion.Script(args[1], progName)
+		if err != nil {
+			return err
+		}
+		fmt.Print(script)
+	case "mcp":
+		return serveMCP(*cfgFile)
+	case "__complete":
+		complete(*cfgFile, args[1:])
+	default:
+		fset.Usage()
+		return fmt.Errorf("unknown command %s", args[0])
+	}
+
+	return nil
+}
+
+func main() {
+	if err := realMain(); err != nil {
+		log.Fatal(err)
+	}
+}
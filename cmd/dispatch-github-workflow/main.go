@@ -42,17 +42,20 @@ func realMain() error {
 
 	r, err := githubrpc.New(ctx, c, owner, repo, workflowName)
 	if err != nil {
-		fmt.Errorf("failed initialising Github Workflow: %s", err.Error())
+		return fmt.Errorf("failed initialising Github Workflow: %s", err.Error())
 	}
 
-	cliArgs, o, err := arpicee.ArgsFromFlags(r.Params(), os.Args)
+	cliArgs, o, err := arpicee.ArgsFromFlagsOrPrompt(r, r.Metadata().Confirmation(), os.Args)
 	if err != nil {
+		if o == "" {
+			return err
+		}
 		fmt.Fprintf(os.Stderr, "%s", o)
 		return nil
 	}
 	workflowOutput, err := r.Run(cliArgs)
 	if err != nil {
-		fmt.Errorf("failed running Github Workflow: %s", err.Error())
+		return fmt.Errorf("failed running Github Workflow: %s", err.Error())
 	}
 
 	output, err := arpicee.Output(workflowOutput, arpicee.OutputFormat(cliArgs))
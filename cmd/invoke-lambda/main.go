@@ -47,8 +47,11 @@ func realMain() error {
 	if err != nil {
 		return err
 	}
-	cliArgs, o, err := arpicee.ArgsFromFlags(l.Params(), os.Args)
+	cliArgs, o, err := arpicee.ArgsFromFlagsOrPrompt(l, l.Metadata().Confirmation(), os.Args)
 	if err != nil {
+		if o == "" {
+			return err
+		}
 		fmt.Fprintf(os.Stderr, "%s", o)
 		return nil
 	}
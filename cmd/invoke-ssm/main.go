@@ -57,7 +57,14 @@ func realMain() error {
 		log.Fatalf("error creating aws session: %s\n", err)
 	}
 
-	cliArgs, _, err := arpicee.ArgsFromFlags(doc.Params(), os.Args)
+	cliArgs, o, err := arpicee.ArgsFromFlagsOrPrompt(doc, doc.Metadata().Confirmation(), os.Args)
+	if err != nil {
+		if o == "" {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "%s", o)
+		return nil
+	}
 	ssmOutput, err := doc.Run(cliArgs)
 	if err != nil {
 		log.Fatalf("error running ssm automation %s", err)
@@ -2,179 +2,423 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
+	"net"
+	"net/http"
 	"os"
 	"strings"
+	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	awsLambda "github.com/aws/aws-sdk-go/service/lambda"
-	awsSSM "github.com/aws/aws-sdk-go/service/ssm"
-	"github.com/google/go-github/v50/github"
 	"github.com/yannh/arpicee/pkg/arpicee"
-	"github.com/yannh/arpicee/pkg/githubrpc"
-	"github.com/yannh/arpicee/pkg/lambdarpc"
+	"github.com/yannh/arpicee/pkg/chat"
+	"github.com/yannh/arpicee/pkg/config"
+	"github.com/yannh/arpicee/pkg/discord"
+	"github.com/yannh/arpicee/pkg/execution"
+	"github.com/yannh/arpicee/pkg/githubbot"
+	"github.com/yannh/arpicee/pkg/grpcapi"
+	"github.com/yannh/arpicee/pkg/httpapi"
+	"github.com/yannh/arpicee/pkg/mattermost"
+	"github.com/yannh/arpicee/pkg/mcp"
+	"github.com/yannh/arpicee/pkg/policy"
+	"github.com/yannh/arpicee/pkg/queue"
+	"github.com/yannh/arpicee/pkg/registry"
+	"github.com/yannh/arpicee/pkg/scheduler"
 	"github.com/yannh/arpicee/pkg/slackbot"
-	"github.com/yannh/arpicee/pkg/ssmrpc"
-	"golang.org/x/oauth2"
+	"github.com/yannh/arpicee/pkg/teams"
+	"github.com/yannh/arpicee/pkg/webhook"
+	"github.com/yannh/arpicee/pkg/webui"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
-type lambdaDiscovery struct {
-	Region    string
-	TagFilter map[string]string
+// slackTokens returns the Slack tokens from the configuration, or the environment
+func slackTokens(c *config.Config) (string, string, error) {
+	appToken, botToken := c.Slack.AppToken, c.Slack.BotToken
+	if appToken == "" {
+		appToken = os.Getenv("SLACK_APP_TOKEN")
+	}
+	if botToken == "" {
+		botToken = os.Getenv("SLACK_BOT_TOKEN")
+	}
+
+	if appToken == "" {
+		return "", "", fmt.Errorf("SLACK_APP_TOKEN must be set.")
+	}
+
+	if !strings.HasPrefix(appToken, "xapp-") {
+		return "", "", fmt.Errorf("SLACK_APP_TOKEN must have the prefix \"xapp-\".")
+	}
+
+	if botToken == "" {
+		return "", "", fmt.Errorf("SLACK_BOT_TOKEN must be set.")
+	}
+
+	if !strings.HasPrefix(botToken, "xoxb-") {
+		return "", "", fmt.Errorf("SLACK_BOT_TOKEN must have the prefix \"xoxb-\".")
+	}
+
+	return appToken, botToken, nil
 }
-type ssmDiscovery struct {
-	Region    string
-	TagFilter map[string]string
+
+// defaultCacheFile is where the discovered RPCs are cached, unless configured otherwise
+const defaultCacheFile = "discovery-cache.json"
+
+// configWatchInterval is how often the configuration file is checked for changes
+const configWatchInterval = 10 * time.Second
+
+// discoverySources returns the discovery sources, pipelines and overrides of c; the
+// steps of the pipelines and the overrides run the RPCs of reg
+func discoverySources(c *config.Config, reg *registry.Registry) ([]registry.Source, error) {
+	sources, err := c.Sources(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	pipelines, err := c.PipelineRPCs(reg.RPC)
+	if err != nil {
+		return nil, err
+	}
+	overrides, err := c.OverrideRPCs(reg.RPC)
+	if err != nil {
+		return nil, err
+	}
+	sources = append(sources, registry.Source{Name: "pipelines", Discover: func() ([]arpicee.RemoteCall, error) {
+		return pipelines, nil
+	}}, registry.Source{Name: "overrides", Discover: func() ([]arpicee.RemoteCall, error) {
+		return overrides, nil
+	}})
+	return sources, nil
 }
-type githubDiscovery struct {
-	Repo     string
-	Workflow string
+
+// confirmable is implemented by the frontends confirming RPCs before running them
+type confirmable interface {
+	SetConfirmations(confirmations *policy.Confirmations)
 }
 
-type config struct {
-	Lambda []lambdaDiscovery
-	Ssm    []ssmDiscovery
-	Github []githubDiscovery
+// reloadConfig applies the discovery sources, pipelines, access rules, confirm rules and
+// targets of c; other settings require a restart
+func reloadConfig(c *config.Config, reg *registry.Registry, executions *execution.Manager, frontends []confirmable, sb *slackbot.Slackbot) error {
+	pol, err := c.Policy()
+	if err != nil {
+		return err
+	}
+	confirmations, err := c.Confirmations()
+	if err != nil {
+		return err
+	}
+	sources, err := discoverySources(c, reg)
+	if err != nil {
+		return err
+	}
+	if err := reg.SetSources(sources); err != nil {
+		log.Printf("WARN: failed discovering RPCs: %s", err)
+	}
+	executions.SetAuthorizer(pol)
+	for _, f := range frontends {
+		f.SetConfirmations(confirmations)
+	}
+	if sb != nil {
+		sb.SetTargets(c.Targets)
+	}
+	return nil
 }
 
 func realMain() error {
-	var appToken, botToken string
-
-	if appToken = os.Getenv("SLACK_APP_TOKEN"); appToken == "" {
-		return fmt.Errorf("SLACK_APP_TOKEN must be set.")
+	defaultCfgFile := "config.json"
+	if f := os.Getenv("ARPICEE_CONFIG"); f != "" {
+		defaultCfgFile = f
 	}
+	cfgFile := flag.String("config", defaultCfgFile, "path to the configuration file, in JSON or YAML")
+	flag.Parse()
 
-	if !strings.HasPrefix(appToken, "xapp-") {
-		return fmt.Errorf("SLACK_APP_TOKEN must have the prefix \"xapp-\".")
+	c, err := config.Load(*cfgFile)
+	if err != nil {
+		return err
 	}
 
-	if botToken = os.Getenv("SLACK_BOT_TOKEN"); botToken == "" {
-		return fmt.Errorf("SLACK_BOT_TOKEN must be set.")
+	// Slack is optional if another frontend is enabled
+	slackConfigured := c.Slack.AppToken != "" || c.Slack.BotToken != "" || os.Getenv("SLACK_APP_TOKEN") != "" || os.Getenv("SLACK_BOT_TOKEN") != ""
+	useSlack := (c.HTTP.Addr == "" && c.GRPC.Addr == "" && len(c.Scheduler.Jobs) == 0) || slackConfigured
+	var appToken, botToken string
+	if useSlack {
+		if appToken, botToken, err = slackTokens(c); err != nil {
+			return err
+		}
 	}
 
-	if !strings.HasPrefix(botToken, "xoxb-") {
-		return fmt.Errorf("SLACK_BOT_TOKEN must have the prefix \"xoxb-\".")
+	reg := registry.New()
+	if dc, ttl, err := c.Cache.Open(defaultCacheFile); err != nil {
+		log.Printf("WARN: ignoring the discovery cache: %s", err)
+	} else if dc != nil {
+		reg.SetCache(dc, ttl)
 	}
-
-	s, err := slackbot.New(appToken, botToken)
+	sources, err := discoverySources(c, reg)
 	if err != nil {
-		return fmt.Errorf("failed initialising Slackbot: %w", err)
+		return err
+	}
+	// Sources found in the cache are available right away, and discovered again in the
+	// background. Failing sources are reported in the status, and discovered again on
+	// reloads.
+	restored := reg.Restore(sources)
+	if err := reg.ReloadMissing(); err != nil {
+		log.Printf("WARN: failed discovering RPCs: %s", err)
+	}
+	if restored > 0 {
+		go func() {
+			if err := reg.Reload(); err != nil {
+				log.Printf("WARN: failed discovering RPCs: %s", err)
+			}
+		}()
 	}
 
-	cfgFileName := "config.json"
-	f, err := os.ReadFile(cfgFileName)
+	pol, err := c.Policy()
 	if err != nil {
-		log.Fatalf("failed reading from config file %s: %s", cfgFileName, err)
+		return err
 	}
-	var c config
-	if err = json.Unmarshal(f, &c); err != nil {
-		log.Fatalf("failed parsing config file %s: %s", cfgFileName, err)
+	executions := execution.NewManager(1000)
+	executions.SetAuthorizer(pol)
+	confirmations, err := c.Confirmations()
+	if err != nil {
+		return err
 	}
+	core := chat.NewCore(reg, executions)
+	core.SetConfirmations(confirmations)
+	frontends := []confirmable{core}
+
+	errs := make(chan error)
 
-	if len(c.Lambda) > 0 {
-		region, found := os.LookupEnv("AWS_REGION")
-		if !found {
-			region = "us-east-1"
-			// warn for environments where lambdas might not be deployed in this region
-			log.Printf("WARN: AWS_REGION env var not found; using %s as default\n", region)
+	// Results of the executions triggered by webhooks and schedules are posted to Slack
+	var poster webhook.ResultPoster
+	var sb *slackbot.Slackbot
+	if useSlack {
+		dir := c.Queue.Dir
+		if dir == "" {
+			dir = "queue"
+		}
+		store, err := queue.NewFileStore(dir)
+		if err != nil {
+			return fmt.Errorf("%w, set queue.dir to a writable directory, on a persistent volume when running in a container", err)
+		}
+		q, err := queue.New(reg, executions, store, c.Queue.Workers)
+		if err != nil {
+			return fmt.Errorf("failed initialising queue: %w", err)
 		}
+		go func() {
+			errs <- q.Run(context.Background())
+		}()
 
-		awsProfile, found := os.LookupEnv("AWS_PROFILE")
-		if !found {
-			log.Println("WARN: AWS_PROFILE env var not found; invoking lambda might not work as expected")
+		if sb, err = slackbot.New(appToken, botToken, core, q); err != nil {
+			return fmt.Errorf("failed initialising Slackbot: %w", err)
 		}
+		sb.SetTargets(c.Targets)
+		poster = sb
+	}
 
-		sess, err := session.NewSessionWithOptions(session.Options{
-			Config: aws.Config{
-				Region: aws.String(region),
-			},
-			SharedConfigState: session.SharedConfigEnable,
-			Profile:           awsProfile,
-		})
+	if len(c.Scheduler.Jobs) > 0 || c.Scheduler.StateFile != "" {
+		var jobs []scheduler.Job
+		for _, j := range c.Scheduler.Jobs {
+			var jitter time.Duration
+			if j.Jitter != "" {
+				if jitter, err = time.ParseDuration(j.Jitter); err != nil {
+					return fmt.Errorf("scheduled job %s: invalid jitter %s", j.Name, j.Jitter)
+				}
+			}
+			jobs = append(jobs, scheduler.Job{
+				Name:         j.Name,
+				Schedule:     j.Schedule,
+				TimeZone:     j.TimeZone,
+				RPC:          j.RPC,
+				Arguments:    j.Arguments,
+				Jitter:       jitter,
+				Overlap:      scheduler.Overlap(j.Overlap),
+				CatchUp:      j.CatchUp,
+				SlackChannel: j.SlackChannel,
+			})
+		}
+		sched, err := scheduler.New(reg, executions, jobs, c.Scheduler.StateFile, poster)
 		if err != nil {
-			log.Fatalf("error creating AWS session: %s\n", err)
+			return fmt.Errorf("failed initialising scheduler: %w", err)
+		}
+		if sb != nil {
+			sb.SetScheduler(sched)
 		}
-		lambdaSvc := awsLambda.New(sess)
-		for _, d := range c.Lambda {
-			var filters []func(configuration *awsLambda.ListTagsOutput) bool
-			for k, v := range d.TagFilter {
-				filters = append(filters, lambdarpc.TagFilter(k, v))
+		go func() {
+			errs <- sched.Run(context.Background())
+		}()
+	}
+
+	if sb != nil {
+		go func() {
+			errs <- sb.Run()
+		}()
+	}
+
+	reg.OnChange(func(d registry.Diff) {
+		log.Printf("RPCs reloaded: %s", d)
+		if c.Reload.SlackChannel != "" && sb != nil {
+			if err := sb.Notify(c.Reload.SlackChannel, "RPCs reloaded: "+d.String()); err != nil {
+				log.Printf("failed posting RPC changes to Slack: %s", err)
 			}
-			s.AddDiscoveryFunction(func() ([]arpicee.RemoteCall, error) {
-				r, err := lambdarpc.Discover(lambdaSvc, filters)
-				var rpcs []arpicee.RemoteCall
-				for _, ra := range r {
-					rpcs = append(rpcs, ra)
-				}
-				return rpcs, err
-			})
 		}
+	})
+	if c.Reload.Interval != "" {
+		// The interval was validated when loading the configuration
+		interval, _ := time.ParseDuration(c.Reload.Interval)
+		go func() {
+			errs <- reg.ReloadEvery(context.Background(), interval)
+		}()
+	}
+
+	chatBots := c.Mattermost.URL != "" || c.Discord.ApplicationID != "" || c.Teams.AppID != "" || c.GithubChatOps.WebhookSecret != ""
+	if chatBots && c.HTTP.Addr == "" {
+		return fmt.Errorf("the Mattermost, Discord, Teams and GitHub bots require the HTTP server to be enabled")
+	}
+	if len(c.Webhooks) > 0 && c.HTTP.Addr == "" {
+		return fmt.Errorf("webhooks require the HTTP server to be enabled")
+	}
+
+	var tokens []httpapi.Token
+	for _, t := range c.HTTP.Tokens {
+		tokens = append(tokens, httpapi.Token{Name: t.Name, Token: t.Token})
+	}
+
+	if c.GRPC.Addr != "" {
+		if len(tokens) == 0 {
+			return fmt.Errorf("the gRPC API requires HTTP API tokens to be configured")
+		}
+		var opts []grpc.ServerOption
+		if c.GRPC.CertFile != "" || c.GRPC.KeyFile != "" {
+			creds, err := credentials.NewServerTLSFromFile(c.GRPC.CertFile, c.GRPC.KeyFile)
+			if err != nil {
+				return fmt.Errorf("failed loading gRPC TLS certificate: %w", err)
+			}
+			opts = append(opts, grpc.Creds(creds))
+		}
+		lis, err := net.Listen("tcp", c.GRPC.Addr)
+		if err != nil {
+			return fmt.Errorf("failed listening on %s: %w", c.GRPC.Addr, err)
+		}
+		gs := grpcapi.New(reg, executions, tokens).NewGRPCServer(opts...)
+		go func() {
+			log.Printf("gRPC server listening on %s", c.GRPC.Addr)
+			errs <- gs.Serve(lis)
+		}()
 	}
 
-	if len(c.Ssm) > 0 {
-		region, found := os.LookupEnv("AWS_REGION")
-		if !found {
-			region = "us-east-1"
-			// warn for environments where lambdas might not be deployed in this region
-			log.Printf("WARN: AWS_REGION env var not found; using %s as default\n", region)
+	if c.HTTP.Addr != "" {
+		mux := http.NewServeMux()
+
+		if len(tokens) > 0 {
+			httpapi.New(reg, executions, tokens).Register(mux)
+
+			if len(c.MCP.Tools) > 0 {
+				m, err := mcp.New(reg, executions, c.MCP.Tools)
+				if err != nil {
+					return err
+				}
+				m.SetConfirmations(confirmations)
+				frontends = append(frontends, m)
+				mux.Handle("/mcp", m.HTTPHandler(tokens))
+			}
 		}
 
-		awsProfile, found := os.LookupEnv("AWS_PROFILE")
-		if !found {
-			log.Println("WARN: AWS_PROFILE env var not found; invoking lambda might not work as expected")
+		if c.HTTP.WebUI.Enabled {
+			ui, err := webui.New(reg, executions, webui.Auth{
+				UserHeader: c.HTTP.WebUI.UserHeader,
+				DevUser:    c.HTTP.WebUI.DevUser,
+			})
+			if err != nil {
+				return fmt.Errorf("failed initialising web UI: %w", err)
+			}
+			ui.SetConfirmations(confirmations)
+			frontends = append(frontends, ui)
+			ui.Register(mux)
 		}
 
-		sess, err := session.NewSessionWithOptions(session.Options{
-			Config: aws.Config{
-				Region: aws.String(region),
-			},
-			SharedConfigState: session.SharedConfigEnable,
-			Profile:           awsProfile,
-		})
-		if err != nil {
-			log.Fatalf("error creating AWS session: %s\n", err)
+		if c.Mattermost.URL != "" {
+			mm := mattermost.NewClient(c.Mattermost.URL, c.Mattermost.BotToken)
+			b, err := mattermost.New(mm, core, c.Mattermost.CommandToken, c.Mattermost.PublicURL)
+			if err != nil {
+				return fmt.Errorf("failed initialising Mattermost bot: %w", err)
+			}
+			b.Register(mux)
 		}
-		ssmSvc := awsSSM.New(sess)
-		for _, ssm := range c.Ssm {
-			var filters []func(configuration *awsSSM.DocumentIdentifier) bool
-			for k, v := range ssm.TagFilter {
-				filters = append(filters, ssmrpc.TagFilter(k, v))
+
+		if c.Discord.ApplicationID != "" {
+			dc := discord.NewClient(discord.DefaultAPIURL, c.Discord.BotToken)
+			b, err := discord.New(dc, core, c.Discord.ApplicationID, c.Discord.GuildID, c.Discord.PublicKey)
+			if err != nil {
+				return fmt.Errorf("failed initialising Discord bot: %w", err)
 			}
-			s.AddDiscoveryFunction(func() ([]arpicee.RemoteCall, error) {
-				r, err := ssmrpc.Discover(ssmSvc, filters)
-				var rpcs []arpicee.RemoteCall
-				for _, ra := range r {
-					rpcs = append(rpcs, ra)
+			// Discord commands are registered again when the RPCs change
+			if err := b.RegisterCommands(); err != nil {
+				log.Printf("failed registering Discord commands: %s", err)
+			}
+			reg.OnChange(func(d registry.Diff) {
+				if err := b.RegisterCommands(); err != nil {
+					log.Printf("failed registering Discord commands: %s", err)
 				}
-				return rpcs, err
 			})
+			b.Register(mux)
+		}
+
+		if c.Teams.AppID != "" {
+			tc := teams.NewClient(teams.TokenURL(c.Teams.TenantID), c.Teams.AppID, c.Teams.AppPassword)
+			v := teams.NewVerifier(teams.DefaultOpenIDMetadataURL, c.Teams.AppID)
+			teams.New(tc, v, core).Register(mux)
 		}
+
+		if c.GithubChatOps.WebhookSecret != "" {
+			b, err := githubbot.New(c.GithubClient(context.Background()), core, c.GithubChatOps.WebhookSecret, c.GithubChatOps.MinPermission, c.GithubChatOps.Reply)
+			if err != nil {
+				return fmt.Errorf("failed initialising GitHub bot: %w", err)
+			}
+			b.Register(mux)
+		}
+
+		if len(c.Webhooks) > 0 {
+			var hooks []webhook.Hook
+			for _, h := range c.Webhooks {
+				hooks = append(hooks, webhook.Hook{
+					Name:            h.Name,
+					RPC:             h.RPC,
+					Secret:          h.Secret,
+					SignatureHeader: h.SignatureHeader,
+					Filter:          h.Filter,
+					Arguments:       h.Arguments,
+					SlackChannel:    h.SlackChannel,
+				})
+			}
+			wh, err := webhook.New(reg, executions, hooks, poster)
+			if err != nil {
+				return fmt.Errorf("failed initialising webhooks: %w", err)
+			}
+			wh.Register(mux)
+		}
+
+		if len(c.HTTP.Tokens) == 0 && !c.HTTP.WebUI.Enabled && !chatBots && len(c.Webhooks) == 0 {
+			return fmt.Errorf("no HTTP frontend enabled: configure API tokens, the web UI, a chat bot, or webhooks")
+		}
+
+		go func() {
+			log.Printf("HTTP server listening on %s", c.HTTP.Addr)
+			errs <- http.ListenAndServe(c.HTTP.Addr, mux)
+		}()
 	}
-	if len(c.Github) > 0 {
-		githubToken := os.Getenv("GITHUB_TOKEN")
-		ctx := context.Background()
-		ts := oauth2.StaticTokenSource(
-			&oauth2.Token{AccessToken: githubToken},
-		)
-		gc := github.NewClient(oauth2.NewClient(ctx, ts))
-		for _, g := range c.Github {
-			s.AddDiscoveryFunction(func() ([]arpicee.RemoteCall, error) {
-				b := strings.Split(g.Repo, "/")
-				owner, repo := b[0], b[1]
-				r, err := githubrpc.Discover(ctx, gc, owner, repo)
-				var rpcs []arpicee.RemoteCall
-				for _, ra := range r {
-					rpcs = append(rpcs, ra)
+
+	// The configuration is watched once all frontends are started
+	if c.Reload.WatchConfig {
+		go func() {
+			errs <- config.Watch(context.Background(), *cfgFile, configWatchInterval, func(nc *config.Config) {
+				if err := reloadConfig(nc, reg, executions, frontends, sb); err != nil {
+					log.Printf("failed reloading the configuration: %s", err)
 				}
-				return rpcs, err
 			})
-		}
+		}()
 	}
 
-	return s.Run()
+	return <-errs
 }
 
 func main() {
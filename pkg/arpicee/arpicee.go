@@ -6,6 +6,7 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"strconv"
 	"strings"
 	"text/template"
 )
@@ -31,6 +32,67 @@ type Parameter struct {
 	Type        ParamType
 	Description string
 	Required    bool
+
+	// Default is the value used when the parameter is not set, as a string
+	Default string
+	// AllowedValues, when not empty, restricts the values the parameter accepts
+	AllowedValues []string
+	// Secret parameters are not echoed back when prompted for
+	Secret bool
+}
+
+func (t ParamType) String() string {
+	switch t {
+	case TypeBool:
+		return "bool"
+	case TypeInt:
+		return "int"
+	default:
+		return "string"
+	}
+}
+
+// ParseParamType parses the name of a type, as returned by String. An empty name is a
+// string.
+func ParseParamType(t string) (ParamType, error) {
+	switch t {
+	case "", "string":
+		return TypeString, nil
+	case "int":
+		return TypeInt, nil
+	case "bool":
+		return TypeBool, nil
+	}
+	return TypeString, fmt.Errorf("invalid type %s, expected string, int or bool", t)
+}
+
+// ParamDeclaration declares a parameter in the configuration, for example of a manifest
+// or a pipeline; Type is string (default), int or bool
+type ParamDeclaration struct {
+	Name          string
+	Type          string
+	Description   string
+	Required      bool
+	Default       string
+	AllowedValues []string
+	Secret        bool
+}
+
+// Parameter returns the parameter declared by d
+func (d ParamDeclaration) Parameter() (Parameter, error) {
+	t, err := ParseParamType(d.Type)
+	if err != nil {
+		return Parameter{}, err
+	}
+	return Parameter{
+		Name:          d.Name,
+		Type:          t,
+		Description:   d.Description,
+		Required:      d.Required,
+		Default:       d.Default,
+		AllowedValues: d.AllowedValues,
+		Secret:        d.Secret,
+	}, nil
 }
 
 type Argument interface {
@@ -73,8 +135,46 @@ func (as *ArgumentInt) name() string {
 	return as.Name
 }
 
+// MissingParameterError is returned when a required parameter was not passed
+type MissingParameterError struct {
+	Name string
+}
+
+func (e *MissingParameterError) Error() string {
+	return fmt.Sprintf("parameter %s is required", e.Name)
+}
+
+func argString(arg Argument) string {
+	switch a := arg.(type) {
+	case *ArgumentString:
+		return a.Val
+	case *ArgumentInt:
+		return strconv.Itoa(a.Val)
+	case *ArgumentBool:
+		return strconv.FormatBool(a.Val)
+	}
+	return ""
+}
+
+func isAllowed(param Parameter, arg Argument) bool {
+	if len(param.AllowedValues) == 0 {
+		return true
+	}
+	v := argString(arg)
+	for _, av := range param.AllowedValues {
+		if av == v {
+			return true
+		}
+	}
+	return false
+}
+
 func ValidateArguments(args []Argument, params []Parameter) error {
 	for _, param := range params {
+		if arg := GetArg(args, param.Name); arg != nil && !isAllowed(param, arg) {
+			return fmt.Errorf("invalid value for parameter %s, allowed values: %s", param.Name, strings.Join(param.AllowedValues, ", "))
+		}
+
 		if !param.Required {
 			continue
 		}
@@ -87,7 +187,7 @@ func ValidateArguments(args []Argument, params []Parameter) error {
 		}
 
 		if requiredArgPassed == false {
-			return fmt.Errorf("parameter %s is required", param.Name)
+			return &MissingParameterError{Name: param.Name}
 		}
 	}
 
@@ -99,7 +199,16 @@ type flagsUsageError struct {
 	usage string
 }
 
+// ArgsFromFlags parses the flags of the parameters params. Parameters that are not
+// passed as flags are set to their default value, if they have one.
 func ArgsFromFlags(params []Parameter, flags []string) ([]Argument, string, error) {
+	return argsFromFlags(params, flags, nil, true)
+}
+
+// argsFromFlags implements ArgsFromFlags. When yes is not nil, the -yes flag is
+// accepted, unless a parameter is named yes, and sets it. Parameters that are not
+// passed as flags are omitted, unless defaults is set.
+func argsFromFlags(params []Parameter, flags []string, yes *bool, defaults bool) ([]Argument, string, error) {
 	if len(flags) == 0 {
 		return nil, "", fmt.Errorf("fatal error: flags array is empty")
 	}
@@ -109,20 +218,39 @@ func ArgsFromFlags(params []Parameter, flags []string) ([]Argument, string, erro
 	fset.SetOutput(&buf)
 
 	cliArgs := map[string]interface{}{}
+	// hasDefault lists the parameters whose flag defaults to the default of the parameter
+	hasDefault := map[string]bool{}
 	for _, param := range params {
+		var def Argument
+		if param.Default != "" {
+			if d, err := ParseArgument(param, param.Default); err == nil {
+				def, hasDefault[param.Name] = d, true
+			}
+		}
 		switch param.Type {
 		case TypeString:
-			cliArgs[param.Name] = fset.String(param.Name, "", param.Description)
+			cliArgs[param.Name] = fset.String(param.Name, param.Default, param.Description)
 		case TypeBool:
-			cliArgs[param.Name] = fset.Bool(param.Name, false, param.Description+" (Default: false)")
+			v := false
+			if d, ok := def.(*ArgumentBool); ok {
+				v = d.Val
+			}
+			cliArgs[param.Name] = fset.Bool(param.Name, v, fmt.Sprintf("%s (Default: %t)", param.Description, v))
 		case TypeInt:
-			cliArgs[param.Name] = fset.Int(param.Name, 0, param.Description)
+			v := 0
+			if d, ok := def.(*ArgumentInt); ok {
+				v = d.Val
+			}
+			cliArgs[param.Name] = fset.Int(param.Name, v, param.Description)
 		}
 	}
 
 	// Parameters common to all Lambdas
 	cliArgs["outputFormat"] = fset.String("output", "text", "output type: json or text")
 	cliArgs["help"] = fset.Bool("h", false, "display help")
+	if yes != nil && fset.Lookup("yes") == nil {
+		fset.BoolVar(yes, "yes", false, "run without asking for confirmation")
+	}
 	// cliArgs["debug"] = fset.Bool("debug", false, "set debug mode")
 	fset.Usage = func() {
 		fmt.Fprintf(&buf, "Usage: %s [OPTION]... [FILE OR FOLDER]...\n", flags[0])
@@ -146,14 +274,15 @@ func ArgsFromFlags(params []Parameter, flags []string) ([]Argument, string, erro
 			continue
 		}
 
-		// Ensure we do not set the argument if the parameter was not explicitly passed
+		// Ensure we do not set the argument if the parameter was not explicitly passed,
+		// and has no default
 		found := false
 		fset.Visit(func(f *flag.Flag) {
 			if f.Name == k {
 				found = true
 			}
 		})
-		if found == false {
+		if found == false && !(defaults && hasDefault[k]) {
 			continue
 		}
 
@@ -198,7 +327,10 @@ func Output(res map[string]interface{}, outputFormat string) (string, error) {
 		if _, ok := res["formatString"]; !ok {
 			return "", ErrMissingFormatString
 		}
-		t := template.Must(template.New("").Parse(fmt.Sprintf("%s", res["formatString"])))
+		t, err := template.New("").Parse(fmt.Sprintf("%s", res["formatString"]))
+		if err != nil {
+			return "", fmt.Errorf("failed parsing formatString: %w", err)
+		}
 		b := bytes.Buffer{}
 		if err := t.Execute(&b, res); err != nil {
 			return "", err
@@ -243,3 +375,42 @@ OUTER:
 
 	return true
 }
+
+// Provided is implemented by RemoteCalls that report the backend they run on
+type Provided interface {
+	Provider() string
+}
+
+// Provider returns the name of the backend rpc runs on, or "other"
+func Provider(rpc RemoteCall) string {
+	if p, ok := rpc.(Provided); ok {
+		return p.Provider()
+	}
+	return "other"
+}
+
+// ChannelRestricted is implemented by RemoteCalls that chat frontends only list, and
+// run, in some channels. An empty list of channels means all channels.
+type ChannelRestricted interface {
+	Channels() []string
+}
+
+// Replacer is implemented by RemoteCalls that replace another RPC, named by Replaces,
+// in the chat channels they are available in
+type Replacer interface {
+	Replaces() string
+}
+
+// AvailableIn returns true if rpc is available in the chat channel channelID
+func AvailableIn(rpc RemoteCall, channelID string) bool {
+	r, ok := rpc.(ChannelRestricted)
+	if !ok || len(r.Channels()) == 0 {
+		return true
+	}
+	for _, c := range r.Channels() {
+		if c == channelID {
+			return true
+		}
+	}
+	return false
+}
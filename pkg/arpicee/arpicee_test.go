@@ -1,6 +1,7 @@
 package arpicee
 
 import (
+	"encoding/json"
 	"flag"
 	"fmt"
 	"reflect"
@@ -68,10 +69,10 @@ func TestArgsFromFlags(t *testing.T) {
 		{
 			[]Parameter{
 				{
-					"param1",
-					TypeString,
-					"",
-					true,
+					Name:        "param1",
+					Type:        TypeString,
+					Description: "",
+					Required:    true,
 				},
 			},
 			[]string{"cli", "-param1", "foo"},
@@ -103,10 +104,10 @@ func TestArgsFromFlags(t *testing.T) {
 		{
 			[]Parameter{
 				{
-					"param1",
-					TypeString,
-					"",
-					true,
+					Name:        "param1",
+					Type:        TypeString,
+					Description: "",
+					Required:    true,
 				},
 			},
 			[]string{"cli"},
@@ -120,6 +121,43 @@ func TestArgsFromFlags(t *testing.T) {
 `,
 			fmt.Errorf("parameter param1 is required"),
 		},
+		{
+			[]Parameter{
+				{Name: "replicas", Type: TypeInt, Required: true, Default: "3"},
+			},
+			[]string{"cli"},
+			[]Argument{
+				&ArgumentInt{Name: "replicas", Val: 3},
+			},
+			"",
+			nil,
+		},
+		{
+			[]Parameter{
+				{Name: "env", Type: TypeString, Default: "staging"},
+			},
+			[]string{"cli", "-env", "production"},
+			[]Argument{
+				&ArgumentString{Name: "env", Val: "production"},
+			},
+			"",
+			nil,
+		},
+		{
+			[]Parameter{
+				{Name: "env", Type: TypeString, Default: "staging"},
+			},
+			[]string{"cli", "-h"},
+			[]Argument{},
+			`Usage: cli [OPTION]... [FILE OR FOLDER]...
+  -env string
+    	 (default "staging")
+  -h	display help
+  -output string
+    	output type: json or text (default "text")
+`,
+			flag.ErrHelp,
+		},
 		{
 			[]Parameter{},
 			[]string{"cli", "-param1", "foo"},
@@ -149,3 +187,104 @@ Usage: cli [OPTION]... [FILE OR FOLDER]...
 		}
 	}
 }
+
+func TestArgsFromMap(t *testing.T) {
+	params := []Parameter{
+		{Name: "name", Type: TypeString, Required: true},
+		{Name: "count", Type: TypeInt},
+		{Name: "dryrun", Type: TypeBool},
+		{Name: "env", Type: TypeString, AllowedValues: []string{"staging", "production"}},
+		{Name: "region", Type: TypeString, Required: true, Default: "eu-west-1"},
+	}
+
+	for i, testCase := range []struct {
+		json      string
+		expect    []Argument
+		expectErr string
+	}{
+		{
+			`{"name": "foo", "count": 3, "dryrun": true}`,
+			[]Argument{
+				&ArgumentInt{Name: "count", Val: 3},
+				&ArgumentBool{Name: "dryrun", Val: true},
+				&ArgumentString{Name: "name", Val: "foo"},
+				&ArgumentString{Name: "region", Val: "eu-west-1"},
+			},
+			"",
+		},
+		{
+			`{"name": "foo", "region": "us-east-1"}`,
+			[]Argument{
+				&ArgumentString{Name: "name", Val: "foo"},
+				&ArgumentString{Name: "region", Val: "us-east-1"},
+			},
+			"",
+		},
+		{
+			`{"name": "foo", "region": null}`,
+			[]Argument{
+				&ArgumentString{Name: "name", Val: "foo"},
+				&ArgumentString{Name: "region", Val: "eu-west-1"},
+			},
+			"",
+		},
+		{
+			`{"count": 3}`,
+			nil,
+			"parameter name is required",
+		},
+		{
+			`{"name": "foo", "count": "3"}`,
+			nil,
+			"parameter count should be a int, got a string",
+		},
+		{
+			`{"name": "foo", "count": 3.5}`,
+			nil,
+			"parameter count should be an integer: 3.5 is not an integer",
+		},
+		{
+			`{"name": "foo", "other": 1}`,
+			nil,
+			"unknown parameter other",
+		},
+		{
+			`{"name": "foo", "env": "dev"}`,
+			nil,
+			"invalid value for parameter env, allowed values: staging, production",
+		},
+	} {
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(testCase.json), &m); err != nil {
+			t.Fatalf("failed setting up test %d: %s", i, err)
+		}
+		got, err := ArgsFromMap(params, m)
+		if testCase.expectErr != "" {
+			if err == nil || err.Error() != testCase.expectErr {
+				t.Errorf("test %d - expected error %s, got %v", i, testCase.expectErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %d - unexpected error: %s", i, err)
+		}
+		if !reflect.DeepEqual(got, testCase.expect) {
+			t.Errorf("test %d - expected %+v, got %+v", i, testCase.expect, got)
+		}
+	}
+}
+
+func TestJSONSchema(t *testing.T) {
+	schema := JSONSchema([]Parameter{
+		{Name: "name", Type: TypeString, Required: true},
+		{Name: "region", Type: TypeString, Required: true, Default: "eu-west-1"},
+		{Name: "count", Type: TypeInt, Default: "3"},
+	})
+	if expect := []string{"name"}; !reflect.DeepEqual(schema["required"], expect) {
+		t.Errorf("expected required parameters %v, got %v", expect, schema["required"])
+	}
+	count := schema["properties"].(map[string]interface{})["count"].(map[string]interface{})
+	if count["type"] != "integer" || count["default"] != 3 {
+		t.Errorf("unexpected schema for parameter count: %v", count)
+	}
+}
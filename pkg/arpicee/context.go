@@ -0,0 +1,76 @@
+package arpicee
+
+import (
+	"context"
+)
+
+// ContextRemoteCall is implemented by RemoteCalls that can be cancelled, and that
+// report their progress using ReportProgress
+type ContextRemoteCall interface {
+	RemoteCall
+
+	RunContext(ctx context.Context, args []Argument) (map[string]interface{}, error)
+}
+
+// Run invokes rpc with the given context if it supports it. RPCs that do
+// not implement ContextRemoteCall run to completion even if ctx is cancelled.
+func Run(ctx context.Context, rpc RemoteCall, args []Argument) (map[string]interface{}, error) {
+	if crpc, ok := rpc.(ContextRemoteCall); ok {
+		return crpc.RunContext(ctx, args)
+	}
+	return rpc.Run(args)
+}
+
+type progressKey struct{}
+
+// WithProgress returns a context that RPCs can use to report their progress to f
+func WithProgress(ctx context.Context, f func(msg string)) context.Context {
+	return context.WithValue(ctx, progressKey{}, f)
+}
+
+// ReportProgress sends a progress message to the function registered with WithProgress, if any
+func ReportProgress(ctx context.Context, msg string) {
+	if f, ok := ctx.Value(progressKey{}).(func(string)); ok {
+		f(msg)
+	}
+}
+
+type authorizerKey struct{}
+
+// WithAuthorizer returns a context that RPCs running other RPCs, such as pipelines,
+// use to check the user is allowed to run them with f
+func WithAuthorizer(ctx context.Context, f func(rpc RemoteCall) error) context.Context {
+	return context.WithValue(ctx, authorizerKey{}, f)
+}
+
+// Authorize checks rpc with the function registered with WithAuthorizer, if any
+func Authorize(ctx context.Context, rpc RemoteCall) error {
+	if f, ok := ctx.Value(authorizerKey{}).(func(RemoteCall) error); ok {
+		return f(rpc)
+	}
+	return nil
+}
+
+// ResumableRemoteCall is implemented by RemoteCalls running remotely, such as SSM
+// automations or GitHub workflows. They report the ID of the remote execution with
+// ReportExternalID, and Resume waits for the remote execution with that ID to
+// complete, for example after a restart.
+type ResumableRemoteCall interface {
+	ContextRemoteCall
+
+	Resume(ctx context.Context, externalID string) (map[string]interface{}, error)
+}
+
+type externalIDKey struct{}
+
+// WithExternalID returns a context that RPCs can use to report the ID of their remote execution to f
+func WithExternalID(ctx context.Context, f func(id string)) context.Context {
+	return context.WithValue(ctx, externalIDKey{}, f)
+}
+
+// ReportExternalID sends the ID of the remote execution to the function registered with WithExternalID, if any
+func ReportExternalID(ctx context.Context, id string) {
+	if f, ok := ctx.Value(externalIDKey{}).(func(string)); ok {
+		f(id)
+	}
+}
@@ -0,0 +1,33 @@
+package arpicee
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ItemError is the error discovering one item of a discovery source, such as a
+// Lambda function or a GitHub workflow
+type ItemError struct {
+	Item string
+	Err  error
+}
+
+// DiscoveryErrors is returned by discovery functions along with the RPCs they could
+// discover, when some items of the source could not be discovered
+type DiscoveryErrors []ItemError
+
+func (e DiscoveryErrors) Error() string {
+	var msgs []string
+	for _, ie := range e {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", ie.Item, ie.Err))
+	}
+	return strings.Join(msgs, ", ")
+}
+
+// Err returns e, or nil if there are no errors
+func (e DiscoveryErrors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
@@ -0,0 +1,166 @@
+package arpicee
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// ArgsToMap returns the arguments as a map of parameter names to values
+func ArgsToMap(args []Argument) map[string]interface{} {
+	m := map[string]interface{}{}
+	for _, arg := range args {
+		switch a := arg.(type) {
+		case *ArgumentString:
+			m[a.Name] = a.Val
+		case *ArgumentInt:
+			m[a.Name] = a.Val
+		case *ArgumentBool:
+			m[a.Name] = a.Val
+		}
+	}
+	return m
+}
+
+// ArgsFromMap converts decoded JSON values to arguments, checking the type of every
+// value against its parameter, then validates the arguments. Parameters missing from
+// m take their default value.
+func ArgsFromMap(params []Parameter, m map[string]interface{}) ([]Argument, error) {
+	args := []Argument{}
+
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		var param *Parameter
+		for i := range params {
+			if params[i].Name == name {
+				param = &params[i]
+			}
+		}
+		if param == nil {
+			return nil, fmt.Errorf("unknown parameter %s", name)
+		}
+
+		switch v := m[name].(type) {
+		case string:
+			if param.Type != TypeString {
+				return nil, fmt.Errorf("parameter %s should be a %s, got a string", name, param.Type)
+			}
+			args = append(args, &ArgumentString{Name: name, Val: v})
+		case bool:
+			if param.Type != TypeBool {
+				return nil, fmt.Errorf("parameter %s should be a %s, got a boolean", name, param.Type)
+			}
+			args = append(args, &ArgumentBool{Name: name, Val: v})
+		case float64, int, json.Number:
+			if param.Type != TypeInt {
+				return nil, fmt.Errorf("parameter %s should be a %s, got a number", name, param.Type)
+			}
+			i, err := toInt(v)
+			if err != nil {
+				return nil, fmt.Errorf("parameter %s should be an integer: %w", name, err)
+			}
+			args = append(args, &ArgumentInt{Name: name, Val: i})
+		case nil:
+			continue
+		default:
+			return nil, fmt.Errorf("parameter %s should be a %s", name, param.Type)
+		}
+	}
+
+	for _, param := range params {
+		if m[param.Name] != nil || param.Default == "" {
+			continue
+		}
+		arg, err := ParseArgument(param, param.Default)
+		if err != nil {
+			return nil, fmt.Errorf("invalid default for parameter %s: %w", param.Name, err)
+		}
+		args = append(args, arg)
+	}
+
+	return args, ValidateArguments(args, params)
+}
+
+func toInt(v interface{}) (int, error) {
+	switch n := v.(type) {
+	case int:
+		return n, nil
+	case float64:
+		if n != math.Trunc(n) {
+			return 0, fmt.Errorf("%v is not an integer", n)
+		}
+		return int(n), nil
+	case json.Number:
+		i, err := strconv.Atoi(n.String())
+		if err != nil {
+			return 0, fmt.Errorf("%s is not an integer", n)
+		}
+		return i, nil
+	}
+	return 0, fmt.Errorf("%v is not a number", v)
+}
+
+func jsonSchemaType(t ParamType) string {
+	switch t {
+	case TypeBool:
+		return "boolean"
+	case TypeInt:
+		return "integer"
+	default:
+		return "string"
+	}
+}
+
+// JSONSchema returns a JSON Schema describing the object accepted by ArgsFromMap.
+// Parameters with a default are not required.
+func JSONSchema(params []Parameter) map[string]interface{} {
+	properties := map[string]interface{}{}
+	required := []string{}
+	for _, p := range params {
+		prop := map[string]interface{}{
+			"type": jsonSchemaType(p.Type),
+		}
+		if p.Description != "" {
+			prop["description"] = p.Description
+		}
+		if len(p.AllowedValues) > 0 {
+			var enum []interface{}
+			for _, v := range p.AllowedValues {
+				if arg, err := ParseArgument(p, v); err == nil {
+					enum = append(enum, ArgsToMap([]Argument{arg})[p.Name])
+				}
+			}
+			prop["enum"] = enum
+		}
+		if p.Default != "" {
+			if arg, err := ParseArgument(p, p.Default); err == nil {
+				prop["default"] = ArgsToMap([]Argument{arg})[p.Name]
+			}
+		}
+		if p.Secret {
+			prop["writeOnly"] = true
+		}
+		properties[p.Name] = prop
+		if p.Required && p.Default == "" {
+			required = append(required, p.Name)
+		}
+	}
+	sort.Strings(required)
+
+	schema := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
@@ -0,0 +1,215 @@
+package arpicee
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Risk is the risk level of running an RPC: low, medium or high
+type Risk string
+
+const (
+	RiskLow    Risk = "low"
+	RiskMedium Risk = "medium"
+	RiskHigh   Risk = "high"
+)
+
+// Validate checks r is empty or a valid risk level
+func (r Risk) Validate() error {
+	switch r {
+	case "", RiskLow, RiskMedium, RiskHigh:
+		return nil
+	}
+	return fmt.Errorf("invalid risk %s, expected low, medium or high", r)
+}
+
+// Confirmation is the confirmation required before running an RPC from an interactive
+// frontend: none, arguments (confirming the arguments) or name (typing the name of the
+// RPC)
+type Confirmation string
+
+const (
+	ConfirmNone      Confirmation = "none"
+	ConfirmArguments Confirmation = "arguments"
+	ConfirmName      Confirmation = "name"
+)
+
+// Validate checks c is empty or a valid confirmation
+func (c Confirmation) Validate() error {
+	switch c {
+	case "", ConfirmNone, ConfirmArguments, ConfirmName:
+		return nil
+	}
+	return fmt.Errorf("invalid confirmation %s, expected none, arguments or name", c)
+}
+
+// Metadata describes an RPC beyond its name and description. All fields are optional.
+// EstimatedDuration is free text, such as "5m"; Deprecation is the notice shown to the
+// users of a deprecated RPC, such as "use deploy-v2 instead". Confirm defaults to
+// arguments for high risk RPCs, see Confirmation.
+type Metadata struct {
+	Owner             string
+	Category          string
+	Tags              []string
+	RunbookURL        string
+	Risk              Risk
+	EstimatedDuration string
+	Deprecation       string
+	Confirm           Confirmation
+}
+
+// Described is implemented by the RPCs with metadata
+type Described interface {
+	Metadata() Metadata
+}
+
+// MetadataOf returns the metadata of rpc, if it implements Described
+func MetadataOf(rpc RemoteCall) Metadata {
+	if d, ok := rpc.(Described); ok {
+		return d.Metadata()
+	}
+	return Metadata{}
+}
+
+// MetadataPrefix prefixes the names of the tags, or workflow comments, setting the
+// metadata of discovered RPCs, such as "arpicee:owner" or "arpicee:risk"
+const MetadataPrefix = "arpicee:"
+
+// ParseMetadata returns the metadata set by values, keyed by the names of the fields of
+// Metadata prefixed with MetadataPrefix, in any case: "arpicee:owner", "arpicee:tags"
+// (a comma separated list), "arpicee:runbookURL"... Other keys are ignored.
+func ParseMetadata(values map[string]string) (Metadata, error) {
+	var m Metadata
+	for k, v := range values {
+		if !strings.HasPrefix(strings.ToLower(k), MetadataPrefix) {
+			continue
+		}
+		v = strings.TrimSpace(v)
+		switch strings.ToLower(k[len(MetadataPrefix):]) {
+		case "owner":
+			m.Owner = v
+		case "category":
+			m.Category = v
+		case "tags":
+			for _, t := range strings.Split(v, ",") {
+				if t = strings.TrimSpace(t); t != "" {
+					m.Tags = append(m.Tags, t)
+				}
+			}
+			sort.Strings(m.Tags)
+		case "runbookurl":
+			m.RunbookURL = v
+		case "risk":
+			m.Risk = Risk(strings.ToLower(v))
+		case "estimatedduration":
+			m.EstimatedDuration = v
+		case "deprecation":
+			m.Deprecation = v
+		case "confirm":
+			m.Confirm = Confirmation(strings.ToLower(v))
+		}
+	}
+	return m, m.Validate()
+}
+
+// Validate checks the risk level and the confirmation are valid
+func (m Metadata) Validate() error {
+	if err := m.Risk.Validate(); err != nil {
+		return err
+	}
+	return m.Confirm.Validate()
+}
+
+// Confirmation returns the confirmation required before running the RPC: Confirm if
+// set, arguments for high risk RPCs, none otherwise
+func (m Metadata) Confirmation() Confirmation {
+	if m.Confirm != "" {
+		return m.Confirm
+	}
+	if m.Risk == RiskHigh {
+		return ConfirmArguments
+	}
+	return ConfirmNone
+}
+
+// Summary returns the metadata worth showing before running an RPC, one line each:
+// the deprecation notice, the risk level, the owner, the estimated duration and the
+// runbook
+func (m Metadata) Summary() []string {
+	var lines []string
+	if m.Deprecation != "" {
+		lines = append(lines, "Deprecated: "+m.Deprecation)
+	}
+	if m.Risk != "" {
+		lines = append(lines, "Risk: "+string(m.Risk))
+	}
+	if m.Owner != "" {
+		lines = append(lines, "Owner: "+m.Owner)
+	}
+	if m.EstimatedDuration != "" {
+		lines = append(lines, "Estimated duration: "+m.EstimatedDuration)
+	}
+	if m.RunbookURL != "" {
+		lines = append(lines, "Runbook: "+m.RunbookURL)
+	}
+	return lines
+}
+
+// Search returns the RPCs matching all the words of query, case insensitively, in their
+// name, description, owner, category or tags. An empty query matches all RPCs.
+func Search(rpcs []RemoteCall, query string) []RemoteCall {
+	words := strings.Fields(strings.ToLower(query))
+	res := []RemoteCall{}
+	for _, rpc := range rpcs {
+		m := MetadataOf(rpc)
+		text := strings.ToLower(strings.Join(append([]string{rpc.Name(), rpc.Description(), m.Owner, m.Category}, m.Tags...), "\n"))
+		found := true
+		for _, w := range words {
+			if !strings.Contains(text, w) {
+				found = false
+				break
+			}
+		}
+		if found {
+			res = append(res, rpc)
+		}
+	}
+	return res
+}
+
+// Uncategorized is the category of the RPCs without one, see GroupByCategory
+const Uncategorized = "Other"
+
+// Group is a category of RPCs
+type Group struct {
+	Category string
+	RPCs     []RemoteCall
+}
+
+// GroupByCategory groups the RPCs by category, sorted by name. RPCs without a category
+// are grouped last, as Uncategorized. The order of the RPCs of each group is kept.
+func GroupByCategory(rpcs []RemoteCall) []Group {
+	var groups []Group
+	index := map[string]int{}
+	var other []RemoteCall
+	for _, rpc := range rpcs {
+		c := MetadataOf(rpc).Category
+		if c == "" {
+			other = append(other, rpc)
+			continue
+		}
+		i, ok := index[c]
+		if !ok {
+			i = len(groups)
+			index[c] = i
+			groups = append(groups, Group{Category: c})
+		}
+		groups[i].RPCs = append(groups[i].RPCs, rpc)
+	}
+	sort.SliceStable(groups, func(i, j int) bool { return groups[i].Category < groups[j].Category })
+	if len(other) > 0 {
+		groups = append(groups, Group{Category: Uncategorized, RPCs: other})
+	}
+	return groups
+}
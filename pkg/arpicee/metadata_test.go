@@ -0,0 +1,147 @@
+package arpicee
+
+import (
+	"reflect"
+	"testing"
+)
+
+type describedRPC struct {
+	name        string
+	description string
+	metadata    Metadata
+}
+
+func (r *describedRPC) Name() string        { return r.name }
+func (r *describedRPC) Description() string { return r.description }
+func (r *describedRPC) Params() []Parameter { return nil }
+func (r *describedRPC) Metadata() Metadata  { return r.metadata }
+func (r *describedRPC) Run(args []Argument) (map[string]interface{}, error) {
+	return nil, nil
+}
+
+func TestParseMetadata(t *testing.T) {
+	for i, testCase := range []struct {
+		values    map[string]string
+		expect    Metadata
+		expectErr string
+	}{
+		{
+			map[string]string{"param:env:string": "environment", "owner": "ignored"},
+			Metadata{},
+			"",
+		},
+		{
+			map[string]string{
+				"arpicee:owner":             " platform ",
+				"Arpicee:Category":          "Releases",
+				"arpicee:tags":              "kubernetes, deploy,,",
+				"arpicee:runbookURL":        "https://wiki/deploy",
+				"arpicee:risk":              "High",
+				"arpicee:estimatedDuration": "5m",
+				"arpicee:deprecation":       "use deploy-v2 instead",
+				"arpicee:confirm":           "Name",
+			},
+			Metadata{
+				Owner:             "platform",
+				Category:          "Releases",
+				Tags:              []string{"deploy", "kubernetes"},
+				RunbookURL:        "https://wiki/deploy",
+				Risk:              RiskHigh,
+				EstimatedDuration: "5m",
+				Deprecation:       "use deploy-v2 instead",
+				Confirm:           ConfirmName,
+			},
+			"",
+		},
+		{
+			map[string]string{"arpicee:risk": "extreme"},
+			Metadata{Risk: "extreme"},
+			"invalid risk extreme, expected low, medium or high",
+		},
+		{
+			map[string]string{"arpicee:confirm": "twice"},
+			Metadata{Confirm: "twice"},
+			"invalid confirmation twice, expected none, arguments or name",
+		},
+	} {
+		got, err := ParseMetadata(testCase.values)
+		if (err == nil && testCase.expectErr != "") || (err != nil && err.Error() != testCase.expectErr) {
+			t.Errorf("test %d - expected error %q, got %v", i, testCase.expectErr, err)
+		}
+		if !reflect.DeepEqual(got, testCase.expect) {
+			t.Errorf("test %d - expected %+v, got %+v", i, testCase.expect, got)
+		}
+	}
+}
+
+func TestConfirmation(t *testing.T) {
+	for i, testCase := range []struct {
+		m      Metadata
+		expect Confirmation
+	}{
+		{Metadata{}, ConfirmNone},
+		{Metadata{Risk: RiskMedium}, ConfirmNone},
+		{Metadata{Risk: RiskHigh}, ConfirmArguments},
+		{Metadata{Risk: RiskHigh, Confirm: ConfirmName}, ConfirmName},
+		{Metadata{Risk: RiskHigh, Confirm: ConfirmNone}, ConfirmNone},
+		{Metadata{Confirm: ConfirmArguments}, ConfirmArguments},
+	} {
+		if got := testCase.m.Confirmation(); got != testCase.expect {
+			t.Errorf("test %d - expected confirmation %s, got %s", i, testCase.expect, got)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	rpcs := []RemoteCall{
+		&describedRPC{name: "deploy", description: "Deploys a service", metadata: Metadata{Owner: "platform", Category: "Releases", Tags: []string{"kubernetes"}}},
+		&describedRPC{name: "rollback", description: "Rolls a service back", metadata: Metadata{Category: "Releases"}},
+		&describedRPC{name: "refund", description: "Refunds an order", metadata: Metadata{Owner: "payments"}},
+	}
+	for i, testCase := range []struct {
+		query  string
+		expect []string
+	}{
+		{"", []string{"deploy", "rollback", "refund"}},
+		{"SERVICE", []string{"deploy", "rollback"}},
+		{"releases kubernetes", []string{"deploy"}},
+		{"payments", []string{"refund"}},
+		{"payments kubernetes", []string{}},
+	} {
+		got := []string{}
+		for _, rpc := range Search(rpcs, testCase.query) {
+			got = append(got, rpc.Name())
+		}
+		if !reflect.DeepEqual(got, testCase.expect) {
+			t.Errorf("test %d - expected %v, got %v", i, testCase.expect, got)
+		}
+	}
+}
+
+func TestGroupByCategory(t *testing.T) {
+	rpcs := []RemoteCall{
+		&describedRPC{name: "refund"},
+		&describedRPC{name: "rollback", metadata: Metadata{Category: "Releases"}},
+		&describedRPC{name: "restart", metadata: Metadata{Category: "Operations"}},
+		&describedRPC{name: "deploy", metadata: Metadata{Category: "Releases"}},
+	}
+	got := map[string][]string{}
+	var categories []string
+	for _, g := range GroupByCategory(rpcs) {
+		categories = append(categories, g.Category)
+		for _, rpc := range g.RPCs {
+			got[g.Category] = append(got[g.Category], rpc.Name())
+		}
+	}
+	if expect := []string{"Operations", "Releases", Uncategorized}; !reflect.DeepEqual(categories, expect) {
+		t.Errorf("expected categories %v, got %v", expect, categories)
+	}
+	expect := map[string][]string{
+		"Operations":  {"restart"},
+		"Releases":    {"rollback", "deploy"},
+		Uncategorized: {"refund"},
+	}
+	if !reflect.DeepEqual(got, expect) {
+		t.Errorf("expected groups %v, got %v", expect, got)
+	}
+}
@@ -0,0 +1,287 @@
+package arpicee
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Prompter interactively asks for parameter values, typically on a terminal
+type Prompter struct {
+	in         *bufio.Reader
+	out        io.Writer
+	readSecret func() (string, error)
+}
+
+func NewPrompter(in io.Reader, out io.Writer) *Prompter {
+	p := &Prompter{
+		in:  bufio.NewReader(in),
+		out: out,
+	}
+	p.readSecret = p.readLine
+	return p
+}
+
+// NewTerminalPrompter reads from stdin and writes prompts to stderr, so that
+// the output of the RPC can still be piped. Secrets are read without echo.
+func NewTerminalPrompter() *Prompter {
+	p := NewPrompter(os.Stdin, os.Stderr)
+	p.readSecret = func() (string, error) {
+		if err := stty("-echo"); err != nil {
+			return p.readLine()
+		}
+		defer func() {
+			stty("echo")
+			fmt.Fprintln(p.out)
+		}()
+		return p.readLine()
+	}
+	return p
+}
+
+func stty(arg string) error {
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
+
+// IsTerminal returns true if f is a character device, eg a terminal
+func IsTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// Interactive returns true when prompting for parameters should be enabled:
+// stdin is a terminal, and it was not disabled with ARPICEE_INTERACTIVE=false
+func Interactive() bool {
+	if v, ok := os.LookupEnv("ARPICEE_INTERACTIVE"); ok {
+		if b, err := strconv.ParseBool(v); err == nil && !b {
+			return false
+		}
+	}
+	return IsTerminal(os.Stdin)
+}
+
+func (p *Prompter) readLine() (string, error) {
+	line, err := p.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		if err == io.EOF {
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func promptText(param Parameter) string {
+	qualifier := "optional"
+	if param.Required {
+		qualifier = "required"
+	}
+	text := fmt.Sprintf("%s (%s, %s)", param.Name, param.Type, qualifier)
+	if param.Description != "" {
+		text += " - " + param.Description
+	}
+	if len(param.AllowedValues) > 0 {
+		text += " [" + strings.Join(param.AllowedValues, "|") + "]"
+	}
+	if param.Default != "" {
+		text += fmt.Sprintf(" (default: %s)", param.Default)
+	}
+	return text + ": "
+}
+
+// ParseArgument converts the string representation of a value to an
+// Argument of the type expected by param
+func ParseArgument(param Parameter, v string) (Argument, error) {
+	switch param.Type {
+	case TypeInt:
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s should be a number, could not parse given value: %s", param.Name, v)
+		}
+		return &ArgumentInt{Name: param.Name, Val: i}, nil
+	case TypeBool:
+		switch strings.ToLower(v) {
+		case "y", "yes", "true", "1":
+			return &ArgumentBool{Name: param.Name, Val: true}, nil
+		case "n", "no", "false", "0":
+			return &ArgumentBool{Name: param.Name, Val: false}, nil
+		}
+		return nil, fmt.Errorf("parameter %s should be a boolean, could not parse given value: %s", param.Name, v)
+	default:
+		return &ArgumentString{Name: param.Name, Val: v}, nil
+	}
+}
+
+func (p *Prompter) promptParam(param Parameter) (Argument, error) {
+	for {
+		fmt.Fprint(p.out, promptText(param))
+		read := p.readLine
+		if param.Secret {
+			read = p.readSecret
+		}
+		v, err := read()
+		if err != nil {
+			return nil, err
+		}
+
+		if v == "" {
+			if param.Default != "" {
+				v = param.Default
+			} else if param.Required {
+				fmt.Fprintf(p.out, "a value is required for %s\n", param.Name)
+				continue
+			} else {
+				return nil, nil
+			}
+		}
+
+		arg, err := ParseArgument(param, v)
+		if err == nil && !isAllowed(param, arg) {
+			err = fmt.Errorf("invalid value for parameter %s, allowed values: %s", param.Name, strings.Join(param.AllowedValues, ", "))
+		}
+		if err != nil {
+			fmt.Fprintln(p.out, err)
+			continue
+		}
+		return arg, nil
+	}
+}
+
+// PromptArgs asks for a value for every parameter that is not already set in args.
+// Optional parameters can be skipped by entering an empty value.
+func (p *Prompter) PromptArgs(params []Parameter, args []Argument) ([]Argument, error) {
+	res := append([]Argument{}, args...)
+	for _, param := range params {
+		if GetArg(args, param.Name) != nil {
+			continue
+		}
+		arg, err := p.promptParam(param)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading value for parameter %s: %w", param.Name, err)
+		}
+		if arg != nil {
+			res = append(res, arg)
+		}
+	}
+
+	return res, ValidateArguments(res, params)
+}
+
+// summary displays the summary of the metadata of the RPC, and of the arguments it is
+// about to be invoked with. Values of secret parameters are masked.
+func (p *Prompter) summary(rpcName string, m Metadata, params []Parameter, args []Argument) {
+	for _, line := range m.Summary() {
+		fmt.Fprintln(p.out, line)
+	}
+	fmt.Fprintf(p.out, "About to run %s with:\n", rpcName)
+	for _, param := range params {
+		arg := GetArg(args, param.Name)
+		if arg == nil {
+			continue
+		}
+		v := argString(arg)
+		if param.Secret {
+			v = "********"
+		}
+		fmt.Fprintf(p.out, "  %s = %s\n", param.Name, v)
+	}
+}
+
+// Confirm displays the summary of the metadata of the RPC, and of the arguments it is
+// about to be invoked with, and asks for confirmation. Values of secret parameters are
+// masked.
+func (p *Prompter) Confirm(rpcName string, m Metadata, params []Parameter, args []Argument) (bool, error) {
+	p.summary(rpcName, m, params, args)
+	fmt.Fprint(p.out, "Proceed? [y/N]: ")
+	answer, err := p.readLine()
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
+
+// ConfirmName behaves like Confirm, but asks to type the name of the RPC to confirm
+func (p *Prompter) ConfirmName(rpcName string, m Metadata, params []Parameter, args []Argument) (bool, error) {
+	p.summary(rpcName, m, params, args)
+	fmt.Fprintf(p.out, "Type %s to proceed: ", rpcName)
+	answer, err := p.readLine()
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(answer) == rpcName, nil
+}
+
+var ErrAborted = errors.New("aborted")
+
+// allSet returns true if args has a value for all parameters params
+func allSet(params []Parameter, args []Argument) bool {
+	for _, param := range params {
+		if GetArg(args, param.Name) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// ArgsFromFlagsOrPrompt behaves like ArgsFromFlags for the parameters of rpc, but when
+// running interactively, it prompts for all parameters that were not passed as flags,
+// offering their default values, then asks for confirmation.
+//
+// When confirm requires a confirmation, see Confirmation, it is also asked when all
+// parameters were passed as flags. The -yes flag skips the confirmation, and is required
+// to run such RPCs non-interactively.
+func ArgsFromFlagsOrPrompt(rpc RemoteCall, confirm Confirmation, flags []string) ([]Argument, string, error) {
+	params := rpc.Params()
+	var yes bool
+	var p *Prompter
+	if Interactive() {
+		p = NewTerminalPrompter()
+	}
+	// Defaults are offered when prompting, rather than set
+	args, usage, err := argsFromFlags(params, flags, &yes, p == nil)
+	var missing *MissingParameterError
+	prompted := false
+	if p != nil && (err == nil || errors.As(err, &missing)) && !allSet(params, args) {
+		if args, err = p.PromptArgs(params, args); err != nil {
+			return nil, "", err
+		}
+		prompted = true
+	} else if err != nil {
+		return args, usage, err
+	}
+
+	if yes || (!prompted && (confirm == "" || confirm == ConfirmNone)) {
+		return args, usage, nil
+	}
+	if p == nil {
+		return nil, "", fmt.Errorf("%s requires confirmation, pass -yes to run it non-interactively", rpc.Name())
+	}
+
+	ask := p.Confirm
+	if confirm == ConfirmName {
+		ask = p.ConfirmName
+	}
+	ok, err := ask(rpc.Name(), MetadataOf(rpc), params, args)
+	if err != nil {
+		return nil, "", err
+	}
+	if !ok {
+		return nil, "", ErrAborted
+	}
+
+	return args, "", nil
+}
@@ -0,0 +1,170 @@
+package arpicee
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPromptArgs(t *testing.T) {
+	for i, testCase := range []struct {
+		params    []Parameter
+		args      []Argument
+		input     string
+		expect    []Argument
+		expectErr bool
+	}{
+		{
+			[]Parameter{
+				{Name: "name", Type: TypeString, Required: true},
+			},
+			[]Argument{},
+			"foo\n",
+			[]Argument{
+				&ArgumentString{Name: "name", Val: "foo"},
+			},
+			false,
+		},
+		{
+			// Parameters passed as flags are not prompted for
+			[]Parameter{
+				{Name: "name", Type: TypeString, Required: true},
+				{Name: "count", Type: TypeInt},
+			},
+			[]Argument{
+				&ArgumentString{Name: "name", Val: "foo"},
+			},
+			"3\n",
+			[]Argument{
+				&ArgumentString{Name: "name", Val: "foo"},
+				&ArgumentInt{Name: "count", Val: 3},
+			},
+			false,
+		},
+		{
+			// Empty values for optional parameters are skipped, defaults are applied,
+			// invalid values are asked for again
+			[]Parameter{
+				{Name: "env", Type: TypeString, Required: true, AllowedValues: []string{"staging", "production"}},
+				{Name: "dryrun", Type: TypeBool, Default: "true"},
+				{Name: "comment", Type: TypeString},
+				{Name: "count", Type: TypeInt, Required: true},
+			},
+			[]Argument{},
+			"dev\n\nstaging\n\n\nabc\n2\n",
+			[]Argument{
+				&ArgumentString{Name: "env", Val: "staging"},
+				&ArgumentBool{Name: "dryrun", Val: true},
+				&ArgumentInt{Name: "count", Val: 2},
+			},
+			false,
+		},
+		{
+			[]Parameter{
+				{Name: "name", Type: TypeString, Required: true},
+			},
+			[]Argument{},
+			"",
+			nil,
+			true,
+		},
+	} {
+		var out bytes.Buffer
+		p := NewPrompter(strings.NewReader(testCase.input), &out)
+		got, err := p.PromptArgs(testCase.params, testCase.args)
+		if (err != nil) != testCase.expectErr {
+			t.Errorf("test %d - expected error: %t, got %s", i, testCase.expectErr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, testCase.expect) {
+			t.Errorf("test %d - expected %+v, got %+v", i, testCase.expect, got)
+		}
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	params := []Parameter{
+		{Name: "user", Type: TypeString},
+		{Name: "password", Type: TypeString, Secret: true},
+	}
+	args := []Argument{
+		&ArgumentString{Name: "user", Val: "foo"},
+		&ArgumentString{Name: "password", Val: "hunter2"},
+	}
+
+	for i, testCase := range []struct {
+		input  string
+		expect bool
+	}{
+		{"y\n", true},
+		{"yes\n", true},
+		{"\n", false},
+		{"no\n", false},
+	} {
+		var out bytes.Buffer
+		p := NewPrompter(strings.NewReader(testCase.input), &out)
+		ok, err := p.Confirm("myrpc", Metadata{Risk: RiskHigh, Owner: "platform"}, params, args)
+		if err != nil {
+			t.Errorf("test %d - unexpected error: %s", i, err)
+		}
+		if ok != testCase.expect {
+			t.Errorf("test %d - expected confirmation to be %t, got %t", i, testCase.expect, ok)
+		}
+		if strings.Contains(out.String(), "hunter2") {
+			t.Errorf("test %d - secret value displayed in summary: %s", i, out.String())
+		}
+		if !strings.Contains(out.String(), "Risk: high\nOwner: platform\n") {
+			t.Errorf("test %d - expected summary to contain the metadata, got %s", i, out.String())
+		}
+		if !strings.Contains(out.String(), "user = foo") {
+			t.Errorf("test %d - expected summary to contain argument user, got %s", i, out.String())
+		}
+	}
+}
+
+func TestConfirmName(t *testing.T) {
+	for i, testCase := range []struct {
+		input  string
+		expect bool
+	}{
+		{"myrpc\n", true},
+		{" myrpc \n", true},
+		{"y\n", false},
+		{"MYRPC\n", false},
+	} {
+		var out bytes.Buffer
+		p := NewPrompter(strings.NewReader(testCase.input), &out)
+		ok, err := p.ConfirmName("myrpc", Metadata{Risk: RiskHigh}, nil, nil)
+		if err != nil {
+			t.Errorf("test %d - unexpected error: %s", i, err)
+		}
+		if ok != testCase.expect {
+			t.Errorf("test %d - expected confirmation to be %t, got %t", i, testCase.expect, ok)
+		}
+		if !strings.HasSuffix(out.String(), "Type myrpc to proceed: ") {
+			t.Errorf("test %d - expected to be asked for the name of the RPC, got %s", i, out.String())
+		}
+	}
+}
+
+func TestArgsFromFlagsOrPromptNonInteractive(t *testing.T) {
+	t.Setenv("ARPICEE_INTERACTIVE", "false")
+	rpc := &describedRPC{name: "deploy"}
+	for i, testCase := range []struct {
+		confirm   Confirmation
+		flags     []string
+		expectErr string
+	}{
+		{ConfirmNone, []string{"cli"}, ""},
+		{ConfirmArguments, []string{"cli"}, "deploy requires confirmation, pass -yes to run it non-interactively"},
+		{ConfirmName, []string{"cli"}, "deploy requires confirmation, pass -yes to run it non-interactively"},
+		{ConfirmName, []string{"cli", "-yes"}, ""},
+		{ConfirmArguments, []string{"cli", "--yes"}, ""},
+	} {
+		_, _, err := ArgsFromFlagsOrPrompt(rpc, testCase.confirm, testCase.flags)
+		if (err == nil && testCase.expectErr != "") || (err != nil && err.Error() != testCase.expectErr) {
+			t.Errorf("test %d - expected error %q, got %v", i, testCase.expectErr, err)
+		}
+	}
+}
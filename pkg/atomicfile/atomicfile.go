@@ -0,0 +1,30 @@
+// Package atomicfile writes files atomically
+package atomicfile
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// WriteFile behaves like os.WriteFile, but writes data to a temporary file of the same
+// directory first, then renames it to path: processes reading path concurrently never
+// read a partially written file, and a crash never leaves it half written.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,34 @@
+package atomicfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+
+	for i, content := range []string{`{"a": 1}`, `{}`} {
+		if err := WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatalf("test %d - failed writing file: %s", i, err)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil || string(b) != content {
+			t.Errorf("test %d - expected %s, got %s (%v)", i, content, b, err)
+		}
+		if st, err := os.Stat(path); err != nil || st.Mode().Perm() != 0o600 {
+			t.Errorf("test %d - expected mode 0600, got %v (%v)", i, st, err)
+		}
+	}
+
+	// The temporary files are removed
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("expected only the written file in %s, got %v", dir, entries)
+	}
+
+	if err := WriteFile(filepath.Join(dir, "missing", "state.json"), []byte("{}"), 0o600); err == nil {
+		t.Errorf("expected an error writing to a missing directory")
+	}
+}
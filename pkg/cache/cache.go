@@ -0,0 +1,125 @@
+package cache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/atomicfile"
+)
+
+// Referencer is implemented by the RPCs that can be cached: Ref returns what their
+// source needs to restore them without network access, such as a function name
+type Referencer interface {
+	Ref() string
+}
+
+// Entry is the description of a cached RPC
+type Entry struct {
+	Name        string
+	Description string
+	Provider    string
+	Params      []arpicee.Parameter
+	Metadata    arpicee.Metadata
+	Ref         string
+}
+
+// Source holds the RPCs discovered by a source. Fingerprint identifies the settings
+// of the source when it was discovered: entries of a source whose settings changed
+// since are not used.
+type Source struct {
+	Fingerprint  string
+	DiscoveredAt time.Time
+	Entries      []Entry
+}
+
+// Cache stores the RPCs discovered by each source on disk, so that they can be used
+// on startup without discovering them again
+type Cache struct {
+	mu      sync.Mutex
+	path    string
+	sources map[string]Source
+}
+
+// Entries returns the cache entries of rpcs, or false if one of them does not
+// implement Referencer
+func Entries(rpcs []arpicee.RemoteCall) ([]Entry, bool) {
+	entries := []Entry{}
+	for _, rpc := range rpcs {
+		r, ok := rpc.(Referencer)
+		if !ok {
+			return nil, false
+		}
+		entries = append(entries, Entry{
+			Name:        rpc.Name(),
+			Description: rpc.Description(),
+			Provider:    arpicee.Provider(rpc),
+			Params:      rpc.Params(),
+			Metadata:    arpicee.MetadataOf(rpc),
+			Ref:         r.Ref(),
+		})
+	}
+	return entries, true
+}
+
+// Load reads the cache stored at path. A missing file is an empty cache.
+func Load(path string) (*Cache, error) {
+	c := &Cache{
+		path:    path,
+		sources: map[string]Source{},
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &c.sources); err != nil {
+		return nil, fmt.Errorf("failed parsing discovery cache %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Get returns the cached RPCs of the source name, if they were discovered with the
+// same fingerprint less than maxAge ago. A maxAge of 0 accepts entries of any age.
+func (c *Cache) Get(name, fingerprint string, maxAge time.Duration) (Source, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.sources[name]
+	if !ok || s.Fingerprint != fingerprint {
+		return Source{}, false
+	}
+	if maxAge > 0 && time.Since(s.DiscoveredAt) > maxAge {
+		return Source{}, false
+	}
+	return s, true
+}
+
+// Put replaces the cached RPCs of the source name; call Save to write them to disk
+func (c *Cache) Put(name string, s Source) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sources[name] = s
+}
+
+// Save writes the cache to disk. The cache is written to a temporary file first, so
+// that a process loading the cache concurrently never reads a partially written file.
+func (c *Cache) Save() error {
+	c.mu.Lock()
+	b, err := json.Marshal(c.sources)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(c.path, b, 0o600)
+}
@@ -0,0 +1,60 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+)
+
+func TestCache(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "arpicee", "discovery.json")
+	c, err := Load(file)
+	if err != nil {
+		t.Fatalf("expected a missing cache to be empty, got %s", err)
+	}
+	entries := []Entry{{
+		Name:        "123456789012:eu-west-1:deploy",
+		Description: "deploys",
+		Provider:    "lambda",
+		Params:      []arpicee.Parameter{{Name: "env", Type: arpicee.TypeString, Required: true, AllowedValues: []string{"prod", "staging"}}},
+		Ref:         "deploy",
+	}}
+	c.Put("lambda eu-west-1", Source{Fingerprint: "v1", DiscoveredAt: time.Now().Add(-time.Hour), Entries: entries})
+	if err := c.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if c, err = Load(file); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, testCase := range []struct {
+		name, fingerprint string
+		maxAge            time.Duration
+		expectFound       bool
+	}{
+		{"lambda eu-west-1", "v1", 2 * time.Hour, true},
+		{"lambda eu-west-1", "v1", 0, true},
+		{"lambda eu-west-1", "v1", time.Minute, false},
+		{"lambda eu-west-1", "v2", 0, false},
+		{"lambda us-east-1", "v1", 0, false},
+	} {
+		s, found := c.Get(testCase.name, testCase.fingerprint, testCase.maxAge)
+		if found != testCase.expectFound {
+			t.Errorf("test %d - expected found to be %t", i, testCase.expectFound)
+		}
+		if found && !reflect.DeepEqual(s.Entries, entries) {
+			t.Errorf("test %d - expected entries %+v, got %+v", i, entries, s.Entries)
+		}
+	}
+
+	if err := os.WriteFile(file, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(file); err == nil {
+		t.Errorf("expected an error loading an invalid cache")
+	}
+}
@@ -0,0 +1,234 @@
+package chat
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/execution"
+	"github.com/yannh/arpicee/pkg/policy"
+	"github.com/yannh/arpicee/pkg/registry"
+)
+
+// Core implements the logic shared by the chat frontends: listing RPCs, parsing the
+// values submitted in dialogs, and running RPCs while reporting on their executions.
+type Core struct {
+	registry   *registry.Registry
+	executions *execution.Manager
+
+	mu            sync.Mutex
+	confirmations *policy.Confirmations
+}
+
+func NewCore(reg *registry.Registry, executions *execution.Manager) *Core {
+	return &Core{
+		registry:   reg,
+		executions: executions,
+	}
+}
+
+// RPCs returns the RPCs of the registry, sorted by name
+func (c *Core) RPCs() []arpicee.RemoteCall {
+	rpcs := c.registry.RPCs()
+	sort.Slice(rpcs, func(i, j int) bool {
+		return rpcs[i].Name() < rpcs[j].Name()
+	})
+	return rpcs
+}
+
+func (c *Core) RPC(name string) arpicee.RemoteCall {
+	return c.registry.RPC(name)
+}
+
+// RPCsIn returns the RPCs available in the chat channel channelID, sorted by name:
+// RPCs restricted to other channels, and RPCs replaced in the channel, are omitted.
+// See arpicee.ChannelRestricted and arpicee.Replacer.
+func (c *Core) RPCsIn(channelID string) []arpicee.RemoteCall {
+	rpcs := c.RPCs()
+	replaced := map[string]bool{}
+	for _, rpc := range rpcs {
+		if r, ok := rpc.(arpicee.Replacer); ok && r.Replaces() != "" && arpicee.AvailableIn(rpc, channelID) {
+			replaced[r.Replaces()] = true
+		}
+	}
+	res := []arpicee.RemoteCall{}
+	for _, rpc := range rpcs {
+		if arpicee.AvailableIn(rpc, channelID) && !replaced[rpc.Name()] {
+			res = append(res, rpc)
+		}
+	}
+	return res
+}
+
+// RPCIn returns the RPC with the given name if it is available in the chat channel
+// channelID, or nil
+func (c *Core) RPCIn(channelID, name string) arpicee.RemoteCall {
+	for _, rpc := range c.RPCsIn(channelID) {
+		if rpc.Name() == name {
+			return rpc
+		}
+	}
+	return nil
+}
+
+// Reload discovers the RPCs again
+func (c *Core) Reload() error {
+	return c.registry.Reload()
+}
+
+// Status returns the status of the discovery sources
+func (c *Core) Status() []registry.SourceStatus {
+	return c.registry.Status()
+}
+
+// Authorize checks if user is allowed to run rpc, before a dialog is opened
+func (c *Core) Authorize(user string, rpc arpicee.RemoteCall) error {
+	return c.executions.Authorize(user, rpc)
+}
+
+// SetConfirmations sets the confirmations required before running RPCs from dialogs
+func (c *Core) SetConfirmations(confirmations *policy.Confirmations) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.confirmations = confirmations
+}
+
+// Confirmation returns the confirmation required before running rpc from a dialog, see
+// policy.Confirmations
+func (c *Core) Confirmation(rpc arpicee.RemoteCall) arpicee.Confirmation {
+	c.mu.Lock()
+	confirmations := c.confirmations
+	c.mu.Unlock()
+	return confirmations.Required(rpc)
+}
+
+// FieldErrors maps the names of the parameters to the errors found in their values,
+// so that chat frontends can show the errors next to the dialog fields.
+type FieldErrors map[string]string
+
+func (e FieldErrors) Error() string {
+	var names []string
+	for name := range e {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var msgs []string
+	for _, name := range names {
+		msgs = append(msgs, e[name])
+	}
+	return strings.Join(msgs, ", ")
+}
+
+// ArgsFromValues parses the values submitted through a dialog. Empty values default to
+// the default value of the parameter, and are omitted if there is none, unless the
+// parameter is a boolean. Errors are returned as FieldErrors.
+func ArgsFromValues(params []arpicee.Parameter, values map[string]string) ([]arpicee.Argument, error) {
+	args := []arpicee.Argument{}
+	errs := FieldErrors{}
+	for _, p := range params {
+		v := strings.TrimSpace(values[p.Name])
+		if v == "" {
+			v = p.Default
+		}
+		if v == "" {
+			switch {
+			case p.Type == arpicee.TypeBool:
+				v = "false"
+			case p.Required:
+				errs[p.Name] = (&arpicee.MissingParameterError{Name: p.Name}).Error()
+				continue
+			default:
+				continue
+			}
+		}
+
+		arg, err := arpicee.ParseArgument(p, v)
+		if err != nil {
+			errs[p.Name] = err.Error()
+			continue
+		}
+		if err := arpicee.ValidateArguments([]arpicee.Argument{arg}, []arpicee.Parameter{p}); err != nil {
+			errs[p.Name] = err.Error()
+			continue
+		}
+		args = append(args, arg)
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return args, nil
+}
+
+// StringValues converts values decoded from JSON, which are strings, numbers or
+// booleans, to the strings expected by ArgsFromValues
+func StringValues(values map[string]interface{}) map[string]string {
+	res := map[string]string{}
+	for k, v := range values {
+		switch val := v.(type) {
+		case string:
+			res[k] = val
+		case bool:
+			res[k] = strconv.FormatBool(val)
+		case float64:
+			res[k] = strconv.FormatFloat(val, 'f', -1, 64)
+		}
+	}
+	return res
+}
+
+// Run starts rpc, and calls update with the state of the execution once it is
+// started, and once it is finished. Run returns when the execution is finished.
+func (c *Core) Run(ctx context.Context, rpc arpicee.RemoteCall, args []arpicee.Argument, user string, update func(execution.Execution)) error {
+	e, err := c.executions.Start(rpc, args, user)
+	if err != nil {
+		return err
+	}
+	update(e)
+
+	id := e.ID
+	if e, err = c.executions.Wait(ctx, id); err != nil {
+		return err
+	}
+	update(e)
+	return nil
+}
+
+// Color returns the colour of the messages reporting on an execution
+func Color(status execution.Status) string {
+	switch status {
+	case execution.StatusSucceeded:
+		return "00CB53"
+	case execution.StatusFailed:
+		return "e5345e"
+	case execution.StatusCancelled:
+		return "a0a0a0"
+	default:
+		return "439fe0"
+	}
+}
+
+// Output returns the result of a finished execution, formatted with its formatString
+func Output(e execution.Execution) string {
+	switch e.Status {
+	case execution.StatusRunning:
+		return "currently running..."
+	case execution.StatusFailed:
+		return e.Error
+	case execution.StatusCancelled:
+		return "the execution was cancelled"
+	}
+
+	if e.Result == nil || e.Result["formatString"] == nil || e.Result["formatString"] == "" {
+		return "the remote procedure you are invoking is missing a formatString property in the return value"
+	}
+	out, err := arpicee.Output(e.Result, "text")
+	if err != nil {
+		return fmt.Sprintf("failed formatting the result: %s", err)
+	}
+	return out
+}
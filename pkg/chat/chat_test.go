@@ -0,0 +1,219 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/execution"
+	"github.com/yannh/arpicee/pkg/mock"
+	"github.com/yannh/arpicee/pkg/policy"
+	"github.com/yannh/arpicee/pkg/registry/registrytest"
+)
+
+func TestArgsFromValues(t *testing.T) {
+	params := []arpicee.Parameter{
+		{Name: "env", Type: arpicee.TypeString, Required: true, AllowedValues: []string{"staging", "production"}},
+		{Name: "replicas", Type: arpicee.TypeInt, Default: "1"},
+		{Name: "dryrun", Type: arpicee.TypeBool},
+	}
+
+	for i, testCase := range []struct {
+		values       map[string]string
+		expectArgs   []arpicee.Argument
+		expectErrors FieldErrors
+	}{
+		{
+			map[string]string{"env": "staging", "replicas": "3", "dryrun": "true"},
+			[]arpicee.Argument{
+				&arpicee.ArgumentString{Name: "env", Val: "staging"},
+				&arpicee.ArgumentInt{Name: "replicas", Val: 3},
+				&arpicee.ArgumentBool{Name: "dryrun", Val: true},
+			},
+			nil,
+		},
+		{
+			map[string]string{"env": " production "},
+			[]arpicee.Argument{
+				&arpicee.ArgumentString{Name: "env", Val: "production"},
+				&arpicee.ArgumentInt{Name: "replicas", Val: 1},
+				&arpicee.ArgumentBool{Name: "dryrun", Val: false},
+			},
+			nil,
+		},
+		{
+			map[string]string{"env": "dev", "replicas": "many"},
+			nil,
+			FieldErrors{
+				"env":      "invalid value for parameter env, allowed values: staging, production",
+				"replicas": "parameter replicas should be a number, could not parse given value: many",
+			},
+		},
+		{
+			map[string]string{},
+			nil,
+			FieldErrors{"env": "parameter env is required"},
+		},
+	} {
+		args, err := ArgsFromValues(params, testCase.values)
+		var fieldErrors FieldErrors
+		errors.As(err, &fieldErrors)
+		if !reflect.DeepEqual(fieldErrors, testCase.expectErrors) {
+			t.Errorf("test %d - expected errors %+v, got %+v", i, testCase.expectErrors, err)
+		}
+		if testCase.expectErrors == nil && !reflect.DeepEqual(args, testCase.expectArgs) {
+			t.Errorf("test %d - expected arguments %+v, got %+v", i, testCase.expectArgs, args)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	reg := registrytest.New(t,
+		mock.New("deploy", nil, nil),
+		mock.New("failing", nil, func(ctx context.Context, args []arpicee.Argument) (map[string]interface{}, error) {
+			return nil, fmt.Errorf("something went wrong")
+		}),
+		mock.New("restricted", nil, nil),
+	)
+	mgr := execution.NewManager(10)
+	p, err := policy.New([]policy.Rule{{RPC: "restricted", Users: []string{"admin"}}})
+	if err != nil {
+		t.Fatalf("failed creating policy: %s", err)
+	}
+	mgr.SetAuthorizer(p)
+	core := NewCore(reg, mgr)
+
+	for i, testCase := range []struct {
+		rpc          string
+		expectStatus []execution.Status
+		expectOutput string
+		expectErr    bool
+	}{
+		{"deploy", []execution.Status{execution.StatusRunning, execution.StatusSucceeded}, "Running deploy", false},
+		{"failing", []execution.Status{execution.StatusRunning, execution.StatusFailed}, "something went wrong", false},
+		{"restricted", nil, "", true},
+	} {
+		var statuses []execution.Status
+		var last execution.Execution
+		err := core.Run(context.Background(), core.RPC(testCase.rpc), nil, "alice", func(e execution.Execution) {
+			statuses = append(statuses, e.Status)
+			last = e
+		})
+		if (err != nil) != testCase.expectErr {
+			t.Errorf("test %d - expected error %t, got %v", i, testCase.expectErr, err)
+		}
+		if !reflect.DeepEqual(statuses, testCase.expectStatus) {
+			t.Errorf("test %d - expected updates %v, got %v", i, testCase.expectStatus, statuses)
+		}
+		if statuses != nil && Output(last) != testCase.expectOutput {
+			t.Errorf("test %d - expected output %q, got %q", i, testCase.expectOutput, Output(last))
+		}
+	}
+}
+
+// channelRPC is an RPC restricted to some channels, replacing another RPC there
+type channelRPC struct {
+	*mock.Mock
+	channels []string
+	replaces string
+}
+
+func (c channelRPC) Channels() []string { return c.channels }
+func (c channelRPC) Replaces() string   { return c.replaces }
+
+func TestRPCsIn(t *testing.T) {
+	reg := registrytest.New(t,
+		mock.New("deploy", nil, nil),
+		mock.New("rollback", nil, nil),
+		channelRPC{mock.New("deploy-staging", nil, nil), []string{"C-staging"}, "deploy"},
+		channelRPC{mock.New("debug", nil, nil), []string{"C-ops", "C-staging"}, ""},
+	)
+	core := NewCore(reg, execution.NewManager(10))
+
+	for i, testCase := range []struct {
+		channel string
+		expect  []string
+	}{
+		{"C-staging", []string{"debug", "deploy-staging", "rollback"}},
+		{"C-ops", []string{"debug", "deploy", "rollback"}},
+		{"C-other", []string{"deploy", "rollback"}},
+	} {
+		var names []string
+		for _, rpc := range core.RPCsIn(testCase.channel) {
+			names = append(names, rpc.Name())
+		}
+		if !reflect.DeepEqual(names, testCase.expect) {
+			t.Errorf("test %d - expected %v, got %v", i, testCase.expect, names)
+		}
+	}
+	if core.RPCIn("C-staging", "deploy") != nil || core.RPCIn("C-ops", "deploy") == nil {
+		t.Errorf("expected deploy to be replaced in C-staging only")
+	}
+}
+
+func TestConfirmOption(t *testing.T) {
+	rpc := mock.New("deploy", nil, nil)
+	for i, testCase := range []struct {
+		confirmation arpicee.Confirmation
+		command      string
+		expectFields []string
+		expect       string
+	}{
+		{arpicee.ConfirmNone, `env=prod`, []string{"env=prod"}, ""},
+		{arpicee.ConfirmArguments, `env=prod`, []string{"env=prod"}, "--yes"},
+		{arpicee.ConfirmArguments, `env=prod --yes`, []string{"env=prod"}, ""},
+		{arpicee.ConfirmArguments, `--yes=deploy env=prod`, []string{"env=prod"}, ""},
+		{arpicee.ConfirmArguments, `--yes=rollback`, nil, "--yes"},
+		{arpicee.ConfirmName, `--yes`, nil, "--yes=deploy"},
+		{arpicee.ConfirmName, `--yes=deploy`, nil, ""},
+	} {
+		fields, _ := SplitFields(testCase.command)
+		fields, yes, found := TakeYes(fields)
+		if !reflect.DeepEqual(fields, testCase.expectFields) {
+			t.Errorf("test %d - expected fields %v, got %v", i, testCase.expectFields, fields)
+		}
+		if got := ConfirmOption(rpc, testCase.confirmation, yes, found); got != testCase.expect {
+			t.Errorf("test %d - expected option %q, got %q", i, testCase.expect, got)
+		}
+	}
+}
+
+func TestArgsFromForm(t *testing.T) {
+	rpc := mock.New("deploy", []arpicee.Parameter{{Name: "env", Type: arpicee.TypeString, Required: true}}, nil)
+	for i, testCase := range []struct {
+		confirmation arpicee.Confirmation
+		values       map[string]string
+		expectFields int
+		expectErrors []string
+	}{
+		{arpicee.ConfirmNone, map[string]string{"env": "prod"}, 1, nil},
+		{arpicee.ConfirmArguments, map[string]string{"env": "prod"}, 2, []string{ConfirmField}},
+		{arpicee.ConfirmArguments, map[string]string{"env": "prod", ConfirmField: "false"}, 2, []string{ConfirmField}},
+		{arpicee.ConfirmArguments, map[string]string{"env": "prod", ConfirmField: "on"}, 2, nil},
+		{arpicee.ConfirmArguments, map[string]string{ConfirmField: "true"}, 2, []string{"env"}},
+		{arpicee.ConfirmName, map[string]string{ConfirmField: "true"}, 2, []string{ConfirmField, "env"}},
+		{arpicee.ConfirmName, map[string]string{"env": "prod", ConfirmField: " deploy "}, 2, nil},
+	} {
+		if fields := FormFields(rpc, testCase.confirmation); len(fields) != testCase.expectFields {
+			t.Errorf("test %d - expected %d fields, got %+v", i, testCase.expectFields, fields)
+		}
+		args, err := ArgsFromForm(rpc, testCase.confirmation, testCase.values)
+		var fieldErrors FieldErrors
+		errors.As(err, &fieldErrors)
+		var got []string
+		for name := range fieldErrors {
+			got = append(got, name)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, testCase.expectErrors) {
+			t.Errorf("test %d - expected errors for %v, got %v", i, testCase.expectErrors, err)
+		}
+		if err == nil && len(args) != 1 {
+			t.Errorf("test %d - expected the argument env, got %+v", i, args)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package chat
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+)
+
+// SplitFields splits s on spaces, except in single or double quoted strings
+func SplitFields(s string) ([]string, error) {
+	var fields []string
+	var cur strings.Builder
+	var quote rune
+	inField := false
+	for _, r := range s {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			cur.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			inField = true
+		case r == ' ' || r == '\t':
+			if inField {
+				fields = append(fields, cur.String())
+				cur.Reset()
+				inField = false
+			}
+		default:
+			cur.WriteRune(r)
+			inField = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quoted string")
+	}
+	if inField {
+		fields = append(fields, cur.String())
+	}
+	return fields, nil
+}
+
+// KeyValues parses arguments in the form param=value
+func KeyValues(fields []string) (map[string]string, error) {
+	values := map[string]string{}
+	for _, f := range fields {
+		kv := strings.SplitN(f, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid argument %s, expected param=value", f)
+		}
+		values[kv[0]] = kv[1]
+	}
+	return values, nil
+}
+
+// ArgsFromCommand converts the values typed in a command to arguments. Unlike
+// ArgsFromValues, unknown parameters are refused, as they are likely typos.
+func ArgsFromCommand(params []arpicee.Parameter, values map[string]string) ([]arpicee.Argument, error) {
+	for name := range values {
+		found := false
+		for _, p := range params {
+			if p.Name == name {
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown parameter %s", name)
+		}
+	}
+	return ArgsFromValues(params, values)
+}
+
+// TakeYes removes the --yes or --yes=NAME option from the fields of a command, and
+// returns its value, and whether it was set
+func TakeYes(fields []string) ([]string, string, bool) {
+	var res []string
+	var yes string
+	found := false
+	for _, f := range fields {
+		if f == "--yes" || strings.HasPrefix(f, "--yes=") {
+			yes, found = strings.TrimPrefix(strings.TrimPrefix(f, "--yes"), "="), true
+			continue
+		}
+		res = append(res, f)
+	}
+	return res, yes, found
+}
+
+// ConfirmOption returns the option a command must have to confirm running rpc, or an
+// empty string if the option yes, see TakeYes, confirms it. Commands can not open
+// dialogs: they confirm with --yes, or --yes=NAME when the name of the RPC must be typed.
+func ConfirmOption(rpc arpicee.RemoteCall, confirmation arpicee.Confirmation, yes string, found bool) string {
+	switch confirmation {
+	case arpicee.ConfirmNone:
+		return ""
+	case arpicee.ConfirmName:
+		if found && yes == rpc.Name() {
+			return ""
+		}
+		return "--yes=" + rpc.Name()
+	}
+	if found && (yes == "" || yes == rpc.Name()) {
+		return ""
+	}
+	return "--yes"
+}
@@ -0,0 +1,118 @@
+package chat
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+)
+
+type FieldKind string
+
+const (
+	FieldText     FieldKind = "text"
+	FieldNumber   FieldKind = "number"
+	FieldPassword FieldKind = "password"
+	FieldSelect   FieldKind = "select"
+	FieldCheckbox FieldKind = "checkbox"
+)
+
+// Field is an input of the form asking for the parameters of an RPC; every
+// frontend renders the fields with its own widgets
+type Field struct {
+	arpicee.Parameter
+	Kind FieldKind
+	// Label is shown next to the input, the name of the parameter for parameters
+	Label string
+}
+
+// Fields returns the form fields for params, sorted by name
+func Fields(params []arpicee.Parameter) []Field {
+	res := []Field{}
+	for _, p := range params {
+		f := Field{Parameter: p, Kind: FieldText, Label: p.Name}
+		switch {
+		case p.Type == arpicee.TypeBool:
+			f.Kind = FieldCheckbox
+		case len(p.AllowedValues) > 0:
+			f.Kind = FieldSelect
+		case p.Secret:
+			f.Kind = FieldPassword
+		case p.Type == arpicee.TypeInt:
+			f.Kind = FieldNumber
+		}
+		res = append(res, f)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// ConfirmField is the name of the field confirming running an RPC, see FormFields
+const ConfirmField = "arpicee_confirm"
+
+// confirmationField returns the field confirming running rpc: a checkbox, or an input
+// the name of the RPC must be typed in. It returns false if rpc requires no confirmation.
+func confirmationField(rpc arpicee.RemoteCall, confirmation arpicee.Confirmation) (Field, bool) {
+	switch confirmation {
+	case arpicee.ConfirmArguments:
+		return Field{
+			Parameter: arpicee.Parameter{Name: ConfirmField, Type: arpicee.TypeBool, Description: "I confirm running " + rpc.Name()},
+			Kind:      FieldCheckbox,
+			Label:     "Confirm",
+		}, true
+	case arpicee.ConfirmName:
+		return Field{
+			Parameter: arpicee.Parameter{Name: ConfirmField, Type: arpicee.TypeString, Required: true, Description: "Type " + rpc.Name() + " to confirm"},
+			Kind:      FieldText,
+			Label:     "Confirm",
+		}, true
+	}
+	return Field{}, false
+}
+
+// CheckConfirmation checks the value submitted in the ConfirmField of the form running
+// rpc. Errors are returned as FieldErrors.
+func CheckConfirmation(rpc arpicee.RemoteCall, confirmation arpicee.Confirmation, value string) error {
+	value = strings.TrimSpace(value)
+	switch confirmation {
+	case arpicee.ConfirmArguments:
+		if value == "" || value == "false" {
+			return FieldErrors{ConfirmField: "check the box to confirm running " + rpc.Name()}
+		}
+	case arpicee.ConfirmName:
+		if value != rpc.Name() {
+			return FieldErrors{ConfirmField: "type " + rpc.Name() + " to confirm"}
+		}
+	}
+	return nil
+}
+
+// FormFields returns the fields of the form running rpc: the fields for its parameters,
+// followed by the ConfirmField when rpc requires confirmation
+func FormFields(rpc arpicee.RemoteCall, confirmation arpicee.Confirmation) []Field {
+	fields := Fields(rpc.Params())
+	if f, ok := confirmationField(rpc, confirmation); ok {
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// ArgsFromForm parses the values submitted in the form running rpc, see FormFields and
+// ArgsFromValues, and checks the value of the ConfirmField. Errors are returned as
+// FieldErrors.
+func ArgsFromForm(rpc arpicee.RemoteCall, confirmation arpicee.Confirmation, values map[string]string) ([]arpicee.Argument, error) {
+	args, err := ArgsFromValues(rpc.Params(), values)
+	confirmErr := CheckConfirmation(rpc, confirmation, values[ConfirmField])
+	if confirmErr == nil {
+		return args, err
+	}
+	errs := confirmErr.(FieldErrors)
+	if fe, ok := err.(FieldErrors); ok {
+		for name, msg := range fe {
+			errs[name] = msg
+		}
+	}
+	return nil, errs
+}
@@ -0,0 +1,257 @@
+package completion
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/atomicfile"
+)
+
+// RPC is the description of a discovered RemoteCall, as stored in a Snapshot
+type RPC struct {
+	Name        string
+	Description string
+	Params      []arpicee.Parameter
+}
+
+// Snapshot is a copy of the RPCs found during the last discovery. Shell completion
+// is served from the snapshot, as running discovery on every keypress would be too slow.
+type Snapshot struct {
+	CreatedAt time.Time
+	RPCs      []RPC
+}
+
+// Commands are the subcommands of the arpicee CLI
+var Commands = []string{"completion", "config", "describe", "fanout", "list", "mcp", "refresh", "run"}
+
+// Shells are the shells completion scripts can be generated for
+var Shells = []string{"bash", "fish", "zsh"}
+
+var commonFlags = []string{"-h", "-output", "-yes"}
+
+func NewSnapshot(rpcs []arpicee.RemoteCall) *Snapshot {
+	s := &Snapshot{
+		CreatedAt: time.Now(),
+	}
+	for _, rpc := range rpcs {
+		s.RPCs = append(s.RPCs, RPC{
+			Name:        rpc.Name(),
+			Description: rpc.Description(),
+			Params:      rpc.Params(),
+		})
+	}
+	sort.Slice(s.RPCs, func(i, j int) bool {
+		return s.RPCs[i].Name < s.RPCs[j].Name
+	})
+	return s
+}
+
+// DefaultPath returns the location of the snapshot in the user cache directory
+func DefaultPath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "arpicee", "completion.json"), nil
+}
+
+func Load(path string) (*Snapshot, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var s Snapshot
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("failed parsing completion snapshot %s: %w", path, err)
+	}
+	return &s, nil
+}
+
+// Save writes the snapshot to path. The snapshot is written to a temporary file first,
+// so that a completion running concurrently never reads a partially written file.
+func (s *Snapshot) Save(path string) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(path, b, 0o600)
+}
+
+// Stale returns true if the snapshot is older than ttl
+func (s *Snapshot) Stale(ttl time.Duration) bool {
+	return time.Since(s.CreatedAt) > ttl
+}
+
+func (s *Snapshot) rpc(name string) *RPC {
+	for i := range s.RPCs {
+		if s.RPCs[i].Name == name {
+			return &s.RPCs[i]
+		}
+	}
+	return nil
+}
+
+func filterPrefix(candidates []string, prefix string) []string {
+	res := []string{}
+	for _, c := range candidates {
+		if strings.HasPrefix(c, prefix) {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+func paramByFlag(params []arpicee.Parameter, flag string) *arpicee.Parameter {
+	name := strings.TrimLeft(flag, "-")
+	for i := range params {
+		if params[i].Name == name {
+			return &params[i]
+		}
+	}
+	return nil
+}
+
+func completeRunFlags(rpc *RPC, previous []string, cur string) []string {
+	// -param=value
+	if strings.HasPrefix(cur, "-") && strings.Contains(cur, "=") {
+		parts := strings.SplitN(cur, "=", 2)
+		p := paramByFlag(rpc.Params, parts[0])
+		if p == nil {
+			return []string{}
+		}
+		var values []string
+		for _, v := range p.AllowedValues {
+			values = append(values, parts[0]+"="+v)
+		}
+		return filterPrefix(values, cur)
+	}
+
+	// -param value
+	if len(previous) > 0 {
+		prev := previous[len(previous)-1]
+		if strings.HasPrefix(prev, "-") && !strings.Contains(prev, "=") {
+			if p := paramByFlag(rpc.Params, prev); p != nil && p.Type != arpicee.TypeBool {
+				return filterPrefix(p.AllowedValues, cur)
+			}
+		}
+	}
+
+	used := map[string]bool{}
+	for _, w := range previous {
+		used[strings.SplitN(w, "=", 2)[0]] = true
+	}
+	flags := []string{}
+	for _, p := range rpc.Params {
+		if f := "-" + p.Name; !used[f] {
+			flags = append(flags, f)
+		}
+	}
+	sort.Strings(flags)
+	for _, f := range commonFlags {
+		if !used[f] {
+			flags = append(flags, f)
+		}
+	}
+	return filterPrefix(flags, cur)
+}
+
+// Complete returns the completion candidates for the command line words, the first
+// word being the subcommand and the last one the word currently being completed.
+func (s *Snapshot) Complete(words []string) []string {
+	if len(words) == 0 {
+		return Commands
+	}
+	cur := words[len(words)-1]
+	previous := words[:len(words)-1]
+	if len(previous) == 0 {
+		return filterPrefix(Commands, cur)
+	}
+
+	switch previous[0] {
+	case "completion":
+		if len(previous) == 1 {
+			return filterPrefix(Shells, cur)
+		}
+	case "config":
+		if len(previous) == 1 {
+			return filterPrefix([]string{"validate"}, cur)
+		}
+	case "describe", "run":
+		if len(previous) == 1 {
+			names := []string{}
+			for _, rpc := range s.RPCs {
+				names = append(names, rpc.Name)
+			}
+			return filterPrefix(names, cur)
+		}
+		if rpc := s.rpc(previous[1]); rpc != nil && previous[0] == "run" {
+			return completeRunFlags(rpc, previous[2:], cur)
+		}
+	}
+
+	return []string{}
+}
+
+// bashScript splits the words from COMP_LINE, as COMP_WORDS is also split on the
+// characters of COMP_WORDBREAKS, such as the ':' of qualified names. Bash replaces
+// the text after the last of these characters, which is trimmed from the candidates.
+const bashScript = `# bash completion for {{prog}}
+_{{fn}}_complete() {
+    local line="${COMP_LINE:0:COMP_POINT}" words cur prefix
+    read -ra words <<< "$line"
+    [[ -z $line || $line == *[[:space:]] ]] && words+=("")
+    cur="${words[${#words[@]}-1]}"
+    local IFS=$'\n'
+    COMPREPLY=($({{prog}} __complete "${words[@]:1}" 2>/dev/null))
+    prefix="${cur%"${cur##*[:=]}"}"
+    if [[ -n $prefix && $COMP_WORDBREAKS == *"${prefix: -1}"* ]]; then
+        COMPREPLY=("${COMPREPLY[@]#"$prefix"}")
+    fi
+}
+complete -o default -F _{{fn}}_complete {{prog}}
+`
+
+const zshScript = `#compdef {{prog}}
+_{{fn}}() {
+    local -a completions
+    completions=("${(@f)$({{prog}} __complete "${(@)words[2,$CURRENT]}" 2>/dev/null)}")
+    compadd -- $completions
+}
+compdef _{{fn}} {{prog}}
+`
+
+const fishScript = `# fish completion for {{prog}}
+complete -c {{prog}} -f -a '({{prog}} __complete (commandline -opc)[2..-1] (commandline -ct) 2>/dev/null)'
+`
+
+// Script returns the completion script for shell; the script calls "prog __complete"
+func Script(shell, prog string) (string, error) {
+	var script string
+	switch shell {
+	case "bash":
+		script = bashScript
+	case "zsh":
+		script = zshScript
+	case "fish":
+		script = fishScript
+	default:
+		return "", fmt.Errorf("unsupported shell %s, supported shells: %s", shell, strings.Join(Shells, ", "))
+	}
+
+	fn := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return '_'
+	}, prog)
+	return strings.NewReplacer("{{prog}}", prog, "{{fn}}", fn).Replace(script), nil
+}
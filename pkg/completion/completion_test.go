@@ -0,0 +1,184 @@
+package completion
+
+import (
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+)
+
+func TestComplete(t *testing.T) {
+	s := &Snapshot{
+		RPCs: []RPC{
+			{
+				Name: "deploy",
+				Params: []arpicee.Parameter{
+					{Name: "env", Type: arpicee.TypeString, AllowedValues: []string{"staging", "production"}},
+					{Name: "dryrun", Type: arpicee.TypeBool},
+				},
+			},
+			{
+				Name: "purge-cache",
+			},
+		},
+	}
+
+	for i, testCase := range []struct {
+		words  []string
+		expect []string
+	}{
+		{
+			[]string{""},
+			[]string{"completion", "config", "describe", "fanout", "list", "mcp", "refresh", "run"},
+		},
+		{
+			[]string{"r"},
+			[]string{"refresh", "run"},
+		},
+		{
+			[]string{"completion", ""},
+			[]string{"bash", "fish", "zsh"},
+		},
+		{
+			[]string{"config", ""},
+			[]string{"validate"},
+		},
+		{
+			[]string{"run", ""},
+			[]string{"deploy", "purge-cache"},
+		},
+		{
+			[]string{"run", "de"},
+			[]string{"deploy"},
+		},
+		{
+			[]string{"describe", "p"},
+			[]string{"purge-cache"},
+		},
+		{
+			[]string{"describe", "deploy", ""},
+			[]string{},
+		},
+		{
+			[]string{"run", "deploy", ""},
+			[]string{"-dryrun", "-env", "-h", "-output", "-yes"},
+		},
+		{
+			[]string{"run", "deploy", "-dryrun", "-"},
+			[]string{"-env", "-h", "-output", "-yes"},
+		},
+		{
+			[]string{"run", "deploy", "-y"},
+			[]string{"-yes"},
+		},
+		{
+			[]string{"run", "deploy", "-env", ""},
+			[]string{"staging", "production"},
+		},
+		{
+			[]string{"run", "deploy", "-env", "st"},
+			[]string{"staging"},
+		},
+		{
+			[]string{"run", "deploy", "-env=p"},
+			[]string{"-env=production"},
+		},
+		{
+			[]string{"run", "unknown", ""},
+			[]string{},
+		},
+	} {
+		got := s.Complete(testCase.words)
+		if !reflect.DeepEqual(got, testCase.expect) {
+			t.Errorf("test %d - expected %+v, got %+v", i, testCase.expect, got)
+		}
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "arpicee", "completion.json")
+	s := &Snapshot{
+		RPCs: []RPC{
+			{
+				Name:        "deploy",
+				Description: "deploys things",
+				Params: []arpicee.Parameter{
+					{Name: "env", Type: arpicee.TypeString, Required: true, AllowedValues: []string{"staging"}},
+				},
+			},
+		},
+	}
+	if err := s.Save(path); err != nil {
+		t.Fatalf("failed saving snapshot: %s", err)
+	}
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("failed loading snapshot: %s", err)
+	}
+	if !reflect.DeepEqual(got.RPCs, s.RPCs) {
+		t.Errorf("expected %+v, got %+v", s.RPCs, got.RPCs)
+	}
+}
+
+func TestScript(t *testing.T) {
+	for _, shell := range Shells {
+		script, err := Script(shell, "arpicee")
+		if err != nil {
+			t.Errorf("failed generating %s script: %s", shell, err)
+		}
+		if !strings.Contains(script, "arpicee __complete") {
+			t.Errorf("%s completion script does not call __complete: %s", shell, script)
+		}
+	}
+
+	if _, err := Script("powershell", "arpicee"); err == nil {
+		t.Errorf("expected an error for an unsupported shell")
+	}
+}
+
+func TestBashScript(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash is not installed")
+	}
+	script, err := Script("bash", "arpicee")
+	if err != nil {
+		t.Fatalf("failed generating bash script: %s", err)
+	}
+	// arpicee stands in for the CLI, returning candidates for the words it is passed
+	fake := `arpicee() {
+    local IFS=' '
+    case "$*" in
+        "__complete ru") echo run ;;
+        "__complete run prod:de") printf '%s\n' prod:deploy prod:destroy ;;
+        "__complete run prod:deploy -env=st") echo -env=staging ;;
+        "__complete run prod:deploy ") printf '%s\n' -env -h ;;
+    esac
+}
+`
+
+	for i, testCase := range []struct {
+		line   string
+		expect []string
+	}{
+		{"arpicee ru", []string{"run"}},
+		{"arpicee run prod:de", []string{"deploy", "destroy"}},
+		{"arpicee run prod:deploy -env=st", []string{"staging"}},
+		{"arpicee run prod:deploy ", []string{"-env", "-h"}},
+	} {
+		cmd := exec.Command("bash", "-c", fake+script+`
+COMP_LINE="$1"
+COMP_POINT=${#COMP_LINE}
+_arpicee_complete
+printf '%s\n' "${COMPREPLY[@]}"`, "bash", testCase.line)
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("test %d - failed running completion: %s", i, err)
+		}
+		if got := strings.Fields(string(out)); !reflect.DeepEqual(got, testCase.expect) {
+			t.Errorf("test %d - expected %q, got %q", i, testCase.expect, got)
+		}
+	}
+}
@@ -0,0 +1,603 @@
+package config
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	awsLambda "github.com/aws/aws-sdk-go/service/lambda"
+	awsSSM "github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/sts"
+	"github.com/google/go-github/v50/github"
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/cache"
+	"github.com/yannh/arpicee/pkg/fanout"
+	"github.com/yannh/arpicee/pkg/filter"
+	"github.com/yannh/arpicee/pkg/githubrpc"
+	"github.com/yannh/arpicee/pkg/lambdarpc"
+	"github.com/yannh/arpicee/pkg/override"
+	"github.com/yannh/arpicee/pkg/pipeline"
+	"github.com/yannh/arpicee/pkg/policy"
+	"github.com/yannh/arpicee/pkg/registry"
+	"github.com/yannh/arpicee/pkg/ssmrpc"
+	"golang.org/x/oauth2"
+)
+
+// AWSSource configures the AWS account and region of a discovery source. Region and
+// Profile default to the AWS_REGION and AWS_PROFILE env vars. When RoleARN is set, the
+// role is assumed with ExternalID and SessionName (default "arpicee").
+// When Qualify is set, the names of the RPCs are prefixed with "ACCOUNT:REGION:", for
+// example "123456789012:eu-west-1:deploy", to tell apart functions and documents with
+// the same name; ACCOUNT defaults to the ID of the AWS account.
+type AWSSource struct {
+	Region      string
+	Profile     string
+	RoleARN     string
+	ExternalID  string
+	SessionName string
+	Account     string
+	Qualify     bool
+}
+
+// LambdaDiscovery discovers the Lambda functions with the tags of TagFilter, and
+// matching Filter
+type LambdaDiscovery struct {
+	AWSSource
+	TagFilter map[string]string
+	Filter    filter.Filter
+}
+
+// SSMDiscovery discovers the SSM documents with the tags of TagFilter, and matching
+// Filter
+type SSMDiscovery struct {
+	AWSSource
+	TagFilter map[string]string
+	Filter    filter.Filter
+}
+
+// GithubDiscovery discovers the workflows of Repo, "owner/repo", matching Filter on
+// their name. When Workflow is set, only the workflow with that name is discovered.
+type GithubDiscovery struct {
+	Repo     string
+	Workflow string
+	Filter   filter.Filter
+}
+
+// matcher returns the Matcher selecting the items with the tags of tagFilter, matching
+// f, and named name if it is set
+func matcher(tagFilter map[string]string, f filter.Filter, name string) (filter.Matcher, error) {
+	m, err := f.Compile()
+	if err != nil {
+		return nil, err
+	}
+	matchers := []filter.Matcher{m}
+	for k, v := range tagFilter {
+		matchers = append(matchers, filter.Tag(k, v))
+	}
+	if name != "" {
+		matchers = append(matchers, filter.NameIs(name))
+	}
+	return filter.And(matchers...), nil
+}
+
+type HTTPToken struct {
+	Name  string
+	Token string `config:"secret"`
+}
+
+// WebUI users are identified by a header set by a reverse proxy, or by a fixed DevUser
+type WebUI struct {
+	Enabled    bool
+	UserHeader string
+	DevUser    string
+}
+
+// HTTP configures the HTTP frontends; they are disabled when Addr is empty.
+// The REST API is enabled when tokens are configured.
+type HTTP struct {
+	Addr   string
+	Tokens []HTTPToken
+	WebUI  WebUI
+}
+
+// GRPC configures the gRPC API, disabled when Addr is empty. Clients authenticate with
+// the HTTP API tokens. TLS is enabled when CertFile and KeyFile are set.
+type GRPC struct {
+	Addr     string
+	CertFile string
+	KeyFile  string
+}
+
+// Webhook triggers the RPC when a JSON payload is posted to the HTTP server at
+// "/webhooks/" + Name, see webhook.Hook. Executions are attributed to the user
+// "webhook:" + Name in access rules.
+type Webhook struct {
+	Name            string
+	RPC             string
+	Secret          string `config:"secret"`
+	SignatureHeader string
+	Filter          string
+	Arguments       map[string]string
+	SlackChannel    string
+}
+
+// ScheduledJob runs RPC with Arguments on Schedule, a cron expression such as
+// "0 3 * * 1-5", evaluated in TimeZone (default UTC), see scheduler.Job. Jitter is a
+// duration such as "5m", and Overlap one of skip (default), queue or allow. Executions
+// are attributed to the user "schedule:" + Name in access rules.
+type ScheduledJob struct {
+	Name         string
+	Schedule     string
+	TimeZone     string
+	RPC          string
+	Arguments    map[string]string
+	Jitter       string
+	Overlap      string
+	CatchUp      bool
+	SlackChannel string
+}
+
+// Scheduler configures the scheduled jobs. Jobs added with the "/arpicee schedule"
+// Slack command, paused jobs and the history of the runs are kept in StateFile, and
+// lost on restart if it is not set.
+type Scheduler struct {
+	StateFile string
+	Jobs      []ScheduledJob
+}
+
+// Queue configures the queue of the RPCs invoked through Slack. Jobs are kept in the
+// directory Dir (default "queue", relative to the working directory) until they
+// finish, so that they survive restarts, and run by up to Workers workers (default 4).
+// Dir must be writable, and on a persistent volume when running in a container.
+type Queue struct {
+	Dir     string
+	Workers int
+}
+
+// Reload configures the periodic rediscovery of the RPCs every Interval, a duration
+// such as "15m", and the reload of the configuration file when it changes, if
+// WatchConfig is set. Only the discovery sources, pipelines, access rules, confirm rules
+// and targets are reloaded; other settings require a restart. Changes to the RPCs are logged, and
+// posted to SlackChannel if set.
+type Reload struct {
+	Interval     string
+	WatchConfig  bool
+	SlackChannel string
+}
+
+// Cache configures the on-disk cache of the discovered RPCs, stored in File. On startup,
+// the RPCs cached less than TTL ago, a duration such as "12h" (default 24h), are used
+// right away and discovered again in the background. The RPCs of sources whose settings
+// changed since are not used. The cache is enabled unless Disabled is set.
+type Cache struct {
+	File     string
+	TTL      string
+	Disabled bool
+}
+
+// DefaultCacheTTL is the maximum age of the cached RPCs when Cache.TTL is not set
+const DefaultCacheTTL = 24 * time.Hour
+
+// Open returns the cache configured in c, stored in defaultFile unless File is set, and
+// its TTL; it returns a nil cache if the cache is disabled
+func (c Cache) Open(defaultFile string) (*cache.Cache, time.Duration, error) {
+	if c.Disabled {
+		return nil, 0, nil
+	}
+	ttl := DefaultCacheTTL
+	if c.TTL != "" {
+		// The TTL was validated when loading the configuration
+		ttl, _ = time.ParseDuration(c.TTL)
+	}
+	file := c.File
+	if file == "" {
+		file = defaultFile
+	}
+	dc, err := cache.Load(file)
+	return dc, ttl, err
+}
+
+// AccessRule restricts the RPCs matching the glob pattern RPC to the listed users, "*"
+// for anyone. Users are qualified by the frontend they use, so that users of different
+// frontends can not be mistaken for each other:
+//   - Slack: the user ID, such as "U0123ABCD", without prefix
+//   - Mattermost: "mattermost:" + user ID
+//   - Discord: "discord:" + user ID
+//   - Teams: "teams:" + Azure AD object ID
+//   - GitHub comments: "github:" + login
+//   - HTTP, gRPC and MCP APIs: "token:" + token name
+//   - web UI: "web:" + user
+//   - webhooks and scheduled jobs: "webhook:" + name and "schedule:" + name
+//   - MCP over stdio: the user set in the mcp configuration
+type AccessRule struct {
+	RPC   string
+	Users []string
+}
+
+// ConfirmRule requires a confirmation before running the RPCs matching the glob pattern
+// RPC from any frontend: none, arguments or name. The first matching rule wins over the
+// metadata of the RPCs, see policy.Confirmations. MCP does not expose the RPCs
+// requiring a confirmation.
+type ConfirmRule struct {
+	RPC     string
+	Confirm string
+}
+
+// MCP exposes the RPCs matching the glob patterns in Tools to AI assistants.
+// Executions through the stdio transport are attributed to User.
+type MCP struct {
+	Tools []string
+	User  string
+}
+
+// Mattermost configures the Mattermost bot, served by the HTTP server. The slash command
+// must be configured in Mattermost with the URL PublicURL + "/mattermost/command".
+type Mattermost struct {
+	URL          string
+	BotToken     string `config:"secret"`
+	CommandToken string `config:"secret"`
+	PublicURL    string
+}
+
+// Discord configures the Discord bot, served by the HTTP server. The interactions endpoint
+// URL of the application must be set to the public URL of the server + "/discord/interactions".
+// Commands are registered in the guild GuildID, or globally if empty.
+type Discord struct {
+	ApplicationID string
+	PublicKey     string
+	BotToken      string `config:"secret"`
+	GuildID       string
+}
+
+// GithubChatOps runs RPCs on comments like "/arpicee run deploy env=staging" posted on
+// issues and pull requests, when WebhookSecret is set. Repository webhooks must send
+// issue_comment events to the public URL of the HTTP server + "/github/webhook".
+// Commenters need at least the MinPermission permission on the repository (default
+// write). Results are posted as a comment, or as a check run on pull requests if Reply
+// is "check-run", which requires GITHUB_TOKEN to be a GitHub App token.
+type GithubChatOps struct {
+	WebhookSecret string `config:"secret"`
+	MinPermission string
+	Reply         string
+}
+
+// Teams configures the Microsoft Teams bot, served by the HTTP server. The messaging
+// endpoint of the Azure bot must be set to the public URL of the server + "/teams/messages".
+// TenantID is only required for single tenant bots.
+type Teams struct {
+	AppID       string
+	AppPassword string `config:"secret"`
+	TenantID    string
+}
+
+// Slack configures the Slack bot. The tokens default to the SLACK_APP_TOKEN and
+// SLACK_BOT_TOKEN env vars.
+type Slack struct {
+	AppToken string `config:"secret"`
+	BotToken string `config:"secret"`
+}
+
+// Config is the configuration of arpicee, see Load. GithubToken authenticates the
+// GitHub discovery and bot, and defaults to the GITHUB_TOKEN env var.
+type Config struct {
+	Lambda     []LambdaDiscovery
+	Ssm        []SSMDiscovery
+	Github     []GithubDiscovery
+	Manifests  []ManifestDiscovery
+	HTTP       HTTP
+	GRPC       GRPC
+	Pipelines  []pipeline.Definition
+	Overrides  []override.Definition
+	Targets    map[string][]fanout.Target
+	Webhooks   []Webhook
+	Scheduler  Scheduler
+	Queue      Queue
+	Reload     Reload
+	Cache      Cache
+	Access     []AccessRule
+	Confirm    []ConfirmRule
+	MCP        MCP
+	Mattermost Mattermost
+	Discord    Discord
+	Teams      Teams
+	Slack      Slack
+
+	GithubChatOps GithubChatOps
+	GithubToken   string `config:"secret"`
+}
+
+// awsSession returns a session with the credentials and region of src
+func awsSession(src AWSSource) (*session.Session, error) {
+	region := src.Region
+	if region == "" {
+		var found bool
+		if region, found = os.LookupEnv("AWS_REGION"); !found {
+			region = "us-east-1"
+			// warn for environments where lambdas might not be deployed in this region
+			log.Printf("WARN: AWS_REGION env var not found; using %s as default\n", region)
+		}
+	}
+
+	awsProfile := src.Profile
+	if awsProfile == "" {
+		var found bool
+		if awsProfile, found = os.LookupEnv("AWS_PROFILE"); !found && src.RoleARN == "" {
+			log.Println("WARN: AWS_PROFILE env var not found; invoking lambda might not work as expected")
+		}
+	}
+
+	sess, err := session.NewSessionWithOptions(session.Options{
+		Config: aws.Config{
+			Region: aws.String(region),
+		},
+		SharedConfigState: session.SharedConfigEnable,
+		Profile:           awsProfile,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error creating AWS session: %w", err)
+	}
+
+	if src.RoleARN != "" {
+		sessionName := src.SessionName
+		if sessionName == "" {
+			sessionName = "arpicee"
+		}
+		creds := stscreds.NewCredentials(sess, src.RoleARN, func(p *stscreds.AssumeRoleProvider) {
+			p.RoleSessionName = sessionName
+			if src.ExternalID != "" {
+				p.ExternalID = aws.String(src.ExternalID)
+			}
+		})
+		sess = sess.Copy(&aws.Config{Credentials: creds})
+	}
+	return sess, nil
+}
+
+// qualifier returns the prefix of the names of the RPCs of src, see AWSSource
+func qualifier(src AWSSource, sess *session.Session) (string, error) {
+	if !src.Qualify {
+		return "", nil
+	}
+	account := src.Account
+	if account == "" {
+		identity, err := sts.New(sess).GetCallerIdentity(&sts.GetCallerIdentityInput{})
+		if err != nil {
+			return "", fmt.Errorf("failed retrieving AWS account ID: %w", err)
+		}
+		account = aws.StringValue(identity.Account)
+	}
+	return fmt.Sprintf("%s:%s:", account, aws.StringValue(sess.Config.Region)), nil
+}
+
+// GithubClient returns a GitHub client authenticated with the GitHub token
+func (c *Config) GithubClient(ctx context.Context) *github.Client {
+	token := c.GithubToken
+	if token == "" {
+		token = os.Getenv("GITHUB_TOKEN")
+	}
+	ts := oauth2.StaticTokenSource(
+		&oauth2.Token{AccessToken: token},
+	)
+	return github.NewClient(oauth2.NewClient(ctx, ts))
+}
+
+// awsSourceName returns the name of the discovery source of kind lambda or ssm, such
+// as "lambda eu-west-1 role arn:aws:iam::123456789012:role/arpicee"
+func awsSourceName(kind string, src AWSSource, sess *session.Session) string {
+	name := kind + " " + aws.StringValue(sess.Config.Region)
+	if src.Profile != "" {
+		name += " profile " + src.Profile
+	}
+	if src.RoleARN != "" {
+		name += " role " + src.RoleARN
+	}
+	return name
+}
+
+// fingerprint identifies the settings of a discovery source, to invalidate its cached
+// RPCs when the settings change
+func fingerprint(settings ...interface{}) string {
+	b, _ := json.Marshal(settings)
+	return fmt.Sprintf("%x", sha256.Sum256(b))
+}
+
+// restoreEach restores the RPCs of cached entries with f
+func restoreEach(entries []cache.Entry, f func(e cache.Entry) (arpicee.RemoteCall, error)) ([]arpicee.RemoteCall, error) {
+	var rpcs []arpicee.RemoteCall
+	for _, e := range entries {
+		rpc, err := f(e)
+		if err != nil {
+			return nil, fmt.Errorf("failed restoring %s: %w", e.Name, err)
+		}
+		rpcs = append(rpcs, rpc)
+	}
+	return rpcs, nil
+}
+
+// Sources returns the discovery sources declared in the configuration
+func (c *Config) Sources(ctx context.Context) ([]registry.Source, error) {
+	var sources []registry.Source
+	// add adds a source, numbering sources with the same name
+	add := func(src registry.Source) {
+		n := 1
+		for _, s := range sources {
+			if strings.SplitN(s.Name, " #", 2)[0] == src.Name {
+				n++
+			}
+		}
+		if n > 1 {
+			src.Name = fmt.Sprintf("%s #%d", src.Name, n)
+		}
+		sources = append(sources, src)
+	}
+
+	for _, d := range c.Lambda {
+		d := d
+		sess, err := awsSession(d.AWSSource)
+		if err != nil {
+			return nil, err
+		}
+		lambdaSvc := awsLambda.New(sess)
+		match, err := matcher(d.TagFilter, d.Filter, "")
+		if err != nil {
+			return nil, err
+		}
+		add(registry.Source{
+			Name: awsSourceName("lambda", d.AWSSource, sess),
+			Discover: func() ([]arpicee.RemoteCall, error) {
+				q, err := qualifier(d.AWSSource, sess)
+				if err != nil {
+					return nil, err
+				}
+				r, err := lambdarpc.Discover(lambdaSvc, match)
+				var rpcs []arpicee.RemoteCall
+				for _, ra := range r {
+					ra.Qualify(q)
+					rpcs = append(rpcs, ra)
+				}
+				return rpcs, err
+			},
+			Fingerprint: fingerprint("lambda", aws.StringValue(sess.Config.Region), d),
+			Restore: func(entries []cache.Entry) ([]arpicee.RemoteCall, error) {
+				return restoreEach(entries, func(e cache.Entry) (arpicee.RemoteCall, error) {
+					ra := lambdarpc.Restore(lambdaSvc, e.Ref, e.Description, e.Params, e.Metadata)
+					ra.Qualify(strings.TrimSuffix(e.Name, e.Ref))
+					return ra, nil
+				})
+			},
+		})
+	}
+
+	for _, d := range c.Ssm {
+		d := d
+		sess, err := awsSession(d.AWSSource)
+		if err != nil {
+			return nil, err
+		}
+		ssmSvc := awsSSM.New(sess)
+		match, err := matcher(d.TagFilter, d.Filter, "")
+		if err != nil {
+			return nil, err
+		}
+		add(registry.Source{
+			Name: awsSourceName("ssm", d.AWSSource, sess),
+			Discover: func() ([]arpicee.RemoteCall, error) {
+				q, err := qualifier(d.AWSSource, sess)
+				if err != nil {
+					return nil, err
+				}
+				r, err := ssmrpc.Discover(ssmSvc, match)
+				var rpcs []arpicee.RemoteCall
+				for _, ra := range r {
+					ra.Qualify(q)
+					rpcs = append(rpcs, ra)
+				}
+				return rpcs, err
+			},
+			Fingerprint: fingerprint("ssm", aws.StringValue(sess.Config.Region), d),
+			Restore: func(entries []cache.Entry) ([]arpicee.RemoteCall, error) {
+				return restoreEach(entries, func(e cache.Entry) (arpicee.RemoteCall, error) {
+					ra := ssmrpc.Restore(ssmSvc, e.Ref, e.Description, e.Params, e.Metadata)
+					ra.Qualify(strings.TrimSuffix(e.Name, e.Ref))
+					return ra, nil
+				})
+			},
+		})
+	}
+
+	if len(c.Github) > 0 {
+		gc := c.GithubClient(ctx)
+		for _, g := range c.Github {
+			b := strings.Split(g.Repo, "/")
+			if len(b) != 2 {
+				return nil, fmt.Errorf("invalid github repo %s, expected owner/repo", g.Repo)
+			}
+			owner, repo := b[0], b[1]
+			match, err := matcher(nil, g.Filter, g.Workflow)
+			if err != nil {
+				return nil, err
+			}
+			add(registry.Source{
+				Name: "github " + g.Repo,
+				Discover: func() ([]arpicee.RemoteCall, error) {
+					r, err := githubrpc.Discover(ctx, gc, owner, repo, match)
+					var rpcs []arpicee.RemoteCall
+					for _, ra := range r {
+						rpcs = append(rpcs, ra)
+					}
+					return rpcs, err
+				},
+				Fingerprint: fingerprint("github", g),
+				Restore: func(entries []cache.Entry) ([]arpicee.RemoteCall, error) {
+					return restoreEach(entries, func(e cache.Entry) (arpicee.RemoteCall, error) {
+						id, err := strconv.ParseInt(e.Ref, 10, 64)
+						if err != nil {
+							return nil, err
+						}
+						return githubrpc.Restore(ctx, gc, owner, repo, id, e.Name, e.Description, e.Params, e.Metadata), nil
+					})
+				},
+			})
+		}
+	}
+
+	for _, d := range c.Manifests {
+		add(manifestSource(d))
+	}
+
+	return sources, nil
+}
+
+// PipelineRPCs returns the pipelines defined in the configuration, see pipeline.Definition.
+// Their steps run the RPCs returned by lookup.
+func (c *Config) PipelineRPCs(lookup func(name string) arpicee.RemoteCall) ([]arpicee.RemoteCall, error) {
+	var rpcs []arpicee.RemoteCall
+	for _, d := range c.Pipelines {
+		p, err := pipeline.New(d, lookup)
+		if err != nil {
+			return nil, err
+		}
+		rpcs = append(rpcs, p)
+	}
+	return rpcs, nil
+}
+
+// OverrideRPCs returns the RPCs derived from discovered RPCs, see override.Definition
+func (c *Config) OverrideRPCs(lookup func(name string) arpicee.RemoteCall) ([]arpicee.RemoteCall, error) {
+	var rpcs []arpicee.RemoteCall
+	for _, d := range c.Overrides {
+		o, err := override.New(d, lookup)
+		if err != nil {
+			return nil, err
+		}
+		rpcs = append(rpcs, o)
+	}
+	return rpcs, nil
+}
+
+// Policy returns the access policy defined by the access rules
+func (c *Config) Policy() (*policy.Policy, error) {
+	var rules []policy.Rule
+	for _, r := range c.Access {
+		rules = append(rules, policy.Rule{RPC: r.RPC, Users: r.Users})
+	}
+	return policy.New(rules)
+}
+
+// Confirmations returns the confirmations required by the confirm rules
+func (c *Config) Confirmations() (*policy.Confirmations, error) {
+	var rules []policy.ConfirmRule
+	for _, r := range c.Confirm {
+		rules = append(rules, policy.ConfirmRule{RPC: r.RPC, Confirm: arpicee.Confirmation(r.Confirm)})
+	}
+	return policy.NewConfirmations(rules)
+}
@@ -0,0 +1,349 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+)
+
+func TestLoad(t *testing.T) {
+	t.Setenv("ARPICEE_TEST_REGION", "eu-west-1")
+	t.Setenv("ARPICEE_TEST_TOKEN", "s3cr3t")
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secretFile, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := Config{
+		Lambda: []LambdaDiscovery{{
+			AWSSource: AWSSource{Region: "eu-west-1", RoleARN: "arn:aws:iam::123456789012:role/arpicee", ExternalID: "s3cr3t", Qualify: true},
+			TagFilter: map[string]string{"arpicee": "1"},
+		}},
+		HTTP: HTTP{
+			Addr:   ":8080",
+			Tokens: []HTTPToken{{Name: "ci", Token: "s3cr3t"}, {Name: "ops", Token: "from-file"}},
+			WebUI:  WebUI{Enabled: true},
+		},
+		Queue: Queue{Workers: 2},
+	}
+	for i, testCase := range []struct {
+		fileName string
+		content  string
+	}{
+		{
+			"config.json",
+			`{
+  "lambda": [{
+    "region": "${ARPICEE_TEST_REGION}",
+    "roleARN": "arn:aws:iam::123456789012:role/arpicee",
+    "externalID": "${ARPICEE_TEST_TOKEN}",
+    "qualify": true,
+    "tagFilter": {"arpicee": "1"}
+  }],
+  "http": {
+    "addr": ":8080",
+    "tokens": [{"name": "ci", "token": "env:ARPICEE_TEST_TOKEN"}, {"name": "ops", "token": "file:` + secretFile + `"}],
+    "webUI": {"enabled": true}
+  },
+  "queue": {"workers": 2}
+}`,
+		},
+		{
+			"config.yaml",
+			`lambda:
+  - region: ${ARPICEE_TEST_REGION}
+    roleArn: arn:aws:iam::123456789012:role/arpicee
+    externalId: ${ARPICEE_TEST_TOKEN}
+    qualify: true
+    tagFilter:
+      arpicee: 1
+http:
+  addr: ":8080"
+  tokens:
+    - name: ci
+      token: env:ARPICEE_TEST_TOKEN
+    - name: ops
+      token: file:` + secretFile + `
+  WebUI:
+    enabled: "true"
+queue:
+  workers: 2
+`,
+		},
+	} {
+		c, err := parse(testCase.fileName, []byte(testCase.content))
+		if err != nil {
+			t.Errorf("test %d - unexpected error: %s", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(*c, expect) {
+			t.Errorf("test %d - expected %+v, got %+v", i, expect, *c)
+		}
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for i, testCase := range []struct {
+		fileName     string
+		content      string
+		expectErrors []string
+	}{
+		{
+			"config.json",
+			"{\n  \"lambda\": [\n    {\"region\": \"us-east-1\",}\n  ]\n}",
+			[]string{"config.json:3: invalid character '}' looking for beginning of object key string"},
+		},
+		{
+			"config.yaml",
+			"http:\n  addr: :8080\n  adress: :8081\n",
+			[]string{"config.yaml:3: http.adress: unknown setting, expected one of addr, tokens, webUI"},
+		},
+		{
+			"config.yaml",
+			"queue:\n  workers: many\nscheduler:\n  jobs: {}",
+			[]string{
+				`config.yaml:2: queue.workers: expected an integer, got "many"`,
+				"config.yaml:4: scheduler.jobs: expected a list, got a mapping",
+			},
+		},
+		{
+			"config.yaml",
+			"slack:\n  botToken: env:ARPICEE_TEST_UNSET\nhttp:\n  addr: ${ARPICEE_TEST_UNSET}\n",
+			[]string{
+				"config.yaml:2: slack.botToken: environment variable ARPICEE_TEST_UNSET is not set",
+				"config.yaml:4: http.addr: environment variable ARPICEE_TEST_UNSET is not set",
+			},
+		},
+		{
+			"config.yaml",
+			`lambda:
+  - filter:
+      name: ["re:(deploy"]
+      any:
+        - tags:
+            team: "[a"
+`,
+			[]string{
+				"config.yaml:3: lambda[0].filter.name[0]: invalid regular expression (deploy: error parsing regexp: missing closing ): `(deploy`",
+				"config.yaml:6: lambda[0].filter.any[0].tags.team: invalid pattern [a: syntax error in pattern",
+			},
+		},
+		{
+			"config.yaml",
+			`overrides:
+  - name: deploy-staging
+    rpc: deploy
+    pin:
+      environment: staging
+    defaults:
+      environment: production
+  - name: deploy-staging
+    channels: [C0123]
+`,
+			[]string{
+				"config.yaml:2: overrides[0]: override deploy-staging: parameter environment can not be pinned and have a default",
+				"config.yaml:8: overrides[1].name: duplicate name deploy-staging",
+				"config.yaml:8: overrides[1].rpc: required setting is missing",
+			},
+		},
+		{
+			"config.yaml",
+			"manifests:\n  - region: eu-west-1\n",
+			[]string{"config.yaml:2: manifests[0].dir: required setting is missing"},
+		},
+		{
+			"config.yaml",
+			"confirm:\n  - rpc: deploy-*\n    confirm: twice\n  - rpc: restart\n",
+			[]string{
+				"config.yaml:3: confirm[0].confirm: invalid confirmation twice, expected none, arguments or name",
+				"config.yaml:4: confirm[1].confirm: required setting is missing",
+			},
+		},
+		{
+			"config.yaml",
+			`github:
+  - repo: arpicee
+ssm:
+  - region: eu-west-1
+    externalID: abc
+webhooks:
+  - name: deploy
+    rpc: deploy
+  - name: deploy
+scheduler:
+  jobs:
+    - name: nightly
+      schedule: "0 3 * * *"
+      rpc: backup
+      overlap: sometimes
+cache:
+  ttl: soon
+`,
+			[]string{
+				"config.yaml:2: github[0].repo: invalid repository arpicee, expected owner/repo",
+				"config.yaml:4: ssm[0].roleARN: required to set externalID or sessionName",
+				"config.yaml:9: webhooks[1].name: duplicate name deploy",
+				"config.yaml:9: webhooks[1].rpc: required setting is missing",
+				"config.yaml:15: scheduler.jobs[0].overlap: invalid overlap policy sometimes, expected skip, queue or allow",
+				"config.yaml:17: cache.ttl: invalid duration soon",
+			},
+		},
+	} {
+		_, err := parse(testCase.fileName, []byte(testCase.content))
+		var verr *ValidationError
+		if !errors.As(err, &verr) {
+			t.Errorf("test %d - expected a validation error, got %v", i, err)
+			continue
+		}
+		if got := strings.Split(verr.Error(), "\n"); !reflect.DeepEqual(got, testCase.expectErrors) {
+			t.Errorf("test %d - expected errors\n%s\ngot\n%s", i, strings.Join(testCase.expectErrors, "\n"), verr)
+		}
+	}
+}
+
+func TestLoadManifests(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"deploy.yaml": `name: deploy
+description: Deploys a service
+params:
+  - name: service
+    required: true
+    allowedValues: [api, web]
+  - name: replicas
+    type: int
+    default: "2"
+metadata:
+  owner: platform
+  tags: [deploy, kubernetes]
+  risk: high
+lambda:
+  function: deploy-service
+`,
+		"payments/refund.json": `{"name": "refund", "http": {"url": "https://payments.internal/refund", "headers": {"Authorization": "env:ARPICEE_TEST_TOKEN"}}}`,
+		"payments/deploy.yml":  "name: deploy\nssm:\n  document: deploy\n",
+		"invalid.yaml": `name: restart
+metadata:
+  risk: extreme
+params:
+  - name: force
+    type: bool
+    default: maybe
+http:
+  url: payments.internal/restart
+ssm:
+  document: restart
+`,
+		".git/config.yaml": "not: a manifest",
+		"README.md":        "# Manifests",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("ARPICEE_TEST_TOKEN", "s3cr3t")
+
+	manifests, err := LoadManifests(dir)
+	var names []string
+	for _, m := range manifests {
+		names = append(names, m.Name)
+	}
+	if expect := []string{"deploy", "refund"}; !reflect.DeepEqual(names, expect) {
+		t.Errorf("expected manifests %v, got %v", expect, names)
+	}
+	if len(manifests) == 2 {
+		if p := manifests[0].Params[1]; p.Type != "int" || p.Default != "2" || manifests[0].Lambda.Function != "deploy-service" || !reflect.DeepEqual(manifests[0].Metadata, arpicee.Metadata{Owner: "platform", Tags: []string{"deploy", "kubernetes"}, Risk: arpicee.RiskHigh}) {
+			t.Errorf("unexpected manifest %+v", manifests[0])
+		}
+		if h := manifests[1].HTTP; h.Headers["Authorization"] != "s3cr3t" {
+			t.Errorf("expected the secret header to be resolved, got %+v", h)
+		}
+	}
+
+	expectErrors := []string{
+		filepath.Join(dir, "invalid.yaml") + ": expected exactly one of lambda, ssm or http, got 2",
+		filepath.Join(dir, "invalid.yaml") + ":3: metadata.risk: invalid risk extreme, expected low, medium or high",
+		filepath.Join(dir, "invalid.yaml") + ":7: params[0].default: parameter force should be a boolean, could not parse given value: maybe",
+		filepath.Join(dir, "invalid.yaml") + ":9: http.url: invalid URL \"payments.internal/restart\", expected http:// or https://",
+		filepath.Join(dir, "payments/deploy.yml") + ": deploy is already declared in " + filepath.Join(dir, "deploy.yaml"),
+	}
+	var itemErrs arpicee.DiscoveryErrors
+	if !errors.As(err, &itemErrs) {
+		t.Fatalf("expected discovery errors, got %v", err)
+	}
+	var got []string
+	for _, ie := range itemErrs {
+		if _, ok := ie.Err.(*ValidationError); ok {
+			got = append(got, strings.Split(ie.Err.Error(), "\n")...)
+		} else {
+			got = append(got, ie.Item+": "+ie.Err.Error())
+		}
+	}
+	if !reflect.DeepEqual(got, expectErrors) {
+		t.Errorf("expected errors\n%s\ngot\n%s", strings.Join(expectErrors, "\n"), strings.Join(got, "\n"))
+	}
+
+	if _, err := LoadManifests(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("expected an error for a missing directory")
+	}
+}
+
+func TestSettingName(t *testing.T) {
+	for i, testCase := range []struct {
+		field, expect string
+	}{
+		{"TagFilter", "tagFilter"},
+		{"HTTP", "http"},
+		{"RPC", "rpc"},
+		{"WebUI", "webUI"},
+		{"AppID", "appID"},
+		{"Ssm", "ssm"},
+	} {
+		if got := settingName(testCase.field); got != testCase.expect {
+			t.Errorf("test %d - expected %s, got %s", i, testCase.expect, got)
+		}
+	}
+}
+
+func TestWatch(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "config.yaml")
+	// The file is replaced, so that the watcher never reads a truncated file
+	write := func(content string) {
+		if err := os.WriteFile(fileName+".tmp", []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(fileName+".tmp", fileName); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("queue:\n  workers: 1\n")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := make(chan *Config)
+	go Watch(ctx, fileName, 10*time.Millisecond, func(c *Config) { changes <- c })
+
+	// Invalid configurations are ignored
+	write("queue:\n  workers: many\n")
+	time.Sleep(50 * time.Millisecond)
+	write("queue:\n  workers: 3\n")
+	select {
+	case c := <-changes:
+		if c.Queue.Workers != 3 {
+			t.Errorf("expected the new configuration, got %+v", c.Queue)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the configuration change")
+	}
+}
@@ -0,0 +1,495 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/filter"
+	"github.com/yannh/arpicee/pkg/override"
+	"gopkg.in/yaml.v3"
+)
+
+// FieldError is an error on the setting Path of a configuration file, such as
+// "webhooks[0].rpc". Line is 0 when unknown.
+type FieldError struct {
+	Line int
+	Path string
+	Msg  string
+}
+
+// ValidationError lists all the errors found in a configuration file
+type ValidationError struct {
+	File   string
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	var lines []string
+	for _, fe := range e.Errors {
+		location := e.File
+		if fe.Line > 0 {
+			location = fmt.Sprintf("%s:%d", e.File, fe.Line)
+		}
+		if fe.Path != "" {
+			lines = append(lines, fmt.Sprintf("%s: %s: %s", location, fe.Path, fe.Msg))
+		} else {
+			lines = append(lines, fmt.Sprintf("%s: %s", location, fe.Msg))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Load reads the configuration file fileName, in JSON or YAML. Setting names are case
+// insensitive. In string values, ${VAR} is replaced with the value of the environment
+// variable VAR. Secrets, the fields tagged `config:"secret"`, can also reference an
+// environment variable with "env:VAR" or the content of a file with "file:PATH".
+// Unknown settings, values of the wrong type and invalid settings are returned as a
+// *ValidationError.
+func Load(fileName string) (*Config, error) {
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading from config file %s: %w", fileName, err)
+	}
+	return parse(fileName, b)
+}
+
+func parse(fileName string, b []byte) (*Config, error) {
+	var c Config
+	if err := decodeFile(fileName, b, &c, c.validate); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// decodeFile decodes the JSON or YAML content b of fileName into v, a pointer to a
+// struct, then checks it with validate. Errors are returned as a *ValidationError,
+// with the line of the settings.
+func decodeFile(fileName string, b []byte, v interface{}, validate func() []FieldError) error {
+	// YAML is a superset of JSON, but JSON files get JSON syntax errors
+	if ext := strings.ToLower(filepath.Ext(fileName)); ext == ".json" {
+		var v interface{}
+		var syntaxErr *json.SyntaxError
+		if err := json.Unmarshal(b, &v); errors.As(err, &syntaxErr) {
+			line := bytes.Count(b[:syntaxErr.Offset], []byte("\n")) + 1
+			return &ValidationError{File: fileName, Errors: []FieldError{{Line: line, Msg: err.Error()}}}
+		}
+	}
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return fmt.Errorf("failed parsing %s: %w", fileName, err)
+	}
+	d := &decoder{lines: map[string]int{}}
+	var decoded interface{} = map[string]interface{}{}
+	if len(doc.Content) > 0 {
+		decoded = d.decode(doc.Content[0], reflect.TypeOf(v).Elem(), "", false)
+	}
+	if len(d.errs) > 0 {
+		return &ValidationError{File: fileName, Errors: d.errs}
+	}
+
+	// The decoded value only has known settings of the right type
+	j, err := json.Marshal(decoded)
+	if err != nil {
+		return fmt.Errorf("failed parsing %s: %w", fileName, err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(j))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("failed parsing %s: %w", fileName, err)
+	}
+
+	if errs := validate(); len(errs) > 0 {
+		for i := range errs {
+			errs[i].Line = d.line(errs[i].Path)
+		}
+		sort.SliceStable(errs, func(i, j int) bool { return errs[i].Line < errs[j].Line })
+		return &ValidationError{File: fileName, Errors: errs}
+	}
+	return nil
+}
+
+// decoder converts YAML nodes to values json.Unmarshal decodes to the configuration,
+// recording the errors and the line of every setting
+type decoder struct {
+	errs  []FieldError
+	lines map[string]int
+}
+
+func (d *decoder) fail(n *yaml.Node, path, format string, args ...interface{}) {
+	d.errs = append(d.errs, FieldError{Line: n.Line, Path: path, Msg: fmt.Sprintf(format, args...)})
+}
+
+// line returns the line of the setting path, or of its closest parent if it is not set
+func (d *decoder) line(path string) int {
+	for path != "" {
+		if l, ok := d.lines[strings.ToLower(path)]; ok {
+			return l
+		}
+		i := strings.LastIndexAny(path, ".[")
+		if i < 0 {
+			break
+		}
+		path = path[:i]
+	}
+	return 0
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+var kindNames = map[yaml.Kind]string{
+	yaml.MappingNode:  "a mapping",
+	yaml.SequenceNode: "a list",
+	yaml.ScalarNode:   "a scalar",
+}
+
+func (d *decoder) decode(n *yaml.Node, t reflect.Type, path string, secret bool) interface{} {
+	if n.Kind == yaml.AliasNode {
+		n = n.Alias
+	}
+	d.lines[strings.ToLower(path)] = n.Line
+	if n.Kind == yaml.ScalarNode && n.Tag == "!!null" {
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return d.decode(n, t.Elem(), path, secret)
+
+	case reflect.Struct:
+		if n.Kind != yaml.MappingNode {
+			d.fail(n, path, "expected a mapping, got %s", kindNames[n.Kind])
+			return nil
+		}
+		fields := map[string]reflect.StructField{}
+		for _, f := range settings(t) {
+			fields[strings.ToLower(f.Name)] = f
+		}
+		res := map[string]interface{}{}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i].Value
+			f, ok := fields[strings.ToLower(key)]
+			if !ok {
+				d.fail(n.Content[i], joinPath(path, key), "unknown setting, expected one of %s", fieldNames(t))
+				continue
+			}
+			res[f.Name] = d.decode(n.Content[i+1], f.Type, joinPath(path, key), f.Tag.Get("config") == "secret")
+		}
+		return res
+
+	case reflect.Map:
+		if n.Kind != yaml.MappingNode {
+			d.fail(n, path, "expected a mapping, got %s", kindNames[n.Kind])
+			return nil
+		}
+		res := map[string]interface{}{}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i].Value
+			res[key] = d.decode(n.Content[i+1], t.Elem(), joinPath(path, key), secret)
+		}
+		return res
+
+	case reflect.Slice:
+		if n.Kind != yaml.SequenceNode {
+			d.fail(n, path, "expected a list, got %s", kindNames[n.Kind])
+			return nil
+		}
+		res := []interface{}{}
+		for i, item := range n.Content {
+			res = append(res, d.decode(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i), secret))
+		}
+		return res
+	}
+
+	if n.Kind != yaml.ScalarNode {
+		d.fail(n, path, "expected a %s, got %s", t.Kind(), kindNames[n.Kind])
+		return nil
+	}
+	v, err := expandEnv(n.Value)
+	if err != nil {
+		d.fail(n, path, "%s", err)
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		if secret {
+			if v, err = resolveSecret(v); err != nil {
+				d.fail(n, path, "%s", err)
+				return nil
+			}
+		}
+		return v
+	case reflect.Bool:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			d.fail(n, path, "expected a boolean, got %q", v)
+			return nil
+		}
+		return b
+	case reflect.Int:
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			d.fail(n, path, "expected an integer, got %q", v)
+			return nil
+		}
+		return i
+	}
+	d.fail(n, path, "unsupported setting type %s", t)
+	return nil
+}
+
+// settings returns the exported fields of the struct type t, including the fields of
+// embedded structs, as encoding/json does
+func settings(t reflect.Type) []reflect.StructField {
+	var fields []reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		switch {
+		case f.Anonymous && f.Type.Kind() == reflect.Struct:
+			fields = append(fields, settings(f.Type)...)
+		case f.IsExported():
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// fieldNames returns the settings of the struct type t
+func fieldNames(t reflect.Type) string {
+	var names []string
+	for _, f := range settings(t) {
+		names = append(names, settingName(f.Name))
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// settingName returns the name of the field as written in configuration files, such
+// as tagFilter for TagFilter, or http for HTTP
+func settingName(field string) string {
+	upper := 0
+	for upper < len(field) && field[upper] >= 'A' && field[upper] <= 'Z' {
+		upper++
+	}
+	if upper > 1 && upper < len(field) {
+		upper--
+	}
+	return strings.ToLower(field[:upper]) + field[upper:]
+}
+
+var envVar = regexp.MustCompile(`\$\{([a-zA-Z_][a-zA-Z0-9_]*)\}`)
+
+// expandEnv replaces ${VAR} with the value of the environment variable VAR
+func expandEnv(s string) (string, error) {
+	var missing []string
+	res := envVar.ReplaceAllStringFunc(s, func(m string) string {
+		name := envVar.FindStringSubmatch(m)[1]
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return v
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s is not set", strings.Join(missing, ", "))
+	}
+	return res, nil
+}
+
+// resolveSecret returns the value of the secret references "env:VAR" and "file:PATH",
+// or s itself. Trailing newlines of files are removed.
+func resolveSecret(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, "env:"):
+		name := strings.TrimPrefix(s, "env:")
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return v, nil
+	case strings.HasPrefix(s, "file:"):
+		b, err := os.ReadFile(strings.TrimPrefix(s, "file:"))
+		if err != nil {
+			return "", fmt.Errorf("failed reading secret: %w", err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+	return s, nil
+}
+
+// validate returns the errors of the settings that can be checked without
+// connecting to any service
+func (c *Config) validate() []FieldError {
+	var errs []FieldError
+	fail := func(path, format string, args ...interface{}) {
+		errs = append(errs, FieldError{Path: path, Msg: fmt.Sprintf(format, args...)})
+	}
+	required := func(path, v string) bool {
+		if v == "" {
+			fail(path, "required setting is missing")
+			return false
+		}
+		return true
+	}
+	// unique checks the names of a list of settings are set and unique
+	unique := func(names map[string]bool, path, name string) {
+		if !required(path, name) {
+			return
+		}
+		if names[name] {
+			fail(path, "duplicate name %s", name)
+		}
+		names[name] = true
+	}
+
+	checkAWS := func(path string, src AWSSource) {
+		if src.RoleARN == "" && (src.ExternalID != "" || src.SessionName != "") {
+			fail(path+".roleARN", "required to set externalID or sessionName")
+		}
+	}
+	var checkFilter func(path string, f filter.Filter)
+	checkFilter = func(path string, f filter.Filter) {
+		checkPatterns := func(key string, patterns []string) {
+			for i, p := range patterns {
+				if _, err := filter.CompilePattern(p); err != nil {
+					fail(fmt.Sprintf("%s.%s[%d]", path, key, i), "%s", err)
+				}
+			}
+		}
+		checkTags := func(key string, tags map[string]string) {
+			for k, p := range tags {
+				if _, err := filter.CompilePattern(p); err != nil {
+					fail(path+"."+key+"."+k, "%s", err)
+				}
+			}
+		}
+		checkFilters := func(key string, filters []filter.Filter) {
+			for i, sub := range filters {
+				checkFilter(fmt.Sprintf("%s.%s[%d]", path, key, i), sub)
+			}
+		}
+		checkPatterns("name", f.Name)
+		checkPatterns("excludeName", f.ExcludeName)
+		checkTags("tags", f.Tags)
+		checkTags("excludeTags", f.ExcludeTags)
+		checkFilters("all", f.All)
+		checkFilters("any", f.Any)
+		checkFilters("none", f.None)
+	}
+
+	for i, d := range c.Lambda {
+		checkAWS(fmt.Sprintf("lambda[%d]", i), d.AWSSource)
+		checkFilter(fmt.Sprintf("lambda[%d].filter", i), d.Filter)
+	}
+	for i, d := range c.Ssm {
+		checkAWS(fmt.Sprintf("ssm[%d]", i), d.AWSSource)
+		checkFilter(fmt.Sprintf("ssm[%d].filter", i), d.Filter)
+	}
+
+	for i, g := range c.Github {
+		checkFilter(fmt.Sprintf("github[%d].filter", i), g.Filter)
+		path := fmt.Sprintf("github[%d].repo", i)
+		if required(path, g.Repo) {
+			if owner, repo, ok := strings.Cut(g.Repo, "/"); !ok || owner == "" || repo == "" || strings.Contains(repo, "/") {
+				fail(path, "invalid repository %s, expected owner/repo", g.Repo)
+			}
+		}
+	}
+
+	for i, d := range c.Manifests {
+		path := fmt.Sprintf("manifests[%d]", i)
+		checkAWS(path, d.AWSSource)
+		required(path+".dir", d.Dir)
+	}
+
+	tokens := map[string]bool{}
+	for i, t := range c.HTTP.Tokens {
+		unique(tokens, fmt.Sprintf("http.tokens[%d].name", i), t.Name)
+		required(fmt.Sprintf("http.tokens[%d].token", i), t.Token)
+	}
+	if (c.GRPC.CertFile == "") != (c.GRPC.KeyFile == "") {
+		fail("grpc", "certFile and keyFile must be set together")
+	}
+
+	pipelines := map[string]bool{}
+	for i, p := range c.Pipelines {
+		unique(pipelines, fmt.Sprintf("pipelines[%d].name", i), p.Name)
+	}
+
+	for i, o := range c.Overrides {
+		path := fmt.Sprintf("overrides[%d]", i)
+		unique(pipelines, path+".name", o.Name)
+		if required(path+".rpc", o.RPC) && o.Name != "" {
+			if _, err := override.New(o, nil); err != nil {
+				fail(path, "%s", err)
+			}
+		}
+	}
+
+	webhooks := map[string]bool{}
+	for i, h := range c.Webhooks {
+		unique(webhooks, fmt.Sprintf("webhooks[%d].name", i), h.Name)
+		required(fmt.Sprintf("webhooks[%d].rpc", i), h.RPC)
+	}
+
+	jobs := map[string]bool{}
+	for i, j := range c.Scheduler.Jobs {
+		path := fmt.Sprintf("scheduler.jobs[%d]", i)
+		unique(jobs, path+".name", j.Name)
+		required(path+".schedule", j.Schedule)
+		required(path+".rpc", j.RPC)
+		if j.Jitter != "" {
+			if _, err := time.ParseDuration(j.Jitter); err != nil {
+				fail(path+".jitter", "invalid duration %s", j.Jitter)
+			}
+		}
+		switch j.Overlap {
+		case "", "skip", "queue", "allow":
+		default:
+			fail(path+".overlap", "invalid overlap policy %s, expected skip, queue or allow", j.Overlap)
+		}
+	}
+	if c.Reload.Interval != "" {
+		if d, err := time.ParseDuration(c.Reload.Interval); err != nil || d <= 0 {
+			fail("reload.interval", "invalid duration %s", c.Reload.Interval)
+		}
+	}
+	if c.Cache.TTL != "" {
+		if d, err := time.ParseDuration(c.Cache.TTL); err != nil || d <= 0 {
+			fail("cache.ttl", "invalid duration %s", c.Cache.TTL)
+		}
+	}
+	if c.Queue.Workers < 0 {
+		fail("queue.workers", "must not be negative")
+	}
+
+	for i, r := range c.Access {
+		required(fmt.Sprintf("access[%d].rpc", i), r.RPC)
+	}
+	for i, r := range c.Confirm {
+		path := fmt.Sprintf("confirm[%d]", i)
+		required(path+".rpc", r.RPC)
+		required(path+".confirm", r.Confirm)
+		if err := arpicee.Confirmation(r.Confirm).Validate(); err != nil {
+			fail(path+".confirm", "%s", err)
+		}
+	}
+	return errs
+}
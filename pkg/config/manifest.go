@@ -0,0 +1,219 @@
+package config
+
+import (
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	awsLambda "github.com/aws/aws-sdk-go/service/lambda"
+	awsSSM "github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/manifest"
+	"github.com/yannh/arpicee/pkg/registry"
+)
+
+// ManifestDiscovery loads the RPCs declared in the manifest files of Dir, see
+// LoadManifests. Their Lambda functions and SSM documents run in the AWS account and
+// region of AWSSource.
+type ManifestDiscovery struct {
+	AWSSource
+	Dir string
+}
+
+// LoadManifest reads the manifest file fileName, in JSON or YAML, see manifest.Manifest.
+// Settings are decoded and validated as in Load.
+func LoadManifest(fileName string) (*manifest.Manifest, error) {
+	b, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading manifest %s: %w", fileName, err)
+	}
+	var m manifest.Manifest
+	if err := decodeFile(fileName, b, &m, func() []FieldError { return validateManifest(m) }); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// LoadManifests reads the manifest files of dir and its subdirectories, the files with
+// a .yaml, .yml or .json extension, in lexical order. Hidden files and directories are
+// skipped. Invalid manifests, and manifests named like a previous one, are returned as
+// arpicee.DiscoveryErrors along with the valid ones.
+func LoadManifests(dir string) ([]manifest.Manifest, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && strings.HasPrefix(e.Name(), ".") {
+			if e.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		switch strings.ToLower(filepath.Ext(path)) {
+		case ".yaml", ".yml", ".json":
+			if !e.IsDir() {
+				files = append(files, path)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed reading manifests: %w", err)
+	}
+	sort.Strings(files)
+
+	var manifests []manifest.Manifest
+	var itemErrs arpicee.DiscoveryErrors
+	declared := map[string]string{}
+	for _, f := range files {
+		m, err := LoadManifest(f)
+		if err != nil {
+			itemErrs = append(itemErrs, arpicee.ItemError{Item: f, Err: err})
+			continue
+		}
+		if prev, ok := declared[m.Name]; ok {
+			itemErrs = append(itemErrs, arpicee.ItemError{Item: f, Err: fmt.Errorf("%s is already declared in %s", m.Name, prev)})
+			continue
+		}
+		declared[m.Name] = f
+		manifests = append(manifests, *m)
+	}
+	return manifests, itemErrs.Err()
+}
+
+// validateManifest returns the errors of the settings of m
+func validateManifest(m manifest.Manifest) []FieldError {
+	var errs []FieldError
+	fail := func(path, format string, args ...interface{}) {
+		errs = append(errs, FieldError{Path: path, Msg: fmt.Sprintf(format, args...)})
+	}
+	required := func(path, v string) {
+		if v == "" {
+			fail(path, "required setting is missing")
+		}
+	}
+
+	required("name", m.Name)
+	params := map[string]bool{}
+	for i, p := range m.Params {
+		path := fmt.Sprintf("params[%d]", i)
+		required(path+".name", p.Name)
+		if p.Name != "" && params[p.Name] {
+			fail(path+".name", "duplicate name %s", p.Name)
+		}
+		params[p.Name] = true
+		param, err := p.Parameter()
+		if err != nil {
+			fail(path+".type", "%s", err)
+			continue
+		}
+		if p.Default != "" {
+			if _, err := arpicee.ParseArgument(param, p.Default); err != nil {
+				fail(path+".default", "%s", err)
+			} else if len(p.AllowedValues) > 0 && !contains(p.AllowedValues, p.Default) {
+				fail(path+".default", "default %s is not an allowed value", p.Default)
+			}
+		}
+	}
+
+	if err := m.Metadata.Risk.Validate(); err != nil {
+		fail("metadata.risk", "%s", err)
+	}
+	if err := m.Metadata.Confirm.Validate(); err != nil {
+		fail("metadata.confirm", "%s", err)
+	}
+
+	var backends []string
+	if m.Lambda != nil {
+		backends = append(backends, "lambda")
+		required("lambda.function", m.Lambda.Function)
+	}
+	if m.SSM != nil {
+		backends = append(backends, "ssm")
+		required("ssm.document", m.SSM.Document)
+	}
+	if h := m.HTTP; h != nil {
+		backends = append(backends, "http")
+		if u, err := url.Parse(h.URL); h.URL == "" || err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("http.url", "invalid URL %q, expected http:// or https://", h.URL)
+		}
+		switch strings.ToUpper(h.Method) {
+		case "", "GET", "POST", "PUT", "PATCH", "DELETE":
+		default:
+			fail("http.method", "invalid method %s, expected GET, POST, PUT, PATCH or DELETE", h.Method)
+		}
+		if h.Timeout != "" {
+			if _, err := time.ParseDuration(h.Timeout); err != nil {
+				fail("http.timeout", "invalid duration %s", h.Timeout)
+			}
+		}
+	}
+	if len(backends) != 1 {
+		fail("", "expected exactly one of lambda, ssm or http, got %d", len(backends))
+	}
+	return errs
+}
+
+func contains(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
+
+// manifestSource returns the discovery source of the manifests of d. The AWS session is
+// only created once a manifest runs a Lambda function or an SSM document.
+func manifestSource(d ManifestDiscovery) registry.Source {
+	var mu sync.Mutex
+	var backends *manifest.Backends
+	aws := func() (manifest.Backends, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if backends == nil {
+			sess, err := awsSession(d.AWSSource)
+			if err != nil {
+				return manifest.Backends{}, err
+			}
+			backends = &manifest.Backends{Lambda: awsLambda.New(sess), SSM: awsSSM.New(sess)}
+		}
+		return *backends, nil
+	}
+
+	return registry.Source{
+		Name: "manifests " + d.Dir,
+		Discover: func() ([]arpicee.RemoteCall, error) {
+			manifests, err := LoadManifests(d.Dir)
+			itemErrs, ok := err.(arpicee.DiscoveryErrors)
+			if err != nil && !ok {
+				return nil, err
+			}
+
+			var rpcs []arpicee.RemoteCall
+			for _, m := range manifests {
+				var b manifest.Backends
+				if m.Lambda != nil || m.SSM != nil {
+					if b, err = aws(); err != nil {
+						itemErrs = append(itemErrs, arpicee.ItemError{Item: m.Name, Err: err})
+						continue
+					}
+				}
+				rpc, err := manifest.New(m, b)
+				if err != nil {
+					itemErrs = append(itemErrs, arpicee.ItemError{Item: m.Name, Err: err})
+					continue
+				}
+				rpcs = append(rpcs, rpc)
+			}
+			return rpcs, itemErrs.Err()
+		},
+	}
+}
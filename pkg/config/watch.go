@@ -0,0 +1,45 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"time"
+)
+
+// Watch checks the configuration file fileName every interval, and calls onChange
+// with the new configuration when its content changed. Invalid configurations are
+// logged and ignored. Watch returns when ctx is done.
+func Watch(ctx context.Context, fileName string, interval time.Duration, onChange func(*Config)) error {
+	last, err := os.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		b, err := os.ReadFile(fileName)
+		if err != nil {
+			log.Printf("failed reading config file %s: %s", fileName, err)
+			continue
+		}
+		if bytes.Equal(b, last) {
+			continue
+		}
+		last = b
+		c, err := parse(fileName, b)
+		if err != nil {
+			log.Printf("ignoring changes to the config file: %s", err)
+			continue
+		}
+		log.Printf("config file %s changed, reloading", fileName)
+		onChange(c)
+	}
+}
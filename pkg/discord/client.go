@@ -0,0 +1,129 @@
+package discord
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const DefaultAPIURL = "https://discord.com/api/v10"
+
+// Client is a minimal client for the Discord REST API, authenticating with a bot token
+type Client struct {
+	url        string
+	token      string
+	httpClient *http.Client
+}
+
+func NewClient(apiURL, token string) *Client {
+	return &Client{
+		url:        strings.TrimSuffix(apiURL, "/"),
+		token:      token,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+const (
+	optionTypeString  = 3
+	optionTypeInteger = 4
+	optionTypeBoolean = 5
+)
+
+type Choice struct {
+	Name  string      `json:"name"`
+	Value interface{} `json:"value"`
+}
+
+type CommandOption struct {
+	Type         int      `json:"type"`
+	Name         string   `json:"name"`
+	Description  string   `json:"description"`
+	Required     bool     `json:"required,omitempty"`
+	Choices      []Choice `json:"choices,omitempty"`
+	Autocomplete bool     `json:"autocomplete,omitempty"`
+}
+
+type Command struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	Options     []CommandOption `json:"options,omitempty"`
+}
+
+type EmbedField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline,omitempty"`
+}
+
+type Embed struct {
+	Title       string       `json:"title,omitempty"`
+	Description string       `json:"description,omitempty"`
+	Color       int          `json:"color,omitempty"`
+	Fields      []EmbedField `json:"fields,omitempty"`
+}
+
+type Message struct {
+	ID      string  `json:"id,omitempty"`
+	Content string  `json:"content"`
+	Embeds  []Embed `json:"embeds"`
+}
+
+func (c *Client) do(method, path string, body, out interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, c.url+path, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bot "+c.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		if json.Unmarshal(msg, &apiErr) == nil && apiErr.Message != "" {
+			return fmt.Errorf("%s %s: %s (%d)", method, path, apiErr.Message, resp.StatusCode)
+		}
+		return fmt.Errorf("%s %s: unexpected status %d", method, path, resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// OverwriteCommands replaces the application commands, in a guild if guildID is set,
+// globally otherwise. Guild commands are available immediately, global ones can take an hour.
+func (c *Client) OverwriteCommands(applicationID, guildID string, commands []Command) error {
+	path := "/applications/" + url.PathEscape(applicationID) + "/commands"
+	if guildID != "" {
+		path = "/applications/" + url.PathEscape(applicationID) + "/guilds/" + url.PathEscape(guildID) + "/commands"
+	}
+	return c.do(http.MethodPut, path, commands, nil)
+}
+
+// EditOriginalResponse edits the response to an interaction; interaction tokens are valid 15 minutes
+func (c *Client) EditOriginalResponse(applicationID, interactionToken string, m Message) error {
+	return c.do(http.MethodPatch, "/webhooks/"+url.PathEscape(applicationID)+"/"+url.PathEscape(interactionToken)+"/messages/@original", m, nil)
+}
+
+func (c *Client) CreateMessage(channelID string, m Message) (Message, error) {
+	var res Message
+	err := c.do(http.MethodPost, "/channels/"+url.PathEscape(channelID)+"/messages", m, &res)
+	return res, err
+}
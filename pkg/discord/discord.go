@@ -0,0 +1,667 @@
+package discord
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/chat"
+	"github.com/yannh/arpicee/pkg/execution"
+)
+
+const InteractionsPath = "/discord/interactions"
+
+const (
+	interactionPing               = 1
+	interactionApplicationCommand = 2
+	interactionMessageComponent   = 3
+	interactionAutocomplete       = 4
+	interactionModalSubmit        = 5
+)
+
+const (
+	responsePong                   = 1
+	responseChannelMessage         = 4
+	responseDeferredChannelMessage = 5
+	responseAutocompleteResult     = 8
+	responseModal                  = 9
+)
+
+const (
+	flagEphemeral      = 64
+	componentActionRow = 1
+	componentButton    = 2
+	componentTextInput = 4
+	buttonDanger       = 4
+	textInputShort     = 1
+)
+
+const (
+	maxChoices     = 25
+	maxModalInputs = 5
+	pendingTTL     = 15 * time.Minute
+	// maxCommands and maxOptions are the limits of Discord on the commands of an
+	// application, and the options of a command
+	maxCommands = 100
+	maxOptions  = 25
+	// confirmPrefix prefixes the custom IDs of the runs waiting for confirmation
+	confirmPrefix = "confirm:"
+)
+
+// Bot registers one application command per RPC, and serves the interactions Discord
+// sends to the interactions endpoint. Plain string parameters are asked for in a modal.
+// RPCs requiring confirmation are run from a button, and their name typed in a modal
+// when required.
+type Bot struct {
+	client        *Client
+	core          *chat.Core
+	applicationID string
+	guildID       string
+	publicKey     ed25519.PublicKey
+
+	mu      sync.Mutex
+	pending map[string]pendingRun // modal or button custom ID to the values entered
+}
+
+type pendingRun struct {
+	rpc     string
+	userID  string
+	values  map[string]string
+	created time.Time
+}
+
+type user struct {
+	ID       string `json:"id"`
+	Username string `json:"username"`
+}
+
+type interactionOption struct {
+	Name    string      `json:"name"`
+	Type    int         `json:"type"`
+	Value   interface{} `json:"value"`
+	Focused bool        `json:"focused"`
+}
+
+type interaction struct {
+	ID        string `json:"id"`
+	Type      int    `json:"type"`
+	Token     string `json:"token"`
+	ChannelID string `json:"channel_id"`
+	Member    *struct {
+		User user `json:"user"`
+	} `json:"member"`
+	User *user `json:"user"`
+	Data struct {
+		Name       string              `json:"name"`
+		Options    []interactionOption `json:"options"`
+		CustomID   string              `json:"custom_id"`
+		Components []struct {
+			Components []struct {
+				CustomID string `json:"custom_id"`
+				Value    string `json:"value"`
+			} `json:"components"`
+		} `json:"components"`
+	} `json:"data"`
+}
+
+// user returns the user in a guild, or in a direct message
+func (i interaction) user() user {
+	if i.Member != nil {
+		return i.Member.User
+	}
+	if i.User != nil {
+		return *i.User
+	}
+	return user{}
+}
+
+// component is a text input or a button
+type component struct {
+	Type        int    `json:"type"`
+	CustomID    string `json:"custom_id"`
+	Label       string `json:"label"`
+	Style       int    `json:"style"`
+	Required    bool   `json:"required,omitempty"`
+	Value       string `json:"value,omitempty"`
+	Placeholder string `json:"placeholder,omitempty"`
+}
+
+type actionRow struct {
+	Type       int         `json:"type"`
+	Components []component `json:"components"`
+}
+
+type responseData struct {
+	Content    string      `json:"content,omitempty"`
+	Flags      int         `json:"flags,omitempty"`
+	Choices    []Choice    `json:"choices,omitempty"`
+	CustomID   string      `json:"custom_id,omitempty"`
+	Title      string      `json:"title,omitempty"`
+	Components []actionRow `json:"components,omitempty"`
+}
+
+type response struct {
+	Type int           `json:"type"`
+	Data *responseData `json:"data,omitempty"`
+}
+
+// User returns the user executions are attributed to, in access rules, for the Discord
+// user with the given ID
+func User(id string) string {
+	return "discord:" + id
+}
+
+// New returns a Bot; publicKey is the hex encoded public key of the Discord application,
+// used to verify interactions. Commands are registered in guildID, or globally if empty.
+func New(client *Client, core *chat.Core, applicationID, guildID, publicKey string) (*Bot, error) {
+	key, err := hex.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid Discord application public key")
+	}
+	if applicationID == "" {
+		return nil, fmt.Errorf("the Discord application ID must be set")
+	}
+	return &Bot{
+		client:        client,
+		core:          core,
+		applicationID: applicationID,
+		guildID:       guildID,
+		publicKey:     key,
+		pending:       map[string]pendingRun{},
+	}, nil
+}
+
+// Register adds the interactions endpoint to mux
+func (b *Bot) Register(mux *http.ServeMux) {
+	mux.Handle(InteractionsPath, b)
+}
+
+// Name returns a valid Discord command or option name for s: lowercase, and
+// restricted to letters, numbers, - and _
+func Name(s string) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_' || r == '-' {
+			return r
+		}
+		if r >= 'A' && r <= 'Z' {
+			return r - 'A' + 'a'
+		}
+		return '-'
+	}, s)
+	if len(name) > 32 {
+		name = name[:32]
+	}
+	return name
+}
+
+// truncate shortens s to n characters; limits are in characters, not bytes
+func truncate(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-2]) + ".."
+	}
+	return s
+}
+
+// modalParams returns the plain string parameters, asked for in a modal. Modals are
+// limited to 5 inputs: if there are more, they are all passed as command options.
+func modalParams(params []arpicee.Parameter) []arpicee.Parameter {
+	var res []arpicee.Parameter
+	for _, p := range params {
+		if p.Type == arpicee.TypeString && len(p.AllowedValues) == 0 {
+			res = append(res, p)
+		}
+	}
+	if len(res) > maxModalInputs {
+		return nil
+	}
+	return res
+}
+
+func inModal(modal []arpicee.Parameter, name string) bool {
+	for _, p := range modal {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func commandOption(p arpicee.Parameter) CommandOption {
+	desc := p.Description
+	if desc == "" {
+		desc = p.Name
+	}
+	o := CommandOption{
+		Type:        optionTypeString,
+		Name:        Name(p.Name),
+		Description: truncate(desc, 100),
+		Required:    p.Required && p.Default == "",
+	}
+	switch p.Type {
+	case arpicee.TypeInt:
+		o.Type = optionTypeInteger
+	case arpicee.TypeBool:
+		o.Type = optionTypeBoolean
+	}
+	if len(p.AllowedValues) > maxChoices {
+		o.Autocomplete = true
+	} else {
+		for _, v := range p.AllowedValues {
+			c := Choice{Name: v, Value: v}
+			if i, err := strconv.Atoi(v); err == nil && p.Type == arpicee.TypeInt {
+				c.Value = i
+			}
+			o.Choices = append(o.Choices, c)
+		}
+	}
+	return o
+}
+
+// Commands returns the application commands for rpcs, one per RPC. Discord allows at
+// most 100 commands with 25 options each: the RPCs above these limits are left out.
+func Commands(rpcs []arpicee.RemoteCall) []Command {
+	commands := []Command{}
+	seen := map[string]bool{}
+	for i, rpc := range rpcs {
+		if len(commands) == maxCommands {
+			log.Printf("%d RPCs not registered as Discord commands: Discord allows at most %d commands", len(rpcs)-i, maxCommands)
+			break
+		}
+		name := Name(rpc.Name())
+		if seen[name] {
+			log.Printf("RPC %s not registered as a Discord command: another RPC has the command name %s", rpc.Name(), name)
+			continue
+		}
+
+		desc := rpc.Description()
+		if desc == "" {
+			desc = "Run " + rpc.Name()
+		}
+		// Commands can not be grouped: the category prefixes the description
+		m := arpicee.MetadataOf(rpc)
+		if m.Category != "" {
+			desc = "[" + m.Category + "] " + desc
+		}
+		if m.Deprecation != "" {
+			desc = "Deprecated: " + desc
+		}
+		cmd := Command{
+			Name:        name,
+			Description: truncate(desc, 100),
+		}
+		modal := modalParams(rpc.Params())
+		for _, p := range rpc.Params() {
+			if !inModal(modal, p.Name) {
+				cmd.Options = append(cmd.Options, commandOption(p))
+			}
+		}
+		if len(cmd.Options) > maxOptions {
+			log.Printf("RPC %s not registered as a Discord command: it has more than %d parameters", rpc.Name(), maxOptions)
+			continue
+		}
+		seen[name] = true
+		// Required options must be listed first
+		sort.SliceStable(cmd.Options, func(i, j int) bool {
+			if cmd.Options[i].Required != cmd.Options[j].Required {
+				return cmd.Options[i].Required
+			}
+			return cmd.Options[i].Name < cmd.Options[j].Name
+		})
+		commands = append(commands, cmd)
+	}
+	return commands
+}
+
+// RegisterCommands replaces the application commands with one command per RPC
+func (b *Bot) RegisterCommands() error {
+	return b.client.OverwriteCommands(b.applicationID, b.guildID, Commands(b.core.RPCs()))
+}
+
+// rpc returns the RPC of the command, if it is available in the channel channelID
+func (b *Bot) rpc(channelID, commandName string) arpicee.RemoteCall {
+	for _, rpc := range b.core.RPCsIn(channelID) {
+		if Name(rpc.Name()) == commandName {
+			return rpc
+		}
+	}
+	return nil
+}
+
+func (b *Bot) verify(r *http.Request, body []byte) bool {
+	sig, err := hex.DecodeString(r.Header.Get("X-Signature-Ed25519"))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return false
+	}
+	msg := append([]byte(r.Header.Get("X-Signature-Timestamp")), body...)
+	return ed25519.Verify(b.publicKey, msg, sig)
+}
+
+func respond(w http.ResponseWriter, resp response) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Printf("failed writing Discord interaction response: %s", err)
+	}
+}
+
+func ephemeral(w http.ResponseWriter, content string) {
+	respond(w, response{Type: responseChannelMessage, Data: &responseData{Content: content, Flags: flagEphemeral}})
+}
+
+func (b *Bot) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1024*1024))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !b.verify(r, body) {
+		http.Error(w, "invalid request signature", http.StatusUnauthorized)
+		return
+	}
+	var i interaction
+	if err := json.Unmarshal(body, &i); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch i.Type {
+	case interactionPing:
+		respond(w, response{Type: responsePong})
+	case interactionApplicationCommand:
+		b.command(w, i)
+	case interactionMessageComponent:
+		b.confirm(w, i)
+	case interactionAutocomplete:
+		b.autocomplete(w, i)
+	case interactionModalSubmit:
+		b.modalSubmit(w, i)
+	default:
+		http.Error(w, fmt.Sprintf("unsupported interaction type %d", i.Type), http.StatusBadRequest)
+	}
+}
+
+// optionValues returns the values of the options of the command, by parameter name
+func optionValues(params []arpicee.Parameter, options []interactionOption) map[string]string {
+	byOption := map[string]interface{}{}
+	for _, o := range options {
+		byOption[o.Name] = o.Value
+	}
+	strs := chat.StringValues(byOption)
+	values := map[string]string{}
+	for _, p := range params {
+		if v, ok := strs[Name(p.Name)]; ok {
+			values[p.Name] = v
+		}
+	}
+	return values
+}
+
+func (b *Bot) addPending(id string, p pendingRun) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for id, p := range b.pending {
+		if time.Since(p.created) > pendingTTL {
+			delete(b.pending, id)
+		}
+	}
+	p.created = time.Now()
+	b.pending[id] = p
+}
+
+// takePending removes and returns the pending run with the given ID, if it was started
+// by userID and has not expired
+func (b *Bot) takePending(id, userID string) (pendingRun, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	p, ok := b.pending[id]
+	if !ok || p.userID != userID || time.Since(p.created) > pendingTTL {
+		return pendingRun{}, false
+	}
+	delete(b.pending, id)
+	return p, true
+}
+
+func (b *Bot) command(w http.ResponseWriter, i interaction) {
+	u := i.user()
+	rpc := b.rpc(i.ChannelID, i.Data.Name)
+	if rpc == nil {
+		ephemeral(w, fmt.Sprintf("Unknown remote procedure %s", i.Data.Name))
+		return
+	}
+	// Usernames can be changed, users are identified by their ID
+	if err := b.core.Authorize(User(u.ID), rpc); err != nil {
+		log.Printf("RPC %s invoked by %s: %s", rpc.Name(), u.Username, err)
+		ephemeral(w, fmt.Sprintf("You are not allowed to run **%s**", rpc.Name()))
+		return
+	}
+
+	values := optionValues(rpc.Params(), i.Data.Options)
+	modal := modalParams(rpc.Params())
+	if len(modal) == 0 {
+		b.start(w, i, rpc, values, false)
+		return
+	}
+
+	b.addPending(i.ID, pendingRun{rpc: rpc.Name(), userID: u.ID, values: values})
+
+	sort.Slice(modal, func(i, j int) bool {
+		return modal[i].Name < modal[j].Name
+	})
+	rows := []actionRow{}
+	for _, p := range modal {
+		rows = append(rows, actionRow{
+			Type: componentActionRow,
+			Components: []component{{
+				Type:        componentTextInput,
+				CustomID:    p.Name,
+				Label:       truncate(p.Name, 45),
+				Style:       textInputShort,
+				Required:    p.Required,
+				Value:       p.Default,
+				Placeholder: truncate(p.Description, 100),
+			}},
+		})
+	}
+	respond(w, response{Type: responseModal, Data: &responseData{
+		CustomID:   i.ID,
+		Title:      truncate(rpc.Name(), 45),
+		Components: rows,
+	}})
+}
+
+func (b *Bot) modalSubmit(w http.ResponseWriter, i interaction) {
+	u := i.user()
+	p, ok := b.takePending(i.Data.CustomID, u.ID)
+	if !ok {
+		ephemeral(w, "This form has expired, please run the command again")
+		return
+	}
+
+	rpc := b.core.RPCIn(i.ChannelID, p.rpc)
+	if rpc == nil {
+		ephemeral(w, fmt.Sprintf("Unknown remote procedure %s", p.rpc))
+		return
+	}
+	confirmed := false
+	for _, row := range i.Data.Components {
+		for _, c := range row.Components {
+			if c.CustomID != chat.ConfirmField || !strings.HasPrefix(i.Data.CustomID, confirmPrefix) {
+				p.values[c.CustomID] = c.Value
+				continue
+			}
+			// The modal asking for the name of the RPC, see confirm
+			if err := chat.CheckConfirmation(rpc, arpicee.ConfirmName, c.Value); err != nil {
+				b.addPending(i.Data.CustomID, p)
+				ephemeral(w, fmt.Sprintf("Type **%s** to confirm running it", rpc.Name()))
+				return
+			}
+			confirmed = true
+		}
+	}
+	b.start(w, i, rpc, p.values, confirmed)
+}
+
+// askConfirmation shows the values rpc would run with, and a button to run it
+func (b *Bot) askConfirmation(w http.ResponseWriter, i interaction, rpc arpicee.RemoteCall, values map[string]string) {
+	id := confirmPrefix + i.ID
+	b.addPending(id, pendingRun{rpc: rpc.Name(), userID: i.user().ID, values: values})
+
+	content := fmt.Sprintf("You are about to run **%s**", rpc.Name())
+	var lines []string
+	for _, p := range rpc.Params() {
+		v, ok := values[p.Name]
+		if !ok || v == "" {
+			continue
+		}
+		if p.Secret {
+			v = "********"
+		}
+		lines = append(lines, fmt.Sprintf("- %s: `%s`", p.Name, v))
+	}
+	if len(lines) > 0 {
+		content += " with:\n" + strings.Join(lines, "\n")
+	}
+	if summary := arpicee.MetadataOf(rpc).Summary(); len(summary) > 0 {
+		content += "\n" + strings.Join(summary, "\n")
+	}
+	respond(w, response{Type: responseChannelMessage, Data: &responseData{
+		Content: content,
+		Flags:   flagEphemeral,
+		Components: []actionRow{{
+			Type:       componentActionRow,
+			Components: []component{{Type: componentButton, CustomID: id, Label: "Run", Style: buttonDanger}},
+		}},
+	}})
+}
+
+// confirm handles the clicks on the buttons confirming runs: the RPC is started, or
+// a modal asks for its name if it must be typed
+func (b *Bot) confirm(w http.ResponseWriter, i interaction) {
+	u := i.user()
+	p, ok := b.takePending(i.Data.CustomID, u.ID)
+	if !ok {
+		ephemeral(w, "This confirmation has expired, please run the command again")
+		return
+	}
+	rpc := b.core.RPCIn(i.ChannelID, p.rpc)
+	if rpc == nil {
+		ephemeral(w, fmt.Sprintf("Unknown remote procedure %s", p.rpc))
+		return
+	}
+	if b.core.Confirmation(rpc) != arpicee.ConfirmName {
+		b.start(w, i, rpc, p.values, true)
+		return
+	}
+
+	b.addPending(i.Data.CustomID, p)
+	respond(w, response{Type: responseModal, Data: &responseData{
+		CustomID: i.Data.CustomID,
+		Title:    truncate("Confirm "+rpc.Name(), 45),
+		Components: []actionRow{{
+			Type: componentActionRow,
+			Components: []component{{
+				Type:     componentTextInput,
+				CustomID: chat.ConfirmField,
+				Label:    truncate("Type "+rpc.Name()+" to confirm", 45),
+				Style:    textInputShort,
+				Required: true,
+			}},
+		}},
+	}})
+}
+
+func (b *Bot) autocomplete(w http.ResponseWriter, i interaction) {
+	choices := []Choice{}
+	rpc := b.rpc(i.ChannelID, i.Data.Name)
+	for _, o := range i.Data.Options {
+		if !o.Focused || rpc == nil {
+			continue
+		}
+		typed := strings.ToLower(fmt.Sprintf("%v", o.Value))
+		for _, p := range rpc.Params() {
+			if Name(p.Name) != o.Name {
+				continue
+			}
+			for _, v := range p.AllowedValues {
+				if strings.Contains(strings.ToLower(v), typed) && len(choices) < maxChoices {
+					choices = append(choices, Choice{Name: v, Value: v})
+				}
+			}
+		}
+	}
+	respond(w, response{Type: responseAutocompleteResult, Data: &responseData{Choices: choices}})
+}
+
+// start validates the values, acknowledges the interaction and runs the RPC in the
+// background, once confirmed if it requires confirmation
+func (b *Bot) start(w http.ResponseWriter, i interaction, rpc arpicee.RemoteCall, values map[string]string, confirmed bool) {
+	args, err := chat.ArgsFromValues(rpc.Params(), values)
+	if err != nil {
+		ephemeral(w, fmt.Sprintf("Invalid parameters for **%s**: %s", rpc.Name(), err))
+		return
+	}
+	if !confirmed && b.core.Confirmation(rpc) != arpicee.ConfirmNone {
+		b.askConfirmation(w, i, rpc, values)
+		return
+	}
+	respond(w, response{Type: responseDeferredChannelMessage})
+	go b.run(i, rpc, args)
+}
+
+func embed(e execution.Execution, userID string) Embed {
+	color, _ := strconv.ParseInt(chat.Color(e.Status), 16, 32)
+	em := Embed{
+		Title:       e.RPC,
+		Description: fmt.Sprintf("Remote procedure **%s** invoked by <@%s>", e.RPC, userID),
+		Color:       int(color),
+	}
+	switch e.Status {
+	case execution.StatusRunning:
+		em.Description = fmt.Sprintf("RPC **%s** invoked by <@%s>, currently running...", e.RPC, userID)
+	case execution.StatusFailed:
+		em.Fields = []EmbedField{{Name: "Error Message", Value: truncate(chat.Output(e), 1024)}}
+	default:
+		em.Fields = []EmbedField{{Name: "Result", Value: truncate(chat.Output(e), 1024)}}
+	}
+	return em
+}
+
+func (b *Bot) run(i interaction, rpc arpicee.RemoteCall, args []arpicee.Argument) {
+	u := i.user()
+	err := b.core.Run(context.Background(), rpc, args, User(u.ID), func(e execution.Execution) {
+		m := Message{Embeds: []Embed{embed(e, u.ID)}}
+		err := b.client.EditOriginalResponse(b.applicationID, i.Token, m)
+		if err != nil && e.Done() && i.ChannelID != "" {
+			// Interaction tokens expire after 15 minutes, post the result in a new message
+			_, err = b.client.CreateMessage(i.ChannelID, m)
+		}
+		if err != nil {
+			log.Printf("RPC %s invoked by %s: error posting to Discord: %s", rpc.Name(), u.Username, err)
+		}
+	})
+
+	var forbidden *execution.ForbiddenError
+	if errors.As(err, &forbidden) {
+		log.Printf("RPC %s invoked by %s: %s", rpc.Name(), u.Username, err)
+		b.client.EditOriginalResponse(b.applicationID, i.Token, Message{Content: fmt.Sprintf("You are not allowed to run **%s**", rpc.Name())})
+		return
+	}
+	if err != nil {
+		log.Printf("failed invoking RPC %s: %s", rpc.Name(), err)
+	}
+}
@@ -0,0 +1,275 @@
+package discord
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/chat"
+	"github.com/yannh/arpicee/pkg/execution"
+	"github.com/yannh/arpicee/pkg/mock"
+	"github.com/yannh/arpicee/pkg/policy"
+	"github.com/yannh/arpicee/pkg/registry/registrytest"
+)
+
+// fakeDiscord is a local stand-in for the Discord API, recording commands and message edits
+type fakeDiscord struct {
+	mu       sync.Mutex
+	commands []Command
+	edits    []Message
+}
+
+func (f *fakeDiscord) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Header.Get("Authorization") != "Bot bot-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case r.Method == http.MethodPut && r.URL.Path == "/applications/app1/guilds/guild1/commands":
+		json.NewDecoder(r.Body).Decode(&f.commands)
+	case r.Method == http.MethodPatch && r.URL.Path == "/webhooks/app1/itoken/messages/@original":
+		var m Message
+		json.NewDecoder(r.Body).Decode(&m)
+		f.edits = append(f.edits, m)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+	w.Write([]byte(`{}`))
+}
+
+func newTestBot(t *testing.T) (*fakeDiscord, *Bot, ed25519.PrivateKey) {
+	fake := &fakeDiscord{}
+	api := httptest.NewServer(fake)
+	t.Cleanup(api.Close)
+
+	reg := registrytest.New(t,
+		mock.New("Deploy App", []arpicee.Parameter{
+			{Name: "env", Type: arpicee.TypeString, Required: true, AllowedValues: []string{"staging", "production"}},
+			{Name: "replicas", Type: arpicee.TypeInt},
+			{Name: "version", Type: arpicee.TypeString, Required: true, Description: "version to deploy"},
+		}, nil),
+		mock.New("restart", []arpicee.Parameter{
+			{Name: "replicas", Type: arpicee.TypeInt, Required: true},
+		}, nil),
+		mock.New("restricted", nil, nil),
+		mock.New("destroy", []arpicee.Parameter{
+			{Name: "token", Type: arpicee.TypeInt, Secret: true},
+		}, nil).WithMetadata(arpicee.Metadata{Confirm: arpicee.ConfirmName}),
+		mock.New("debug", nil, nil).WithChannels("ops"),
+	)
+	mgr := execution.NewManager(10)
+	p, err := policy.New([]policy.Rule{{RPC: "restricted", Users: []string{"admin"}}})
+	if err != nil {
+		t.Fatalf("failed creating policy: %s", err)
+	}
+	mgr.SetAuthorizer(p)
+
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	b, err := New(NewClient(api.URL, "bot-token"), chat.NewCore(reg, mgr), "app1", "guild1", hex.EncodeToString(pub))
+	if err != nil {
+		t.Fatalf("failed creating bot: %s", err)
+	}
+	return fake, b, priv
+}
+
+func interact(b *Bot, key ed25519.PrivateKey, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, InteractionsPath, strings.NewReader(body))
+	ts := "1700000000"
+	req.Header.Set("X-Signature-Timestamp", ts)
+	req.Header.Set("X-Signature-Ed25519", hex.EncodeToString(ed25519.Sign(key, append([]byte(ts), body...))))
+	w := httptest.NewRecorder()
+	b.ServeHTTP(w, req)
+	return w
+}
+
+func TestCommands(t *testing.T) {
+	fake, b, _ := newTestBot(t)
+	if err := b.RegisterCommands(); err != nil {
+		t.Fatalf("failed registering commands: %s", err)
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.commands) != 5 {
+		t.Fatalf("expected 5 commands, got %+v", fake.commands)
+	}
+	deploy := fake.commands[0]
+	if deploy.Name != "deploy-app" || len(deploy.Options) != 2 {
+		t.Fatalf("unexpected command for Deploy App: %+v", deploy)
+	}
+	// version is asked for in a modal, required options are listed first
+	if o := deploy.Options[0]; o.Name != "env" || !o.Required || o.Type != optionTypeString || len(o.Choices) != 2 {
+		t.Errorf("unexpected option env: %+v", o)
+	}
+	if o := deploy.Options[1]; o.Name != "replicas" || o.Required || o.Type != optionTypeInteger {
+		t.Errorf("unexpected option replicas: %+v", o)
+	}
+}
+
+func TestCommandLimits(t *testing.T) {
+	var params []arpicee.Parameter
+	for i := 0; i <= maxOptions; i++ {
+		params = append(params, arpicee.Parameter{Name: fmt.Sprintf("p%d", i), Type: arpicee.TypeInt})
+	}
+	rpcs := []arpicee.RemoteCall{mock.New("too-many-params", params, nil)}
+	for i := 0; i <= maxCommands; i++ {
+		rpcs = append(rpcs, mock.New(fmt.Sprintf("rpc%d", i), nil, nil))
+	}
+
+	commands := Commands(rpcs)
+	if len(commands) != maxCommands {
+		t.Fatalf("expected %d commands, got %d", maxCommands, len(commands))
+	}
+	if commands[0].Name != "rpc0" {
+		t.Errorf("expected the RPC with too many parameters to be left out, got %s", commands[0].Name)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	for i, testCase := range []struct {
+		s      string
+		n      int
+		expect string
+	}{
+		{"deploy", 10, "deploy"},
+		{"deploy the app", 10, "deploy t.."},
+		{"déployer l'été", 10, "déployer.."},
+	} {
+		if got := truncate(testCase.s, testCase.n); got != testCase.expect {
+			t.Errorf("test %d - expected %q, got %q", i, testCase.expect, got)
+		}
+	}
+}
+
+func TestInteractions(t *testing.T) {
+	fake, b, key := newTestBot(t)
+
+	for i, testCase := range []struct {
+		body         string
+		badSignature bool
+		expectStatus int
+		expectBody   string
+	}{
+		{`{"type": 1}`, true, http.StatusUnauthorized, "invalid request signature"},
+		{`{"type": 1}`, false, http.StatusOK, `{"type":1}`},
+		{
+			// Users are authorized by ID, not by username
+			`{"id": "i1", "type": 2, "token": "itoken", "member": {"user": {"id": "42", "username": "admin"}}, "data": {"name": "restricted"}}`,
+			false, http.StatusOK, `You are not allowed to run **restricted**`,
+		},
+		{
+			// debug is only available in the ops channel
+			`{"id": "i7", "type": 2, "token": "itoken", "channel_id": "general", "member": {"user": {"id": "42", "username": "alice"}}, "data": {"name": "debug"}}`,
+			false, http.StatusOK, `Unknown remote procedure debug`,
+		},
+		{
+			`{"id": "i2", "type": 2, "token": "itoken", "member": {"user": {"id": "42", "username": "alice"}}, "data": {"name": "restart", "options": []}}`,
+			false, http.StatusOK, `parameter replicas is required`,
+		},
+		{
+			`{"id": "i3", "type": 4, "token": "itoken", "member": {"user": {"id": "42", "username": "alice"}}, "data": {"name": "deploy-app", "options": [{"name": "env", "value": "prod", "focused": true}]}}`,
+			false, http.StatusOK, `"choices":[{"name":"production","value":"production"}]`,
+		},
+		{
+			`{"id": "i4", "type": 2, "token": "itoken", "member": {"user": {"id": "42", "username": "alice"}}, "data": {"name": "deploy-app", "options": [{"name": "env", "value": "staging"}]}}`,
+			false, http.StatusOK, `"type":9,"data":{"custom_id":"i4","title":"Deploy App","components":[{"type":1,"components":[{"type":4,"custom_id":"version"`,
+		},
+		{
+			`{"id": "i5", "type": 5, "token": "itoken", "member": {"user": {"id": "43", "username": "mallory"}}, "data": {"custom_id": "i4", "components": [{"components": [{"custom_id": "version", "value": "1.2.3"}]}]}}`,
+			false, http.StatusOK, `This form has expired`,
+		},
+		{
+			`{"id": "i6", "type": 5, "token": "itoken", "member": {"user": {"id": "42", "username": "alice"}}, "data": {"custom_id": "i4", "components": [{"components": [{"custom_id": "version", "value": "1.2.3"}]}]}}`,
+			false, http.StatusOK, `{"type":5}`,
+		},
+	} {
+		var w *httptest.ResponseRecorder
+		if testCase.badSignature {
+			_, otherKey, _ := ed25519.GenerateKey(nil)
+			w = interact(b, otherKey, testCase.body)
+		} else {
+			w = interact(b, key, testCase.body)
+		}
+		if w.Code != testCase.expectStatus {
+			t.Errorf("test %d - expected status %d, got %d", i, testCase.expectStatus, w.Code)
+		}
+		if !strings.Contains(w.Body.String(), testCase.expectBody) {
+			t.Errorf("test %d - expected body to contain %s, got %s", i, testCase.expectBody, w.Body.String())
+		}
+	}
+
+	var edits []Message
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		fake.mu.Lock()
+		edits = append([]Message{}, fake.edits...)
+		fake.mu.Unlock()
+		if len(edits) == 2 {
+			break
+		}
+	}
+	if len(edits) != 2 {
+		t.Fatalf("expected the response to be edited twice, got %+v", edits)
+	}
+	result := edits[1].Embeds[0]
+	if result.Color != 0x00CB53 || len(result.Fields) != 1 || !strings.Contains(result.Fields[0].Value, "--env staging") || !strings.Contains(result.Fields[0].Value, "--version 1.2.3") {
+		t.Errorf("unexpected result embed: %+v", result)
+	}
+	if running := edits[0].Embeds[0]; !strings.Contains(running.Description, "currently running") {
+		t.Errorf("expected the first edit to report the execution running, got %+v", running)
+	}
+}
+
+func TestConfirmation(t *testing.T) {
+	fake, b, key := newTestBot(t)
+
+	for i, testCase := range []struct {
+		body       string
+		expectBody string
+	}{
+		{
+			`{"id": "i1", "type": 2, "token": "itoken", "member": {"user": {"id": "42", "username": "alice"}}, "data": {"name": "destroy", "options": [{"name": "token", "value": 1234}]}}`,
+			`"content":"You are about to run **destroy** with:\n- token: ` + "`********`" + `","flags":64,"components":[{"type":1,"components":[{"type":2,"custom_id":"confirm:i1","label":"Run","style":4}]}]`,
+		},
+		{
+			`{"id": "i2", "type": 3, "token": "itoken", "member": {"user": {"id": "43", "username": "mallory"}}, "data": {"custom_id": "confirm:i1"}}`,
+			`This confirmation has expired`,
+		},
+		{
+			`{"id": "i3", "type": 3, "token": "itoken", "member": {"user": {"id": "42", "username": "alice"}}, "data": {"custom_id": "confirm:i1"}}`,
+			`"type":9,"data":{"custom_id":"confirm:i1","title":"Confirm destroy","components":[{"type":1,"components":[{"type":4,"custom_id":"arpicee_confirm","label":"Type destroy to confirm"`,
+		},
+		{
+			`{"id": "i4", "type": 5, "token": "itoken", "member": {"user": {"id": "42", "username": "alice"}}, "data": {"custom_id": "confirm:i1", "components": [{"components": [{"custom_id": "arpicee_confirm", "value": "deploy"}]}]}}`,
+			`Type **destroy** to confirm running it`,
+		},
+		{
+			`{"id": "i5", "type": 5, "token": "itoken", "member": {"user": {"id": "42", "username": "alice"}}, "data": {"custom_id": "confirm:i1", "components": [{"components": [{"custom_id": "arpicee_confirm", "value": "destroy"}]}]}}`,
+			`{"type":5}`,
+		},
+	} {
+		w := interact(b, key, testCase.body)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), testCase.expectBody) {
+			t.Errorf("test %d - expected body to contain %s, got %d %s", i, testCase.expectBody, w.Code, w.Body.String())
+		}
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		fake.mu.Lock()
+		n := len(fake.edits)
+		fake.mu.Unlock()
+		if n == 2 {
+			return
+		}
+	}
+	t.Errorf("expected destroy to run once confirmed")
+}
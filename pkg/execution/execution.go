@@ -0,0 +1,343 @@
+package execution
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+)
+
+type Status string
+
+const (
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusCancelled Status = "cancelled"
+)
+
+var (
+	ErrNotFound = errors.New("execution not found")
+	ErrFinished = errors.New("execution already finished")
+)
+
+// Authorizer returns an error if user is not allowed to run rpc
+type Authorizer interface {
+	Authorize(user string, rpc arpicee.RemoteCall) error
+}
+
+// ForbiddenError is returned when the Authorizer refuses an execution
+type ForbiddenError struct {
+	Err error
+}
+
+func (e *ForbiddenError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ForbiddenError) Unwrap() error {
+	return e.Err
+}
+
+type Progress struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// Execution is a snapshot of the state of an RPC invocation
+type Execution struct {
+	ID         string                 `json:"id"`
+	RPC        string                 `json:"rpc"`
+	User       string                 `json:"user,omitempty"`
+	Arguments  map[string]interface{} `json:"arguments"`
+	Status     Status                 `json:"status"`
+	Result     map[string]interface{} `json:"result,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+	Progress   []Progress             `json:"progress,omitempty"`
+	ExternalID string                 `json:"externalId,omitempty"`
+	CreatedAt  time.Time              `json:"createdAt"`
+	FinishedAt *time.Time             `json:"finishedAt,omitempty"`
+}
+
+func (e Execution) Done() bool {
+	return e.Status != StatusRunning
+}
+
+type execution struct {
+	Execution
+	cancel  context.CancelFunc
+	done    chan struct{}
+	changed chan struct{}
+}
+
+// notify wakes up the watchers of the execution; m.mu must be held
+func (e *execution) notify() {
+	close(e.changed)
+	e.changed = make(chan struct{})
+}
+
+// Manager runs RPCs in the background and keeps track of their executions
+type Manager struct {
+	mu         sync.Mutex
+	executions map[string]*execution
+	maxHistory int
+	authorizer Authorizer
+}
+
+// NewManager returns a Manager keeping at most maxHistory finished executions
+func NewManager(maxHistory int) *Manager {
+	return &Manager{
+		executions: map[string]*execution{},
+		maxHistory: maxHistory,
+	}
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// MaskSecrets returns the arguments as a map, with the values of secret parameters masked
+func MaskSecrets(params []arpicee.Parameter, args []arpicee.Argument) map[string]interface{} {
+	m := arpicee.ArgsToMap(args)
+	for _, p := range params {
+		if _, ok := m[p.Name]; ok && p.Secret {
+			m[p.Name] = "********"
+		}
+	}
+	return m
+}
+
+// SetAuthorizer sets the Authorizer checked before starting executions
+func (m *Manager) SetAuthorizer(a Authorizer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.authorizer = a
+}
+
+// Authorize checks if user is allowed to run rpc
+func (m *Manager) Authorize(user string, rpc arpicee.RemoteCall) error {
+	m.mu.Lock()
+	a := m.authorizer
+	m.mu.Unlock()
+	if a == nil {
+		return nil
+	}
+	if err := a.Authorize(user, rpc); err != nil {
+		return &ForbiddenError{Err: err}
+	}
+	return nil
+}
+
+// Start checks the user is authorized, validates the arguments and runs the RPC in the background
+func (m *Manager) Start(rpc arpicee.RemoteCall, args []arpicee.Argument, user string) (Execution, error) {
+	if err := m.Authorize(user, rpc); err != nil {
+		return Execution{}, err
+	}
+	if err := arpicee.ValidateArguments(args, rpc.Params()); err != nil {
+		return Execution{}, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	e := &execution{
+		Execution: Execution{
+			ID:        newID(),
+			RPC:       rpc.Name(),
+			User:      user,
+			Arguments: MaskSecrets(rpc.Params(), args),
+			Status:    StatusRunning,
+			CreatedAt: time.Now(),
+		},
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		changed: make(chan struct{}),
+	}
+
+	m.mu.Lock()
+	m.executions[e.ID] = e
+	m.gc()
+	res := e.Execution
+	m.mu.Unlock()
+
+	ctx = arpicee.WithProgress(ctx, func(msg string) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		e.Progress = append(e.Progress, Progress{Time: time.Now(), Message: msg})
+		e.notify()
+	})
+	ctx = arpicee.WithExternalID(ctx, func(id string) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		e.ExternalID = id
+		e.notify()
+	})
+	ctx = arpicee.WithAuthorizer(ctx, func(rpc arpicee.RemoteCall) error {
+		return m.Authorize(user, rpc)
+	})
+
+	go func() {
+		out, err := arpicee.Run(ctx, rpc, args)
+		m.finish(e, out, err)
+	}()
+
+	return res, nil
+}
+
+func (m *Manager) finish(e *execution, out map[string]interface{}, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	defer e.cancel()
+
+	if e.Done() {
+		// Cancelled while running, the result is discarded
+		return
+	}
+
+	now := time.Now()
+	e.FinishedAt = &now
+	e.Result = out
+	e.Status = StatusSucceeded
+	if err != nil {
+		e.Status = StatusFailed
+		e.Error = err.Error()
+	}
+	close(e.done)
+	e.notify()
+}
+
+// gc removes the oldest finished executions when there are more than maxHistory
+func (m *Manager) gc() {
+	if m.maxHistory <= 0 || len(m.executions) <= m.maxHistory {
+		return
+	}
+	var finished []*execution
+	for _, e := range m.executions {
+		if e.Done() {
+			finished = append(finished, e)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].CreatedAt.Before(finished[j].CreatedAt)
+	})
+	for i := 0; i < len(finished) && len(m.executions) > m.maxHistory; i++ {
+		delete(m.executions, finished[i].ID)
+	}
+}
+
+func (m *Manager) Get(id string) (Execution, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.executions[id]
+	if !ok {
+		return Execution{}, false
+	}
+	return e.copy(), true
+}
+
+func (e *execution) copy() Execution {
+	c := e.Execution
+	c.Progress = append([]Progress{}, e.Progress...)
+	return c
+}
+
+// List returns all executions, most recent first
+func (m *Manager) List() []Execution {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := make([]Execution, 0, len(m.executions))
+	for _, e := range m.executions {
+		res = append(res, e.copy())
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].CreatedAt.After(res[j].CreatedAt)
+	})
+	return res
+}
+
+// AuthorizeExecution checks if user is allowed to see or cancel e: users can manage
+// the executions they started, and those of the RPCs they are allowed to run. rpc is
+// nil when the RPC of e is no longer known.
+func (m *Manager) AuthorizeExecution(user string, e Execution, rpc arpicee.RemoteCall) error {
+	if e.User == user {
+		return nil
+	}
+	if rpc == nil {
+		return &ForbiddenError{Err: fmt.Errorf("execution %s was started by another user", e.ID)}
+	}
+	return m.Authorize(user, rpc)
+}
+
+// ListFor returns the executions user is allowed to see, most recent first. rpc
+// returns the RPC of a name, or nil if it is not known.
+func (m *Manager) ListFor(user string, rpc func(name string) arpicee.RemoteCall) []Execution {
+	res := []Execution{}
+	for _, e := range m.List() {
+		if m.AuthorizeExecution(user, e, rpc(e.RPC)) == nil {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// Cancel cancels a running execution. RPCs that support it are interrupted,
+// the result of the others is discarded.
+func (m *Manager) Cancel(id string) (Execution, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.executions[id]
+	if !ok {
+		return Execution{}, ErrNotFound
+	}
+	if e.Done() {
+		return e.copy(), ErrFinished
+	}
+
+	e.cancel()
+	now := time.Now()
+	e.FinishedAt = &now
+	e.Status = StatusCancelled
+	close(e.done)
+	e.notify()
+	return e.copy(), nil
+}
+
+// Watch returns the current state of the execution, and a channel closed on the next
+// change: a progress message, or the end of the execution
+func (m *Manager) Watch(id string) (Execution, <-chan struct{}, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.executions[id]
+	if !ok {
+		return Execution{}, nil, ErrNotFound
+	}
+	return e.copy(), e.changed, nil
+}
+
+// Wait blocks until the execution is finished or ctx is done
+func (m *Manager) Wait(ctx context.Context, id string) (Execution, error) {
+	m.mu.Lock()
+	e, ok := m.executions[id]
+	m.mu.Unlock()
+	if !ok {
+		return Execution{}, ErrNotFound
+	}
+
+	select {
+	case <-e.done:
+	case <-ctx.Done():
+		return Execution{}, ctx.Err()
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return e.copy(), nil
+}
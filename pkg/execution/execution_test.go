@@ -0,0 +1,225 @@
+package execution
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/mock"
+)
+
+func TestStartAndWait(t *testing.T) {
+	params := []arpicee.Parameter{
+		{Name: "name", Type: arpicee.TypeString, Required: true},
+		{Name: "password", Type: arpicee.TypeString, Secret: true},
+	}
+
+	for i, testCase := range []struct {
+		run          func(ctx context.Context, args []arpicee.Argument) (map[string]interface{}, error)
+		args         []arpicee.Argument
+		expectErr    bool
+		expectStatus Status
+	}{
+		{
+			nil,
+			[]arpicee.Argument{},
+			true,
+			"",
+		},
+		{
+			func(ctx context.Context, args []arpicee.Argument) (map[string]interface{}, error) {
+				arpicee.ReportProgress(ctx, "halfway there")
+				return map[string]interface{}{"foo": "bar"}, nil
+			},
+			[]arpicee.Argument{
+				&arpicee.ArgumentString{Name: "name", Val: "foo"},
+				&arpicee.ArgumentString{Name: "password", Val: "hunter2"},
+			},
+			false,
+			StatusSucceeded,
+		},
+		{
+			func(ctx context.Context, args []arpicee.Argument) (map[string]interface{}, error) {
+				return nil, fmt.Errorf("failed")
+			},
+			[]arpicee.Argument{
+				&arpicee.ArgumentString{Name: "name", Val: "foo"},
+			},
+			false,
+			StatusFailed,
+		},
+	} {
+		m := NewManager(10)
+		e, err := m.Start(mock.New("rpc", params, testCase.run), testCase.args, "user")
+		if (err != nil) != testCase.expectErr {
+			t.Errorf("test %d - expected error: %t, got %s", i, testCase.expectErr, err)
+		}
+		if err != nil {
+			continue
+		}
+
+		if e.Arguments["password"] != nil && e.Arguments["password"] != "********" {
+			t.Errorf("test %d - expected secret argument to be masked, got %s", i, e.Arguments["password"])
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		e, err = m.Wait(ctx, e.ID)
+		cancel()
+		if err != nil {
+			t.Errorf("test %d - failed waiting for execution: %s", i, err)
+		}
+		if e.Status != testCase.expectStatus {
+			t.Errorf("test %d - expected status %s, got %s", i, testCase.expectStatus, e.Status)
+		}
+		if e.Status == StatusSucceeded && len(e.Progress) != 1 {
+			t.Errorf("test %d - expected 1 progress message, got %+v", i, e.Progress)
+		}
+	}
+}
+
+func TestCancel(t *testing.T) {
+	m := NewManager(10)
+	rpc := mock.New("rpc", nil, func(ctx context.Context, args []arpicee.Argument) (map[string]interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	e, err := m.Start(rpc, nil, "user")
+	if err != nil {
+		t.Fatalf("failed starting execution: %s", err)
+	}
+
+	if e, err = m.Cancel(e.ID); err != nil {
+		t.Errorf("failed cancelling execution: %s", err)
+	}
+	if e.Status != StatusCancelled {
+		t.Errorf("expected status to be %s, got %s", StatusCancelled, e.Status)
+	}
+	if _, err = m.Cancel(e.ID); err != ErrFinished {
+		t.Errorf("expected cancelling twice to return %s, got %s", ErrFinished, err)
+	}
+	if _, err = m.Cancel("unknown"); err != ErrNotFound {
+		t.Errorf("expected %s, got %s", ErrNotFound, err)
+	}
+}
+
+type adminOnly struct{}
+
+func (adminOnly) Authorize(user string, rpc arpicee.RemoteCall) error {
+	if user != "admin" {
+		return fmt.Errorf("%s is not allowed to run %s", user, rpc.Name())
+	}
+	return nil
+}
+
+func TestAuthorizeExecution(t *testing.T) {
+	m := NewManager(10)
+	m.SetAuthorizer(adminOnly{})
+	rpc := mock.New("rpc", nil, nil)
+	e := Execution{ID: "1", RPC: "rpc", User: "alice"}
+
+	for i, testCase := range []struct {
+		user      string
+		rpc       arpicee.RemoteCall
+		expectErr bool
+	}{
+		{"alice", rpc, false},
+		{"alice", nil, false},
+		{"admin", rpc, false},
+		{"admin", nil, true},
+		{"bob", rpc, true},
+	} {
+		err := m.AuthorizeExecution(testCase.user, e, testCase.rpc)
+		if (err != nil) != testCase.expectErr {
+			t.Errorf("test %d - expected error to be %t, got %v", i, testCase.expectErr, err)
+		}
+	}
+}
+
+func TestListFor(t *testing.T) {
+	m := NewManager(10)
+	rpc := mock.New("rpc", nil, nil)
+	for _, user := range []string{"alice", "bob"} {
+		e, err := m.Start(rpc, nil, user)
+		if err != nil {
+			t.Fatalf("failed starting execution: %s", err)
+		}
+		m.Wait(context.Background(), e.ID)
+	}
+	m.SetAuthorizer(adminOnly{})
+	lookup := func(name string) arpicee.RemoteCall { return rpc }
+
+	for i, testCase := range []struct {
+		user   string
+		expect int
+	}{
+		{"alice", 1},
+		{"admin", 2},
+		{"carol", 0},
+	} {
+		if n := len(m.ListFor(testCase.user, lookup)); n != testCase.expect {
+			t.Errorf("test %d - expected %d executions, got %d", i, testCase.expect, n)
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	m := NewManager(2)
+	rpc := mock.New("rpc", nil, nil)
+	for i := 0; i < 5; i++ {
+		e, err := m.Start(rpc, nil, "user")
+		if err != nil {
+			t.Fatalf("failed starting execution: %s", err)
+		}
+		m.Wait(context.Background(), e.ID)
+	}
+	// The execution that was just started is kept, even if it is not finished
+	if n := len(m.List()); n > 3 {
+		t.Errorf("expected at most 3 executions in history, got %d", n)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	m := NewManager(10)
+	proceed := make(chan struct{})
+	rpc := mock.New("rpc", nil, func(ctx context.Context, args []arpicee.Argument) (map[string]interface{}, error) {
+		arpicee.ReportProgress(ctx, "step 1")
+		<-proceed
+		return map[string]interface{}{"output": "done"}, nil
+	})
+	e, err := m.Start(rpc, nil, "user")
+	if err != nil {
+		t.Fatalf("failed starting execution: %s", err)
+	}
+
+	var messages []string
+	for {
+		e, changed, err := m.Watch(e.ID)
+		if err != nil {
+			t.Fatalf("failed watching execution: %s", err)
+		}
+		if len(e.Progress) > len(messages) {
+			messages = append(messages, e.Progress[len(messages)].Message)
+			close(proceed)
+		}
+		if e.Done() {
+			if e.Status != StatusSucceeded {
+				t.Errorf("expected status %s, got %s", StatusSucceeded, e.Status)
+			}
+			break
+		}
+		select {
+		case <-changed:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for a change")
+		}
+	}
+	if len(messages) != 1 || messages[0] != "step 1" {
+		t.Errorf("expected progress [step 1], got %v", messages)
+	}
+
+	if _, _, err := m.Watch("unknown"); err != ErrNotFound {
+		t.Errorf("expected %s, got %s", ErrNotFound, err)
+	}
+}
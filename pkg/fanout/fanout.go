@@ -0,0 +1,323 @@
+package fanout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/chat"
+	"github.com/yannh/arpicee/pkg/execution"
+)
+
+// Status of the execution of the RPC on a target
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	// StatusSkipped targets were not run, because too many targets failed or the
+	// fan-out was cancelled
+	StatusSkipped Status = "skipped"
+)
+
+// DefaultConcurrency is the number of targets run in parallel by default
+const DefaultConcurrency = 4
+
+// Target is one execution of the RPC, with Values overriding the common values.
+// Named groups of targets can be defined in the configuration.
+type Target struct {
+	Name   string
+	Values map[string]string
+}
+
+// Targets returns one target per value of the parameter param
+func Targets(param string, values []string) []Target {
+	var targets []Target
+	for _, v := range values {
+		targets = append(targets, Target{Name: v, Values: map[string]string{param: v}})
+	}
+	return targets
+}
+
+// ParseTargets parses values such as "region=eu-west-1,us-east-1", returning one
+// target per comma separated value
+func ParseTargets(s string) ([]Target, error) {
+	param, values, ok := strings.Cut(s, "=")
+	if !ok || param == "" || values == "" {
+		return nil, fmt.Errorf("invalid targets %s, expected param=value1,value2", s)
+	}
+	return Targets(param, strings.Split(values, ",")), nil
+}
+
+// ResolveTargets returns the group of targets named group, if set, followed by the
+// targets parsed from over with ParseTargets, if set
+func ResolveTargets(groups map[string][]Target, group, over string) ([]Target, error) {
+	var targets []Target
+	if group != "" {
+		t, ok := groups[group]
+		if !ok {
+			return nil, fmt.Errorf("no targets named %s", group)
+		}
+		targets = append(targets, t...)
+	}
+	if over != "" {
+		t, err := ParseTargets(over)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, t...)
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no targets")
+	}
+	return targets, nil
+}
+
+// Options control how targets are run. The first Canary targets run one at a time
+// before all others, and stop the fan-out if one of them fails. The remaining targets
+// run up to Concurrency at a time. Once more than MaxFailures targets failed, no new
+// target is started.
+type Options struct {
+	Concurrency int
+	Canary      int
+	MaxFailures int
+}
+
+// Runner runs the RPC with args for one target
+type Runner func(ctx context.Context, args []arpicee.Argument) (map[string]interface{}, error)
+
+// RPCRunner runs rpc directly
+func RPCRunner(rpc arpicee.RemoteCall) Runner {
+	return func(ctx context.Context, args []arpicee.Argument) (map[string]interface{}, error) {
+		return arpicee.Run(ctx, rpc, args)
+	}
+}
+
+// CoreRunner runs rpc through core on behalf of user, so that every target is
+// authorized and shows up in the execution history
+func CoreRunner(core *chat.Core, rpc arpicee.RemoteCall, user string) Runner {
+	return func(ctx context.Context, args []arpicee.Argument) (map[string]interface{}, error) {
+		var e execution.Execution
+		if err := core.Run(ctx, rpc, args, user, func(update execution.Execution) { e = update }); err != nil {
+			return nil, err
+		}
+		if e.Status != execution.StatusSucceeded {
+			return e.Result, errors.New(chat.Output(e))
+		}
+		return e.Result, nil
+	}
+}
+
+// Result is the result of the RPC on a target
+type Result struct {
+	Target   string
+	Status   Status
+	Output   string
+	Duration time.Duration
+}
+
+// Summary aggregates the results of all targets, in the order of the targets
+type Summary struct {
+	RPC     string
+	Results []Result
+}
+
+// Count returns the number of targets with the given status
+func (s Summary) Count(status Status) int {
+	n := 0
+	for _, r := range s.Results {
+		if r.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Done returns if no target is pending or running anymore
+func (s Summary) Done() bool {
+	return s.Count(StatusPending) == 0 && s.Count(StatusRunning) == 0
+}
+
+// Status is failed if any target failed or was skipped, succeeded otherwise
+func (s Summary) Status() Status {
+	switch {
+	case !s.Done():
+		return StatusRunning
+	case s.Count(StatusFailed) > 0 || s.Count(StatusSkipped) > 0:
+		return StatusFailed
+	}
+	return StatusSucceeded
+}
+
+const maxOutputWidth = 60
+
+// firstLine returns the first line of s, truncated for tables
+func firstLine(s string) string {
+	s, _, _ = strings.Cut(strings.TrimSpace(s), "\n")
+	if r := []rune(s); len(r) > maxOutputWidth {
+		s = string(r[:maxOutputWidth-3]) + "..."
+	}
+	return s
+}
+
+// Table returns the results as a text table, followed by the totals
+func (s Summary) Table() string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TARGET\tSTATUS\tDURATION\tOUTPUT")
+	for _, r := range s.Results {
+		d := "-"
+		if r.Duration > 0 {
+			d = r.Duration.Round(time.Millisecond).String()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Target, r.Status, d, firstLine(r.Output))
+	}
+	w.Flush()
+
+	var totals []string
+	for _, status := range []Status{StatusSucceeded, StatusFailed, StatusSkipped, StatusRunning, StatusPending} {
+		if n := s.Count(status); n > 0 {
+			totals = append(totals, fmt.Sprintf("%d %s", n, status))
+		}
+	}
+	fmt.Fprintf(&b, "%d targets: %s\n", len(s.Results), strings.Join(totals, ", "))
+	return b.String()
+}
+
+// output returns the text output of res, or err
+func output(res map[string]interface{}, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	out, oerr := arpicee.Output(res, "text")
+	if errors.Is(oerr, arpicee.ErrMissingFormatString) {
+		out, oerr = arpicee.Output(res, "json")
+	}
+	if oerr != nil {
+		return oerr.Error()
+	}
+	return out
+}
+
+// Run runs rpc on every target, with values common to all targets, and returns the
+// summary once all targets ran or were skipped. update is called with the summary
+// every time a target starts or finishes; it is never called concurrently.
+// The arguments of all targets are validated before any target runs.
+func Run(ctx context.Context, rpc arpicee.RemoteCall, values map[string]string, targets []Target, opts Options, run Runner, update func(Summary)) (Summary, error) {
+	if len(targets) == 0 {
+		return Summary{}, fmt.Errorf("no targets")
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultConcurrency
+	}
+	if opts.MaxFailures < 0 {
+		return Summary{}, fmt.Errorf("negative maximum number of failures")
+	}
+
+	names := map[string]bool{}
+	targetArgs := make([][]arpicee.Argument, len(targets))
+	for i, t := range targets {
+		if names[t.Name] {
+			return Summary{}, fmt.Errorf("duplicate target %s", t.Name)
+		}
+		names[t.Name] = true
+
+		v := map[string]string{}
+		for k, val := range values {
+			v[k] = val
+		}
+		for k, val := range t.Values {
+			v[k] = val
+		}
+		args, err := chat.ArgsFromCommand(rpc.Params(), v)
+		if err != nil {
+			return Summary{}, fmt.Errorf("target %s: %w", t.Name, err)
+		}
+		targetArgs[i] = args
+	}
+
+	summary := Summary{RPC: rpc.Name()}
+	for _, t := range targets {
+		summary.Results = append(summary.Results, Result{Target: t.Name, Status: StatusPending})
+	}
+	if update == nil {
+		update = func(Summary) {}
+	}
+
+	var mu sync.Mutex
+	failures := 0
+	stopped := false
+	// set updates the result of target i, and reports the summary
+	set := func(i int, f func(r *Result)) {
+		mu.Lock()
+		defer mu.Unlock()
+		f(&summary.Results[i])
+		update(copySummary(summary))
+	}
+	runTarget := func(i int) {
+		mu.Lock()
+		if stopped || ctx.Err() != nil {
+			mu.Unlock()
+			return
+		}
+		summary.Results[i].Status = StatusRunning
+		update(copySummary(summary))
+		mu.Unlock()
+
+		start := time.Now()
+		res, err := run(ctx, targetArgs[i])
+		set(i, func(r *Result) {
+			r.Duration = time.Since(start)
+			r.Output = output(res, err)
+			r.Status = StatusSucceeded
+			if err != nil {
+				r.Status = StatusFailed
+				failures++
+				if failures > opts.MaxFailures || i < opts.Canary {
+					stopped = true
+				}
+			}
+		})
+	}
+
+	// Canary targets run one at a time
+	next := 0
+	for ; next < len(targets) && next < opts.Canary; next++ {
+		runTarget(next)
+	}
+
+	sem := make(chan struct{}, opts.Concurrency)
+	var wg sync.WaitGroup
+	for ; next < len(targets); next++ {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			runTarget(i)
+		}(next)
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i := range summary.Results {
+		if summary.Results[i].Status == StatusPending {
+			summary.Results[i].Status = StatusSkipped
+		}
+	}
+	update(copySummary(summary))
+	return copySummary(summary), nil
+}
+
+func copySummary(s Summary) Summary {
+	s.Results = append([]Result{}, s.Results...)
+	return s
+}
@@ -0,0 +1,199 @@
+package fanout
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/mock"
+)
+
+var deploy = mock.New("deploy", []arpicee.Parameter{
+	{Name: "region", Type: arpicee.TypeString, Required: true},
+	{Name: "version", Type: arpicee.TypeString},
+}, nil)
+
+// runner fails on the regions in failing, and records the maximum number of
+// concurrent executions
+type runner struct {
+	mu         sync.Mutex
+	failing    map[string]bool
+	order      []string
+	running    int
+	maxRunning int
+}
+
+func (r *runner) run(ctx context.Context, args []arpicee.Argument) (map[string]interface{}, error) {
+	region := arpicee.ArgsToMap(args)["region"].(string)
+	r.mu.Lock()
+	r.order = append(r.order, region)
+	r.running++
+	if r.running > r.maxRunning {
+		r.maxRunning = r.running
+	}
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.running--
+		r.mu.Unlock()
+	}()
+
+	if r.failing[region] {
+		return nil, fmt.Errorf("deployment to %s failed", region)
+	}
+	return map[string]interface{}{"formatString": "deployed {{ .version }}", "version": arpicee.ArgsToMap(args)["version"]}, nil
+}
+
+func statuses(s Summary) map[string]Status {
+	m := map[string]Status{}
+	for _, r := range s.Results {
+		m[r.Target] = r.Status
+	}
+	return m
+}
+
+func TestRun(t *testing.T) {
+	regions := []string{"eu-west-1", "us-east-1", "us-west-2", "ap-south-1"}
+	for i, testCase := range []struct {
+		options        Options
+		failing        []string
+		expectStatuses map[string]Status
+	}{
+		{
+			Options{Concurrency: 2},
+			nil,
+			map[string]Status{"eu-west-1": StatusSucceeded, "us-east-1": StatusSucceeded, "us-west-2": StatusSucceeded, "ap-south-1": StatusSucceeded},
+		},
+		{
+			Options{Concurrency: 1, Canary: 1},
+			[]string{"eu-west-1"},
+			map[string]Status{"eu-west-1": StatusFailed, "us-east-1": StatusSkipped, "us-west-2": StatusSkipped, "ap-south-1": StatusSkipped},
+		},
+		{
+			Options{Concurrency: 1, MaxFailures: 1},
+			[]string{"us-east-1", "us-west-2"},
+			map[string]Status{"eu-west-1": StatusSucceeded, "us-east-1": StatusFailed, "us-west-2": StatusFailed, "ap-south-1": StatusSkipped},
+		},
+		{
+			Options{Concurrency: 1, MaxFailures: 2},
+			[]string{"us-east-1", "us-west-2"},
+			map[string]Status{"eu-west-1": StatusSucceeded, "us-east-1": StatusFailed, "us-west-2": StatusFailed, "ap-south-1": StatusSucceeded},
+		},
+	} {
+		r := &runner{failing: map[string]bool{}}
+		for _, f := range testCase.failing {
+			r.failing[f] = true
+		}
+		updates := 0
+		s, err := Run(context.Background(), deploy, map[string]string{"version": "v2"}, Targets("region", regions), testCase.options, r.run, func(Summary) {
+			updates++
+		})
+		if err != nil {
+			t.Fatalf("test %d - unexpected error: %s", i, err)
+		}
+		if got := statuses(s); !reflect.DeepEqual(got, testCase.expectStatuses) {
+			t.Errorf("test %d - expected statuses %v, got %v", i, testCase.expectStatuses, got)
+		}
+		if r.maxRunning > testCase.options.Concurrency {
+			t.Errorf("test %d - expected at most %d concurrent executions, got %d", i, testCase.options.Concurrency, r.maxRunning)
+		}
+		if updates == 0 || !s.Done() {
+			t.Errorf("test %d - expected a finished summary and updates, got %d updates", i, updates)
+		}
+	}
+}
+
+func TestCanaryRunsFirst(t *testing.T) {
+	r := &runner{}
+	s, err := Run(context.Background(), deploy, nil, Targets("region", []string{"a", "b", "c", "d", "e"}), Options{Concurrency: 4, Canary: 2}, r.run, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(r.order[:2], []string{"a", "b"}) || s.Status() != StatusSucceeded {
+		t.Errorf("expected canaries a and b to run first, got %v", r.order)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	for i, testCase := range []struct {
+		values    map[string]string
+		targets   []Target
+		expectErr string
+	}{
+		{nil, nil, "no targets"},
+		{nil, []Target{{Name: "a", Values: map[string]string{"region": "a"}}, {Name: "a", Values: map[string]string{"region": "a"}}}, "duplicate target a"},
+		{map[string]string{"env": "prod"}, Targets("region", []string{"a"}), "target a: unknown parameter env"},
+		{nil, []Target{{Name: "a"}}, "target a: parameter region is required"},
+	} {
+		r := &runner{}
+		_, err := Run(context.Background(), deploy, testCase.values, testCase.targets, Options{}, r.run, nil)
+		if err == nil || !strings.Contains(err.Error(), testCase.expectErr) {
+			t.Errorf("test %d - expected error containing %s, got %v", i, testCase.expectErr, err)
+		}
+		if len(r.order) != 0 {
+			t.Errorf("test %d - expected no execution, got %v", i, r.order)
+		}
+	}
+}
+
+func TestResolveTargets(t *testing.T) {
+	groups := map[string][]Target{"prod": {{Name: "prod-eu", Values: map[string]string{"region": "eu-west-1", "account": "prod"}}}}
+	for i, testCase := range []struct {
+		group, over   string
+		expectTargets []string
+		expectErr     bool
+	}{
+		{"prod", "", []string{"prod-eu"}, false},
+		{"", "region=eu-west-1,us-east-1", []string{"eu-west-1", "us-east-1"}, false},
+		{"prod", "region=us-east-1", []string{"prod-eu", "us-east-1"}, false},
+		{"staging", "", nil, true},
+		{"", "region", nil, true},
+		{"", "", nil, true},
+	} {
+		targets, err := ResolveTargets(groups, testCase.group, testCase.over)
+		if (err != nil) != testCase.expectErr {
+			t.Errorf("test %d - expected error %t, got %v", i, testCase.expectErr, err)
+		}
+		var names []string
+		for _, target := range targets {
+			names = append(names, target.Name)
+		}
+		if !reflect.DeepEqual(names, testCase.expectTargets) {
+			t.Errorf("test %d - expected targets %v, got %v", i, testCase.expectTargets, names)
+		}
+	}
+}
+
+func TestTable(t *testing.T) {
+	s := Summary{RPC: "deploy", Results: []Result{
+		{Target: "eu-west-1", Status: StatusSucceeded, Output: "deployed v2\nsecond line"},
+		{Target: "us-east-1", Status: StatusFailed, Output: "deployment to us-east-1 failed"},
+		{Target: "ap-south-1", Status: StatusSkipped},
+	}}
+	expect := "TARGET      STATUS     DURATION  OUTPUT\n" +
+		"eu-west-1   succeeded  -         deployed v2\n" +
+		"us-east-1   failed     -         deployment to us-east-1 failed\n" +
+		"ap-south-1  skipped    -         \n" +
+		"3 targets: 1 succeeded, 1 failed, 1 skipped\n"
+	if got := s.Table(); got != expect {
+		t.Errorf("expected table\n%s\ngot\n%s", expect, got)
+	}
+}
+
+func TestFirstLine(t *testing.T) {
+	for i, testCase := range []struct {
+		s      string
+		expect string
+	}{
+		{"deployed v2\nsecond line", "deployed v2"},
+		{strings.Repeat("é", 70), strings.Repeat("é", 57) + "..."},
+	} {
+		if got := firstLine(testCase.s); got != testCase.expect {
+			t.Errorf("test %d - expected %q, got %q", i, testCase.expect, got)
+		}
+	}
+}
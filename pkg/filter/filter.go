@@ -0,0 +1,231 @@
+package filter
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Item is a discovered item, such as a Lambda function, an SSM document or a GitHub
+// workflow, with its tags
+type Item struct {
+	Name string
+	Tags map[string]string
+}
+
+// Matcher returns true if the item is selected
+type Matcher func(Item) bool
+
+// Filter selects the discovered items by name and tags. An item matches when all the
+// conditions set match; an empty filter matches every item.
+//
+// Patterns are globs such as "deploy-*", see path.Match, or regular expressions when
+// prefixed with "re:", such as "re:^(deploy|rollback)-". The pattern "*" matches any
+// value.
+type Filter struct {
+	// Name matches the items whose name matches one of the patterns
+	Name []string
+	// ExcludeName rejects the items whose name matches one of the patterns
+	ExcludeName []string
+	// Tags matches the items having all the tags, with a value matching the pattern;
+	// "*" only checks that the tag exists
+	Tags map[string]string
+	// ExcludeTags rejects the items having one of the tags, with a value matching the
+	// pattern
+	ExcludeTags map[string]string
+
+	// All, Any and None match the items matched by all, at least one, or none of
+	// the filters
+	All  []Filter
+	Any  []Filter
+	None []Filter
+}
+
+// CompilePattern returns a function matching the strings matched by pattern
+func CompilePattern(pattern string) (func(string) bool, error) {
+	if pattern == "*" {
+		return func(string) bool { return true }, nil
+	}
+	if strings.HasPrefix(pattern, "re:") {
+		expr := strings.TrimPrefix(pattern, "re:")
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression %s: %w", expr, err)
+		}
+		return re.MatchString, nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("invalid pattern %s: %w", pattern, err)
+	}
+	return func(s string) bool {
+		ok, _ := path.Match(pattern, s)
+		return ok
+	}, nil
+}
+
+func compileAll(patterns []string) ([]func(string) bool, error) {
+	var res []func(string) bool
+	for _, p := range patterns {
+		m, err := CompilePattern(p)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, m)
+	}
+	return res, nil
+}
+
+func anyMatch(matchers []func(string) bool, s string) bool {
+	for _, m := range matchers {
+		if m(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// tagMatcher is a pattern on the value of a tag
+type tagMatcher struct {
+	key   string
+	match func(string) bool
+}
+
+func (t tagMatcher) matches(tags map[string]string) bool {
+	v, ok := tags[t.key]
+	return ok && t.match(v)
+}
+
+func compileTags(tags map[string]string) ([]tagMatcher, error) {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var res []tagMatcher
+	for _, k := range keys {
+		m, err := CompilePattern(tags[k])
+		if err != nil {
+			return nil, fmt.Errorf("tag %s: %w", k, err)
+		}
+		res = append(res, tagMatcher{k, m})
+	}
+	return res, nil
+}
+
+func compileFilters(filters []Filter) ([]Matcher, error) {
+	var res []Matcher
+	for _, f := range filters {
+		m, err := f.Compile()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, m)
+	}
+	return res, nil
+}
+
+// Compile returns the Matcher of the filter, or an error if a pattern is invalid
+func (f Filter) Compile() (Matcher, error) {
+	names, err := compileAll(f.Name)
+	if err != nil {
+		return nil, err
+	}
+	excludeNames, err := compileAll(f.ExcludeName)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := compileTags(f.Tags)
+	if err != nil {
+		return nil, err
+	}
+	excludeTags, err := compileTags(f.ExcludeTags)
+	if err != nil {
+		return nil, err
+	}
+	all, err := compileFilters(f.All)
+	if err != nil {
+		return nil, err
+	}
+	anyOf, err := compileFilters(f.Any)
+	if err != nil {
+		return nil, err
+	}
+	none, err := compileFilters(f.None)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(it Item) bool {
+		if len(names) > 0 && !anyMatch(names, it.Name) {
+			return false
+		}
+		if anyMatch(excludeNames, it.Name) {
+			return false
+		}
+		for _, t := range tags {
+			if !t.matches(it.Tags) {
+				return false
+			}
+		}
+		for _, t := range excludeTags {
+			if t.matches(it.Tags) {
+				return false
+			}
+		}
+		for _, m := range all {
+			if !m(it) {
+				return false
+			}
+		}
+		if len(anyOf) > 0 && !Or(anyOf...)(it) {
+			return false
+		}
+		for _, m := range none {
+			if m(it) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// Tag matches the items with the tag key set to value
+func Tag(key, value string) Matcher {
+	return func(it Item) bool {
+		v, ok := it.Tags[key]
+		return ok && v == value
+	}
+}
+
+// NameIs matches the item named name
+func NameIs(name string) Matcher {
+	return func(it Item) bool {
+		return it.Name == name
+	}
+}
+
+// And matches the items matched by all matchers; nil matchers are ignored
+func And(matchers ...Matcher) Matcher {
+	return func(it Item) bool {
+		for _, m := range matchers {
+			if m != nil && !m(it) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Or matches the items matched by at least one of the matchers
+func Or(matchers ...Matcher) Matcher {
+	return func(it Item) bool {
+		for _, m := range matchers {
+			if m(it) {
+				return true
+			}
+		}
+		return false
+	}
+}
@@ -0,0 +1,74 @@
+package filter
+
+import (
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	deploy := Item{Name: "deploy-api", Tags: map[string]string{"team": "platform", "env": "prod"}}
+	rollback := Item{Name: "rollback-api", Tags: map[string]string{"team": "payments"}}
+	cleanup := Item{Name: "cleanup", Tags: map[string]string{}}
+
+	for i, testCase := range []struct {
+		filter Filter
+		expect []bool // matches of deploy, rollback and cleanup
+	}{
+		{Filter{}, []bool{true, true, true}},
+		{Filter{Name: []string{"deploy-*"}}, []bool{true, false, false}},
+		{Filter{Name: []string{"re:^(deploy|rollback)-"}}, []bool{true, true, false}},
+		{Filter{ExcludeName: []string{"re:-api$"}}, []bool{false, false, true}},
+		{Filter{Tags: map[string]string{"team": "*"}}, []bool{true, true, false}},
+		{Filter{Tags: map[string]string{"team": "pay*"}}, []bool{false, true, false}},
+		{Filter{ExcludeTags: map[string]string{"env": "prod"}}, []bool{false, true, true}},
+		{Filter{Any: []Filter{{Tags: map[string]string{"env": "*"}}, {Name: []string{"cleanup"}}}}, []bool{true, false, true}},
+		{Filter{All: []Filter{{Tags: map[string]string{"team": "*"}}, {Name: []string{"*-api"}}}}, []bool{true, true, false}},
+		{Filter{None: []Filter{{Tags: map[string]string{"team": "platform"}}}}, []bool{false, true, true}},
+		{Filter{Name: []string{"*-api"}, None: []Filter{{Any: []Filter{{Tags: map[string]string{"env": "prod"}}}}}}, []bool{false, true, false}},
+	} {
+		m, err := testCase.filter.Compile()
+		if err != nil {
+			t.Errorf("test %d - unexpected error: %s", i, err)
+			continue
+		}
+		for j, it := range []Item{deploy, rollback, cleanup} {
+			if got := m(it); got != testCase.expect[j] {
+				t.Errorf("test %d - expected %s to match: %t, got %t", i, it.Name, testCase.expect[j], got)
+			}
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for i, testCase := range []struct {
+		filter Filter
+		expect string
+	}{
+		{Filter{Name: []string{"[a"}}, "invalid pattern [a: syntax error in pattern"},
+		{Filter{Any: []Filter{{ExcludeName: []string{"re:("}}}}, "invalid regular expression (: error parsing regexp: missing closing ): `(`"},
+		{Filter{Tags: map[string]string{"team": "[a"}}, "tag team: invalid pattern [a: syntax error in pattern"},
+	} {
+		if _, err := testCase.filter.Compile(); err == nil || err.Error() != testCase.expect {
+			t.Errorf("test %d - expected error %s, got %v", i, testCase.expect, err)
+		}
+	}
+}
+
+func TestMatchers(t *testing.T) {
+	it := Item{Name: "deploy", Tags: map[string]string{"arpicee": "1"}}
+	for i, testCase := range []struct {
+		m      Matcher
+		expect bool
+	}{
+		{Tag("arpicee", "1"), true},
+		{Tag("arpicee", "*"), false},
+		{NameIs("deploy"), true},
+		{And(nil, Tag("arpicee", "1"), NameIs("deploy")), true},
+		{And(Tag("arpicee", "1"), NameIs("release")), false},
+		{Or(NameIs("release"), NameIs("deploy")), true},
+		{Or(), false},
+	} {
+		if got := testCase.m(it); got != testCase.expect {
+			t.Errorf("test %d - expected %t, got %t", i, testCase.expect, got)
+		}
+	}
+}
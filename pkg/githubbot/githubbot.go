@@ -0,0 +1,366 @@
+package githubbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v50/github"
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/chat"
+	"github.com/yannh/arpicee/pkg/execution"
+)
+
+const (
+	WebhookPath = "/github/webhook"
+
+	// ReplyComment and ReplyCheckRun are the ways results are reported
+	ReplyComment  = "comment"
+	ReplyCheckRun = "check-run"
+
+	commandPrefix = "/arpicee"
+)
+
+// permissionLevels orders the repository permissions returned by the GitHub API
+var permissionLevels = map[string]int{
+	"none":  0,
+	"read":  1,
+	"write": 2,
+	"admin": 3,
+}
+
+// Bot runs RPCs on comments such as "/arpicee run deploy env=staging" posted on issues
+// and pull requests. Commenters need at least the minimum permission on the
+// repository, and are identified by their GitHub login in access rules.
+type Bot struct {
+	client        *github.Client
+	core          *chat.Core
+	webhookSecret []byte
+	minPermission string
+	reply         string
+}
+
+// User returns the user executions are attributed to, in access rules, for the GitHub
+// user login
+func User(login string) string {
+	return "github:" + login
+}
+
+// Channel returns the identifier of the repository owner/repo, in the channels RPCs
+// are restricted to, see arpicee.ChannelRestricted
+func Channel(owner, repo string) string {
+	return owner + "/" + repo
+}
+
+func New(client *github.Client, core *chat.Core, webhookSecret, minPermission, reply string) (*Bot, error) {
+	if webhookSecret == "" {
+		return nil, fmt.Errorf("missing webhook secret")
+	}
+	if minPermission == "" {
+		minPermission = "write"
+	}
+	if _, ok := permissionLevels[minPermission]; !ok || minPermission == "none" {
+		return nil, fmt.Errorf("invalid minimum permission %s, expected read, write or admin", minPermission)
+	}
+	if reply == "" {
+		reply = ReplyComment
+	}
+	if reply != ReplyComment && reply != ReplyCheckRun {
+		return nil, fmt.Errorf("invalid reply %s, expected %s or %s", reply, ReplyComment, ReplyCheckRun)
+	}
+	return &Bot{
+		client:        client,
+		core:          core,
+		webhookSecret: []byte(webhookSecret),
+		minPermission: minPermission,
+		reply:         reply,
+	}, nil
+}
+
+// Register adds the webhook endpoint to mux
+func (b *Bot) Register(mux *http.ServeMux) {
+	mux.Handle(WebhookPath, b)
+}
+
+// Command is a command parsed from a comment. Yes and Confirmed are the value of the
+// --yes option confirming running the RPC, and whether it was set, see chat.TakeYes.
+type Command struct {
+	Action    string
+	RPC       string
+	Values    map[string]string
+	Yes       string
+	Confirmed bool
+}
+
+// ParseCommand returns the command in the first line of body starting with "/arpicee",
+// or nil if there is none
+func ParseCommand(body string) (*Command, error) {
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if line != commandPrefix && !strings.HasPrefix(line, commandPrefix+" ") {
+			continue
+		}
+
+		fields, err := chat.SplitFields(strings.TrimPrefix(line, commandPrefix))
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) == 0 {
+			return &Command{Action: "help"}, nil
+		}
+		cmd := &Command{Action: fields[0]}
+		switch cmd.Action {
+		case "help", "list":
+			return cmd, nil
+		case "run":
+		default:
+			return nil, fmt.Errorf("unknown command %s", cmd.Action)
+		}
+
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("usage: %s run RPC [param=value]... [--yes]", commandPrefix)
+		}
+		cmd.RPC = fields[1]
+		fields, cmd.Yes, cmd.Confirmed = chat.TakeYes(fields[2:])
+		if cmd.Values, err = chat.KeyValues(fields); err != nil {
+			return nil, err
+		}
+		return cmd, nil
+	}
+	return nil, nil
+}
+
+func (b *Bot) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	payload, err := github.ValidatePayload(r, b.webhookSecret)
+	if err != nil {
+		log.Printf("refused GitHub webhook: %s", err)
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+	event, err := github.ParseWebHook(github.WebHookType(r), payload)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Only new comments are handled, ignoring the ones posted by bots - including ours
+	ev, ok := event.(*github.IssueCommentEvent)
+	if !ok || ev.GetAction() != "created" || ev.GetComment().GetUser().GetType() == "Bot" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if err := b.handleComment(r.Context(), ev); err != nil {
+		log.Printf("failed handling GitHub comment %s: %s", ev.GetComment().GetHTMLURL(), err)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// comment is the issue or pull request a command was posted on
+type comment struct {
+	owner  string
+	repo   string
+	number int
+	isPR   bool
+	login  string
+}
+
+func (b *Bot) postComment(ctx context.Context, c comment, body string) (int64, error) {
+	res, _, err := b.client.Issues.CreateComment(ctx, c.owner, c.repo, c.number, &github.IssueComment{Body: github.String(body)})
+	return res.GetID(), err
+}
+
+func (b *Bot) handleComment(ctx context.Context, ev *github.IssueCommentEvent) error {
+	cmd, err := ParseCommand(ev.GetComment().GetBody())
+	if cmd == nil && err == nil {
+		return nil
+	}
+	c := comment{
+		owner:  ev.GetRepo().GetOwner().GetLogin(),
+		repo:   ev.GetRepo().GetName(),
+		number: ev.GetIssue().GetNumber(),
+		isPR:   ev.GetIssue().IsPullRequest(),
+		login:  ev.GetComment().GetUser().GetLogin(),
+	}
+	if err != nil {
+		_, err = b.postComment(ctx, c, fmt.Sprintf("@%s %s", c.login, err))
+		return err
+	}
+
+	perm, _, err := b.client.Repositories.GetPermissionLevel(ctx, c.owner, c.repo, c.login)
+	if err != nil {
+		return fmt.Errorf("failed getting the permission of %s: %w", c.login, err)
+	}
+	if permissionLevels[perm.GetPermission()] < permissionLevels[b.minPermission] {
+		log.Printf("GitHub user %s with permission %s on %s/%s refused", c.login, perm.GetPermission(), c.owner, c.repo)
+		_, err = b.postComment(ctx, c, fmt.Sprintf("@%s running remote procedures requires the %s permission on this repository", c.login, b.minPermission))
+		return err
+	}
+
+	channel := Channel(c.owner, c.repo)
+	available := b.core.RPCsIn(channel)
+	if cmd.Action != "run" {
+		_, err = b.postComment(ctx, c, usage(available))
+		return err
+	}
+
+	rpc := b.core.RPCIn(channel, cmd.RPC)
+	if rpc == nil {
+		_, err = b.postComment(ctx, c, fmt.Sprintf("@%s unknown remote procedure `%s`\n\n%s", c.login, cmd.RPC, usage(available)))
+		return err
+	}
+	if err := b.core.Authorize(User(c.login), rpc); err != nil {
+		log.Printf("RPC %s invoked by %s: %s", rpc.Name(), c.login, err)
+		_, err = b.postComment(ctx, c, fmt.Sprintf("@%s you are not allowed to run `%s`", c.login, rpc.Name()))
+		return err
+	}
+	args, err := chat.ArgsFromCommand(rpc.Params(), cmd.Values)
+	if err != nil {
+		_, err = b.postComment(ctx, c, fmt.Sprintf("@%s %s", c.login, err))
+		return err
+	}
+	if option := chat.ConfirmOption(rpc, b.core.Confirmation(rpc), cmd.Yes, cmd.Confirmed); option != "" {
+		_, err = b.postComment(ctx, c, fmt.Sprintf("@%s `%s` requires confirmation, run the command again with `%s`", c.login, rpc.Name(), option))
+		return err
+	}
+
+	var headSHA string
+	if b.reply == ReplyCheckRun && c.isPR {
+		pr, _, err := b.client.PullRequests.Get(ctx, c.owner, c.repo, c.number)
+		if err != nil {
+			return fmt.Errorf("failed getting pull request %d: %w", c.number, err)
+		}
+		headSHA = pr.GetHead().GetSHA()
+	}
+	go b.run(c, rpc, args, headSHA)
+	return nil
+}
+
+func usage(rpcs []arpicee.RemoteCall) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Usage: `%s run RPC [param=value]... [--yes]`\n\nAvailable remote procedures:\n", commandPrefix))
+	groups := arpicee.GroupByCategory(rpcs)
+	for _, g := range groups {
+		if len(groups) > 1 || g.Category != arpicee.Uncategorized {
+			sb.WriteString(fmt.Sprintf("\n**%s**\n", g.Category))
+		}
+		for _, rpc := range g.RPCs {
+			sb.WriteString(usageLine(rpc))
+		}
+	}
+	return sb.String()
+}
+
+// usageLine describes the command running rpc, and the summary of its metadata
+func usageLine(rpc arpicee.RemoteCall) string {
+	params := []string{}
+	for _, p := range rpc.Params() {
+		param := p.Name + "=" + p.Type.String()
+		if !p.Required {
+			param = "[" + param + "]"
+		}
+		params = append(params, param)
+	}
+	sort.Strings(params)
+	line := fmt.Sprintf("- `%s %s` %s", rpc.Name(), strings.Join(params, " "), rpc.Description())
+	if summary := arpicee.MetadataOf(rpc).Summary(); len(summary) > 0 {
+		line += fmt.Sprintf(" _(%s)_", strings.Join(summary, ", "))
+	}
+	return line + "\n"
+}
+
+var statusEmoji = map[execution.Status]string{
+	execution.StatusRunning:   ":hourglass_flowing_sand:",
+	execution.StatusSucceeded: ":white_check_mark:",
+	execution.StatusFailed:    ":x:",
+	execution.StatusCancelled: ":no_entry_sign:",
+}
+
+// ResultComment reports on the execution e, invoked by login
+func ResultComment(e execution.Execution, login string) string {
+	if e.Status == execution.StatusRunning {
+		return fmt.Sprintf("%s Remote procedure `%s` invoked by @%s, currently running...", statusEmoji[e.Status], e.RPC, login)
+	}
+	return fmt.Sprintf("%s Remote procedure `%s` invoked by @%s %s\n\n```\n%s\n```", statusEmoji[e.Status], e.RPC, login, e.Status, chat.Output(e))
+}
+
+var conclusions = map[execution.Status]string{
+	execution.StatusSucceeded: "success",
+	execution.StatusFailed:    "failure",
+	execution.StatusCancelled: "cancelled",
+}
+
+func (b *Bot) run(c comment, rpc arpicee.RemoteCall, args []arpicee.Argument, headSHA string) {
+	ctx := context.Background()
+	var commentID, checkRunID int64
+	checkName := "arpicee/" + rpc.Name()
+
+	err := b.core.Run(ctx, rpc, args, User(c.login), func(e execution.Execution) {
+		var err error
+		switch {
+		case headSHA != "" && e.Status == execution.StatusRunning:
+			var cr *github.CheckRun
+			cr, _, err = b.client.Checks.CreateCheckRun(ctx, c.owner, c.repo, github.CreateCheckRunOptions{
+				Name:       checkName,
+				HeadSHA:    headSHA,
+				ExternalID: github.String(e.ID),
+				Status:     github.String("in_progress"),
+				StartedAt:  &github.Timestamp{Time: e.CreatedAt},
+				Output: &github.CheckRunOutput{
+					Title:   github.String("Running"),
+					Summary: github.String(ResultComment(e, c.login)),
+				},
+			})
+			checkRunID = cr.GetID()
+		// The check run could not be created: create it completed
+		case headSHA != "" && checkRunID == 0:
+			_, _, err = b.client.Checks.CreateCheckRun(ctx, c.owner, c.repo, github.CreateCheckRunOptions{
+				Name:        checkName,
+				HeadSHA:     headSHA,
+				ExternalID:  github.String(e.ID),
+				Status:      github.String("completed"),
+				Conclusion:  github.String(conclusions[e.Status]),
+				StartedAt:   &github.Timestamp{Time: e.CreatedAt},
+				CompletedAt: &github.Timestamp{Time: time.Now()},
+				Output: &github.CheckRunOutput{
+					Title:   github.String(string(e.Status)),
+					Summary: github.String(ResultComment(e, c.login)),
+				},
+			})
+		case headSHA != "":
+			_, _, err = b.client.Checks.UpdateCheckRun(ctx, c.owner, c.repo, checkRunID, github.UpdateCheckRunOptions{
+				Name:        checkName,
+				Status:      github.String("completed"),
+				Conclusion:  github.String(conclusions[e.Status]),
+				CompletedAt: &github.Timestamp{Time: time.Now()},
+				Output: &github.CheckRunOutput{
+					Title:   github.String(string(e.Status)),
+					Summary: github.String(ResultComment(e, c.login)),
+				},
+			})
+		// The comment could not be posted when the RPC started: post the result instead
+		case e.Status == execution.StatusRunning || commentID == 0:
+			commentID, err = b.postComment(ctx, c, ResultComment(e, c.login))
+		default:
+			_, _, err = b.client.Issues.EditComment(ctx, c.owner, c.repo, commentID, &github.IssueComment{
+				Body: github.String(ResultComment(e, c.login)),
+			})
+		}
+		if err != nil {
+			log.Printf("RPC %s invoked by %s: error posting results to GitHub: %s", rpc.Name(), c.login, err)
+		}
+	})
+
+	var forbidden *execution.ForbiddenError
+	if errors.As(err, &forbidden) {
+		log.Printf("RPC %s invoked by %s: %s", rpc.Name(), c.login, err)
+		return
+	}
+	if err != nil {
+		log.Printf("failed invoking RPC %s: %s", rpc.Name(), err)
+	}
+}
@@ -0,0 +1,316 @@
+package githubbot
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v50/github"
+	ghmock "github.com/migueleliasweb/go-github-mock/src/mock"
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/chat"
+	"github.com/yannh/arpicee/pkg/execution"
+	"github.com/yannh/arpicee/pkg/mock"
+	"github.com/yannh/arpicee/pkg/policy"
+	"github.com/yannh/arpicee/pkg/registry/registrytest"
+)
+
+func TestParseCommand(t *testing.T) {
+	for i, testCase := range []struct {
+		body      string
+		expect    *Command
+		expectErr bool
+	}{
+		{"LGTM", nil, false},
+		{"see /arpicee run deploy", nil, false},
+		{"/arpicee", &Command{Action: "help"}, false},
+		{"/arpicee list", &Command{Action: "list"}, false},
+		{
+			"Deploying\r\n/arpicee run deploy env=staging msg=\"hello world\" tag='v1'",
+			&Command{Action: "run", RPC: "deploy", Values: map[string]string{"env": "staging", "msg": "hello world", "tag": "v1"}},
+			false,
+		},
+		{
+			"/arpicee run deploy --yes env=staging",
+			&Command{Action: "run", RPC: "deploy", Values: map[string]string{"env": "staging"}, Confirmed: true},
+			false,
+		},
+		{"/arpicee run", nil, true},
+		{"/arpicee run deploy env", nil, true},
+		{"/arpicee run deploy msg=\"hello", nil, true},
+		{"/arpicee destroy", nil, true},
+	} {
+		cmd, err := ParseCommand(testCase.body)
+		if (err != nil) != testCase.expectErr {
+			t.Errorf("test %d - expected error %t, got %v", i, testCase.expectErr, err)
+		}
+		if !reflect.DeepEqual(cmd, testCase.expect) {
+			t.Errorf("test %d - expected %+v, got %+v", i, testCase.expect, cmd)
+		}
+	}
+}
+
+// fakeGitHub records the comments and check runs created through the mocked GitHub API.
+// When failFirst is set, creating the first comment or check run fails.
+type fakeGitHub struct {
+	mu          sync.Mutex
+	permissions map[string]string
+	comments    []string
+	checkRuns   []github.CreateCheckRunOptions
+	failFirst   bool
+	failed      bool
+	done        chan struct{}
+}
+
+// fail returns true if the creation of a comment or check run must fail; f.mu must be held
+func (f *fakeGitHub) fail(w http.ResponseWriter) bool {
+	if !f.failFirst || f.failed {
+		return false
+	}
+	f.failed = true
+	w.WriteHeader(http.StatusInternalServerError)
+	return true
+}
+
+func (f *fakeGitHub) client() *github.Client {
+	return github.NewClient(ghmock.NewMockedHTTPClient(
+		ghmock.WithRequestMatchHandler(
+			ghmock.GetReposCollaboratorsPermissionByOwnerByRepoByUsername,
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				user := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/repos/yannh/arpicee/collaborators/"), "/permission")
+				w.Write(ghmock.MustMarshal(github.RepositoryPermissionLevel{Permission: github.String(f.permissions[user])}))
+			}),
+		),
+		ghmock.WithRequestMatchHandler(
+			ghmock.PostReposIssuesCommentsByOwnerByRepoByIssueNumber,
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var c github.IssueComment
+				json.NewDecoder(r.Body).Decode(&c)
+				f.mu.Lock()
+				defer f.mu.Unlock()
+				if f.fail(w) {
+					return
+				}
+				f.comments = append(f.comments, c.GetBody())
+				w.Write(ghmock.MustMarshal(github.IssueComment{ID: github.Int64(int64(len(f.comments)))}))
+				if f.failed {
+					close(f.done)
+				}
+			}),
+		),
+		ghmock.WithRequestMatchHandler(
+			ghmock.PatchReposIssuesCommentsByOwnerByRepoByCommentId,
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var c github.IssueComment
+				json.NewDecoder(r.Body).Decode(&c)
+				f.mu.Lock()
+				defer f.mu.Unlock()
+				f.comments[len(f.comments)-1] = c.GetBody()
+				w.Write(ghmock.MustMarshal(c))
+				close(f.done)
+			}),
+		),
+		ghmock.WithRequestMatch(
+			ghmock.GetReposPullsByOwnerByRepoByPullNumber,
+			github.PullRequest{Head: &github.PullRequestBranch{SHA: github.String("abc123")}},
+		),
+		ghmock.WithRequestMatchHandler(
+			ghmock.PostReposCheckRunsByOwnerByRepo,
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var opts github.CreateCheckRunOptions
+				json.NewDecoder(r.Body).Decode(&opts)
+				f.mu.Lock()
+				defer f.mu.Unlock()
+				if f.fail(w) {
+					return
+				}
+				f.checkRuns = append(f.checkRuns, opts)
+				w.Write(ghmock.MustMarshal(github.CheckRun{ID: github.Int64(42)}))
+				if f.failed {
+					close(f.done)
+				}
+			}),
+		),
+		ghmock.WithRequestMatchHandler(
+			ghmock.PatchReposCheckRunsByOwnerByRepoByCheckRunId,
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var opts github.UpdateCheckRunOptions
+				json.NewDecoder(r.Body).Decode(&opts)
+				f.mu.Lock()
+				defer f.mu.Unlock()
+				f.checkRuns[len(f.checkRuns)-1].Status = opts.Status
+				f.checkRuns[len(f.checkRuns)-1].Conclusion = opts.Conclusion
+				w.Write(ghmock.MustMarshal(github.CheckRun{ID: github.Int64(42)}))
+				close(f.done)
+			}),
+		),
+	))
+}
+
+func newTestBot(t *testing.T, f *fakeGitHub, reply string) *Bot {
+	reg := registrytest.New(t,
+		mock.New("deploy", []arpicee.Parameter{
+			{Name: "env", Type: arpicee.TypeString, Required: true, AllowedValues: []string{"staging", "production"}},
+			{Name: "dryrun", Type: arpicee.TypeBool},
+		}, func(ctx context.Context, args []arpicee.Argument) (map[string]interface{}, error) {
+			return map[string]interface{}{"formatString": "deployed"}, nil
+		}),
+		mock.New("destroy", nil, nil),
+		mock.New("purge", nil, func(ctx context.Context, args []arpicee.Argument) (map[string]interface{}, error) {
+			return map[string]interface{}{"formatString": "purged"}, nil
+		}).WithMetadata(arpicee.Metadata{Risk: arpicee.RiskHigh}),
+		mock.New("release", nil, nil).WithChannels("yannh/other"),
+	)
+	pol, err := policy.New([]policy.Rule{{RPC: "destroy", Users: []string{"admin"}}})
+	if err != nil {
+		t.Fatalf("failed creating policy: %s", err)
+	}
+	executions := execution.NewManager(100)
+	executions.SetAuthorizer(pol)
+
+	b, err := New(f.client(), chat.NewCore(reg, executions), "webhook-secret", "write", reply)
+	if err != nil {
+		t.Fatalf("failed creating bot: %s", err)
+	}
+	return b
+}
+
+func commentEvent(login, body string, isPR bool) []byte {
+	issue := &github.Issue{Number: github.Int(7)}
+	if isPR {
+		issue.PullRequestLinks = &github.PullRequestLinks{URL: github.String("https://api.github.com/repos/yannh/arpicee/pulls/7")}
+	}
+	b, _ := json.Marshal(github.IssueCommentEvent{
+		Action: github.String("created"),
+		Issue:  issue,
+		Comment: &github.IssueComment{
+			Body: github.String(body),
+			User: &github.User{Login: github.String(login), Type: github.String("User")},
+		},
+		Repo: &github.Repository{Name: github.String("arpicee"), Owner: &github.User{Login: github.String("yannh")}},
+	})
+	return b
+}
+
+func sendEvent(b *Bot, secret string, payload []byte) *httptest.ResponseRecorder {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	req := httptest.NewRequest(http.MethodPost, WebhookPath, bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-GitHub-Event", "issue_comment")
+	req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	w := httptest.NewRecorder()
+	b.ServeHTTP(w, req)
+	return w
+}
+
+func TestComments(t *testing.T) {
+	for i, testCase := range []struct {
+		login         string
+		body          string
+		expectRun     bool
+		expectComment string
+	}{
+		{"reader", "/arpicee run deploy env=staging", false, "requires the write permission"},
+		{"writer", "/arpicee help", false, "Available remote procedures"},
+		{"writer", "/arpicee run unknown", false, "unknown remote procedure `unknown`"},
+		{"writer", "/arpicee run release", false, "unknown remote procedure `release`"},
+		{"writer", "/arpicee run deploy env=staging region=eu", false, "unknown parameter region"},
+		{"writer", "/arpicee run deploy env=dev", false, "invalid value"},
+		{"writer", "/arpicee run destroy", false, "you are not allowed to run `destroy`"},
+		{"writer", "/arpicee run deploy env=staging", true, "invoked by @writer succeeded\n\n```\ndeployed\n```"},
+		{"writer", "/arpicee run purge", false, "`purge` requires confirmation, run the command again with `--yes`"},
+		{"writer", "/arpicee run purge --yes", true, "invoked by @writer succeeded\n\n```\npurged\n```"},
+	} {
+		f := &fakeGitHub{permissions: map[string]string{"reader": "read", "writer": "write"}, done: make(chan struct{})}
+		b := newTestBot(t, f, ReplyComment)
+
+		if w := sendEvent(b, "webhook-secret", commentEvent(testCase.login, testCase.body, false)); w.Code != http.StatusNoContent {
+			t.Errorf("test %d - expected status %d, got %d", i, http.StatusNoContent, w.Code)
+		}
+		if testCase.expectRun {
+			select {
+			case <-f.done:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("test %d - timed out waiting for the result comment", i)
+			}
+		}
+
+		f.mu.Lock()
+		if len(f.comments) != 1 || !strings.Contains(f.comments[0], testCase.expectComment) {
+			t.Errorf("test %d - expected a comment containing %q, got %q", i, testCase.expectComment, f.comments)
+		}
+		f.mu.Unlock()
+	}
+}
+
+func TestInvalidSignature(t *testing.T) {
+	f := &fakeGitHub{done: make(chan struct{})}
+	b := newTestBot(t, f, ReplyComment)
+	if w := sendEvent(b, "wrong-secret", commentEvent("writer", "/arpicee help", false)); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, w.Code)
+	}
+}
+
+func TestCheckRun(t *testing.T) {
+	f := &fakeGitHub{permissions: map[string]string{"writer": "admin"}, done: make(chan struct{})}
+	b := newTestBot(t, f, ReplyCheckRun)
+
+	sendEvent(b, "webhook-secret", commentEvent("writer", "/arpicee run deploy env=production", true))
+	select {
+	case <-f.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the check run to complete")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.checkRuns) != 1 {
+		t.Fatalf("expected 1 check run, got %d", len(f.checkRuns))
+	}
+	cr := f.checkRuns[0]
+	if cr.Name != "arpicee/deploy" || cr.HeadSHA != "abc123" || cr.GetStatus() != "completed" || cr.GetConclusion() != "success" {
+		t.Errorf("unexpected check run %+v", cr)
+	}
+	if len(f.comments) != 0 {
+		t.Errorf("expected no comment, got %q", f.comments)
+	}
+}
+
+// When the first comment or check run can not be created, the result is posted in a new one
+func TestFailedFirstPost(t *testing.T) {
+	for i, reply := range []string{ReplyComment, ReplyCheckRun} {
+		f := &fakeGitHub{permissions: map[string]string{"writer": "write"}, failFirst: true, done: make(chan struct{})}
+		b := newTestBot(t, f, reply)
+
+		sendEvent(b, "webhook-secret", commentEvent("writer", "/arpicee run deploy env=staging", true))
+		select {
+		case <-f.done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("test %d - timed out waiting for the result", i)
+		}
+
+		f.mu.Lock()
+		switch reply {
+		case ReplyComment:
+			if len(f.comments) != 1 || !strings.Contains(f.comments[0], "invoked by @writer succeeded") {
+				t.Errorf("test %d - expected a comment with the result, got %q", i, f.comments)
+			}
+		case ReplyCheckRun:
+			if len(f.checkRuns) != 1 || f.checkRuns[0].GetStatus() != "completed" || f.checkRuns[0].GetConclusion() != "success" {
+				t.Errorf("test %d - expected a completed check run, got %+v", i, f.checkRuns)
+			}
+		}
+		f.mu.Unlock()
+	}
+}
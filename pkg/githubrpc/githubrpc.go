@@ -3,12 +3,17 @@ package githubrpc
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"log"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/go-github/v50/github"
 	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/filter"
 	"gopkg.in/yaml.v3"
 )
 
@@ -16,16 +21,45 @@ type WorkflowInput struct {
 	Description string
 	Required    bool
 	InputType   string `yaml:"type"`
+	Default     string
+	Options     []string
 }
 
 type WorkflowTriggers struct {
 	Inputs map[string]WorkflowInput
 }
 
+// Triggers are the events triggering a workflow, by name. Workflows can list their
+// events as a single event name, a list of names, or a mapping.
+type Triggers map[string]WorkflowTriggers
+
+func (t *Triggers) UnmarshalYAML(n *yaml.Node) error {
+	*t = Triggers{}
+	switch n.Kind {
+	case yaml.ScalarNode:
+		(*t)[n.Value] = WorkflowTriggers{}
+		return nil
+	case yaml.SequenceNode:
+		for _, e := range n.Content {
+			(*t)[e.Value] = WorkflowTriggers{}
+		}
+		return nil
+	}
+	m := map[string]WorkflowTriggers{}
+	if err := n.Decode(&m); err != nil {
+		return err
+	}
+	*t = m
+	return nil
+}
+
 type Workflow struct {
-	On map[string]WorkflowTriggers
+	On Triggers
 }
 
+// errNotDispatchable is returned for workflows without a workflow_dispatch trigger
+var errNotDispatchable = errors.New("workflow does not contain a workflow_dispatch section")
+
 type GithubRPC struct {
 	c           *github.Client
 	ctx         context.Context
@@ -35,6 +69,7 @@ type GithubRPC struct {
 	id          int64
 	description string
 	params      []arpicee.Parameter
+	metadata    arpicee.Metadata
 }
 
 func (gr *GithubRPC) Name() string {
@@ -49,6 +84,51 @@ func (gr *GithubRPC) Params() []arpicee.Parameter {
 	return gr.params
 }
 
+func (gr *GithubRPC) Provider() string {
+	return "github"
+}
+
+// Metadata returns the metadata set by the comments of the workflow file, such as
+// "# arpicee:owner: platform", see arpicee.ParseMetadata
+func (gr *GithubRPC) Metadata() arpicee.Metadata {
+	return gr.metadata
+}
+
+// metadataComment matches the comments of workflow files setting metadata
+var metadataComment = regexp.MustCompile(`(?i)^\s*#\s*(arpicee:[a-z]+)\s*:(.*)$`)
+
+// workflowMetadata returns the metadata set by the comments of a workflow file
+func workflowMetadata(content []byte) (arpicee.Metadata, error) {
+	values := map[string]string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		if m := metadataComment.FindStringSubmatch(line); m != nil {
+			values[m[1]] = m[2]
+		}
+	}
+	return arpicee.ParseMetadata(values)
+}
+
+// Ref returns the ID of the workflow
+func (gr *GithubRPC) Ref() string {
+	return strconv.FormatInt(gr.id, 10)
+}
+
+// Restore returns the RPC dispatching the workflow with the given ID, from a previous
+// discovery
+func Restore(ctx context.Context, c *github.Client, owner string, repo string, id int64, name, description string, params []arpicee.Parameter, metadata arpicee.Metadata) *GithubRPC {
+	return &GithubRPC{
+		c:           c,
+		ctx:         ctx,
+		owner:       owner,
+		repo:        repo,
+		name:        name,
+		id:          id,
+		description: description,
+		params:      params,
+		metadata:    metadata,
+	}
+}
+
 func New(ctx context.Context, c *github.Client, owner string, repo string, workflowName string) (*GithubRPC, error) {
 	ws, _, err := c.Actions.ListWorkflows(ctx, owner, repo, nil)
 	if err != nil {
@@ -64,7 +144,12 @@ func New(ctx context.Context, c *github.Client, owner string, repo string, workf
 	if w == nil {
 		return nil, fmt.Errorf("failed finding workflow %s in %s", workflowName, repo)
 	}
+	return fromWorkflow(ctx, c, owner, repo, w)
+}
 
+// fromWorkflow returns the RPC dispatching the workflow w, or errNotDispatchable
+func fromWorkflow(ctx context.Context, c *github.Client, owner string, repo string, w *github.Workflow) (*GithubRPC, error) {
+	workflowName := *w.Name
 	workflowContent, _, _, err := c.Repositories.GetContents(ctx, owner, repo, *w.Path, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed getting workflow file: %w", err)
@@ -84,7 +169,11 @@ func New(ctx context.Context, c *github.Client, owner string, repo string, workf
 	}
 
 	if _, ok := workflow.On["workflow_dispatch"]; !ok {
-		return nil, fmt.Errorf("workflow %+v contained in file %s does not contain a workflow_dispatch section", workflow, *w.Path)
+		return nil, fmt.Errorf("file %s: %w", *w.Path, errNotDispatchable)
+	}
+	metadata, err := workflowMetadata(workflowContentBytes)
+	if err != nil {
+		return nil, fmt.Errorf("file %s: %w", *w.Path, err)
 	}
 
 	var params []arpicee.Parameter
@@ -97,14 +186,16 @@ func New(ctx context.Context, c *github.Client, owner string, repo string, workf
 			pType = arpicee.TypeBool
 		case "number":
 			pType = arpicee.TypeInt
-		case "string":
+		case "string", "choice":
 		default:
 		}
 		params = append(params, arpicee.Parameter{
-			Name:        pName,
-			Type:        pType,
-			Description: p.Description,
-			Required:    p.Required,
+			Name:          pName,
+			Type:          pType,
+			Description:   p.Description,
+			Required:      p.Required,
+			Default:       p.Default,
+			AllowedValues: p.Options,
 		})
 	}
 
@@ -117,24 +208,36 @@ func New(ctx context.Context, c *github.Client, owner string, repo string, workf
 		id:          *w.ID,
 		description: fmt.Sprintf("Workflow %s in repo https://github.com/%s/%s", workflowName, owner, repo),
 		params:      params,
+		metadata:    metadata,
 	}, nil
 }
 
-func Discover(ctx context.Context, c *github.Client, owner string, repo string) ([]*GithubRPC, error) {
+// Discover returns the workflows of the repository that can be dispatched, selected by
+// match on their name, or all of them if match is nil. Workflows that can not be
+// retrieved are returned as arpicee.DiscoveryErrors, along with the other workflows.
+func Discover(ctx context.Context, c *github.Client, owner string, repo string, match filter.Matcher) ([]*GithubRPC, error) {
 	ws, _, err := c.Actions.ListWorkflows(ctx, owner, repo, nil)
 	if err != nil {
 		return nil, err
 	}
 
 	rpcs := []*GithubRPC{}
+	var itemErrs arpicee.DiscoveryErrors
 	for _, iw := range ws.Workflows {
-		rpc, err := New(ctx, c, owner, repo, *iw.Name)
+		if match != nil && !match(filter.Item{Name: iw.GetName()}) {
+			continue
+		}
+		rpc, err := fromWorkflow(ctx, c, owner, repo, iw)
+		if errors.Is(err, errNotDispatchable) {
+			continue
+		}
 		if err != nil {
-			return nil, fmt.Errorf("failed getting workflow %s", *iw.Name)
+			itemErrs = append(itemErrs, arpicee.ItemError{Item: *iw.Name, Err: err})
+			continue
 		}
 		rpcs = append(rpcs, rpc)
 	}
-	return rpcs, nil
+	return rpcs, itemErrs.Err()
 }
 
 func output(wfName string, jobs []*github.WorkflowJob) map[string]interface{} {
@@ -157,6 +260,21 @@ func output(wfName string, jobs []*github.WorkflowJob) map[string]interface{} {
 }
 
 func (gr *GithubRPC) Run(args []arpicee.Argument) (map[string]interface{}, error) {
+	return gr.RunContext(gr.ctx, args)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(d):
+		return nil
+	}
+}
+
+// RunContext dispatches the workflow and waits for the run to complete. If ctx
+// is cancelled, the workflow run is cancelled.
+func (gr *GithubRPC) RunContext(ctx context.Context, args []arpicee.Argument) (map[string]interface{}, error) {
 	var payload github.CreateWorkflowDispatchEventRequest
 	payload.Ref = "main"
 	payload.Inputs = map[string]interface{}{}
@@ -170,7 +288,7 @@ func (gr *GithubRPC) Run(args []arpicee.Argument) (map[string]interface{}, error
 	}
 	t := time.Now()
 	p := t.Format(time.RFC3339)
-	w, _, err := gr.c.Actions.ListWorkflowRunsByID(gr.ctx, gr.owner, gr.repo, gr.id, &github.ListWorkflowRunsOptions{
+	w, _, err := gr.c.Actions.ListWorkflowRunsByID(ctx, gr.owner, gr.repo, gr.id, &github.ListWorkflowRunsOptions{
 		Event:   "workflow_dispatch",
 		Created: fmt.Sprintf(">%s", p),
 	})
@@ -179,7 +297,7 @@ func (gr *GithubRPC) Run(args []arpicee.Argument) (map[string]interface{}, error
 	}
 	countBefore := len(w.WorkflowRuns)
 
-	_, err = gr.c.Actions.CreateWorkflowDispatchEventByID(gr.ctx, gr.owner, gr.repo, gr.id, payload)
+	_, err = gr.c.Actions.CreateWorkflowDispatchEventByID(ctx, gr.owner, gr.repo, gr.id, payload)
 	if err != nil {
 		return nil, err
 	}
@@ -189,12 +307,14 @@ func (gr *GithubRPC) Run(args []arpicee.Argument) (map[string]interface{}, error
 	// Dispatching a workflow is asynhronous - we Wait until Workflow run
 	// has actually been triggered
 	for countAfter := countBefore; countAfter == countBefore; tries = tries + 1 {
-		time.Sleep(1 * time.Second)
+		if err := sleep(ctx, 1*time.Second); err != nil {
+			return nil, err
+		}
 		if tries > max_tries {
 			return nil, fmt.Errorf("failed getting dispatch run after %d tries", tries)
 		}
 
-		w, _, err = gr.c.Actions.ListWorkflowRunsByID(gr.ctx, gr.owner, gr.repo, gr.id, &github.ListWorkflowRunsOptions{
+		w, _, err = gr.c.Actions.ListWorkflowRunsByID(ctx, gr.owner, gr.repo, gr.id, &github.ListWorkflowRunsOptions{
 			Event:   "workflow_dispatch",
 			Created: fmt.Sprintf(">%s", p),
 		})
@@ -213,8 +333,33 @@ func (gr *GithubRPC) Run(args []arpicee.Argument) (map[string]interface{}, error
 		}
 	}
 
-	max_tries = 1000
-	tries = 0
+	arpicee.ReportExternalID(ctx, strconv.FormatInt(*latestWorkflowRun.ID, 10))
+	if latestWorkflowRun.HTMLURL != nil {
+		arpicee.ReportProgress(ctx, fmt.Sprintf("workflow run started: %s", *latestWorkflowRun.HTMLURL))
+	}
+	return gr.wait(ctx, latestWorkflowRun)
+}
+
+// Resume waits for the workflow run with the ID id to complete
+func (gr *GithubRPC) Resume(ctx context.Context, id string) (map[string]interface{}, error) {
+	runID, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid workflow run ID %s", id)
+	}
+	run, _, err := gr.c.Actions.GetWorkflowRunByID(ctx, gr.owner, gr.repo, runID)
+	if err != nil {
+		return nil, fmt.Errorf("failed getting workflow run: %w", err)
+	}
+	return gr.wait(ctx, run)
+}
+
+// wait polls the workflow run until it completes. If ctx is cancelled, the workflow
+// run is cancelled.
+func (gr *GithubRPC) wait(ctx context.Context, latestWorkflowRun *github.WorkflowRun) (map[string]interface{}, error) {
+	var err error
+	max_tries := 1000
+	tries := 0
+	status := ""
 	var run *github.WorkflowRun
 	// We wait for our Github Workflow run to complete
 	for run == nil || *run.Status == "queued" || *run.Status == "in_progress" {
@@ -223,21 +368,38 @@ func (gr *GithubRPC) Run(args []arpicee.Argument) (map[string]interface{}, error
 			return nil, fmt.Errorf("failed waiting for workflow to complete, timeout")
 		}
 
-		run, _, err = gr.c.Actions.GetWorkflowRunByID(gr.ctx, gr.owner, gr.repo, *latestWorkflowRun.ID)
+		run, _, err = gr.c.Actions.GetWorkflowRunByID(ctx, gr.owner, gr.repo, *latestWorkflowRun.ID)
 		if err != nil {
+			if ctx.Err() != nil {
+				return nil, gr.cancel(*latestWorkflowRun.ID, ctx.Err())
+			}
 			return nil, fmt.Errorf("failed getting workflow run: %w", err)
 		}
-		time.Sleep(3 * time.Second)
+		if *run.Status != status {
+			status = *run.Status
+			arpicee.ReportProgress(ctx, fmt.Sprintf("workflow run %d: %s", *run.ID, status))
+		}
+		if err := sleep(ctx, 3*time.Second); err != nil {
+			return nil, gr.cancel(*latestWorkflowRun.ID, err)
+		}
 	}
 
 	if *run.Status != "completed" {
 		return nil, fmt.Errorf("workflow run failed: got unexpected status %s", *run.Status)
 	}
 
-	wjs, _, err := gr.c.Actions.ListWorkflowJobs(gr.ctx, gr.owner, gr.repo, *latestWorkflowRun.ID, nil)
+	wjs, _, err := gr.c.Actions.ListWorkflowJobs(ctx, gr.owner, gr.repo, *latestWorkflowRun.ID, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed listing workflow jobs: %w", err)
 	}
 
 	return output(*latestWorkflowRun.Name, wjs.Jobs), nil
 }
+
+func (gr *GithubRPC) cancel(runID int64, cause error) error {
+	// The context is cancelled, the cancellation is sent with the client context
+	if _, err := gr.c.Actions.CancelWorkflowRunByID(gr.ctx, gr.owner, gr.repo, runID); err != nil {
+		log.Printf("failed cancelling workflow run %d: %s", runID, err)
+	}
+	return cause
+}
@@ -3,11 +3,14 @@ package githubrpc
 import (
 	"context"
 	"encoding/base64"
+	"errors"
+	"reflect"
 	"testing"
 
 	"github.com/google/go-github/v50/github"
 	"github.com/migueleliasweb/go-github-mock/src/mock"
 	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/filter"
 )
 
 func TestNewGithubRPC(t *testing.T) {
@@ -130,8 +133,8 @@ jobs:
 			mock.WithRequestMatch(
 				mock.GetReposActionsWorkflowsByOwnerByRepo,
 				github.Workflows{
-					github.Int(1),
-					[]*github.Workflow{
+					TotalCount: github.Int(1),
+					Workflows: []*github.Workflow{
 						{
 							ID:   github.Int64(123),
 							Name: github.String("my_workflow"),
@@ -188,3 +191,104 @@ jobs:
 		}
 	}
 }
+
+func TestWorkflowMetadata(t *testing.T) {
+	for i, testCase := range []struct {
+		workflowData []byte
+		expect       arpicee.Metadata
+		expectErr    string
+	}{
+		{
+			[]byte("name: my_workflow\non:\n  workflow_dispatch:\n"),
+			arpicee.Metadata{},
+			"",
+		},
+		{
+			[]byte(`# arpicee:owner: platform
+# Arpicee:Tags: deploy, kubernetes
+name: my_workflow
+on:
+  workflow_dispatch:
+    # arpicee:risk: high
+    inputs:
+      name:
+        description: "not arpicee:owner: other"
+`),
+			arpicee.Metadata{Owner: "platform", Tags: []string{"deploy", "kubernetes"}, Risk: arpicee.RiskHigh},
+			"",
+		},
+		{
+			[]byte("# arpicee:risk: extreme\nname: my_workflow\n"),
+			arpicee.Metadata{Risk: "extreme"},
+			"invalid risk extreme, expected low, medium or high",
+		},
+	} {
+		got, err := workflowMetadata(testCase.workflowData)
+		if (err == nil && testCase.expectErr != "") || (err != nil && err.Error() != testCase.expectErr) {
+			t.Errorf("test %d - expected error %q, got %v", i, testCase.expectErr, err)
+		}
+		if !reflect.DeepEqual(got, testCase.expect) {
+			t.Errorf("test %d - expected %+v, got %+v", i, testCase.expect, got)
+		}
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	content := func(workflow string) github.RepositoryContent {
+		return github.RepositoryContent{
+			Encoding: github.String("base64"),
+			Content:  github.String(base64.StdEncoding.EncodeToString([]byte(workflow))),
+		}
+	}
+	workflow := func(id int64, name string) *github.Workflow {
+		return &github.Workflow{ID: github.Int64(id), Name: github.String(name), Path: github.String(".github/workflows/" + name + ".yml")}
+	}
+	mockedHTTPClient := mock.NewMockedHTTPClient(
+		mock.WithRequestMatch(
+			mock.GetReposActionsWorkflowsByOwnerByRepo,
+			github.Workflows{
+				TotalCount: github.Int(4),
+				Workflows:  []*github.Workflow{workflow(1, "deploy"), workflow(2, "ci"), workflow(3, "broken"), workflow(4, "release")},
+			},
+		),
+		mock.WithRequestMatch(
+			mock.GetReposContentsByOwnerByRepoByPath,
+			content("on:\n  workflow_dispatch:\n    inputs:\n      env:\n        required: true\n"),
+			content("on: [push, pull_request]\n"),
+			content("on: {workflow_dispatch\n"),
+			content("on: workflow_dispatch\n"),
+		),
+	)
+
+	rpcs, err := Discover(context.Background(), github.NewClient(mockedHTTPClient), "yannh", "arpicee", nil)
+	var names []string
+	for _, rpc := range rpcs {
+		names = append(names, rpc.Name())
+	}
+	if len(names) != 2 || names[0] != "deploy" || names[1] != "release" {
+		t.Errorf("expected workflows deploy and release, got %v", names)
+	}
+	var itemErrs arpicee.DiscoveryErrors
+	if !errors.As(err, &itemErrs) || len(itemErrs) != 1 || itemErrs[0].Item != "broken" {
+		t.Errorf("expected an error for the workflow broken, got %v", err)
+	}
+
+	// Workflows not selected are not retrieved
+	mockedHTTPClient = mock.NewMockedHTTPClient(
+		mock.WithRequestMatch(
+			mock.GetReposActionsWorkflowsByOwnerByRepo,
+			github.Workflows{
+				TotalCount: github.Int(2),
+				Workflows:  []*github.Workflow{workflow(3, "broken"), workflow(4, "release")},
+			},
+		),
+		mock.WithRequestMatch(
+			mock.GetReposContentsByOwnerByRepoByPath,
+			content("on: workflow_dispatch\n"),
+		),
+	)
+	rpcs, err = Discover(context.Background(), github.NewClient(mockedHTTPClient), "yannh", "arpicee", filter.NameIs("release"))
+	if err != nil || len(rpcs) != 1 || rpcs[0].Name() != "release" {
+		t.Errorf("expected the workflow release only, got %v, %v", rpcs, err)
+	}
+}
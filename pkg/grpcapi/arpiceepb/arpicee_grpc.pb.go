@@ -0,0 +1,326 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: arpicee.proto
+
+package arpiceepb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Arpicee_ListRPCs_FullMethodName        = "/arpicee.v1.Arpicee/ListRPCs"
+	Arpicee_DescribeRPC_FullMethodName     = "/arpicee.v1.Arpicee/DescribeRPC"
+	Arpicee_StartExecution_FullMethodName  = "/arpicee.v1.Arpicee/StartExecution"
+	Arpicee_GetExecution_FullMethodName    = "/arpicee.v1.Arpicee/GetExecution"
+	Arpicee_WatchExecution_FullMethodName  = "/arpicee.v1.Arpicee/WatchExecution"
+	Arpicee_CancelExecution_FullMethodName = "/arpicee.v1.Arpicee/CancelExecution"
+)
+
+// ArpiceeClient is the client API for Arpicee service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ArpiceeClient interface {
+	ListRPCs(ctx context.Context, in *ListRPCsRequest, opts ...grpc.CallOption) (*ListRPCsResponse, error)
+	DescribeRPC(ctx context.Context, in *DescribeRPCRequest, opts ...grpc.CallOption) (*RPC, error)
+	StartExecution(ctx context.Context, in *StartExecutionRequest, opts ...grpc.CallOption) (*Execution, error)
+	GetExecution(ctx context.Context, in *GetExecutionRequest, opts ...grpc.CallOption) (*Execution, error)
+	// WatchExecution sends the current state of the execution, then every progress
+	// message, and the final state of the execution once it is finished.
+	WatchExecution(ctx context.Context, in *WatchExecutionRequest, opts ...grpc.CallOption) (Arpicee_WatchExecutionClient, error)
+	CancelExecution(ctx context.Context, in *CancelExecutionRequest, opts ...grpc.CallOption) (*Execution, error)
+}
+
+type arpiceeClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewArpiceeClient(cc grpc.ClientConnInterface) ArpiceeClient {
+	return &arpiceeClient{cc}
+}
+
+func (c *arpiceeClient) ListRPCs(ctx context.Context, in *ListRPCsRequest, opts ...grpc.CallOption) (*ListRPCsResponse, error) {
+	out := new(ListRPCsResponse)
+	err := c.cc.Invoke(ctx, Arpicee_ListRPCs_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *arpiceeClient) DescribeRPC(ctx context.Context, in *DescribeRPCRequest, opts ...grpc.CallOption) (*RPC, error) {
+	out := new(RPC)
+	err := c.cc.Invoke(ctx, Arpicee_DescribeRPC_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *arpiceeClient) StartExecution(ctx context.Context, in *StartExecutionRequest, opts ...grpc.CallOption) (*Execution, error) {
+	out := new(Execution)
+	err := c.cc.Invoke(ctx, Arpicee_StartExecution_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *arpiceeClient) GetExecution(ctx context.Context, in *GetExecutionRequest, opts ...grpc.CallOption) (*Execution, error) {
+	out := new(Execution)
+	err := c.cc.Invoke(ctx, Arpicee_GetExecution_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *arpiceeClient) WatchExecution(ctx context.Context, in *WatchExecutionRequest, opts ...grpc.CallOption) (Arpicee_WatchExecutionClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Arpicee_ServiceDesc.Streams[0], Arpicee_WatchExecution_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &arpiceeWatchExecutionClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Arpicee_WatchExecutionClient interface {
+	Recv() (*ExecutionEvent, error)
+	grpc.ClientStream
+}
+
+type arpiceeWatchExecutionClient struct {
+	grpc.ClientStream
+}
+
+func (x *arpiceeWatchExecutionClient) Recv() (*ExecutionEvent, error) {
+	m := new(ExecutionEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *arpiceeClient) CancelExecution(ctx context.Context, in *CancelExecutionRequest, opts ...grpc.CallOption) (*Execution, error) {
+	out := new(Execution)
+	err := c.cc.Invoke(ctx, Arpicee_CancelExecution_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ArpiceeServer is the server API for Arpicee service.
+// All implementations must embed UnimplementedArpiceeServer
+// for forward compatibility
+type ArpiceeServer interface {
+	ListRPCs(context.Context, *ListRPCsRequest) (*ListRPCsResponse, error)
+	DescribeRPC(context.Context, *DescribeRPCRequest) (*RPC, error)
+	StartExecution(context.Context, *StartExecutionRequest) (*Execution, error)
+	GetExecution(context.Context, *GetExecutionRequest) (*Execution, error)
+	// WatchExecution sends the current state of the execution, then every progress
+	// message, and the final state of the execution once it is finished.
+	WatchExecution(*WatchExecutionRequest, Arpicee_WatchExecutionServer) error
+	CancelExecution(context.Context, *CancelExecutionRequest) (*Execution, error)
+	mustEmbedUnimplementedArpiceeServer()
+}
+
+// UnimplementedArpiceeServer must be embedded to have forward compatible implementations.
+type UnimplementedArpiceeServer struct {
+}
+
+func (UnimplementedArpiceeServer) ListRPCs(context.Context, *ListRPCsRequest) (*ListRPCsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListRPCs not implemented")
+}
+func (UnimplementedArpiceeServer) DescribeRPC(context.Context, *DescribeRPCRequest) (*RPC, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DescribeRPC not implemented")
+}
+func (UnimplementedArpiceeServer) StartExecution(context.Context, *StartExecutionRequest) (*Execution, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method StartExecution not implemented")
+}
+func (UnimplementedArpiceeServer) GetExecution(context.Context, *GetExecutionRequest) (*Execution, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetExecution not implemented")
+}
+func (UnimplementedArpiceeServer) WatchExecution(*WatchExecutionRequest, Arpicee_WatchExecutionServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchExecution not implemented")
+}
+func (UnimplementedArpiceeServer) CancelExecution(context.Context, *CancelExecutionRequest) (*Execution, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CancelExecution not implemented")
+}
+func (UnimplementedArpiceeServer) mustEmbedUnimplementedArpiceeServer() {}
+
+// UnsafeArpiceeServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ArpiceeServer will
+// result in compilation errors.
+type UnsafeArpiceeServer interface {
+	mustEmbedUnimplementedArpiceeServer()
+}
+
+func RegisterArpiceeServer(s grpc.ServiceRegistrar, srv ArpiceeServer) {
+	s.RegisterService(&Arpicee_ServiceDesc, srv)
+}
+
+func _Arpicee_ListRPCs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListRPCsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ArpiceeServer).ListRPCs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Arpicee_ListRPCs_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ArpiceeServer).ListRPCs(ctx, req.(*ListRPCsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Arpicee_DescribeRPC_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DescribeRPCRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ArpiceeServer).DescribeRPC(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Arpicee_DescribeRPC_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ArpiceeServer).DescribeRPC(ctx, req.(*DescribeRPCRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Arpicee_StartExecution_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(StartExecutionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ArpiceeServer).StartExecution(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Arpicee_StartExecution_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ArpiceeServer).StartExecution(ctx, req.(*StartExecutionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Arpicee_GetExecution_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetExecutionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ArpiceeServer).GetExecution(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Arpicee_GetExecution_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ArpiceeServer).GetExecution(ctx, req.(*GetExecutionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Arpicee_WatchExecution_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchExecutionRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ArpiceeServer).WatchExecution(m, &arpiceeWatchExecutionServer{stream})
+}
+
+type Arpicee_WatchExecutionServer interface {
+	Send(*ExecutionEvent) error
+	grpc.ServerStream
+}
+
+type arpiceeWatchExecutionServer struct {
+	grpc.ServerStream
+}
+
+func (x *arpiceeWatchExecutionServer) Send(m *ExecutionEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Arpicee_CancelExecution_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CancelExecutionRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ArpiceeServer).CancelExecution(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Arpicee_CancelExecution_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ArpiceeServer).CancelExecution(ctx, req.(*CancelExecutionRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Arpicee_ServiceDesc is the grpc.ServiceDesc for Arpicee service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Arpicee_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "arpicee.v1.Arpicee",
+	HandlerType: (*ArpiceeServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListRPCs",
+			Handler:    _Arpicee_ListRPCs_Handler,
+		},
+		{
+			MethodName: "DescribeRPC",
+			Handler:    _Arpicee_DescribeRPC_Handler,
+		},
+		{
+			MethodName: "StartExecution",
+			Handler:    _Arpicee_StartExecution_Handler,
+		},
+		{
+			MethodName: "GetExecution",
+			Handler:    _Arpicee_GetExecution_Handler,
+		},
+		{
+			MethodName: "CancelExecution",
+			Handler:    _Arpicee_CancelExecution_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchExecution",
+			Handler:       _Arpicee_WatchExecution_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "arpicee.proto",
+}
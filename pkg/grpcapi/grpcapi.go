@@ -0,0 +1,345 @@
+// Package grpcapi exposes the RPCs of a registry over gRPC, see arpiceepb/arpicee.proto.
+// The stubs are generated with "make proto".
+package grpcapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/execution"
+	"github.com/yannh/arpicee/pkg/grpcapi/arpiceepb"
+	"github.com/yannh/arpicee/pkg/httpapi"
+	"github.com/yannh/arpicee/pkg/registry"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Server implements the Arpicee gRPC service. Clients authenticate with the API
+// tokens of the REST API, passed as "authorization: Bearer TOKEN" metadata.
+type Server struct {
+	arpiceepb.UnimplementedArpiceeServer
+	registry   *registry.Registry
+	executions *execution.Manager
+	tokens     []httpapi.Token
+}
+
+func New(reg *registry.Registry, executions *execution.Manager, tokens []httpapi.Token) *Server {
+	return &Server{
+		registry:   reg,
+		executions: executions,
+		tokens:     tokens,
+	}
+}
+
+type userKey struct{}
+
+func userFromContext(ctx context.Context) string {
+	user, _ := ctx.Value(userKey{}).(string)
+	return user
+}
+
+// authenticate returns a context holding the name of the token passed in the metadata of ctx
+func (s *Server) authenticate(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, auth := range md.Get("authorization") {
+		if user, ok := httpapi.TokenUser(s.tokens, auth); ok {
+			return context.WithValue(ctx, userKey{}, user), nil
+		}
+	}
+	return nil, status.Error(codes.Unauthenticated, "missing or invalid bearer token")
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+// NewGRPCServer returns a gRPC server serving s, checking the token of every call
+func (s *Server) NewGRPCServer(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts,
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			ctx, err := s.authenticate(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			ctx, err := s.authenticate(ss.Context())
+			if err != nil {
+				return err
+			}
+			return handler(srv, authenticatedStream{ServerStream: ss, ctx: ctx})
+		}),
+	)
+	gs := grpc.NewServer(opts...)
+	arpiceepb.RegisterArpiceeServer(gs, s)
+	return gs
+}
+
+func paramType(t arpicee.ParamType) arpiceepb.ParameterType {
+	switch t {
+	case arpicee.TypeString:
+		return arpiceepb.ParameterType_PARAMETER_TYPE_STRING
+	case arpicee.TypeInt:
+		return arpiceepb.ParameterType_PARAMETER_TYPE_INT
+	case arpicee.TypeBool:
+		return arpiceepb.ParameterType_PARAMETER_TYPE_BOOL
+	}
+	return arpiceepb.ParameterType_PARAMETER_TYPE_UNSPECIFIED
+}
+
+func describe(rpc arpicee.RemoteCall) *arpiceepb.RPC {
+	d := &arpiceepb.RPC{
+		Name:        rpc.Name(),
+		Description: rpc.Description(),
+		Provider:    arpicee.Provider(rpc),
+	}
+	for _, p := range rpc.Params() {
+		d.Parameters = append(d.Parameters, &arpiceepb.Parameter{
+			Name:          p.Name,
+			Type:          paramType(p.Type),
+			Description:   p.Description,
+			Required:      p.Required,
+			DefaultValue:  p.Default,
+			AllowedValues: p.AllowedValues,
+			Secret:        p.Secret,
+		})
+	}
+	sort.Slice(d.Parameters, func(i, j int) bool {
+		return d.Parameters[i].Name < d.Parameters[j].Name
+	})
+	return d
+}
+
+func (s *Server) ListRPCs(ctx context.Context, req *arpiceepb.ListRPCsRequest) (*arpiceepb.ListRPCsResponse, error) {
+	rpcs := s.registry.RPCs()
+	sort.Slice(rpcs, func(i, j int) bool {
+		return rpcs[i].Name() < rpcs[j].Name()
+	})
+	res := &arpiceepb.ListRPCsResponse{}
+	for _, rpc := range rpcs {
+		res.Rpcs = append(res.Rpcs, describe(rpc))
+	}
+	return res, nil
+}
+
+func (s *Server) DescribeRPC(ctx context.Context, req *arpiceepb.DescribeRPCRequest) (*arpiceepb.RPC, error) {
+	rpc := s.registry.RPC(req.GetName())
+	if rpc == nil {
+		return nil, status.Errorf(codes.NotFound, "no RPC named %s", req.GetName())
+	}
+	return describe(rpc), nil
+}
+
+func fromValue(v *arpiceepb.Value) (interface{}, error) {
+	switch k := v.GetKind().(type) {
+	case *arpiceepb.Value_StringValue:
+		return k.StringValue, nil
+	case *arpiceepb.Value_IntValue:
+		return int(k.IntValue), nil
+	case *arpiceepb.Value_BoolValue:
+		return k.BoolValue, nil
+	}
+	return nil, errors.New("missing value")
+}
+
+func toValue(v interface{}) *arpiceepb.Value {
+	switch val := v.(type) {
+	case int:
+		return &arpiceepb.Value{Kind: &arpiceepb.Value_IntValue{IntValue: int64(val)}}
+	case bool:
+		return &arpiceepb.Value{Kind: &arpiceepb.Value_BoolValue{BoolValue: val}}
+	default:
+		return &arpiceepb.Value{Kind: &arpiceepb.Value_StringValue{StringValue: fmt.Sprint(val)}}
+	}
+}
+
+// toStruct converts the result of an RPC, values that can not be represented
+// directly are converted through their JSON encoding
+func toStruct(res map[string]interface{}) (*structpb.Struct, error) {
+	if st, err := structpb.NewStruct(res); err == nil {
+		return st, nil
+	}
+	b, err := json.Marshal(res)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return structpb.NewStruct(m)
+}
+
+func executionStatus(st execution.Status) arpiceepb.ExecutionStatus {
+	switch st {
+	case execution.StatusRunning:
+		return arpiceepb.ExecutionStatus_EXECUTION_STATUS_RUNNING
+	case execution.StatusSucceeded:
+		return arpiceepb.ExecutionStatus_EXECUTION_STATUS_SUCCEEDED
+	case execution.StatusFailed:
+		return arpiceepb.ExecutionStatus_EXECUTION_STATUS_FAILED
+	case execution.StatusCancelled:
+		return arpiceepb.ExecutionStatus_EXECUTION_STATUS_CANCELLED
+	}
+	return arpiceepb.ExecutionStatus_EXECUTION_STATUS_UNSPECIFIED
+}
+
+func toProgress(p execution.Progress) *arpiceepb.Progress {
+	return &arpiceepb.Progress{Time: timestamppb.New(p.Time), Message: p.Message}
+}
+
+func toExecution(e execution.Execution) *arpiceepb.Execution {
+	pe := &arpiceepb.Execution{
+		Id:        e.ID,
+		Rpc:       e.RPC,
+		User:      e.User,
+		Arguments: map[string]*arpiceepb.Value{},
+		Status:    executionStatus(e.Status),
+		Error:     e.Error,
+		CreatedAt: timestamppb.New(e.CreatedAt),
+	}
+	for k, v := range e.Arguments {
+		pe.Arguments[k] = toValue(v)
+	}
+	if e.Result != nil {
+		res, err := toStruct(e.Result)
+		if err != nil {
+			log.Printf("failed converting the result of execution %s: %s", e.ID, err)
+		}
+		pe.Result = res
+		if e.Result["formatString"] != nil {
+			if out, err := arpicee.Output(e.Result, "text"); err == nil {
+				pe.Output = out
+			}
+		}
+	}
+	for _, p := range e.Progress {
+		pe.Progress = append(pe.Progress, toProgress(p))
+	}
+	if e.FinishedAt != nil {
+		pe.FinishedAt = timestamppb.New(*e.FinishedAt)
+	}
+	return pe
+}
+
+func (s *Server) StartExecution(ctx context.Context, req *arpiceepb.StartExecutionRequest) (*arpiceepb.Execution, error) {
+	rpc := s.registry.RPC(req.GetRpc())
+	if rpc == nil {
+		return nil, status.Errorf(codes.NotFound, "no RPC named %s", req.GetRpc())
+	}
+
+	values := map[string]interface{}{}
+	for k, v := range req.GetArguments() {
+		val, err := fromValue(v)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "argument %s: %s", k, err)
+		}
+		values[k] = val
+	}
+	args, err := arpicee.ArgsFromMap(rpc.Params(), values)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	e, err := s.executions.Start(rpc, args, userFromContext(ctx))
+	var forbidden *execution.ForbiddenError
+	if errors.As(err, &forbidden) {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return toExecution(e), nil
+}
+
+// execution returns the execution id if the user of ctx is allowed to see it
+func (s *Server) execution(ctx context.Context, id string) (execution.Execution, error) {
+	e, ok := s.executions.Get(id)
+	if !ok {
+		return e, status.Error(codes.NotFound, execution.ErrNotFound.Error())
+	}
+	if err := s.executions.AuthorizeExecution(userFromContext(ctx), e, s.registry.RPC(e.RPC)); err != nil {
+		return e, status.Error(codes.PermissionDenied, err.Error())
+	}
+	return e, nil
+}
+
+func (s *Server) GetExecution(ctx context.Context, req *arpiceepb.GetExecutionRequest) (*arpiceepb.Execution, error) {
+	e, err := s.execution(ctx, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	return toExecution(e), nil
+}
+
+func (s *Server) CancelExecution(ctx context.Context, req *arpiceepb.CancelExecutionRequest) (*arpiceepb.Execution, error) {
+	if _, err := s.execution(ctx, req.GetId()); err != nil {
+		return nil, err
+	}
+	e, err := s.executions.Cancel(req.GetId())
+	switch {
+	case errors.Is(err, execution.ErrNotFound):
+		return nil, status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, execution.ErrFinished):
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	log.Printf("execution %s of RPC %s cancelled by %s", e.ID, e.RPC, userFromContext(ctx))
+	return toExecution(e), nil
+}
+
+func (s *Server) WatchExecution(req *arpiceepb.WatchExecutionRequest, stream arpiceepb.Arpicee_WatchExecutionServer) error {
+	if _, err := s.execution(stream.Context(), req.GetId()); err != nil {
+		return err
+	}
+	e, changed, err := s.executions.Watch(req.GetId())
+	if err != nil {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	if err := stream.Send(&arpiceepb.ExecutionEvent{
+		Event: &arpiceepb.ExecutionEvent_Execution{Execution: toExecution(e)},
+	}); err != nil {
+		return err
+	}
+	if e.Done() {
+		return nil
+	}
+
+	sent := len(e.Progress)
+	for !e.Done() {
+		select {
+		case <-changed:
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		}
+		if e, changed, err = s.executions.Watch(req.GetId()); err != nil {
+			return status.Error(codes.NotFound, err.Error())
+		}
+		for _, p := range e.Progress[sent:] {
+			if err := stream.Send(&arpiceepb.ExecutionEvent{
+				Event: &arpiceepb.ExecutionEvent_Progress{Progress: toProgress(p)},
+			}); err != nil {
+				return err
+			}
+		}
+		sent = len(e.Progress)
+	}
+
+	return stream.Send(&arpiceepb.ExecutionEvent{
+		Event: &arpiceepb.ExecutionEvent_Execution{Execution: toExecution(e)},
+	})
+}
@@ -0,0 +1,212 @@
+package grpcapi
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/execution"
+	"github.com/yannh/arpicee/pkg/grpcapi/arpiceepb"
+	"github.com/yannh/arpicee/pkg/httpapi"
+	"github.com/yannh/arpicee/pkg/mock"
+	"github.com/yannh/arpicee/pkg/policy"
+	"github.com/yannh/arpicee/pkg/registry/registrytest"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func newTestClient(t *testing.T, proceed chan struct{}) arpiceepb.ArpiceeClient {
+	reg := registrytest.New(t,
+		mock.New("deploy", []arpicee.Parameter{
+			{Name: "env", Type: arpicee.TypeString, Required: true, AllowedValues: []string{"staging", "production"}},
+			{Name: "replicas", Type: arpicee.TypeInt},
+			{Name: "dryrun", Type: arpicee.TypeBool},
+		}, nil),
+		mock.New("migrate", nil, func(ctx context.Context, args []arpicee.Argument) (map[string]interface{}, error) {
+			arpicee.ReportProgress(ctx, "step 1")
+			select {
+			case <-proceed:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			arpicee.ReportProgress(ctx, "step 2")
+			return map[string]interface{}{"formatString": "migrated"}, nil
+		}),
+		mock.New("admin", nil, nil),
+	)
+	pol, err := policy.New([]policy.Rule{
+		{RPC: "admin", Users: []string{"token:ops"}},
+		{RPC: "migrate", Users: []string{"token:ci"}},
+	})
+	if err != nil {
+		t.Fatalf("failed creating policy: %s", err)
+	}
+	mgr := execution.NewManager(100)
+	mgr.SetAuthorizer(pol)
+
+	lis := bufconn.Listen(1024 * 1024)
+	gs := New(reg, mgr, []httpapi.Token{{Name: "ci", Token: "secret"}, {Name: "ops", Token: "ops-secret"}}).NewGRPCServer()
+	go gs.Serve(lis)
+	t.Cleanup(gs.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, s string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("failed connecting to server: %s", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return arpiceepb.NewArpiceeClient(conn)
+}
+
+func withToken(token string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+}
+
+func stringValue(s string) *arpiceepb.Value {
+	return &arpiceepb.Value{Kind: &arpiceepb.Value_StringValue{StringValue: s}}
+}
+
+func TestStartExecution(t *testing.T) {
+	client := newTestClient(t, nil)
+
+	for i, testCase := range []struct {
+		token      string
+		req        *arpiceepb.StartExecutionRequest
+		expectCode codes.Code
+	}{
+		{"", &arpiceepb.StartExecutionRequest{Rpc: "deploy"}, codes.Unauthenticated},
+		{"wrong", &arpiceepb.StartExecutionRequest{Rpc: "deploy"}, codes.Unauthenticated},
+		{"secret", &arpiceepb.StartExecutionRequest{Rpc: "unknown"}, codes.NotFound},
+		{"secret", &arpiceepb.StartExecutionRequest{Rpc: "deploy"}, codes.InvalidArgument},
+		{
+			"secret",
+			&arpiceepb.StartExecutionRequest{Rpc: "deploy", Arguments: map[string]*arpiceepb.Value{"env": stringValue("dev")}},
+			codes.InvalidArgument,
+		},
+		{
+			"secret",
+			&arpiceepb.StartExecutionRequest{Rpc: "deploy", Arguments: map[string]*arpiceepb.Value{
+				"env":      stringValue("staging"),
+				"replicas": stringValue("two"),
+			}},
+			codes.InvalidArgument,
+		},
+		{"secret", &arpiceepb.StartExecutionRequest{Rpc: "admin"}, codes.PermissionDenied},
+		{
+			"secret",
+			&arpiceepb.StartExecutionRequest{Rpc: "deploy", Arguments: map[string]*arpiceepb.Value{
+				"env":      stringValue("staging"),
+				"replicas": {Kind: &arpiceepb.Value_IntValue{IntValue: 2}},
+				"dryrun":   {Kind: &arpiceepb.Value_BoolValue{BoolValue: true}},
+			}},
+			codes.OK,
+		},
+	} {
+		ctx := context.Background()
+		if testCase.token != "" {
+			ctx = withToken(testCase.token)
+		}
+		e, err := client.StartExecution(ctx, testCase.req)
+		if code := status.Code(err); code != testCase.expectCode {
+			t.Errorf("test %d - expected code %s, got %s: %s", i, testCase.expectCode, code, err)
+		}
+		if err == nil && e.GetUser() != "token:ci" {
+			t.Errorf("test %d - expected execution to be attributed to token:ci, got %s", i, e.GetUser())
+		}
+	}
+}
+
+func TestDescribeRPC(t *testing.T) {
+	client := newTestClient(t, nil)
+
+	rpc, err := client.DescribeRPC(withToken("secret"), &arpiceepb.DescribeRPCRequest{Name: "deploy"})
+	if err != nil {
+		t.Fatalf("failed describing RPC: %s", err)
+	}
+	if len(rpc.GetParameters()) != 3 || rpc.GetParameters()[0].GetName() != "dryrun" ||
+		rpc.GetParameters()[2].GetType() != arpiceepb.ParameterType_PARAMETER_TYPE_INT {
+		t.Errorf("unexpected parameters %v", rpc.GetParameters())
+	}
+
+	list, err := client.ListRPCs(withToken("secret"), &arpiceepb.ListRPCsRequest{})
+	if err != nil {
+		t.Fatalf("failed listing RPCs: %s", err)
+	}
+	if len(list.GetRpcs()) != 3 || list.GetRpcs()[0].GetName() != "admin" {
+		t.Errorf("unexpected RPCs %v", list.GetRpcs())
+	}
+}
+
+func TestWatchExecution(t *testing.T) {
+	proceed := make(chan struct{})
+	client := newTestClient(t, proceed)
+	ctx := withToken("secret")
+
+	e, err := client.StartExecution(ctx, &arpiceepb.StartExecutionRequest{Rpc: "migrate"})
+	if err != nil {
+		t.Fatalf("failed starting execution: %s", err)
+	}
+	stream, err := client.WatchExecution(ctx, &arpiceepb.WatchExecutionRequest{Id: e.GetId()})
+	if err != nil {
+		t.Fatalf("failed watching execution: %s", err)
+	}
+	first, err := stream.Recv()
+	if err != nil {
+		t.Fatalf("failed receiving event: %s", err)
+	}
+
+	// Executions of RPCs they are not allowed to run are hidden from other users
+	other := withToken("ops-secret")
+	if _, err := client.GetExecution(other, &arpiceepb.GetExecutionRequest{Id: e.GetId()}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected getting the execution of another user to fail with PermissionDenied, got %s", err)
+	}
+	if _, err := client.CancelExecution(other, &arpiceepb.CancelExecutionRequest{Id: e.GetId()}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected cancelling the execution of another user to fail with PermissionDenied, got %s", err)
+	}
+	otherStream, err := client.WatchExecution(other, &arpiceepb.WatchExecutionRequest{Id: e.GetId()})
+	if err == nil {
+		_, err = otherStream.Recv()
+	}
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("expected watching the execution of another user to fail with PermissionDenied, got %s", err)
+	}
+	if first.GetExecution().GetStatus() != arpiceepb.ExecutionStatus_EXECUTION_STATUS_RUNNING {
+		t.Errorf("expected first event to be the running execution, got %v", first)
+	}
+	close(proceed)
+
+	var last *arpiceepb.ExecutionEvent
+	var messages []string
+	for {
+		ev, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("failed receiving event: %s", err)
+		}
+		if p := ev.GetProgress(); p != nil {
+			messages = append(messages, p.GetMessage())
+		}
+		last = ev
+	}
+	if len(messages) == 0 || messages[len(messages)-1] != "step 2" {
+		t.Errorf("expected progress messages ending with step 2, got %v", messages)
+	}
+	final := last.GetExecution()
+	if final.GetStatus() != arpiceepb.ExecutionStatus_EXECUTION_STATUS_SUCCEEDED || final.GetOutput() != "migrated" {
+		t.Errorf("expected last event to be the succeeded execution, got %v", last)
+	}
+
+	if _, err := client.CancelExecution(ctx, &arpiceepb.CancelExecutionRequest{Id: e.GetId()}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("expected cancelling a finished execution to fail with FailedPrecondition, got %s", err)
+	}
+}
@@ -0,0 +1,347 @@
+package httpapi
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/execution"
+	"github.com/yannh/arpicee/pkg/registry"
+)
+
+// Token grants access to the API; executions started with a token are attributed to its name
+type Token struct {
+	Name  string
+	Token string
+}
+
+// Server exposes the RPCs of a registry over a JSON REST API
+type Server struct {
+	registry   *registry.Registry
+	executions *execution.Manager
+	tokens     []Token
+}
+
+type parameter struct {
+	Name          string   `json:"name"`
+	Type          string   `json:"type"`
+	Description   string   `json:"description,omitempty"`
+	Required      bool     `json:"required"`
+	Default       string   `json:"default,omitempty"`
+	AllowedValues []string `json:"allowedValues,omitempty"`
+	Secret        bool     `json:"secret,omitempty"`
+}
+
+type metadata struct {
+	Owner             string   `json:"owner,omitempty"`
+	Category          string   `json:"category,omitempty"`
+	Tags              []string `json:"tags,omitempty"`
+	RunbookURL        string   `json:"runbookURL,omitempty"`
+	Risk              string   `json:"risk,omitempty"`
+	EstimatedDuration string   `json:"estimatedDuration,omitempty"`
+	Deprecation       string   `json:"deprecation,omitempty"`
+}
+
+type rpcDescription struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Parameters  []parameter            `json:"parameters"`
+	Metadata    metadata               `json:"metadata"`
+	Schema      map[string]interface{} `json:"schema,omitempty"`
+}
+
+type executionRequest struct {
+	Arguments map[string]interface{} `json:"arguments"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func New(reg *registry.Registry, executions *execution.Manager, tokens []Token) *Server {
+	return &Server{
+		registry:   reg,
+		executions: executions,
+		tokens:     tokens,
+	}
+}
+
+// Register adds the API routes to mux
+func (s *Server) Register(mux *http.ServeMux) {
+	mux.Handle("/rpcs", s.authenticated(s.handleRPCs))
+	mux.Handle("/rpcs/", s.authenticated(s.handleRPCs))
+	mux.Handle("/executions", s.authenticated(s.handleExecutions))
+	mux.Handle("/executions/", s.authenticated(s.handleExecutions))
+	mux.Handle("/openapi.json", s.authenticated(s.handleOpenAPI))
+	mux.Handle("/status", s.authenticated(s.handleStatus))
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	mux := http.NewServeMux()
+	s.Register(mux)
+	mux.ServeHTTP(w, r)
+}
+
+// BearerUser returns the user of the token passed in the Authorization header of r
+func BearerUser(tokens []Token, r *http.Request) (string, bool) {
+	return TokenUser(tokens, r.Header.Get("Authorization"))
+}
+
+// User returns the user executions started with the token name are attributed to
+func User(name string) string {
+	return "token:" + name
+}
+
+// TokenUser returns the user of the token in the authorization value "Bearer TOKEN", see
+// User
+func TokenUser(tokens []Token, auth string) (string, bool) {
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", false
+	}
+	provided := []byte(strings.TrimPrefix(auth, "Bearer "))
+	for _, t := range tokens {
+		if t.Token != "" && subtle.ConstantTimeCompare(provided, []byte(t.Token)) == 1 {
+			return User(t.Name), true
+		}
+	}
+	return "", false
+}
+
+func (s *Server) authenticated(h func(w http.ResponseWriter, r *http.Request, user string)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, ok := BearerUser(s.tokens, r); ok {
+			h(w, r, user)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		writeError(w, http.StatusUnauthorized, fmt.Errorf("missing or invalid bearer token"))
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("failed writing HTTP response: %s", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+// pathSegments splits the path of the request after prefix into unescaped segments
+func pathSegments(r *http.Request, prefix string) ([]string, error) {
+	p := strings.Trim(strings.TrimPrefix(r.URL.EscapedPath(), prefix), "/")
+	if p == "" {
+		return []string{}, nil
+	}
+	segments := strings.Split(p, "/")
+	for i, seg := range segments {
+		s, err := url.PathUnescape(seg)
+		if err != nil {
+			return nil, err
+		}
+		segments[i] = s
+	}
+	return segments, nil
+}
+
+func describe(rpc arpicee.RemoteCall, withSchema bool) rpcDescription {
+	m := arpicee.MetadataOf(rpc)
+	d := rpcDescription{
+		Name:        rpc.Name(),
+		Description: rpc.Description(),
+		Parameters:  []parameter{},
+		Metadata: metadata{
+			Owner:             m.Owner,
+			Category:          m.Category,
+			Tags:              m.Tags,
+			RunbookURL:        m.RunbookURL,
+			Risk:              string(m.Risk),
+			EstimatedDuration: m.EstimatedDuration,
+			Deprecation:       m.Deprecation,
+		},
+	}
+	for _, p := range rpc.Params() {
+		d.Parameters = append(d.Parameters, parameter{
+			Name:          p.Name,
+			Type:          p.Type.String(),
+			Description:   p.Description,
+			Required:      p.Required,
+			Default:       p.Default,
+			AllowedValues: p.AllowedValues,
+			Secret:        p.Secret,
+		})
+	}
+	sort.Slice(d.Parameters, func(i, j int) bool {
+		return d.Parameters[i].Name < d.Parameters[j].Name
+	})
+	if withSchema {
+		d.Schema = arpicee.JSONSchema(rpc.Params())
+	}
+	return d
+}
+
+func (s *Server) handleRPCs(w http.ResponseWriter, r *http.Request, user string) {
+	segments, err := pathSegments(r, "/rpcs")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	switch {
+	// GET /rpcs?q=QUERY&category=CATEGORY
+	case len(segments) == 0 && r.Method == http.MethodGet:
+		rpcs := arpicee.Search(s.registry.RPCs(), r.URL.Query().Get("q"))
+		if c := r.URL.Query().Get("category"); c != "" {
+			var inCategory []arpicee.RemoteCall
+			for _, rpc := range rpcs {
+				if arpicee.MetadataOf(rpc).Category == c {
+					inCategory = append(inCategory, rpc)
+				}
+			}
+			rpcs = inCategory
+		}
+		sort.Slice(rpcs, func(i, j int) bool {
+			return rpcs[i].Name() < rpcs[j].Name()
+		})
+		res := []rpcDescription{}
+		for _, rpc := range rpcs {
+			res = append(res, describe(rpc, false))
+		}
+		writeJSON(w, http.StatusOK, res)
+
+	// GET /rpcs/{id}
+	case len(segments) == 1 && r.Method == http.MethodGet:
+		rpc := s.registry.RPC(segments[0])
+		if rpc == nil {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no RPC named %s", segments[0]))
+			return
+		}
+		writeJSON(w, http.StatusOK, describe(rpc, true))
+
+	// POST /rpcs/{id}/executions
+	case len(segments) == 2 && segments[1] == "executions" && r.Method == http.MethodPost:
+		rpc := s.registry.RPC(segments[0])
+		if rpc == nil {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no RPC named %s", segments[0]))
+			return
+		}
+
+		var req executionRequest
+		dec := json.NewDecoder(r.Body)
+		dec.UseNumber()
+		if err := dec.Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("failed parsing request body: %w", err))
+			return
+		}
+		args, err := arpicee.ArgsFromMap(rpc.Params(), req.Arguments)
+		if err != nil {
+			writeError(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+		e, err := s.executions.Start(rpc, args, user)
+		var forbidden *execution.ForbiddenError
+		if errors.As(err, &forbidden) {
+			writeError(w, http.StatusForbidden, err)
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusUnprocessableEntity, err)
+			return
+		}
+		w.Header().Set("Location", "/executions/"+e.ID)
+		writeJSON(w, http.StatusAccepted, e)
+
+	case len(segments) <= 2:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("not found"))
+	}
+}
+
+func (s *Server) handleExecutions(w http.ResponseWriter, r *http.Request, user string) {
+	segments, err := pathSegments(r, "/executions")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	switch {
+	// GET /executions
+	case len(segments) == 0 && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, s.executions.ListFor(user, s.registry.RPC))
+
+	// GET /executions/{id}
+	case len(segments) == 1 && r.Method == http.MethodGet:
+		e, ok := s.execution(w, segments[0], user)
+		if !ok {
+			return
+		}
+		writeJSON(w, http.StatusOK, e)
+
+	// POST /executions/{id}/cancel
+	case len(segments) == 2 && segments[1] == "cancel" && r.Method == http.MethodPost:
+		if _, ok := s.execution(w, segments[0], user); !ok {
+			return
+		}
+		e, err := s.executions.Cancel(segments[0])
+		switch {
+		case errors.Is(err, execution.ErrNotFound):
+			writeError(w, http.StatusNotFound, err)
+		case errors.Is(err, execution.ErrFinished):
+			writeError(w, http.StatusConflict, err)
+		default:
+			log.Printf("execution %s of RPC %s cancelled by %s", e.ID, e.RPC, user)
+			writeJSON(w, http.StatusOK, e)
+		}
+
+	case len(segments) <= 2:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("not found"))
+	}
+}
+
+// execution returns the execution id if user is allowed to see it, or writes an error
+func (s *Server) execution(w http.ResponseWriter, id, user string) (execution.Execution, bool) {
+	e, ok := s.executions.Get(id)
+	if !ok {
+		writeError(w, http.StatusNotFound, execution.ErrNotFound)
+		return e, false
+	}
+	if err := s.executions.AuthorizeExecution(user, e, s.registry.RPC(e.RPC)); err != nil {
+		writeError(w, http.StatusForbidden, err)
+		return e, false
+	}
+	return e, true
+}
+
+func (s *Server) handleOpenAPI(w http.ResponseWriter, r *http.Request, user string) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	writeJSON(w, http.StatusOK, OpenAPI(s.registry.RPCs()))
+}
+
+type statusResponse struct {
+	Sources []registry.SourceStatus `json:"sources"`
+}
+
+// handleStatus returns the status of the discovery sources, with their errors
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request, user string) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+	writeJSON(w, http.StatusOK, statusResponse{Sources: s.registry.Status()})
+}
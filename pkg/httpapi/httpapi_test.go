@@ -0,0 +1,163 @@
+package httpapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/execution"
+	"github.com/yannh/arpicee/pkg/mock"
+	"github.com/yannh/arpicee/pkg/policy"
+	"github.com/yannh/arpicee/pkg/registry/registrytest"
+)
+
+func newTestServer(t *testing.T) (*Server, *execution.Manager) {
+	reg := registrytest.New(t,
+		mock.New("deploy", []arpicee.Parameter{
+			{Name: "env", Type: arpicee.TypeString, Required: true, AllowedValues: []string{"staging", "production"}},
+			{Name: "replicas", Type: arpicee.TypeInt},
+		}, nil).WithMetadata(arpicee.Metadata{Owner: "platform", Category: "Releases"}),
+		mock.New("wait", nil, func(ctx context.Context, args []arpicee.Argument) (map[string]interface{}, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}),
+	)
+	mgr := execution.NewManager(100)
+	return New(reg, mgr, []Token{{Name: "ci", Token: "secret"}}), mgr
+}
+
+func TestServer(t *testing.T) {
+	s, _ := newTestServer(t)
+
+	for i, testCase := range []struct {
+		method       string
+		path         string
+		token        string
+		body         string
+		expectStatus int
+		expectBody   string
+	}{
+		{"GET", "/rpcs", "", "", http.StatusUnauthorized, "missing or invalid bearer token"},
+		{"GET", "/rpcs", "wrong", "", http.StatusUnauthorized, "missing or invalid bearer token"},
+		{"GET", "/rpcs", "secret", "", http.StatusOK, `"name":"deploy"`},
+		{"GET", "/rpcs?q=platform", "secret", "", http.StatusOK, `"metadata":{"owner":"platform","category":"Releases"}}]`},
+		{"GET", "/rpcs?q=wait&category=Releases", "secret", "", http.StatusOK, "[]\n"},
+		{"GET", "/rpcs/deploy", "secret", "", http.StatusOK, `"allowedValues":["staging","production"]`},
+		{"GET", "/rpcs/unknown", "secret", "", http.StatusNotFound, "no RPC named unknown"},
+		{"DELETE", "/rpcs/deploy", "secret", "", http.StatusMethodNotAllowed, "not allowed"},
+		{"POST", "/rpcs/deploy/executions", "secret", `{"arguments": {}}`, http.StatusUnprocessableEntity, "parameter env is required"},
+		{"POST", "/rpcs/deploy/executions", "secret", `{"arguments": {"env": "dev"}}`, http.StatusUnprocessableEntity, "invalid value for parameter env"},
+		{"POST", "/rpcs/deploy/executions", "secret", `{"arguments": {"env": "staging", "replicas": "two"}}`, http.StatusUnprocessableEntity, "parameter replicas should be a int"},
+		{"POST", "/rpcs/deploy/executions", "secret", `not json`, http.StatusBadRequest, "failed parsing request body"},
+		{"POST", "/rpcs/deploy/executions", "secret", `{"arguments": {"env": "staging", "replicas": 2}}`, http.StatusAccepted, `"user":"token:ci"`},
+		{"GET", "/executions/unknown", "secret", "", http.StatusNotFound, "execution not found"},
+		{"GET", "/openapi.json", "secret", "", http.StatusOK, `"/rpcs/deploy/executions"`},
+		{"GET", "/status", "", "", http.StatusUnauthorized, "missing or invalid bearer token"},
+		{"GET", "/status", "secret", "", http.StatusOK, `{"sources":[{"name":"source 1","rpcs":2,`},
+	} {
+		req := httptest.NewRequest(testCase.method, testCase.path, strings.NewReader(testCase.body))
+		if testCase.token != "" {
+			req.Header.Set("Authorization", "Bearer "+testCase.token)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+
+		if w.Code != testCase.expectStatus {
+			t.Errorf("test %d - expected status %d, got %d: %s", i, testCase.expectStatus, w.Code, w.Body.String())
+		}
+		if !strings.Contains(w.Body.String(), testCase.expectBody) {
+			t.Errorf("test %d - expected body to contain %s, got %s", i, testCase.expectBody, w.Body.String())
+		}
+	}
+}
+
+func TestExecutionLifecycle(t *testing.T) {
+	s, mgr := newTestServer(t)
+
+	do := func(method, path, body string) (int, execution.Execution) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+		var e execution.Execution
+		json.Unmarshal(w.Body.Bytes(), &e)
+		return w.Code, e
+	}
+
+	code, e := do("POST", "/rpcs/wait/executions", `{}`)
+	if code != http.StatusAccepted || e.Status != execution.StatusRunning {
+		t.Fatalf("expected a running execution, got %d %+v", code, e)
+	}
+
+	if code, got := do("GET", "/executions/"+e.ID, ""); code != http.StatusOK || got.ID != e.ID {
+		t.Errorf("expected to retrieve execution %s, got %d %+v", e.ID, code, got)
+	}
+
+	if code, got := do("POST", "/executions/"+e.ID+"/cancel", ""); code != http.StatusOK || got.Status != execution.StatusCancelled {
+		t.Errorf("expected execution to be cancelled, got %d %+v", code, got)
+	}
+
+	if code, _ := do("POST", "/executions/"+e.ID+"/cancel", ""); code != http.StatusConflict {
+		t.Errorf("expected cancelling a finished execution to return %d, got %d", http.StatusConflict, code)
+	}
+
+	_, e = do("POST", "/rpcs/deploy/executions", `{"arguments": {"env": "staging"}}`)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := mgr.Wait(ctx, e.ID); err != nil {
+		t.Fatalf("failed waiting for execution: %s", err)
+	}
+	if _, got := do("GET", "/executions/"+e.ID, ""); got.Status != execution.StatusSucceeded || got.Result["output"] != "Running deploy --env staging" {
+		t.Errorf("expected execution to succeed, got %+v", got)
+	}
+}
+
+func TestExecutionOwnership(t *testing.T) {
+	s, mgr := newTestServer(t)
+	s.tokens = append(s.tokens, Token{Name: "ops", Token: "ops-secret"}, Token{Name: "dev", Token: "dev-secret"})
+	pol, err := policy.New([]policy.Rule{{RPC: "wait", Users: []string{"token:ci", "token:ops"}}})
+	if err != nil {
+		t.Fatalf("failed creating policy: %s", err)
+	}
+	mgr.SetAuthorizer(pol)
+
+	e, err := mgr.Start(s.registry.RPC("wait"), nil, "token:ci")
+	if err != nil {
+		t.Fatalf("failed starting execution: %s", err)
+	}
+	defer mgr.Cancel(e.ID)
+
+	for i, testCase := range []struct {
+		method       string
+		path         string
+		token        string
+		expectStatus int
+		expectBody   string
+	}{
+		{"GET", "/executions", "dev-secret", http.StatusOK, "[]\n"},
+		{"GET", "/executions", "ops-secret", http.StatusOK, e.ID},
+		{"GET", "/executions/" + e.ID, "dev-secret", http.StatusForbidden, "not allowed"},
+		{"POST", "/executions/" + e.ID + "/cancel", "dev-secret", http.StatusForbidden, "not allowed"},
+		{"GET", "/executions/" + e.ID, "ops-secret", http.StatusOK, e.ID},
+	} {
+		req := httptest.NewRequest(testCase.method, testCase.path, nil)
+		req.Header.Set("Authorization", "Bearer "+testCase.token)
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+
+		if w.Code != testCase.expectStatus {
+			t.Errorf("test %d - expected status %d, got %d: %s", i, testCase.expectStatus, w.Code, w.Body.String())
+		}
+		if !strings.Contains(w.Body.String(), testCase.expectBody) {
+			t.Errorf("test %d - expected body to contain %s, got %s", i, testCase.expectBody, w.Body.String())
+		}
+	}
+	if got, _ := mgr.Get(e.ID); got.Status != execution.StatusRunning {
+		t.Errorf("expected execution to still be running, got %s", got.Status)
+	}
+}
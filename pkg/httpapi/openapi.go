@@ -0,0 +1,195 @@
+package httpapi
+
+import (
+	"net/url"
+	"sort"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+)
+
+type obj = map[string]interface{}
+
+func ref(name string) obj {
+	return obj{"$ref": "#/components/schemas/" + name}
+}
+
+func jsonContent(schema obj) obj {
+	return obj{"application/json": obj{"schema": schema}}
+}
+
+func response(description string, schema obj) obj {
+	return obj{"description": description, "content": jsonContent(schema)}
+}
+
+var errorResponses = obj{
+	"401": response("Missing or invalid token", ref("Error")),
+	"404": response("Not found", ref("Error")),
+}
+
+func withErrors(responses obj) obj {
+	for k, v := range errorResponses {
+		responses[k] = v
+	}
+	return responses
+}
+
+// OpenAPI returns an OpenAPI 3 document describing the API, with one
+// execution endpoint per RPC documenting its parameters.
+func OpenAPI(rpcs []arpicee.RemoteCall) map[string]interface{} {
+	sort.Slice(rpcs, func(i, j int) bool {
+		return rpcs[i].Name() < rpcs[j].Name()
+	})
+
+	idParam := func(name, description string) obj {
+		return obj{"name": name, "in": "path", "required": true, "description": description, "schema": obj{"type": "string"}}
+	}
+
+	paths := obj{
+		"/rpcs": obj{
+			"get": obj{
+				"summary": "List the discovered RPCs",
+				"parameters": []obj{
+					{"name": "q", "in": "query", "description": "Words to search in the name, description, owner, category and tags of the RPCs", "schema": obj{"type": "string"}},
+					{"name": "category", "in": "query", "description": "Category of the RPCs", "schema": obj{"type": "string"}},
+				},
+				"responses": withErrors(obj{"200": response("List of RPCs", obj{"type": "array", "items": ref("RPC")})}),
+			},
+		},
+		"/rpcs/{id}": obj{
+			"get": obj{
+				"summary":    "Describe an RPC and its parameters",
+				"parameters": []obj{idParam("id", "Name of the RPC")},
+				"responses":  withErrors(obj{"200": response("RPC description", ref("RPC"))}),
+			},
+		},
+		"/executions": obj{
+			"get": obj{
+				"summary":   "List the recent executions the caller started, or of the RPCs it is allowed to run",
+				"responses": withErrors(obj{"200": response("List of executions", obj{"type": "array", "items": ref("Execution")})}),
+			},
+		},
+		"/executions/{id}": obj{
+			"get": obj{
+				"summary":    "Get the status and result of an execution",
+				"parameters": []obj{idParam("id", "ID of the execution")},
+				"responses": withErrors(obj{
+					"200": response("Execution", ref("Execution")),
+					"403": response("Execution started by another user", ref("Error")),
+				}),
+			},
+		},
+		"/status": obj{
+			"get": obj{
+				"summary":   "Get the status of the discovery sources, with their errors",
+				"responses": withErrors(obj{"200": response("Status of the discovery sources", obj{"type": "object"})}),
+			},
+		},
+		"/executions/{id}/cancel": obj{
+			"post": obj{
+				"summary":    "Cancel a running execution",
+				"parameters": []obj{idParam("id", "ID of the execution")},
+				"responses": withErrors(obj{
+					"200": response("Cancelled execution", ref("Execution")),
+					"403": response("Execution started by another user", ref("Error")),
+					"409": response("Execution already finished", ref("Error")),
+				}),
+			},
+		},
+	}
+
+	for _, rpc := range rpcs {
+		summary := "Run " + rpc.Name()
+		paths["/rpcs/"+url.PathEscape(rpc.Name())+"/executions"] = obj{
+			"post": obj{
+				"summary":     summary,
+				"description": rpc.Description(),
+				"operationId": "run_" + rpc.Name(),
+				"requestBody": obj{
+					"required": true,
+					"content": jsonContent(obj{
+						"type":     "object",
+						"required": []string{"arguments"},
+						"properties": obj{
+							"arguments": arpicee.JSONSchema(rpc.Params()),
+						},
+					}),
+				},
+				"responses": withErrors(obj{
+					"202": response("Execution started", ref("Execution")),
+					"422": response("Invalid arguments", ref("Error")),
+				}),
+			},
+		}
+	}
+
+	return obj{
+		"openapi": "3.0.3",
+		"info": obj{
+			"title":       "Arpicee",
+			"description": "Trigger remote procedures discovered by Arpicee",
+			"version":     "1",
+		},
+		"security": []obj{{"bearer": []string{}}},
+		"paths":    paths,
+		"components": obj{
+			"securitySchemes": obj{
+				"bearer": obj{"type": "http", "scheme": "bearer"},
+			},
+			"schemas": obj{
+				"Error": obj{
+					"type":       "object",
+					"properties": obj{"error": obj{"type": "string"}},
+				},
+				"Parameter": obj{
+					"type": "object",
+					"properties": obj{
+						"name":          obj{"type": "string"},
+						"type":          obj{"type": "string", "enum": []string{"string", "int", "bool"}},
+						"description":   obj{"type": "string"},
+						"required":      obj{"type": "boolean"},
+						"default":       obj{"type": "string"},
+						"allowedValues": obj{"type": "array", "items": obj{"type": "string"}},
+						"secret":        obj{"type": "boolean"},
+					},
+				},
+				"Metadata": obj{
+					"type": "object",
+					"properties": obj{
+						"owner":             obj{"type": "string"},
+						"category":          obj{"type": "string"},
+						"tags":              obj{"type": "array", "items": obj{"type": "string"}},
+						"runbookURL":        obj{"type": "string"},
+						"risk":              obj{"type": "string", "enum": []string{"low", "medium", "high"}},
+						"estimatedDuration": obj{"type": "string"},
+						"deprecation":       obj{"type": "string"},
+					},
+				},
+				"RPC": obj{
+					"type": "object",
+					"properties": obj{
+						"name":        obj{"type": "string"},
+						"description": obj{"type": "string"},
+						"parameters":  obj{"type": "array", "items": ref("Parameter")},
+						"metadata":    ref("Metadata"),
+						"schema":      obj{"type": "object", "description": "JSON Schema of the arguments"},
+					},
+				},
+				"Execution": obj{
+					"type": "object",
+					"properties": obj{
+						"id":         obj{"type": "string"},
+						"rpc":        obj{"type": "string"},
+						"user":       obj{"type": "string"},
+						"arguments":  obj{"type": "object"},
+						"status":     obj{"type": "string", "enum": []string{"running", "succeeded", "failed", "cancelled"}},
+						"result":     obj{"type": "object"},
+						"error":      obj{"type": "string"},
+						"progress":   obj{"type": "array", "items": obj{"type": "object", "properties": obj{"time": obj{"type": "string", "format": "date-time"}, "message": obj{"type": "string"}}}},
+						"createdAt":  obj{"type": "string", "format": "date-time"},
+						"finishedAt": obj{"type": "string", "format": "date-time"},
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,114 @@
+package httprpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+)
+
+// DefaultTimeout is the timeout of the requests when Endpoint.Timeout is not set
+const DefaultTimeout = 30 * time.Second
+
+// maxBody is the maximum size of the responses read
+const maxBody = 1 << 20
+
+// Endpoint is the HTTP endpoint an RPC sends its arguments to, as a JSON object.
+// Method defaults to POST.
+type Endpoint struct {
+	URL     string
+	Method  string
+	Headers map[string]string
+	Timeout time.Duration
+}
+
+// HTTPRPC is a RemoteCall sending its arguments to an HTTP endpoint. JSON objects
+// returned by the endpoint are the result of the RPC, like the payloads returned by
+// Lambda functions; other responses are returned as "body".
+type HTTPRPC struct {
+	client      *http.Client
+	name        string
+	description string
+	params      []arpicee.Parameter
+	endpoint    Endpoint
+}
+
+func New(name, description string, params []arpicee.Parameter, e Endpoint) *HTTPRPC {
+	if e.Method == "" {
+		e.Method = http.MethodPost
+	}
+	if e.Timeout == 0 {
+		e.Timeout = DefaultTimeout
+	}
+	return &HTTPRPC{
+		client:      &http.Client{Timeout: e.Timeout},
+		name:        name,
+		description: description,
+		params:      params,
+		endpoint:    e,
+	}
+}
+
+func (h *HTTPRPC) Name() string {
+	return h.name
+}
+
+func (h *HTTPRPC) Description() string {
+	return h.description
+}
+
+func (h *HTTPRPC) Params() []arpicee.Parameter {
+	return h.params
+}
+
+func (h *HTTPRPC) Provider() string {
+	return "http"
+}
+
+func (h *HTTPRPC) Run(args []arpicee.Argument) (map[string]interface{}, error) {
+	return h.RunContext(context.Background(), args)
+}
+
+func (h *HTTPRPC) RunContext(ctx context.Context, args []arpicee.Argument) (map[string]interface{}, error) {
+	payload, err := json.Marshal(arpicee.ArgsToMap(args))
+	if err != nil {
+		return nil, fmt.Errorf("failed serializing arguments: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, h.endpoint.Method, h.endpoint.URL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	for k, v := range h.endpoint.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed calling %s: %w", h.name, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed reading the response of %s: %w", h.name, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%s returned %s: %s", h.name, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var res map[string]interface{}
+	if err := json.Unmarshal(body, &res); err != nil || res == nil {
+		return map[string]interface{}{
+			"formatString": "{{ .body }}",
+			"body":         string(body),
+		}, nil
+	}
+	return res, nil
+}
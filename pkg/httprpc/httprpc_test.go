@@ -0,0 +1,66 @@
+package httprpc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+)
+
+func TestRun(t *testing.T) {
+	for i, testCase := range []struct {
+		status      int
+		contentType string
+		body        string
+		expect      map[string]interface{}
+		expectErr   string
+	}{
+		{
+			http.StatusOK, "application/json", `{"formatString": "refunded {{ .amount }}", "amount": 12}`,
+			map[string]interface{}{"formatString": "refunded {{ .amount }}", "amount": float64(12)},
+			"",
+		},
+		{
+			http.StatusOK, "text/plain", "refund queued\n",
+			map[string]interface{}{"formatString": "{{ .body }}", "body": "refund queued\n"},
+			"",
+		},
+		{
+			http.StatusOK, "application/json", `["not", "an", "object"]`,
+			map[string]interface{}{"formatString": "{{ .body }}", "body": `["not", "an", "object"]`},
+			"",
+		},
+		{
+			http.StatusForbidden, "text/plain", "not allowed\n",
+			nil,
+			"refund returned 403 Forbidden: not allowed",
+		},
+	} {
+		var method, auth string
+		var payload map[string]interface{}
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			method, auth = r.Method, r.Header.Get("Authorization")
+			json.NewDecoder(r.Body).Decode(&payload)
+			w.Header().Set("Content-Type", testCase.contentType)
+			w.WriteHeader(testCase.status)
+			w.Write([]byte(testCase.body))
+		}))
+
+		h := New("refund", "Refunds an order", nil, Endpoint{URL: srv.URL, Headers: map[string]string{"Authorization": "Bearer s3cr3t"}})
+		res, err := h.Run([]arpicee.Argument{&arpicee.ArgumentString{Name: "order", Val: "1234"}, &arpicee.ArgumentBool{Name: "notify", Val: true}})
+		srv.Close()
+
+		if (err == nil && testCase.expectErr != "") || (err != nil && err.Error() != testCase.expectErr) {
+			t.Errorf("test %d - expected error %q, got %v", i, testCase.expectErr, err)
+		}
+		if !reflect.DeepEqual(res, testCase.expect) {
+			t.Errorf("test %d - expected %v, got %v", i, testCase.expect, res)
+		}
+		if expect := map[string]interface{}{"order": "1234", "notify": true}; method != http.MethodPost || auth != "Bearer s3cr3t" || !reflect.DeepEqual(payload, expect) {
+			t.Errorf("test %d - expected a POST with %v and the headers, got a %s with %v, authorization %q", i, expect, method, payload, auth)
+		}
+	}
+}
@@ -9,17 +9,26 @@ import (
 	awsLambda "github.com/aws/aws-sdk-go/service/lambda"
 	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
 	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/filter"
 )
 
 type LambdaRPC struct {
 	svc         lambdaiface.LambdaAPI
 	name        string
+	qualifier   string
 	description string
 	params      []arpicee.Parameter
+	metadata    arpicee.Metadata
 }
 
 func (lr *LambdaRPC) Name() string {
-	return lr.name
+	return lr.qualifier + lr.name
+}
+
+// Qualify prefixes the name of the RPC with qualifier, such as "123456789012:eu-west-1:",
+// to tell apart functions with the same name in different accounts and regions
+func (lr *LambdaRPC) Qualify(qualifier string) {
+	lr.qualifier = qualifier
 }
 
 func (lr *LambdaRPC) Description() string {
@@ -30,10 +39,18 @@ func (lr *LambdaRPC) Params() []arpicee.Parameter {
 	return lr.params
 }
 
-func TagFilter(tagName, tagValue string) func(configuration *awsLambda.ListTagsOutput) bool {
-	return func(lambda *awsLambda.ListTagsOutput) bool {
-		return lambda.Tags != nil && lambda.Tags[tagName] != nil && *lambda.Tags[tagName] == tagValue
-	}
+func (lr *LambdaRPC) Provider() string {
+	return "lambda"
+}
+
+// Metadata returns the metadata set by the tags of the function, see arpicee.ParseMetadata
+func (lr *LambdaRPC) Metadata() arpicee.Metadata {
+	return lr.metadata
+}
+
+// Ref returns the name of the function, without qualifier
+func (lr *LambdaRPC) Ref() string {
+	return lr.name
 }
 
 func inArray(ar []string, el string) bool {
@@ -57,6 +74,17 @@ func getParamFlags(paramName string) []string {
 	return strings.Split(parts[2], flagSeparator)
 }
 
+// Restore returns the RPC invoking the function name, from a previous discovery
+func Restore(svc lambdaiface.LambdaAPI, name, description string, params []arpicee.Parameter, metadata arpicee.Metadata) *LambdaRPC {
+	return &LambdaRPC{
+		svc:         svc,
+		name:        name,
+		description: description,
+		params:      params,
+		metadata:    metadata,
+	}
+}
+
 func New(svc lambdaiface.LambdaAPI, name string) (*LambdaRPC, error) {
 	input := awsLambda.GetFunctionInput{
 		FunctionName: &name,
@@ -67,10 +95,15 @@ func New(svc lambdaiface.LambdaAPI, name string) (*LambdaRPC, error) {
 		return nil, err
 	}
 
+	metadata, err := arpicee.ParseMetadata(aws.StringValueMap(output.Tags))
+	if err != nil {
+		return nil, err
+	}
 	l := LambdaRPC{
 		svc:         svc,
 		name:        name,
 		description: *output.Configuration.Description,
+		metadata:    metadata,
 	}
 
 	l.params = []arpicee.Parameter{}
@@ -98,6 +131,7 @@ func New(svc lambdaiface.LambdaAPI, name string) (*LambdaRPC, error) {
 					Type:        t,
 					Description: *tagValue,
 					Required:    required,
+					Secret:      inArray(flags, "secret"),
 				})
 			}
 		}
@@ -148,9 +182,13 @@ func (l *LambdaRPC) Run(args []arpicee.Argument) (map[string]interface{}, error)
 	return res, nil
 }
 
-func Discover(svc lambdaiface.LambdaAPI, filters []func(configuration *awsLambda.ListTagsOutput) bool) ([]*LambdaRPC, error) {
+// Discover returns the functions selected by match, or all functions if match is nil.
+// Functions that can not be retrieved are returned as arpicee.DiscoveryErrors, along
+// with the other functions.
+func Discover(svc lambdaiface.LambdaAPI, match filter.Matcher) ([]*LambdaRPC, error) {
 	var err error
 	var automationLambdas []*LambdaRPC
+	var itemErrs arpicee.DiscoveryErrors
 
 	result := &awsLambda.ListFunctionsOutput{}
 	nCall := 1
@@ -164,23 +202,25 @@ func Discover(svc lambdaiface.LambdaAPI, filters []func(configuration *awsLambda
 			return nil, err
 		}
 
-	OUTER:
 		for _, fn := range result.Functions {
 			tagsOutput, err := svc.ListTags(&awsLambda.ListTagsInput{Resource: fn.FunctionArn})
 			if err != nil {
-				return nil, err
+				itemErrs = append(itemErrs, arpicee.ItemError{Item: *fn.FunctionName, Err: err})
+				continue
 			}
 
-			for _, filter := range filters {
-				if !filter(tagsOutput) {
-					continue OUTER
-				}
+			if match != nil && !match(filter.Item{Name: *fn.FunctionName, Tags: aws.StringValueMap(tagsOutput.Tags)}) {
+				continue
 			}
 
-			f, _ := New(svc, *fn.FunctionName)
+			f, err := New(svc, *fn.FunctionName)
+			if err != nil {
+				itemErrs = append(itemErrs, arpicee.ItemError{Item: *fn.FunctionName, Err: err})
+				continue
+			}
 			automationLambdas = append(automationLambdas, f)
 		}
 	}
 
-	return automationLambdas, nil
+	return automationLambdas, itemErrs.Err()
 }
@@ -1,12 +1,16 @@
 package lambdarpc
 
 import (
+	"errors"
+	"fmt"
+	"reflect"
 	"testing"
 
 	"github.com/aws/aws-sdk-go/aws"
 	awsLambda "github.com/aws/aws-sdk-go/service/lambda"
 	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
 	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/filter"
 )
 
 type mockLambdaClient struct {
@@ -14,6 +18,11 @@ type mockLambdaClient struct {
 	getFunction   func(input *awsLambda.GetFunctionInput) (*awsLambda.GetFunctionOutput, error)
 	listFunctions func(input *awsLambda.ListFunctionsInput) (*awsLambda.ListFunctionsOutput, error)
 	listTags      func(input *awsLambda.ListTagsInput) (*awsLambda.ListTagsOutput, error)
+	invoke        func(input *awsLambda.InvokeInput) (*awsLambda.InvokeOutput, error)
+}
+
+func (m *mockLambdaClient) Invoke(input *awsLambda.InvokeInput) (*awsLambda.InvokeOutput, error) {
+	return m.invoke(input)
 }
 
 func (m *mockLambdaClient) GetFunction(input *awsLambda.GetFunctionInput) (*awsLambda.GetFunctionOutput, error) {
@@ -67,6 +76,7 @@ func TestNewLambdaRPC(t *testing.T) {
 						"param:foo:int":           aws.String("some description"),
 						"someothertag":            aws.String("foobar"),
 						"param:bar:bool/required": aws.String("something"),
+						"arpicee:owner":           aws.String("platform"),
 					},
 				}, nil
 			},
@@ -88,6 +98,7 @@ func TestNewLambdaRPC(t *testing.T) {
 						Description: "some description",
 					},
 				},
+				metadata: arpicee.Metadata{Owner: "platform"},
 			},
 		},
 	} {
@@ -101,6 +112,27 @@ func TestNewLambdaRPC(t *testing.T) {
 		if !arpicee.Equal(testCase.expected, rpc) {
 			t.Errorf("expected %+v, got %+v", testCase.expected, rpc)
 		}
+		if !reflect.DeepEqual(testCase.expected.metadata, rpc.Metadata()) {
+			t.Errorf("expected metadata %+v, got %+v", testCase.expected.metadata, rpc.Metadata())
+		}
+	}
+}
+
+func TestQualify(t *testing.T) {
+	var invoked string
+	c := &mockLambdaClient{
+		invoke: func(input *awsLambda.InvokeInput) (*awsLambda.InvokeOutput, error) {
+			invoked = *input.FunctionName
+			return &awsLambda.InvokeOutput{Payload: []byte("{}")}, nil
+		},
+	}
+	rpc := &LambdaRPC{svc: c, name: "deploy"}
+	rpc.Qualify("123456789012:eu-west-1:")
+	if rpc.Name() != "123456789012:eu-west-1:deploy" {
+		t.Errorf("expected a qualified name, got %s", rpc.Name())
+	}
+	if _, err := rpc.Run(nil); err != nil || invoked != "deploy" {
+		t.Errorf("expected function deploy to be invoked, got %s, %v", invoked, err)
 	}
 }
 
@@ -113,7 +145,7 @@ func TestDiscover(t *testing.T) {
 	}
 	for _, testCase := range []struct {
 		lambdas  []lambda
-		filters  []func(configuration *awsLambda.ListTagsOutput) bool
+		match    filter.Matcher
 		expected []*LambdaRPC
 	}{
 		{
@@ -129,7 +161,7 @@ func TestDiscover(t *testing.T) {
 					},
 				},
 			},
-			filters:  []func(configuration *awsLambda.ListTagsOutput) bool{TagFilter("foo", "bar")},
+			match:    filter.Tag("foo", "bar"),
 			expected: []*LambdaRPC{ // The function is missing the tag foo with value bar, we should ignore it
 			},
 		},
@@ -147,7 +179,7 @@ func TestDiscover(t *testing.T) {
 					},
 				},
 			},
-			filters: []func(configuration *awsLambda.ListTagsOutput) bool{TagFilter("foo", "bar")},
+			match: filter.Tag("foo", "bar"),
 			expected: []*LambdaRPC{
 				{
 					name:        "lambda1",
@@ -203,7 +235,7 @@ func TestDiscover(t *testing.T) {
 			},
 		}
 
-		rpcs, err := Discover(c, testCase.filters)
+		rpcs, err := Discover(c, testCase.match)
 		if err != nil {
 			t.Errorf("failed discovering lambdas: %s", err)
 		}
@@ -227,6 +259,35 @@ func TestDiscover(t *testing.T) {
 	}
 }
 
+func TestDiscoverItemErrors(t *testing.T) {
+	c := &mockLambdaClient{
+		listFunctions: func(input *awsLambda.ListFunctionsInput) (*awsLambda.ListFunctionsOutput, error) {
+			return &awsLambda.ListFunctionsOutput{Functions: []*awsLambda.FunctionConfiguration{
+				{FunctionName: aws.String("deleted"), FunctionArn: aws.String("arn:deleted")},
+				{FunctionName: aws.String("deploy"), FunctionArn: aws.String("arn:deploy")},
+			}}, nil
+		},
+		listTags: func(input *awsLambda.ListTagsInput) (*awsLambda.ListTagsOutput, error) {
+			return &awsLambda.ListTagsOutput{}, nil
+		},
+		getFunction: func(input *awsLambda.GetFunctionInput) (*awsLambda.GetFunctionOutput, error) {
+			if *input.FunctionName == "deleted" {
+				return nil, fmt.Errorf("function not found")
+			}
+			return &awsLambda.GetFunctionOutput{Configuration: &awsLambda.FunctionConfiguration{Description: aws.String("")}}, nil
+		},
+	}
+
+	rpcs, err := Discover(c, nil)
+	if len(rpcs) != 1 || rpcs[0] == nil || rpcs[0].Name() != "deploy" {
+		t.Errorf("expected the function deploy only, got %+v", rpcs)
+	}
+	var itemErrs arpicee.DiscoveryErrors
+	if !errors.As(err, &itemErrs) || len(itemErrs) != 1 || itemErrs[0].Item != "deleted" {
+		t.Errorf("expected an error for the function deleted, got %v", err)
+	}
+}
+
 func TestSerializeArguments(t *testing.T) {
 	res, err := serializeArguments([]arpicee.Argument{
 		&arpicee.ArgumentString{
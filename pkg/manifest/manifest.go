@@ -0,0 +1,158 @@
+package manifest
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/lambda/lambdaiface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/httprpc"
+	"github.com/yannh/arpicee/pkg/lambdarpc"
+	"github.com/yannh/arpicee/pkg/ssmrpc"
+)
+
+// Lambda runs the Lambda function Function
+type Lambda struct {
+	Function string
+}
+
+// SSM runs the SSM document Document
+type SSM struct {
+	Document string
+}
+
+// HTTP sends the arguments to URL, see httprpc.Endpoint. Timeout is a duration such as
+// "1m".
+type HTTP struct {
+	URL     string
+	Method  string
+	Headers map[string]string `config:"secret"`
+	Timeout string
+}
+
+// Manifest declares an RPC run by one of the backends Lambda, SSM or HTTP, with the
+// parameters Params. Unlike discovered RPCs, the backend is not queried for its
+// parameters or metadata.
+type Manifest struct {
+	Name        string
+	Description string
+	Params      []arpicee.ParamDeclaration
+	Metadata    arpicee.Metadata
+	Lambda      *Lambda
+	SSM         *SSM
+	HTTP        *HTTP
+}
+
+// Backends are the AWS clients running the Lambda functions and SSM documents of the
+// manifests
+type Backends struct {
+	Lambda lambdaiface.LambdaAPI
+	SSM    *ssm.SSM
+}
+
+// RPC is a RemoteCall declared by a Manifest
+type RPC struct {
+	name        string
+	description string
+	params      []arpicee.Parameter
+	metadata    arpicee.Metadata
+	backend     arpicee.RemoteCall
+}
+
+// New returns the RPC declared by m, run with the clients of b
+func New(m Manifest, b Backends) (*RPC, error) {
+	if m.Name == "" {
+		return nil, fmt.Errorf("missing RPC name")
+	}
+	if err := m.Metadata.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", m.Name, err)
+	}
+	r := &RPC{name: m.Name, description: m.Description, metadata: m.Metadata}
+	for _, p := range m.Params {
+		param, err := p.Parameter()
+		if err != nil {
+			return nil, fmt.Errorf("%s: parameter %s: %w", m.Name, p.Name, err)
+		}
+		r.params = append(r.params, param)
+	}
+
+	n := 0
+	if m.Lambda != nil {
+		n++
+		if b.Lambda == nil {
+			return nil, fmt.Errorf("%s: no AWS session to run Lambda functions", m.Name)
+		}
+		r.backend = lambdarpc.Restore(b.Lambda, m.Lambda.Function, m.Description, r.params, m.Metadata)
+	}
+	if m.SSM != nil {
+		n++
+		if b.SSM == nil {
+			return nil, fmt.Errorf("%s: no AWS session to run SSM documents", m.Name)
+		}
+		r.backend = ssmrpc.Restore(b.SSM, m.SSM.Document, m.Description, r.params, m.Metadata)
+	}
+	if m.HTTP != nil {
+		n++
+		var timeout time.Duration
+		if m.HTTP.Timeout != "" {
+			var err error
+			if timeout, err = time.ParseDuration(m.HTTP.Timeout); err != nil {
+				return nil, fmt.Errorf("%s: invalid timeout %s", m.Name, m.HTTP.Timeout)
+			}
+		}
+		r.backend = httprpc.New(m.Name, m.Description, r.params, httprpc.Endpoint{
+			URL:     m.HTTP.URL,
+			Method:  m.HTTP.Method,
+			Headers: m.HTTP.Headers,
+			Timeout: timeout,
+		})
+	}
+	if n != 1 {
+		return nil, fmt.Errorf("%s: expected exactly one of lambda, ssm or http", m.Name)
+	}
+	return r, nil
+}
+
+func (r *RPC) Name() string {
+	return r.name
+}
+
+func (r *RPC) Description() string {
+	return r.description
+}
+
+func (r *RPC) Params() []arpicee.Parameter {
+	return r.params
+}
+
+// Provider returns the provider of the backend, such as lambda
+func (r *RPC) Provider() string {
+	return arpicee.Provider(r.backend)
+}
+
+// Metadata returns the metadata of the manifest
+func (r *RPC) Metadata() arpicee.Metadata {
+	return r.metadata
+}
+
+func (r *RPC) Run(args []arpicee.Argument) (map[string]interface{}, error) {
+	return r.RunContext(context.Background(), args)
+}
+
+func (r *RPC) RunContext(ctx context.Context, args []arpicee.Argument) (map[string]interface{}, error) {
+	if err := arpicee.ValidateArguments(args, r.params); err != nil {
+		return nil, err
+	}
+	return arpicee.Run(ctx, r.backend, args)
+}
+
+// Resume resumes an execution of the backend, if it is resumable
+func (r *RPC) Resume(ctx context.Context, externalID string) (map[string]interface{}, error) {
+	rr, ok := r.backend.(arpicee.ResumableRemoteCall)
+	if !ok {
+		return nil, fmt.Errorf("%s can not be resumed", r.name)
+	}
+	return rr.Resume(ctx, externalID)
+}
@@ -0,0 +1,80 @@
+package manifest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	awsLambda "github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/yannh/arpicee/pkg/arpicee"
+)
+
+func TestNew(t *testing.T) {
+	lambdaSvc := awsLambda.New(session.Must(session.NewSession()))
+	for i, testCase := range []struct {
+		m              Manifest
+		b              Backends
+		expectProvider string
+		expectErr      string
+	}{
+		{Manifest{Name: "deploy", Lambda: &Lambda{Function: "deploy-service"}}, Backends{Lambda: lambdaSvc}, "lambda", ""},
+		{Manifest{Name: "refund", HTTP: &HTTP{URL: "https://payments.internal/refund", Timeout: "10s"}}, Backends{}, "http", ""},
+		{Manifest{Lambda: &Lambda{Function: "deploy-service"}}, Backends{Lambda: lambdaSvc}, "", "missing RPC name"},
+		{Manifest{Name: "deploy", Lambda: &Lambda{Function: "deploy-service"}}, Backends{}, "", "deploy: no AWS session to run Lambda functions"},
+		{Manifest{Name: "deploy"}, Backends{}, "", "deploy: expected exactly one of lambda, ssm or http"},
+		{Manifest{Name: "deploy", Metadata: arpicee.Metadata{Risk: "extreme"}}, Backends{}, "", "deploy: invalid risk extreme, expected low, medium or high"},
+		{
+			Manifest{Name: "refund", HTTP: &HTTP{URL: "https://payments.internal/refund"}, Params: []arpicee.ParamDeclaration{{Name: "amount", Type: "float"}}},
+			Backends{},
+			"",
+			"refund: parameter amount: invalid type float, expected string, int or bool",
+		},
+	} {
+		rpc, err := New(testCase.m, testCase.b)
+		if (err == nil && testCase.expectErr != "") || (err != nil && err.Error() != testCase.expectErr) {
+			t.Errorf("test %d - expected error %q, got %v", i, testCase.expectErr, err)
+			continue
+		}
+		if err == nil && rpc.Provider() != testCase.expectProvider {
+			t.Errorf("test %d - expected provider %s, got %s", i, testCase.expectProvider, rpc.Provider())
+		}
+	}
+}
+
+func TestRPC(t *testing.T) {
+	var calls int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte(`{"formatString": "refunded"}`))
+	}))
+	defer srv.Close()
+
+	rpc, err := New(Manifest{
+		Name:        "refund",
+		Description: "Refunds an order",
+		Params:      []arpicee.ParamDeclaration{{Name: "order", Required: true}, {Name: "amount", Type: "int", Default: "0"}},
+		Metadata:    arpicee.Metadata{Owner: "payments"},
+		HTTP:        &HTTP{URL: srv.URL},
+	}, Backends{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectParams := []arpicee.Parameter{
+		{Name: "order", Type: arpicee.TypeString, Required: true},
+		{Name: "amount", Type: arpicee.TypeInt, Default: "0"},
+	}
+	if rpc.Name() != "refund" || rpc.Description() != "Refunds an order" || !reflect.DeepEqual(rpc.Params(), expectParams) || rpc.Metadata().Owner != "payments" {
+		t.Errorf("unexpected RPC %s: %s, params %+v, metadata %v", rpc.Name(), rpc.Description(), rpc.Params(), rpc.Metadata())
+	}
+
+	if _, err := rpc.Run(nil); err == nil || err.Error() != "parameter order is required" || calls != 0 {
+		t.Errorf("expected the missing argument to be rejected before calling the endpoint, got %v", err)
+	}
+	res, err := rpc.Run([]arpicee.Argument{&arpicee.ArgumentString{Name: "order", Val: "1234"}})
+	if err != nil || res["formatString"] != "refunded" || calls != 1 {
+		t.Errorf("expected the endpoint to be called, got %v, %v", res, err)
+	}
+}
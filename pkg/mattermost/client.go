@@ -0,0 +1,143 @@
+package mattermost
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client is a minimal client for the Mattermost REST API, authenticating with a bot token
+type Client struct {
+	url        string
+	token      string
+	httpClient *http.Client
+}
+
+func NewClient(serverURL, token string) *Client {
+	return &Client{
+		url:        strings.TrimSuffix(serverURL, "/"),
+		token:      token,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+type Attachment struct {
+	Fallback string            `json:"fallback,omitempty"`
+	Color    string            `json:"color,omitempty"`
+	Pretext  string            `json:"pretext,omitempty"`
+	Text     string            `json:"text,omitempty"`
+	Fields   []AttachmentField `json:"fields,omitempty"`
+}
+
+type AttachmentField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+type PostProps struct {
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+type Post struct {
+	ID        string    `json:"id,omitempty"`
+	ChannelID string    `json:"channel_id,omitempty"`
+	Message   string    `json:"message"`
+	Props     PostProps `json:"props"`
+}
+
+type DialogOption struct {
+	Text  string `json:"text"`
+	Value string `json:"value"`
+}
+
+type DialogElement struct {
+	DisplayName string         `json:"display_name"`
+	Name        string         `json:"name"`
+	Type        string         `json:"type"`
+	SubType     string         `json:"subtype,omitempty"`
+	Default     string         `json:"default,omitempty"`
+	Placeholder string         `json:"placeholder,omitempty"`
+	HelpText    string         `json:"help_text,omitempty"`
+	Optional    bool           `json:"optional"`
+	Options     []DialogOption `json:"options,omitempty"`
+}
+
+type Dialog struct {
+	CallbackID       string          `json:"callback_id"`
+	Title            string          `json:"title"`
+	IntroductionText string          `json:"introduction_text,omitempty"`
+	Elements         []DialogElement `json:"elements"`
+	SubmitLabel      string          `json:"submit_label,omitempty"`
+	State            string          `json:"state,omitempty"`
+}
+
+type openDialogRequest struct {
+	TriggerID string `json:"trigger_id"`
+	URL       string `json:"url"`
+	Dialog    Dialog `json:"dialog"`
+}
+
+func (c *Client) do(method, path string, body, out interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, c.url+path, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		if json.Unmarshal(msg, &apiErr) == nil && apiErr.Message != "" {
+			return fmt.Errorf("%s %s: %s (%d)", method, path, apiErr.Message, resp.StatusCode)
+		}
+		return fmt.Errorf("%s %s: unexpected status %d", method, path, resp.StatusCode)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// OpenDialog opens an interactive dialog; submissions are sent to callbackURL
+func (c *Client) OpenDialog(triggerID, callbackURL string, d Dialog) error {
+	return c.do(http.MethodPost, "/api/v4/actions/dialogs/open", openDialogRequest{
+		TriggerID: triggerID,
+		URL:       callbackURL,
+		Dialog:    d,
+	}, nil)
+}
+
+func (c *Client) CreatePost(p Post) (Post, error) {
+	var res Post
+	err := c.do(http.MethodPost, "/api/v4/posts", p, &res)
+	return res, err
+}
+
+// UpdatePost replaces the message and attachments of the post with ID p.ID
+func (c *Client) UpdatePost(p Post) (Post, error) {
+	var res Post
+	err := c.do(http.MethodPut, "/api/v4/posts/"+url.PathEscape(p.ID)+"/patch", struct {
+		Message string    `json:"message"`
+		Props   PostProps `json:"props"`
+	}{p.Message, p.Props}, &res)
+	return res, err
+}
@@ -0,0 +1,345 @@
+package mattermost
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/chat"
+	"github.com/yannh/arpicee/pkg/execution"
+)
+
+const (
+	CommandPath = "/mattermost/command"
+	DialogPath  = "/mattermost/dialog"
+)
+
+// stateTTL is how long dialogs can be submitted after they were opened
+const stateTTL = 15 * time.Minute
+
+// Bot serves a Mattermost slash command opening a dialog for the selected RPC,
+// and the submissions of these dialogs. Results are posted in the channel.
+type Bot struct {
+	client       *Client
+	core         *chat.Core
+	commandToken string
+	publicURL    string
+}
+
+// User returns the user executions are attributed to, in access rules, for the
+// Mattermost user with the given ID
+func User(id string) string {
+	return "mattermost:" + id
+}
+
+// New returns a Bot; commandToken is the token Mattermost sends with the slash
+// command, publicURL the URL of the HTTP server as seen by Mattermost.
+func New(client *Client, core *chat.Core, commandToken, publicURL string) (*Bot, error) {
+	if commandToken == "" {
+		return nil, fmt.Errorf("the Mattermost slash command token must be set")
+	}
+	if publicURL == "" {
+		return nil, fmt.Errorf("the public URL of the HTTP server must be set, for Mattermost to send dialog submissions")
+	}
+	return &Bot{
+		client:       client,
+		core:         core,
+		commandToken: commandToken,
+		publicURL:    strings.TrimSuffix(publicURL, "/"),
+	}, nil
+}
+
+// Register adds the slash command and dialog routes to mux
+func (b *Bot) Register(mux *http.ServeMux) {
+	mux.HandleFunc(CommandPath, b.handleCommand)
+	mux.HandleFunc(DialogPath, b.handleDialog)
+}
+
+type commandResponse struct {
+	ResponseType string `json:"response_type"`
+	Text         string `json:"text"`
+}
+
+type dialogSubmission struct {
+	Type       string                 `json:"type"`
+	CallbackID string                 `json:"callback_id"`
+	State      string                 `json:"state"`
+	UserID     string                 `json:"user_id"`
+	ChannelID  string                 `json:"channel_id"`
+	Submission map[string]interface{} `json:"submission"`
+	Cancelled  bool                   `json:"cancelled"`
+}
+
+type dialogResponse struct {
+	Error  string            `json:"error,omitempty"`
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("failed writing Mattermost response: %s", err)
+	}
+}
+
+func ephemeral(w http.ResponseWriter, text string) {
+	writeJSON(w, commandResponse{ResponseType: "ephemeral", Text: text})
+}
+
+// sign returns the dialog state, used to verify dialog submissions come from a
+// dialog opened by the bot: Mattermost does not sign the requests it sends. The
+// time the dialog was opened is signed too, so that states can not be replayed.
+func (b *Bot) sign(rpcName, userID, userName, channelID string, issuedAt time.Time) string {
+	ts := strconv.FormatInt(issuedAt.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(b.commandToken))
+	mac.Write([]byte(strings.Join([]string{rpcName, userID, userName, channelID, ts}, "\x00")))
+	return userName + ":" + ts + ":" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// verify checks the state of a submission, and returns the name of the user; the ID of
+// the user is signed in the state too. States older than stateTTL are refused.
+func (b *Bot) verify(s dialogSubmission) (string, bool) {
+	parts := strings.Split(s.State, ":")
+	if len(parts) < 3 {
+		return "", false
+	}
+	userName := strings.Join(parts[:len(parts)-2], ":")
+	ts, err := strconv.ParseInt(parts[len(parts)-2], 10, 64)
+	if err != nil {
+		return "", false
+	}
+	issuedAt := time.Unix(ts, 0)
+	if age := time.Since(issuedAt); age > stateTTL || age < -time.Minute {
+		return "", false
+	}
+	return userName, hmac.Equal([]byte(s.State), []byte(b.sign(s.CallbackID, s.UserID, userName, s.ChannelID, issuedAt)))
+}
+
+// usage lists the RPCs available in channelID
+func (b *Bot) usage(channelID string) string {
+	return "Run one of the following remote procedures with `/arpicee NAME`, or search them with `/arpicee search WORDS`:\n" + list(b.core.RPCsIn(channelID))
+}
+
+// list lists rpcs, grouped by category if some RPCs have one
+func list(rpcs []arpicee.RemoteCall) string {
+	var sb strings.Builder
+	groups := arpicee.GroupByCategory(rpcs)
+	for _, g := range groups {
+		if len(groups) > 1 || g.Category != arpicee.Uncategorized {
+			fmt.Fprintf(&sb, "#### %s\n", g.Category)
+		}
+		for _, rpc := range g.RPCs {
+			fmt.Fprintf(&sb, "- **%s**", rpc.Name())
+			if rpc.Description() != "" {
+				fmt.Fprintf(&sb, ": %s", rpc.Description())
+			}
+			if summary := arpicee.MetadataOf(rpc).Summary(); len(summary) > 0 {
+				fmt.Fprintf(&sb, " _(%s)_", strings.Join(summary, ", "))
+			}
+			sb.WriteString("\n")
+		}
+	}
+	return sb.String()
+}
+
+func (b *Bot) handleCommand(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.PostForm.Get("token")), []byte(b.commandToken)) != 1 {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return
+	}
+
+	// Usernames can be changed, users are identified by their ID
+	userID := r.PostForm.Get("user_id")
+	userName := r.PostForm.Get("user_name")
+	channelID := r.PostForm.Get("channel_id")
+	fields := strings.Fields(r.PostForm.Get("text"))
+	if len(fields) == 0 || fields[0] == "help" {
+		ephemeral(w, b.usage(channelID))
+		return
+	}
+
+	if fields[0] == "search" {
+		query := strings.Join(fields[1:], " ")
+		rpcs := arpicee.Search(b.core.RPCsIn(channelID), query)
+		if len(rpcs) == 0 {
+			ephemeral(w, fmt.Sprintf("No remote procedure matches %s", query))
+			return
+		}
+		ephemeral(w, list(rpcs))
+		return
+	}
+
+	rpc := b.core.RPCIn(channelID, fields[0])
+	if rpc == nil {
+		ephemeral(w, fmt.Sprintf("Unknown remote procedure **%s**. %s", fields[0], b.usage(channelID)))
+		return
+	}
+	if err := b.core.Authorize(User(userID), rpc); err != nil {
+		log.Printf("RPC %s invoked by %s: %s", rpc.Name(), userName, err)
+		ephemeral(w, fmt.Sprintf("You are not allowed to run **%s**", rpc.Name()))
+		return
+	}
+
+	state := b.sign(rpc.Name(), userID, userName, channelID, time.Now())
+	if err := b.client.OpenDialog(r.PostForm.Get("trigger_id"), b.publicURL+DialogPath, RunRPCDialog(rpc, b.core.Confirmation(rpc), state)); err != nil {
+		log.Printf("failed opening dialog for RPC %s: %s", rpc.Name(), err)
+		ephemeral(w, fmt.Sprintf("Failed opening the dialog for **%s**", rpc.Name()))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// RunRPCDialog returns the dialog asking for the parameters of rpc, and for the
+// confirmation it requires, see chat.FormFields
+func RunRPCDialog(rpc arpicee.RemoteCall, confirmation arpicee.Confirmation, state string) Dialog {
+	elements := []DialogElement{}
+	for _, f := range chat.FormFields(rpc, confirmation) {
+		el := DialogElement{
+			DisplayName: truncate(f.Label, 24),
+			Name:        f.Name,
+			Type:        "text",
+			Default:     f.Default,
+			HelpText:    f.Description,
+			Optional:    !f.Required,
+		}
+		switch f.Kind {
+		case chat.FieldCheckbox:
+			el.Type = "bool"
+			el.Placeholder = f.Description
+			el.HelpText = ""
+		case chat.FieldSelect:
+			el.Type = "select"
+			for _, v := range f.AllowedValues {
+				el.Options = append(el.Options, DialogOption{Text: v, Value: v})
+			}
+		case chat.FieldNumber, chat.FieldPassword:
+			el.SubType = string(f.Kind)
+		}
+		elements = append(elements, el)
+	}
+
+	intro := rpc.Description()
+	if summary := arpicee.MetadataOf(rpc).Summary(); len(summary) > 0 {
+		intro = strings.TrimSpace(intro + "\n\n" + strings.Join(summary, "\n"))
+	}
+	return Dialog{
+		CallbackID:       rpc.Name(),
+		Title:            truncate(rpc.Name(), 24),
+		IntroductionText: intro,
+		Elements:         elements,
+		SubmitLabel:      "Run",
+		State:            state,
+	}
+}
+
+// truncate shortens s to n characters; limits are in characters, not bytes
+func truncate(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-2]) + ".."
+	}
+	return s
+}
+
+func (b *Bot) handleDialog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var s dialogSubmission
+	if err := json.NewDecoder(r.Body).Decode(&s); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	userName, ok := b.verify(s)
+	if !ok {
+		http.Error(w, "invalid dialog state", http.StatusUnauthorized)
+		return
+	}
+	if s.Cancelled {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	rpc := b.core.RPCIn(s.ChannelID, s.CallbackID)
+	if rpc == nil {
+		writeJSON(w, dialogResponse{Error: fmt.Sprintf("remote procedure %s not found", s.CallbackID)})
+		return
+	}
+
+	// Invalid values are shown next to the fields of the dialog
+	args, err := chat.ArgsFromForm(rpc, b.core.Confirmation(rpc), chat.StringValues(s.Submission))
+	var fieldErrors chat.FieldErrors
+	if errors.As(err, &fieldErrors) {
+		writeJSON(w, dialogResponse{Errors: fieldErrors})
+		return
+	}
+
+	go b.run(rpc, args, s.UserID, userName, s.ChannelID)
+	w.WriteHeader(http.StatusOK)
+}
+
+func attachment(e execution.Execution, userName string) Attachment {
+	a := Attachment{
+		Color:   "#" + chat.Color(e.Status),
+		Pretext: fmt.Sprintf("Remote procedure **%s** invoked by @%s", e.RPC, userName),
+	}
+	switch e.Status {
+	case execution.StatusRunning:
+		a.Pretext = fmt.Sprintf("RPC **%s** invoked by @%s, currently running...", e.RPC, userName)
+	case execution.StatusFailed:
+		a.Fields = []AttachmentField{{Title: "Error Message", Value: chat.Output(e)}}
+	default:
+		a.Fields = []AttachmentField{{Title: "Result", Value: chat.Output(e)}}
+	}
+	a.Fallback = a.Pretext
+	return a
+}
+
+func (b *Bot) run(rpc arpicee.RemoteCall, args []arpicee.Argument, userID, userName, channelID string) {
+	var post Post
+	err := b.core.Run(context.Background(), rpc, args, User(userID), func(e execution.Execution) {
+		p := Post{
+			ID:        post.ID,
+			ChannelID: channelID,
+			Props:     PostProps{Attachments: []Attachment{attachment(e, userName)}},
+		}
+
+		var err error
+		if post.ID == "" {
+			post, err = b.client.CreatePost(p)
+		} else {
+			_, err = b.client.UpdatePost(p)
+		}
+		if err != nil {
+			log.Printf("RPC %s invoked by %s: error posting to Mattermost: %s", rpc.Name(), userName, err)
+		}
+	})
+
+	var forbidden *execution.ForbiddenError
+	if errors.As(err, &forbidden) {
+		log.Printf("RPC %s invoked by %s: %s", rpc.Name(), userName, err)
+		return
+	}
+	if err != nil {
+		log.Printf("failed invoking RPC %s: %s", rpc.Name(), err)
+	}
+}
@@ -0,0 +1,233 @@
+package mattermost
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/chat"
+	"github.com/yannh/arpicee/pkg/execution"
+	"github.com/yannh/arpicee/pkg/mock"
+	"github.com/yannh/arpicee/pkg/policy"
+	"github.com/yannh/arpicee/pkg/registry/registrytest"
+)
+
+// fakeMattermost is a local stand-in for the Mattermost API, recording dialogs and posts
+type fakeMattermost struct {
+	mu      sync.Mutex
+	dialogs []openDialogRequest
+	posts   map[string]Post
+}
+
+func (f *fakeMattermost) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if r.Header.Get("Authorization") != "Bearer bot-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"message": "invalid token"}`))
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v4/actions/dialogs/open":
+		var req openDialogRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		f.dialogs = append(f.dialogs, req)
+		w.Write([]byte(`{}`))
+	case r.Method == http.MethodPost && r.URL.Path == "/api/v4/posts":
+		var p Post
+		json.NewDecoder(r.Body).Decode(&p)
+		p.ID = "post1"
+		f.posts[p.ID] = p
+		json.NewEncoder(w).Encode(p)
+	case r.Method == http.MethodPut && strings.HasSuffix(r.URL.Path, "/patch"):
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v4/posts/"), "/patch")
+		p, ok := f.posts[id]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var patch Post
+		json.NewDecoder(r.Body).Decode(&patch)
+		p.Message, p.Props = patch.Message, patch.Props
+		f.posts[id] = p
+		json.NewEncoder(w).Encode(p)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newTestBot(t *testing.T) (*fakeMattermost, *httptest.Server) {
+	fake := &fakeMattermost{posts: map[string]Post{}}
+	api := httptest.NewServer(fake)
+	t.Cleanup(api.Close)
+
+	reg := registrytest.New(t,
+		mock.New("deploy", []arpicee.Parameter{
+			{Name: "env", Type: arpicee.TypeString, Required: true, AllowedValues: []string{"staging", "production"}},
+			{Name: "dryrun", Type: arpicee.TypeBool},
+		}, nil),
+		mock.New("restricted", nil, nil),
+		mock.New("destroy", nil, nil).WithMetadata(arpicee.Metadata{Confirm: arpicee.ConfirmName}),
+	)
+	mgr := execution.NewManager(10)
+	p, err := policy.New([]policy.Rule{{RPC: "restricted", Users: []string{"admin"}}})
+	if err != nil {
+		t.Fatalf("failed creating policy: %s", err)
+	}
+	mgr.SetAuthorizer(p)
+
+	b, err := New(NewClient(api.URL, "bot-token"), chat.NewCore(reg, mgr), "command-token", "https://arpicee.example.com")
+	if err != nil {
+		t.Fatalf("failed creating bot: %s", err)
+	}
+	mux := http.NewServeMux()
+	b.Register(mux)
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return fake, srv
+}
+
+// command runs the slash command as the user u1, named admin. Users are authorized by ID:
+// u1 is not allowed to run restricted.
+func command(srv *httptest.Server, token, text string) (*http.Response, string) {
+	resp, err := http.PostForm(srv.URL+CommandPath, url.Values{
+		"token":      {token},
+		"user_id":    {"u1"},
+		"user_name":  {"admin"},
+		"channel_id": {"c1"},
+		"trigger_id": {"t1"},
+		"text":       {text},
+	})
+	if err != nil {
+		return nil, err.Error()
+	}
+	defer resp.Body.Close()
+	var b bytes.Buffer
+	b.ReadFrom(resp.Body)
+	return resp, b.String()
+}
+
+func TestCommand(t *testing.T) {
+	fake, srv := newTestBot(t)
+
+	for i, testCase := range []struct {
+		token        string
+		text         string
+		expectStatus int
+		expectBody   string
+		expectDialog bool
+	}{
+		{"wrong-token", "deploy", http.StatusUnauthorized, "invalid token", false},
+		{"command-token", "", http.StatusOK, "- **deploy**", false},
+		{"command-token", "unknown", http.StatusOK, "Unknown remote procedure **unknown**", false},
+		{"command-token", "restricted", http.StatusOK, "You are not allowed to run **restricted**", false},
+		{"command-token", "deploy", http.StatusOK, "", true},
+	} {
+		fake.mu.Lock()
+		fake.dialogs = nil
+		fake.mu.Unlock()
+
+		resp, body := command(srv, testCase.token, testCase.text)
+		if resp == nil || resp.StatusCode != testCase.expectStatus {
+			t.Errorf("test %d - expected status %d, got %+v", i, testCase.expectStatus, resp)
+		}
+		if !strings.Contains(body, testCase.expectBody) {
+			t.Errorf("test %d - expected body to contain %q, got %s", i, testCase.expectBody, body)
+		}
+		fake.mu.Lock()
+		if got := len(fake.dialogs) > 0; got != testCase.expectDialog {
+			t.Errorf("test %d - expected dialog opened %t, got %t", i, testCase.expectDialog, got)
+		}
+		fake.mu.Unlock()
+	}
+}
+
+func TestDialogSubmission(t *testing.T) {
+	fake, srv := newTestBot(t)
+
+	if resp, body := command(srv, "command-token", "deploy"); resp == nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed opening dialog: %s", body)
+	}
+	fake.mu.Lock()
+	dialog := fake.dialogs[0]
+	fake.mu.Unlock()
+	if dialog.URL != "https://arpicee.example.com"+DialogPath || dialog.Dialog.CallbackID != "deploy" || len(dialog.Dialog.Elements) != 2 {
+		t.Fatalf("unexpected dialog: %+v", dialog)
+	}
+	if el := dialog.Dialog.Elements[1]; el.Name != "env" || el.Type != "select" || el.Optional {
+		t.Errorf("unexpected element for env: %+v", el)
+	}
+
+	submit := func(callbackID, state string, submission map[string]interface{}) (int, string) {
+		b, _ := json.Marshal(dialogSubmission{
+			Type:       "dialog_submission",
+			CallbackID: callbackID,
+			State:      state,
+			UserID:     "u1",
+			ChannelID:  "c1",
+			Submission: submission,
+		})
+		resp, err := http.Post(srv.URL+DialogPath, "application/json", bytes.NewReader(b))
+		if err != nil {
+			return 0, err.Error()
+		}
+		defer resp.Body.Close()
+		var body bytes.Buffer
+		body.ReadFrom(resp.Body)
+		return resp.StatusCode, body.String()
+	}
+
+	if status, _ := submit("deploy", "mallory:0000", map[string]interface{}{"env": "staging"}); status != http.StatusUnauthorized {
+		t.Errorf("expected forged submission to be refused, got status %d", status)
+	}
+	userName := strings.Split(dialog.Dialog.State, ":")[0]
+	stale := (&Bot{commandToken: "command-token"}).sign("deploy", "u1", userName, "c1", time.Now().Add(-time.Hour))
+	if status, _ := submit("deploy", stale, map[string]interface{}{"env": "staging"}); status != http.StatusUnauthorized {
+		t.Errorf("expected stale submission to be refused, got status %d", status)
+	}
+	if _, body := submit("deploy", dialog.Dialog.State, map[string]interface{}{"env": "dev"}); !strings.Contains(body, `"errors":{"env":"invalid value for parameter env`) {
+		t.Errorf("expected a field error for env, got %s", body)
+	}
+	if status, body := submit("deploy", dialog.Dialog.State, map[string]interface{}{"env": "staging", "dryrun": true}); status != http.StatusOK {
+		t.Fatalf("expected submission to succeed, got %d: %s", status, body)
+	}
+
+	// RPCs requiring confirmation have a confirmation field
+	if resp, body := command(srv, "command-token", "destroy"); resp == nil || resp.StatusCode != http.StatusOK {
+		t.Fatalf("failed opening dialog: %s", body)
+	}
+	fake.mu.Lock()
+	confirmDialog := fake.dialogs[1]
+	fake.mu.Unlock()
+	if len(confirmDialog.Dialog.Elements) != 1 || confirmDialog.Dialog.Elements[0].Name != chat.ConfirmField || confirmDialog.Dialog.Elements[0].Optional {
+		t.Fatalf("unexpected confirmation dialog: %+v", confirmDialog)
+	}
+	if _, body := submit("destroy", confirmDialog.Dialog.State, map[string]interface{}{chat.ConfirmField: "deploy"}); !strings.Contains(body, `"errors":{"arpicee_confirm":"type destroy to confirm"}`) {
+		t.Errorf("expected a confirmation error, got %s", body)
+	}
+
+	var post Post
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		fake.mu.Lock()
+		post = fake.posts["post1"]
+		fake.mu.Unlock()
+		if len(post.Props.Attachments) > 0 && len(post.Props.Attachments[0].Fields) > 0 {
+			break
+		}
+	}
+	if post.ChannelID != "c1" || len(post.Props.Attachments) != 1 {
+		t.Fatalf("expected the result to be posted in channel c1, got %+v", post)
+	}
+	a := post.Props.Attachments[0]
+	if a.Color != "#00CB53" || len(a.Fields) != 1 || a.Fields[0].Value != "Running deploy --env staging --dryrun=true" || !strings.Contains(a.Pretext, "@admin") {
+		t.Errorf("unexpected result attachment: %+v", a)
+	}
+}
@@ -0,0 +1,407 @@
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/execution"
+	"github.com/yannh/arpicee/pkg/httpapi"
+	"github.com/yannh/arpicee/pkg/policy"
+	"github.com/yannh/arpicee/pkg/registry"
+)
+
+// Server implements the Model Context Protocol, exposing every allowed RPC as a tool.
+// Agents can not confirm running RPCs: the RPCs requiring confirmation are not exposed.
+type Server struct {
+	registry   *registry.Registry
+	executions *execution.Manager
+	allowlist  []string
+
+	mu            sync.Mutex
+	running       map[runningCall]string // Execution ID of the running tool calls
+	confirmations *policy.Confirmations
+	collisions    map[string]bool // RPCs not exposed as their tool name is taken, logged once
+}
+
+const (
+	errParse          = -32700
+	errInvalidRequest = -32600
+	errMethodNotFound = -32601
+	errInvalidParams  = -32602
+)
+
+var supportedProtocolVersions = []string{"2025-06-18", "2025-03-26", "2024-11-05"}
+
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// runningCall identifies a tool call: request IDs are chosen by the clients, and
+// only unique per user
+type runningCall struct {
+	user string
+	id   string
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type tool struct {
+	Name        string                 `json:"name"`
+	Title       string                 `json:"title,omitempty"`
+	Description string                 `json:"description,omitempty"`
+	InputSchema map[string]interface{} `json:"inputSchema"`
+}
+
+type content struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type toolResult struct {
+	Content           []content              `json:"content"`
+	StructuredContent map[string]interface{} `json:"structuredContent,omitempty"`
+	IsError           bool                   `json:"isError"`
+}
+
+// New returns an MCP server exposing the RPCs whose names match one of the glob
+// patterns in allowlist. With an empty allowlist, no RPC is exposed.
+func New(reg *registry.Registry, executions *execution.Manager, allowlist []string) (*Server, error) {
+	for _, pattern := range allowlist {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid allowlist pattern %s: %w", pattern, err)
+		}
+	}
+	return &Server{
+		registry:   reg,
+		executions: executions,
+		allowlist:  allowlist,
+		running:    map[runningCall]string{},
+		collisions: map[string]bool{},
+	}, nil
+}
+
+// ToolName returns the name of the tool for rpc; tool names are restricted to [a-zA-Z0-9_-]
+func ToolName(rpc arpicee.RemoteCall) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' {
+			return r
+		}
+		return '_'
+	}, rpc.Name())
+	if len(name) > 64 {
+		name = name[:64]
+	}
+	return name
+}
+
+// SetConfirmations sets the confirmations required before running RPCs, see
+// policy.Confirmations: the RPCs requiring one are not exposed
+func (s *Server) SetConfirmations(confirmations *policy.Confirmations) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.confirmations = confirmations
+}
+
+func (s *Server) allowed(rpc arpicee.RemoteCall) bool {
+	s.mu.Lock()
+	confirmations := s.confirmations
+	s.mu.Unlock()
+	if confirmations.Required(rpc) != arpicee.ConfirmNone {
+		return false
+	}
+	for _, pattern := range s.allowlist {
+		if ok, _ := path.Match(pattern, rpc.Name()); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// tools returns the allowed RPCs by tool name. RPCs whose tool name is already taken
+// by another RPC are skipped.
+func (s *Server) tools() map[string]arpicee.RemoteCall {
+	tools := map[string]arpicee.RemoteCall{}
+	for _, rpc := range s.registry.RPCs() {
+		if !s.allowed(rpc) {
+			continue
+		}
+		name := ToolName(rpc)
+		if other, ok := tools[name]; ok {
+			s.mu.Lock()
+			if !s.collisions[rpc.Name()] {
+				log.Printf("RPC %s not exposed as an MCP tool: RPC %s has the tool name %s", rpc.Name(), other.Name(), name)
+				s.collisions[rpc.Name()] = true
+			}
+			s.mu.Unlock()
+			continue
+		}
+		tools[name] = rpc
+	}
+	return tools
+}
+
+func (s *Server) listTools() interface{} {
+	res := []tool{}
+	for name, rpc := range s.tools() {
+		// Agents are told about the risk and deprecation of the tools
+		description := rpc.Description()
+		if summary := arpicee.MetadataOf(rpc).Summary(); len(summary) > 0 {
+			description = strings.TrimSpace(description + "\n\n" + strings.Join(summary, "\n"))
+		}
+		res = append(res, tool{
+			Name:        name,
+			Title:       rpc.Name(),
+			Description: description,
+			InputSchema: arpicee.JSONSchema(rpc.Params()),
+		})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return map[string]interface{}{"tools": res}
+}
+
+func errorResult(err error) toolResult {
+	return toolResult{
+		Content: []content{{Type: "text", Text: err.Error()}},
+		IsError: true,
+	}
+}
+
+func (s *Server) callTool(ctx context.Context, id json.RawMessage, user string, params json.RawMessage) (interface{}, *rpcError) {
+	var p struct {
+		Name      string                 `json:"name"`
+		Arguments map[string]interface{} `json:"arguments"`
+	}
+	dec := json.NewDecoder(strings.NewReader(string(params)))
+	dec.UseNumber()
+	if err := dec.Decode(&p); err != nil {
+		return nil, &rpcError{Code: errInvalidParams, Message: err.Error()}
+	}
+	rpc, ok := s.tools()[p.Name]
+	if !ok {
+		return nil, &rpcError{Code: errInvalidParams, Message: fmt.Sprintf("unknown tool %s", p.Name)}
+	}
+
+	// Validation errors are returned as tool errors, so the model can correct its call
+	args, err := arpicee.ArgsFromMap(rpc.Params(), p.Arguments)
+	if err != nil {
+		return errorResult(err), nil
+	}
+	e, err := s.executions.Start(rpc, args, user)
+	if err != nil {
+		return errorResult(err), nil
+	}
+	log.Printf("RPC %s invoked by %s through MCP, execution %s", rpc.Name(), user, e.ID)
+
+	call := runningCall{user: user, id: string(id)}
+	s.mu.Lock()
+	s.running[call] = e.ID
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.running, call)
+		s.mu.Unlock()
+	}()
+
+	execID := e.ID
+	e, err = s.executions.Wait(ctx, execID)
+	if err != nil {
+		s.executions.Cancel(execID)
+		return errorResult(err), nil
+	}
+
+	switch e.Status {
+	case execution.StatusFailed:
+		return errorResult(errors.New(e.Error)), nil
+	case execution.StatusCancelled:
+		return errorResult(fmt.Errorf("execution %s was cancelled", e.ID)), nil
+	}
+
+	summary, err := arpicee.Output(e.Result, "text")
+	if err != nil {
+		summary, _ = arpicee.Output(e.Result, "json")
+	}
+	res := toolResult{
+		Content:           []content{{Type: "text", Text: summary}},
+		StructuredContent: map[string]interface{}{},
+	}
+	for k, v := range e.Result {
+		if k != "formatString" {
+			res.StructuredContent[k] = v
+		}
+	}
+	return res, nil
+}
+
+// cancelled cancels the tool call of user with the request ID of the notification
+func (s *Server) cancelled(user string, params json.RawMessage) {
+	var p struct {
+		RequestID json.RawMessage `json:"requestId"`
+	}
+	if err := json.Unmarshal(params, &p); err != nil {
+		return
+	}
+	s.mu.Lock()
+	id, ok := s.running[runningCall{user: user, id: string(p.RequestID)}]
+	s.mu.Unlock()
+	if ok {
+		s.executions.Cancel(id)
+	}
+}
+
+func initializeResult(params json.RawMessage) interface{} {
+	var p struct {
+		ProtocolVersion string `json:"protocolVersion"`
+	}
+	json.Unmarshal(params, &p)
+	version := supportedProtocolVersions[0]
+	for _, v := range supportedProtocolVersions {
+		if v == p.ProtocolVersion {
+			version = v
+		}
+	}
+	return map[string]interface{}{
+		"protocolVersion": version,
+		"capabilities": map[string]interface{}{
+			"tools": map[string]interface{}{"listChanged": false},
+		},
+		"serverInfo": map[string]interface{}{
+			"name":    "arpicee",
+			"version": "1",
+		},
+		"instructions": "Each tool runs an operational job discovered by Arpicee. Jobs may have side effects.",
+	}
+}
+
+// Handle processes a single JSON-RPC message and returns the response, or
+// nil if the message is a notification.
+func (s *Server) Handle(ctx context.Context, user string, msg []byte) []byte {
+	var req request
+	if err := json.Unmarshal(msg, &req); err != nil {
+		return marshal(response{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{Code: errParse, Message: err.Error()}})
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return marshal(response{JSONRPC: "2.0", ID: orNull(req.ID), Error: &rpcError{Code: errInvalidRequest, Message: "invalid JSON-RPC request"}})
+	}
+
+	// Notifications do not get a response
+	if len(req.ID) == 0 {
+		if req.Method == "notifications/cancelled" {
+			s.cancelled(user, req.Params)
+		}
+		return nil
+	}
+
+	resp := response{JSONRPC: "2.0", ID: req.ID}
+	switch req.Method {
+	case "initialize":
+		resp.Result = initializeResult(req.Params)
+	case "ping":
+		resp.Result = map[string]interface{}{}
+	case "tools/list":
+		resp.Result = s.listTools()
+	case "tools/call":
+		resp.Result, resp.Error = s.callTool(ctx, req.ID, user, req.Params)
+	default:
+		resp.Error = &rpcError{Code: errMethodNotFound, Message: fmt.Sprintf("method %s not found", req.Method)}
+	}
+	return marshal(resp)
+}
+
+func orNull(id json.RawMessage) json.RawMessage {
+	if len(id) == 0 {
+		return json.RawMessage("null")
+	}
+	return id
+}
+
+func marshal(resp response) []byte {
+	b, err := json.Marshal(resp)
+	if err != nil {
+		b, _ = json.Marshal(response{JSONRPC: "2.0", ID: orNull(resp.ID), Error: &rpcError{Code: -32603, Message: err.Error()}})
+	}
+	return b
+}
+
+// ServeStdio reads newline-delimited JSON-RPC messages from in and writes responses
+// to out. Requests are processed concurrently, so that long running tools can be cancelled.
+func (s *Server) ServeStdio(ctx context.Context, in io.Reader, out io.Writer, user string) error {
+	var wmu sync.Mutex
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := append([]byte{}, scanner.Bytes()...)
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if resp := s.Handle(ctx, user, line); resp != nil {
+				wmu.Lock()
+				defer wmu.Unlock()
+				out.Write(append(resp, '\n'))
+			}
+		}()
+	}
+	return scanner.Err()
+}
+
+// HTTPHandler serves MCP over HTTP: every POST contains a single JSON-RPC message,
+// the response is returned in the body. Clients authenticate with a bearer token.
+func (s *Server) HTTPHandler(tokens []httpapi.Token) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := httpapi.BearerUser(tokens, r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "missing or invalid bearer token", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, 16*1024*1024))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp := s.Handle(r.Context(), user, body)
+		if resp == nil {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(resp)
+	})
+}
@@ -0,0 +1,203 @@
+package mcp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/execution"
+	"github.com/yannh/arpicee/pkg/httpapi"
+	"github.com/yannh/arpicee/pkg/mock"
+	"github.com/yannh/arpicee/pkg/policy"
+	"github.com/yannh/arpicee/pkg/registry/registrytest"
+)
+
+func newTestServer(t *testing.T) *Server {
+	reg := registrytest.New(t,
+		mock.New("deploy app", []arpicee.Parameter{
+			{Name: "env", Type: arpicee.TypeString, Required: true},
+		}, nil),
+		// deploy_app has the same tool name as deploy app, and is not exposed
+		mock.New("deploy_app", nil, nil),
+		mock.New("restricted", nil, nil),
+		mock.New("failing", nil, func(ctx context.Context, args []arpicee.Argument) (map[string]interface{}, error) {
+			return nil, fmt.Errorf("something went wrong")
+		}),
+		mock.New("hidden", nil, nil),
+		mock.New("deploy-all", nil, nil).WithMetadata(arpicee.Metadata{Risk: arpicee.RiskHigh}),
+	)
+
+	mgr := execution.NewManager(10)
+	p, err := policy.New([]policy.Rule{{RPC: "restricted", Users: []string{"admin", "token:ops"}}})
+	if err != nil {
+		t.Fatalf("failed creating policy: %s", err)
+	}
+	mgr.SetAuthorizer(p)
+
+	s, err := New(reg, mgr, []string{"deploy*", "restricted", "failing"})
+	if err != nil {
+		t.Fatalf("failed creating MCP server: %s", err)
+	}
+	return s
+}
+
+func TestHandle(t *testing.T) {
+	s := newTestServer(t)
+
+	for i, testCase := range []struct {
+		msg    string
+		expect []string
+	}{
+		{
+			`{"jsonrpc": "2.0", "id": 1, "method": "initialize", "params": {"protocolVersion": "2025-03-26"}}`,
+			[]string{`"protocolVersion":"2025-03-26"`, `"tools":{`},
+		},
+		{
+			`{"jsonrpc": "2.0", "id": 2, "method": "tools/list"}`,
+			[]string{`"name":"deploy_app"`, `"required":["env"]`, `"name":"restricted"`},
+		},
+		{
+			`{"jsonrpc": "2.0", "id": 3, "method": "tools/call", "params": {"name": "deploy_app", "arguments": {"env": "staging"}}}`,
+			[]string{`"text":"Running deploy app --env staging"`, `"structuredContent":{"output":"Running deploy app --env staging"}`, `"isError":false`},
+		},
+		{
+			`{"jsonrpc": "2.0", "id": 4, "method": "tools/call", "params": {"name": "deploy_app", "arguments": {}}}`,
+			[]string{`"text":"parameter env is required"`, `"isError":true`},
+		},
+		{
+			`{"jsonrpc": "2.0", "id": 5, "method": "tools/call", "params": {"name": "restricted", "arguments": {}}}`,
+			[]string{`"text":"user assistant is not allowed to run restricted"`, `"isError":true`},
+		},
+		{
+			`{"jsonrpc": "2.0", "id": 6, "method": "tools/call", "params": {"name": "failing", "arguments": {}}}`,
+			[]string{`"text":"something went wrong"`, `"isError":true`},
+		},
+		{
+			`{"jsonrpc": "2.0", "id": 7, "method": "tools/call", "params": {"name": "hidden", "arguments": {}}}`,
+			[]string{`"code":-32602`, `unknown tool hidden`},
+		},
+		{
+			// RPCs requiring confirmation are not exposed
+			`{"jsonrpc": "2.0", "id": 9, "method": "tools/call", "params": {"name": "deploy-all", "arguments": {}}}`,
+			[]string{`"code":-32602`, `unknown tool deploy-all`},
+		},
+		{
+			`{"jsonrpc": "2.0", "id": 8, "method": "resources/list"}`,
+			[]string{`"code":-32601`},
+		},
+		{
+			`not json`,
+			[]string{`"code":-32700`},
+		},
+	} {
+		resp := string(s.Handle(context.Background(), "assistant", []byte(testCase.msg)))
+		for _, e := range testCase.expect {
+			if !strings.Contains(resp, e) {
+				t.Errorf("test %d - expected response to contain %s, got %s", i, e, resp)
+			}
+		}
+	}
+
+	if resp := string(s.Handle(context.Background(), "assistant", []byte(`{"jsonrpc": "2.0", "id": 10, "method": "tools/list"}`))); strings.Count(resp, `"name":"deploy_app"`) != 1 {
+		t.Errorf("expected the tool deploy_app to be listed once, got %s", resp)
+	}
+
+	if resp := s.Handle(context.Background(), "assistant", []byte(`{"jsonrpc": "2.0", "method": "notifications/initialized"}`)); resp != nil {
+		t.Errorf("expected no response to a notification, got %s", resp)
+	}
+}
+
+func TestCancelled(t *testing.T) {
+	reg := registrytest.New(t, mock.New("wait", nil, func(ctx context.Context, args []arpicee.Argument) (map[string]interface{}, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}))
+	mgr := execution.NewManager(10)
+	s, err := New(reg, mgr, []string{"wait"})
+	if err != nil {
+		t.Fatalf("failed creating MCP server: %s", err)
+	}
+
+	done := make(chan []byte)
+	go func() {
+		done <- s.Handle(context.Background(), "alice", []byte(`{"jsonrpc": "2.0", "id": 1, "method": "tools/call", "params": {"name": "wait"}}`))
+	}()
+	for {
+		s.mu.Lock()
+		n := len(s.running)
+		s.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel := []byte(`{"jsonrpc": "2.0", "method": "notifications/cancelled", "params": {"requestId": 1}}`)
+	s.Handle(context.Background(), "bob", cancel)
+	if e := mgr.List()[0]; e.Status != execution.StatusRunning {
+		t.Errorf("expected the tool call of another user not to be cancelled, got %s", e.Status)
+	}
+	s.Handle(context.Background(), "alice", cancel)
+	select {
+	case resp := <-done:
+		if !strings.Contains(string(resp), "was cancelled") {
+			t.Errorf("expected the tool call to be cancelled, got %s", resp)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for the tool call to be cancelled")
+	}
+}
+
+func TestServeStdio(t *testing.T) {
+	s := newTestServer(t)
+	in := strings.NewReader(`{"jsonrpc": "2.0", "id": 1, "method": "ping"}
+{"jsonrpc": "2.0", "method": "notifications/initialized"}
+`)
+	var out bytes.Buffer
+	if err := s.ServeStdio(context.Background(), in, &out, "assistant"); err != nil {
+		t.Fatalf("failed serving: %s", err)
+	}
+	var resp response
+	if err := json.Unmarshal(out.Bytes(), &resp); err != nil {
+		t.Fatalf("expected a single response, got %s", out.String())
+	}
+	if string(resp.ID) != "1" || resp.Error != nil {
+		t.Errorf("unexpected response %s", out.String())
+	}
+}
+
+func TestHTTPHandler(t *testing.T) {
+	h := newTestServer(t).HTTPHandler([]httpapi.Token{{Name: "ops", Token: "secret"}})
+
+	for i, testCase := range []struct {
+		token        string
+		body         string
+		expectStatus int
+		expectBody   string
+	}{
+		{"", `{"jsonrpc": "2.0", "id": 1, "method": "ping"}`, http.StatusUnauthorized, ""},
+		{"secret", `{"jsonrpc": "2.0", "id": 1, "method": "ping"}`, http.StatusOK, `"result":{}`},
+		{"secret", `{"jsonrpc": "2.0", "method": "notifications/initialized"}`, http.StatusAccepted, ""},
+		{"secret", `{"jsonrpc": "2.0", "id": 2, "method": "tools/call", "params": {"name": "restricted"}}`, http.StatusOK, `"isError":false`},
+	} {
+		req := httptest.NewRequest("POST", "/mcp", strings.NewReader(testCase.body))
+		if testCase.token != "" {
+			req.Header.Set("Authorization", "Bearer "+testCase.token)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		if w.Code != testCase.expectStatus {
+			t.Errorf("test %d - expected status %d, got %d", i, testCase.expectStatus, w.Code)
+		}
+		if !strings.Contains(w.Body.String(), testCase.expectBody) {
+			t.Errorf("test %d - expected body to contain %s, got %s", i, testCase.expectBody, w.Body.String())
+		}
+	}
+}
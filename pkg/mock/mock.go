@@ -1,38 +1,97 @@
 package mock
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/yannh/arpicee/pkg/arpicee"
 )
 
+// Mock is a RemoteCall that can be used to test frontends
 type Mock struct {
-	name string
+	name        string
+	description string
+	params      []arpicee.Parameter
+	metadata    arpicee.Metadata
+	channels    []string
+	run         func(ctx context.Context, args []arpicee.Argument) (map[string]interface{}, error)
 }
 
 func Discover() []*Mock {
 	return []*Mock{}
 }
 
-func New(name string) *Mock {
-	return &Mock{name: name}
+// New returns a Mock RPC; when run is nil, running the RPC returns
+// the command line it would have been invoked with
+func New(name string, params []arpicee.Parameter, run func(ctx context.Context, args []arpicee.Argument) (map[string]interface{}, error)) *Mock {
+	return &Mock{
+		name:        name,
+		description: fmt.Sprintf("Mock RPC %s", name),
+		params:      params,
+		run:         run,
+	}
+}
+
+func (m *Mock) Name() string {
+	return m.name
+}
+
+func (m *Mock) Description() string {
+	return m.description
+}
+
+func (m *Mock) Params() []arpicee.Parameter {
+	return m.params
+}
+
+func (m *Mock) Provider() string {
+	return "mock"
+}
+
+func (m *Mock) Metadata() arpicee.Metadata {
+	return m.metadata
 }
 
-func (m *Mock) Run(args []arpicee.Argument) error {
+// WithMetadata sets the metadata of the RPC, and returns it
+func (m *Mock) WithMetadata(metadata arpicee.Metadata) *Mock {
+	m.metadata = metadata
+	return m
+}
+
+// Channels returns the chat channels the RPC is restricted to, see arpicee.ChannelRestricted
+func (m *Mock) Channels() []string {
+	return m.channels
+}
+
+// WithChannels restricts the RPC to the chat channels channels, and returns it
+func (m *Mock) WithChannels(channels ...string) *Mock {
+	m.channels = channels
+	return m
+}
+
+func (m *Mock) Run(args []arpicee.Argument) (map[string]interface{}, error) {
+	return m.RunContext(context.Background(), args)
+}
+
+func (m *Mock) RunContext(ctx context.Context, args []arpicee.Argument) (map[string]interface{}, error) {
+	if m.run != nil {
+		return m.run(ctx, args)
+	}
+
 	output := fmt.Sprintf("Running %s", m.name)
 	for _, arg := range args {
 		switch arg := arg.(type) {
 		case *arpicee.ArgumentString:
 			output = fmt.Sprintf("%s --%s %s", output, arg.Name, arg.Val)
-			// if arg.Val {
-			//	output = fmt.Sprintf("%s --%s true", output, arg.Name)
-			// } else {
-			//	output = fmt.Sprintf("%s --%s false", output, arg.Name)
-			// }
+		case *arpicee.ArgumentInt:
+			output = fmt.Sprintf("%s --%s %d", output, arg.Name, arg.Val)
+		case *arpicee.ArgumentBool:
+			output = fmt.Sprintf("%s --%s=%t", output, arg.Name, arg.Val)
 		}
 	}
 
-	fmt.Println(output)
-
-	return nil
+	return map[string]interface{}{
+		"output":       output,
+		"formatString": "{{ .output }}",
+	}, nil
 }
@@ -0,0 +1,230 @@
+package override
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+)
+
+// Definition derives the RPC Name from the discovered RPC named RPC. Description
+// defaults to the description of RPC.
+//
+// Pin hides parameters, and runs RPC with the given values. Defaults changes the
+// default values of parameters. AllowedValues restricts the values of parameters;
+// values that RPC does not allow are ignored.
+//
+// Channels restricts the chat channels, by ID, the RPC is listed in and can be run
+// from; GitHub repositories are channels named owner/repo. When Replace is set, RPC
+// is not listed in these channels, or in any channel if Channels is empty.
+//
+// Channels and Replace only apply to chat frontends: RPC can still be run from the
+// HTTP and gRPC APIs, the web UI, MCP, webhooks and the scheduler. Overrides are not
+// access control, restrict who can run RPC with access rules.
+type Definition struct {
+	Name          string
+	RPC           string
+	Description   string
+	Pin           map[string]string
+	Defaults      map[string]string
+	AllowedValues map[string][]string
+	Channels      []string
+	Replace       bool
+}
+
+// Override is a RemoteCall running the RPC it derives from, see Definition. The RPC
+// is looked up on every call, so that it can be discovered again.
+type Override struct {
+	d      Definition
+	lookup func(name string) arpicee.RemoteCall
+}
+
+func New(d Definition, lookup func(name string) arpicee.RemoteCall) (*Override, error) {
+	if d.Name == "" {
+		return nil, fmt.Errorf("missing override name")
+	}
+	if d.RPC == "" {
+		return nil, fmt.Errorf("override %s: missing RPC", d.Name)
+	}
+	if d.RPC == d.Name {
+		return nil, fmt.Errorf("override %s: must be named differently from the RPC it derives from", d.Name)
+	}
+	for name := range d.Pin {
+		if _, ok := d.Defaults[name]; ok {
+			return nil, fmt.Errorf("override %s: parameter %s can not be pinned and have a default", d.Name, name)
+		}
+		if _, ok := d.AllowedValues[name]; ok {
+			return nil, fmt.Errorf("override %s: parameter %s can not be pinned and have allowed values", d.Name, name)
+		}
+	}
+	return &Override{d: d, lookup: lookup}, nil
+}
+
+func (o *Override) Name() string {
+	return o.d.Name
+}
+
+func (o *Override) Description() string {
+	if o.d.Description != "" {
+		return o.d.Description
+	}
+	if base := o.lookup(o.d.RPC); base != nil {
+		return base.Description()
+	}
+	return ""
+}
+
+// restrict returns the values of allowed that are also in base, or allowed if base is empty
+func restrict(allowed, base []string) []string {
+	if len(base) == 0 {
+		return allowed
+	}
+	in := map[string]bool{}
+	for _, v := range base {
+		in[v] = true
+	}
+	res := []string{}
+	for _, v := range allowed {
+		if in[v] {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+// Params returns the parameters of the RPC it derives from, without the pinned ones
+func (o *Override) Params() []arpicee.Parameter {
+	base := o.lookup(o.d.RPC)
+	if base == nil {
+		return nil
+	}
+	return o.params(base)
+}
+
+func (o *Override) params(base arpicee.RemoteCall) []arpicee.Parameter {
+	var params []arpicee.Parameter
+	for _, p := range base.Params() {
+		if _, ok := o.d.Pin[p.Name]; ok {
+			continue
+		}
+		if v, ok := o.d.Defaults[p.Name]; ok {
+			p.Default = v
+		}
+		if v, ok := o.d.AllowedValues[p.Name]; ok {
+			p.AllowedValues = restrict(v, p.AllowedValues)
+		}
+		params = append(params, p)
+	}
+	return params
+}
+
+func (o *Override) Provider() string {
+	if base := o.lookup(o.d.RPC); base != nil {
+		return arpicee.Provider(base)
+	}
+	return "other"
+}
+
+// Metadata returns the metadata of the RPC it derives from
+func (o *Override) Metadata() arpicee.Metadata {
+	if base := o.lookup(o.d.RPC); base != nil {
+		return arpicee.MetadataOf(base)
+	}
+	return arpicee.Metadata{}
+}
+
+func (o *Override) Channels() []string {
+	return o.d.Channels
+}
+
+// Replaces returns the name of the RPC hidden in the channels of the override, if
+// Replace is set
+func (o *Override) Replaces() string {
+	if o.d.Replace {
+		return o.d.RPC
+	}
+	return ""
+}
+
+// args returns the arguments of the RPC it derives from: args, validated against the
+// parameters of the override, the pinned values, and the defaults of the override
+// for the parameters not set
+func (o *Override) args(base arpicee.RemoteCall, args []arpicee.Argument) ([]arpicee.Argument, error) {
+	if err := arpicee.ValidateArguments(args, o.params(base)); err != nil {
+		return nil, err
+	}
+
+	var res []arpicee.Argument
+	for _, p := range base.Params() {
+		v, ok := o.d.Pin[p.Name]
+		if !ok {
+			if arg := arpicee.GetArg(args, p.Name); arg != nil {
+				res = append(res, arg)
+				continue
+			}
+			// Frontends do not always set the arguments to their default value
+			if v, ok = o.d.Defaults[p.Name]; !ok {
+				continue
+			}
+		}
+		arg, err := arpicee.ParseArgument(p, v)
+		if err != nil {
+			return nil, fmt.Errorf("override %s: %w", o.d.Name, err)
+		}
+		res = append(res, arg)
+	}
+
+	var names []string
+	for name := range o.d.Pin {
+		if arpicee.GetArg(res, name) == nil {
+			names = append(names, name)
+		}
+	}
+	if len(names) > 0 {
+		sort.Strings(names)
+		return nil, fmt.Errorf("override %s: %s has no parameter %s", o.d.Name, o.d.RPC, strings.Join(names, ", "))
+	}
+	if err := arpicee.ValidateArguments(res, base.Params()); err != nil {
+		return nil, fmt.Errorf("override %s: %w", o.d.Name, err)
+	}
+	return res, nil
+}
+
+func (o *Override) base() (arpicee.RemoteCall, error) {
+	base := o.lookup(o.d.RPC)
+	if base == nil {
+		return nil, fmt.Errorf("override %s: remote procedure %s not found", o.d.Name, o.d.RPC)
+	}
+	return base, nil
+}
+
+func (o *Override) Run(args []arpicee.Argument) (map[string]interface{}, error) {
+	return o.RunContext(context.Background(), args)
+}
+
+func (o *Override) RunContext(ctx context.Context, args []arpicee.Argument) (map[string]interface{}, error) {
+	base, err := o.base()
+	if err != nil {
+		return nil, err
+	}
+	baseArgs, err := o.args(base, args)
+	if err != nil {
+		return nil, err
+	}
+	return arpicee.Run(ctx, base, baseArgs)
+}
+
+// Resume resumes an execution of the RPC it derives from, if it is resumable
+func (o *Override) Resume(ctx context.Context, externalID string) (map[string]interface{}, error) {
+	base, err := o.base()
+	if err != nil {
+		return nil, err
+	}
+	r, ok := base.(arpicee.ResumableRemoteCall)
+	if !ok {
+		return nil, fmt.Errorf("override %s: %s can not be resumed", o.d.Name, o.d.RPC)
+	}
+	return r.Resume(ctx, externalID)
+}
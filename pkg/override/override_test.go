@@ -0,0 +1,118 @@
+package override
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/mock"
+)
+
+// deploy records the arguments it was last invoked with
+type deploy struct {
+	args map[string]interface{}
+}
+
+func (d *deploy) lookup(name string) arpicee.RemoteCall {
+	if name != "deploy" {
+		return nil
+	}
+	return mock.New("deploy", []arpicee.Parameter{
+		{Name: "service", Type: arpicee.TypeString, Required: true, AllowedValues: []string{"api", "web", "worker"}},
+		{Name: "environment", Type: arpicee.TypeString, Required: true, AllowedValues: []string{"staging", "production"}},
+		{Name: "replicas", Type: arpicee.TypeInt, Default: "1"},
+	}, func(ctx context.Context, args []arpicee.Argument) (map[string]interface{}, error) {
+		d.args = arpicee.ArgsToMap(args)
+		return map[string]interface{}{"formatString": "deployed"}, nil
+	})
+}
+
+func TestNew(t *testing.T) {
+	for i, testCase := range []struct {
+		d         Definition
+		expectErr string
+	}{
+		{Definition{Name: "deploy-staging", RPC: "deploy"}, ""},
+		{Definition{RPC: "deploy"}, "missing override name"},
+		{Definition{Name: "deploy-staging"}, "override deploy-staging: missing RPC"},
+		{Definition{Name: "deploy", RPC: "deploy"}, "override deploy: must be named differently from the RPC it derives from"},
+		{
+			Definition{Name: "deploy-staging", RPC: "deploy", Pin: map[string]string{"environment": "staging"}, Defaults: map[string]string{"environment": "staging"}},
+			"override deploy-staging: parameter environment can not be pinned and have a default",
+		},
+	} {
+		_, err := New(testCase.d, nil)
+		if (err == nil && testCase.expectErr != "") || (err != nil && err.Error() != testCase.expectErr) {
+			t.Errorf("test %d - expected error %q, got %v", i, testCase.expectErr, err)
+		}
+	}
+}
+
+func TestOverride(t *testing.T) {
+	d := &deploy{}
+	o, err := New(Definition{
+		Name:          "deploy-staging",
+		RPC:           "deploy",
+		Pin:           map[string]string{"environment": "staging"},
+		Defaults:      map[string]string{"replicas": "2"},
+		AllowedValues: map[string][]string{"service": {"api", "web", "unknown"}},
+		Channels:      []string{"C123"},
+		Replace:       true,
+	}, d.lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectParams := []arpicee.Parameter{
+		{Name: "service", Type: arpicee.TypeString, Required: true, AllowedValues: []string{"api", "web"}},
+		{Name: "replicas", Type: arpicee.TypeInt, Default: "2"},
+	}
+	if !reflect.DeepEqual(o.Params(), expectParams) {
+		t.Errorf("expected params %+v, got %+v", expectParams, o.Params())
+	}
+	if o.Provider() != "mock" || o.Replaces() != "deploy" || !arpicee.AvailableIn(o, "C123") || arpicee.AvailableIn(o, "C456") {
+		t.Errorf("unexpected provider %s, replaced RPC %s, or channels %v", o.Provider(), o.Replaces(), o.Channels())
+	}
+
+	for i, testCase := range []struct {
+		args       []arpicee.Argument
+		expectArgs map[string]interface{}
+		expectErr  string
+	}{
+		{
+			[]arpicee.Argument{&arpicee.ArgumentString{Name: "service", Val: "api"}},
+			map[string]interface{}{"service": "api", "environment": "staging", "replicas": 2},
+			"",
+		},
+		{
+			// Pinned values can not be changed
+			[]arpicee.Argument{&arpicee.ArgumentString{Name: "service", Val: "web"}, &arpicee.ArgumentString{Name: "environment", Val: "production"}, &arpicee.ArgumentInt{Name: "replicas", Val: 3}},
+			map[string]interface{}{"service": "web", "environment": "staging", "replicas": 3},
+			"",
+		},
+		{
+			[]arpicee.Argument{&arpicee.ArgumentString{Name: "service", Val: "worker"}},
+			nil,
+			"invalid value for parameter service, allowed values: api, web",
+		},
+	} {
+		d.args = nil
+		_, err := o.Run(testCase.args)
+		if (err == nil && testCase.expectErr != "") || (err != nil && err.Error() != testCase.expectErr) {
+			t.Errorf("test %d - expected error %q, got %v", i, testCase.expectErr, err)
+		}
+		if !reflect.DeepEqual(d.args, testCase.expectArgs) {
+			t.Errorf("test %d - expected deploy to run with %v, got %v", i, testCase.expectArgs, d.args)
+		}
+	}
+
+	missing, _ := New(Definition{Name: "deploy-staging", RPC: "deploy", Pin: map[string]string{"region": "eu-west-1"}}, d.lookup)
+	if _, err := missing.Run([]arpicee.Argument{&arpicee.ArgumentString{Name: "service", Val: "api"}, &arpicee.ArgumentString{Name: "environment", Val: "staging"}}); err == nil || err.Error() != "override deploy-staging: deploy has no parameter region" {
+		t.Errorf("expected an error for the unknown parameter, got %v", err)
+	}
+	gone, _ := New(Definition{Name: "rollback-staging", RPC: "rollback"}, d.lookup)
+	if _, err := gone.Run(nil); err == nil || err.Error() != "override rollback-staging: remote procedure rollback not found" {
+		t.Errorf("expected an error for the missing RPC, got %v", err)
+	}
+}
@@ -0,0 +1,362 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+)
+
+// Step runs RPC with Arguments. Arguments and If are templates, evaluated with the
+// arguments of the pipeline as .args and the results of the previous steps as
+// .steps.NAME, for example "{{ .steps.scale_down.replicas }}". Arguments rendered
+// empty are omitted. The step is skipped if If renders empty, "false" or "0".
+// Compensate runs if a later step fails, to undo the step; its templates can use
+// .error, the error of the failed step, and is named NAME_compensation by default.
+type Step struct {
+	Name       string
+	RPC        string
+	Arguments  map[string]string
+	If         string
+	Compensate *Step
+}
+
+// Definition defines a pipeline. Output is an optional template rendered with the
+// same data as the steps once they all succeeded.
+type Definition struct {
+	Name        string
+	Description string
+	Params      []arpicee.ParamDeclaration
+	Steps       []Step
+	Output      string
+}
+
+// step is a Step with its templates parsed
+type step struct {
+	Step
+	arguments  map[string]*template.Template
+	cond       *template.Template
+	compensate *step
+}
+
+// Pipeline is a RemoteCall running the steps of a Definition one after the other
+type Pipeline struct {
+	name        string
+	description string
+	params      []arpicee.Parameter
+	steps       []*step
+	output      *template.Template
+	lookup      func(name string) arpicee.RemoteCall
+}
+
+// Step names are used in templates, and must be valid template field names
+var validStepName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+func parseTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Option("missingkey=error").Parse(text)
+}
+
+func compileStep(s Step) (*step, error) {
+	if !validStepName.MatchString(s.Name) {
+		return nil, fmt.Errorf("invalid step name %q, expected letters, digits and underscores", s.Name)
+	}
+	if s.RPC == "" {
+		return nil, fmt.Errorf("step %s: missing RPC", s.Name)
+	}
+	c := &step{Step: s, arguments: map[string]*template.Template{}}
+	for param, text := range s.Arguments {
+		t, err := parseTemplate(param, text)
+		if err != nil {
+			return nil, fmt.Errorf("step %s: invalid template for argument %s: %w", s.Name, param, err)
+		}
+		c.arguments[param] = t
+	}
+	if s.If != "" {
+		t, err := parseTemplate("if", s.If)
+		if err != nil {
+			return nil, fmt.Errorf("step %s: invalid condition: %w", s.Name, err)
+		}
+		c.cond = t
+	}
+	if s.Compensate != nil {
+		if s.Compensate.Compensate != nil {
+			return nil, fmt.Errorf("step %s: compensation steps can not be compensated", s.Name)
+		}
+		comp := *s.Compensate
+		if comp.Name == "" {
+			comp.Name = s.Name + "_compensation"
+		}
+		compensate, err := compileStep(comp)
+		if err != nil {
+			return nil, fmt.Errorf("step %s: compensation: %w", s.Name, err)
+		}
+		c.compensate = compensate
+	}
+	return c, nil
+}
+
+// New returns the pipeline defined by d. The RPCs of the steps are resolved with lookup
+// when the pipeline runs, so that they can be rediscovered in the meantime.
+func New(d Definition, lookup func(name string) arpicee.RemoteCall) (*Pipeline, error) {
+	if d.Name == "" {
+		return nil, fmt.Errorf("missing pipeline name")
+	}
+	if len(d.Steps) == 0 {
+		return nil, fmt.Errorf("pipeline %s: no steps", d.Name)
+	}
+
+	p := &Pipeline{name: d.Name, description: d.Description, lookup: lookup}
+	for _, dp := range d.Params {
+		param, err := dp.Parameter()
+		if err != nil {
+			return nil, fmt.Errorf("pipeline %s: parameter %s: %w", d.Name, dp.Name, err)
+		}
+		p.params = append(p.params, param)
+	}
+
+	names := map[string]bool{}
+	for _, s := range d.Steps {
+		if names[s.Name] {
+			return nil, fmt.Errorf("pipeline %s: duplicate step %s", d.Name, s.Name)
+		}
+		names[s.Name] = true
+		c, err := compileStep(s)
+		if err != nil {
+			return nil, fmt.Errorf("pipeline %s: %w", d.Name, err)
+		}
+		if c.RPC == d.Name || (c.compensate != nil && c.compensate.RPC == d.Name) {
+			return nil, fmt.Errorf("pipeline %s: step %s can not run the pipeline itself", d.Name, s.Name)
+		}
+		p.steps = append(p.steps, c)
+	}
+
+	if d.Output != "" {
+		t, err := parseTemplate("output", d.Output)
+		if err != nil {
+			return nil, fmt.Errorf("pipeline %s: invalid output template: %w", d.Name, err)
+		}
+		p.output = t
+	}
+	return p, nil
+}
+
+func (p *Pipeline) Name() string {
+	return p.name
+}
+
+func (p *Pipeline) Description() string {
+	if p.description != "" {
+		return p.description
+	}
+	var rpcs []string
+	for _, s := range p.steps {
+		rpcs = append(rpcs, s.RPC)
+	}
+	return "Runs " + strings.Join(rpcs, ", ")
+}
+
+func (p *Pipeline) Params() []arpicee.Parameter {
+	return p.params
+}
+
+func (p *Pipeline) Provider() string {
+	return "pipeline"
+}
+
+func (p *Pipeline) Run(args []arpicee.Argument) (map[string]interface{}, error) {
+	return p.RunContext(context.Background(), args)
+}
+
+func render(t *template.Template, data map[string]interface{}) (string, error) {
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// args renders the arguments of s, and converts them to the types of the
+// parameters of rpc
+func (s *step) args(rpc arpicee.RemoteCall, data map[string]interface{}) ([]arpicee.Argument, error) {
+	names := make([]string, 0, len(s.arguments))
+	for name := range s.arguments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var args []arpicee.Argument
+	for _, name := range names {
+		var param *arpicee.Parameter
+		params := rpc.Params()
+		for i := range params {
+			if params[i].Name == name {
+				param = &params[i]
+			}
+		}
+		if param == nil {
+			return nil, fmt.Errorf("unknown parameter %s", name)
+		}
+		v, err := render(s.arguments[name], data)
+		if err != nil {
+			return nil, fmt.Errorf("failed rendering argument %s: %w", name, err)
+		}
+		if v == "" {
+			continue
+		}
+		arg, err := arpicee.ParseArgument(*param, v)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	return args, arpicee.ValidateArguments(args, rpc.Params())
+}
+
+// run runs s if the user running the pipeline is allowed to run its RPC, reporting the progress of its RPC prefixed with the name of the step
+func (s *step) run(ctx context.Context, lookup func(string) arpicee.RemoteCall, data map[string]interface{}) (map[string]interface{}, error) {
+	rpc := lookup(s.RPC)
+	if rpc == nil {
+		return nil, fmt.Errorf("no RPC named %s", s.RPC)
+	}
+	if err := arpicee.Authorize(ctx, rpc); err != nil {
+		return nil, err
+	}
+	args, err := s.args(rpc, data)
+	if err != nil {
+		return nil, err
+	}
+	sctx := arpicee.WithProgress(ctx, func(msg string) {
+		arpicee.ReportProgress(ctx, fmt.Sprintf("%s: %s", s.Name, msg))
+	})
+	return arpicee.Run(sctx, rpc, args)
+}
+
+// skip evaluates the condition of s
+func (s *step) skip(data map[string]interface{}) (bool, error) {
+	if s.cond == nil {
+		return false, nil
+	}
+	v, err := render(s.cond, data)
+	if err != nil {
+		return false, fmt.Errorf("failed evaluating condition: %w", err)
+	}
+	return v == "" || v == "false" || v == "0", nil
+}
+
+// argsData returns the arguments of the pipeline for use in templates, with the
+// defaults or zero values of the parameters not passed, so that templates can refer
+// to optional parameters
+func (p *Pipeline) argsData(args []arpicee.Argument) map[string]interface{} {
+	m := arpicee.ArgsToMap(args)
+	for _, param := range p.params {
+		if _, ok := m[param.Name]; ok {
+			continue
+		}
+		if param.Default != "" {
+			if arg, err := arpicee.ParseArgument(param, param.Default); err == nil {
+				for k, v := range arpicee.ArgsToMap([]arpicee.Argument{arg}) {
+					m[k] = v
+				}
+				continue
+			}
+		}
+		switch param.Type {
+		case arpicee.TypeBool:
+			m[param.Name] = false
+		case arpicee.TypeInt:
+			m[param.Name] = 0
+		default:
+			m[param.Name] = ""
+		}
+	}
+	return m
+}
+
+// RunContext runs the steps in order. If a step fails, the compensation steps of the
+// steps that succeeded run in reverse order, and the pipeline fails.
+func (p *Pipeline) RunContext(ctx context.Context, args []arpicee.Argument) (map[string]interface{}, error) {
+	results := map[string]interface{}{}
+	data := map[string]interface{}{
+		"args":  p.argsData(args),
+		"steps": results,
+	}
+	var report []string
+	var done []*step
+
+	for i, s := range p.steps {
+		skip, err := s.skip(data)
+		if err == nil && skip {
+			arpicee.ReportProgress(ctx, fmt.Sprintf("step %d/%d %s: skipped", i+1, len(p.steps), s.Name))
+			report = append(report, fmt.Sprintf("%s: skipped", s.Name))
+			continue
+		}
+		if err == nil {
+			arpicee.ReportProgress(ctx, fmt.Sprintf("step %d/%d %s: running %s", i+1, len(p.steps), s.Name, s.RPC))
+			var res map[string]interface{}
+			if res, err = s.run(ctx, p.lookup, data); err == nil {
+				results[s.Name] = res
+				report = append(report, fmt.Sprintf("%s: succeeded", s.Name))
+				done = append(done, s)
+				continue
+			}
+		}
+
+		err = fmt.Errorf("step %s failed: %w", s.Name, err)
+		arpicee.ReportProgress(ctx, err.Error())
+		report = append(report, fmt.Sprintf("%s: failed, %s", s.Name, err))
+		report = append(report, p.compensate(ctx, done, data, err)...)
+		return result(report, ""), err
+	}
+
+	var out string
+	if p.output != nil {
+		var err error
+		if out, err = render(p.output, data); err != nil {
+			return result(report, ""), fmt.Errorf("failed rendering output: %w", err)
+		}
+	}
+	return result(report, out), nil
+}
+
+// compensate runs the compensation steps of done in reverse order. They run even if
+// ctx was cancelled, as they are meant to restore a consistent state.
+func (p *Pipeline) compensate(ctx context.Context, done []*step, data map[string]interface{}, stepErr error) []string {
+	data["error"] = stepErr.Error()
+	progress := func(msg string) { arpicee.ReportProgress(ctx, msg) }
+	cctx := arpicee.WithAuthorizer(context.Background(), func(rpc arpicee.RemoteCall) error {
+		return arpicee.Authorize(ctx, rpc)
+	})
+	cctx = arpicee.WithProgress(cctx, progress)
+
+	var report []string
+	for i := len(done) - 1; i >= 0; i-- {
+		c := done[i].compensate
+		if c == nil {
+			continue
+		}
+		progress(fmt.Sprintf("compensating step %s: running %s", done[i].Name, c.RPC))
+		if _, err := c.run(cctx, p.lookup, data); err != nil {
+			progress(fmt.Sprintf("compensation %s failed: %s", c.Name, err))
+			report = append(report, fmt.Sprintf("%s (compensation): failed, %s", c.Name, err))
+			continue
+		}
+		report = append(report, fmt.Sprintf("%s (compensation): succeeded", c.Name))
+	}
+	return report
+}
+
+func result(report []string, output string) map[string]interface{} {
+	if output == "" {
+		output = strings.Join(report, "\n")
+	}
+	return map[string]interface{}{
+		"steps":        report,
+		"output":       output,
+		"formatString": "{{ .output }}",
+	}
+}
@@ -0,0 +1,201 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/mock"
+)
+
+// recorder returns RPCs recording their invocations; "migrate" fails when passed
+// fail=true, and "scale" returns the previous number of replicas
+type recorder struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (r *recorder) lookup(name string) arpicee.RemoteCall {
+	params := map[string][]arpicee.Parameter{
+		"scale": {
+			{Name: "service", Type: arpicee.TypeString, Required: true},
+			{Name: "replicas", Type: arpicee.TypeInt, Required: true},
+		},
+		"migrate": {
+			{Name: "version", Type: arpicee.TypeString},
+			{Name: "fail", Type: arpicee.TypeBool},
+		},
+	}
+	if _, ok := params[name]; !ok {
+		return nil
+	}
+	return mock.New(name, params[name], func(ctx context.Context, args []arpicee.Argument) (map[string]interface{}, error) {
+		m := arpicee.ArgsToMap(args)
+		var kv []string
+		for _, p := range params[name] {
+			if v, ok := m[p.Name]; ok {
+				kv = append(kv, fmt.Sprintf("%s=%v", p.Name, v))
+			}
+		}
+		r.mu.Lock()
+		r.calls = append(r.calls, name+" "+strings.Join(kv, " "))
+		r.mu.Unlock()
+
+		arpicee.ReportProgress(ctx, "working")
+		if m["fail"] == true {
+			return nil, fmt.Errorf("migration failed")
+		}
+		return map[string]interface{}{"previousReplicas": 3}, nil
+	})
+}
+
+var deploy = Definition{
+	Name: "deploy",
+	Params: []arpicee.ParamDeclaration{
+		{Name: "version", Required: true},
+		{Name: "fail", Type: "bool"},
+		{Name: "migrate", Type: "bool", Default: "true"},
+	},
+	Steps: []Step{
+		{
+			Name:      "scale_down",
+			RPC:       "scale",
+			Arguments: map[string]string{"service": "api", "replicas": "0"},
+			Compensate: &Step{
+				RPC:       "scale",
+				Arguments: map[string]string{"service": "api", "replicas": "{{ .steps.scale_down.previousReplicas }}"},
+			},
+		},
+		{
+			Name:      "migrate",
+			RPC:       "migrate",
+			If:        "{{ .args.migrate }}",
+			Arguments: map[string]string{"version": "{{ .args.version }}", "fail": "{{ .args.fail }}"},
+		},
+		{
+			Name:      "scale_up",
+			RPC:       "scale",
+			Arguments: map[string]string{"service": "api", "replicas": "{{ .steps.scale_down.previousReplicas }}"},
+		},
+	},
+	Output: "deployed {{ .args.version }}",
+}
+
+func TestRunContext(t *testing.T) {
+	for i, testCase := range []struct {
+		args           []arpicee.Argument
+		expectCalls    []string
+		expectErr      string
+		expectOutput   string
+		expectProgress string
+	}{
+		{
+			[]arpicee.Argument{&arpicee.ArgumentString{Name: "version", Val: "v2"}},
+			[]string{"scale service=api replicas=0", "migrate version=v2 fail=false", "scale service=api replicas=3"},
+			"",
+			"deployed v2",
+			"step 2/3 migrate: running migrate\nmigrate: working",
+		},
+		{
+			[]arpicee.Argument{&arpicee.ArgumentString{Name: "version", Val: "v2"}, &arpicee.ArgumentBool{Name: "migrate", Val: false}},
+			[]string{"scale service=api replicas=0", "scale service=api replicas=3"},
+			"",
+			"deployed v2",
+			"step 2/3 migrate: skipped",
+		},
+		{
+			[]arpicee.Argument{&arpicee.ArgumentString{Name: "version", Val: "v2"}, &arpicee.ArgumentBool{Name: "fail", Val: true}},
+			[]string{"scale service=api replicas=0", "migrate version=v2 fail=true", "scale service=api replicas=3"},
+			"step migrate failed: migration failed",
+			"scale_down: succeeded\nmigrate: failed, step migrate failed: migration failed\nscale_down_compensation (compensation): succeeded",
+			"compensating step scale_down: running scale",
+		},
+	} {
+		r := &recorder{}
+		p, err := New(deploy, r.lookup)
+		if err != nil {
+			t.Fatalf("test %d - failed creating pipeline: %s", i, err)
+		}
+
+		var progress []string
+		ctx := arpicee.WithProgress(context.Background(), func(msg string) {
+			progress = append(progress, msg)
+		})
+		res, err := p.RunContext(ctx, testCase.args)
+		if testCase.expectErr == "" && err != nil {
+			t.Errorf("test %d - unexpected error: %s", i, err)
+		}
+		if testCase.expectErr != "" && (err == nil || err.Error() != testCase.expectErr) {
+			t.Errorf("test %d - expected error %s, got %v", i, testCase.expectErr, err)
+		}
+		if !reflect.DeepEqual(r.calls, testCase.expectCalls) {
+			t.Errorf("test %d - expected calls %q, got %q", i, testCase.expectCalls, r.calls)
+		}
+		if out, _ := arpicee.Output(res, "text"); out != testCase.expectOutput {
+			t.Errorf("test %d - expected output %q, got %q", i, testCase.expectOutput, out)
+		}
+		if !strings.Contains(strings.Join(progress, "\n"), testCase.expectProgress) {
+			t.Errorf("test %d - expected progress to contain %q, got %q", i, testCase.expectProgress, progress)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	for i, testCase := range []struct {
+		d         Definition
+		expectErr string
+	}{
+		{Definition{Name: "p"}, "no steps"},
+		{Definition{Name: "p", Steps: []Step{{Name: "scale-down", RPC: "scale"}}}, "invalid step name"},
+		{Definition{Name: "p", Steps: []Step{{Name: "a", RPC: "scale"}, {Name: "a", RPC: "scale"}}}, "duplicate step a"},
+		{Definition{Name: "p", Steps: []Step{{Name: "a", RPC: "p"}}}, "can not run the pipeline itself"},
+		{Definition{Name: "p", Steps: []Step{{Name: "a", RPC: "scale", If: "{{ .args.x "}}}, "invalid condition"},
+		{Definition{Name: "p", Params: []arpicee.ParamDeclaration{{Name: "x", Type: "float"}}, Steps: []Step{{Name: "a", RPC: "scale"}}}, "invalid type float"},
+		{Definition{Name: "p", Steps: []Step{{Name: "a", RPC: "scale", Compensate: &Step{RPC: "scale", Compensate: &Step{RPC: "scale"}}}}}, "can not be compensated"},
+		{deploy, ""},
+	} {
+		_, err := New(testCase.d, (&recorder{}).lookup)
+		if testCase.expectErr == "" && err != nil {
+			t.Errorf("test %d - unexpected error: %s", i, err)
+		}
+		if testCase.expectErr != "" && (err == nil || !strings.Contains(err.Error(), testCase.expectErr)) {
+			t.Errorf("test %d - expected error containing %s, got %v", i, testCase.expectErr, err)
+		}
+	}
+}
+
+func TestUnknownRPC(t *testing.T) {
+	p, err := New(Definition{Name: "p", Steps: []Step{{Name: "a", RPC: "unknown"}}}, (&recorder{}).lookup)
+	if err != nil {
+		t.Fatalf("failed creating pipeline: %s", err)
+	}
+	if _, err := p.Run(nil); err == nil || err.Error() != "step a failed: no RPC named unknown" {
+		t.Errorf("expected unknown RPC error, got %v", err)
+	}
+}
+
+func TestAuthorizeSteps(t *testing.T) {
+	r := &recorder{}
+	p, err := New(deploy, r.lookup)
+	if err != nil {
+		t.Fatalf("failed creating pipeline: %s", err)
+	}
+	ctx := arpicee.WithAuthorizer(context.Background(), func(rpc arpicee.RemoteCall) error {
+		if rpc.Name() == "migrate" {
+			return fmt.Errorf("not allowed to run %s", rpc.Name())
+		}
+		return nil
+	})
+
+	_, err = p.RunContext(ctx, []arpicee.Argument{&arpicee.ArgumentString{Name: "version", Val: "v2"}})
+	if err == nil || err.Error() != "step migrate failed: not allowed to run migrate" {
+		t.Errorf("expected the migrate step to be refused, got %v", err)
+	}
+	if expect := []string{"scale service=api replicas=0", "scale service=api replicas=3"}; !reflect.DeepEqual(r.calls, expect) {
+		t.Errorf("expected calls %q, got %q", expect, r.calls)
+	}
+}
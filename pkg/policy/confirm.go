@@ -0,0 +1,51 @@
+package policy
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+)
+
+// ConfirmRule requires the confirmation Confirm before running the RPCs matching the
+// glob pattern RPC
+type ConfirmRule struct {
+	RPC     string
+	Confirm arpicee.Confirmation
+}
+
+// Confirmations decide the confirmation required before running RPCs from interactive
+// frontends. The first rule matching an RPC wins, so that rules can also lift the
+// confirmation of an RPC; RPCs matched by no rule require the confirmation set by their
+// metadata, see arpicee.Metadata.Confirmation.
+type Confirmations struct {
+	rules []ConfirmRule
+}
+
+func NewConfirmations(rules []ConfirmRule) (*Confirmations, error) {
+	for _, r := range rules {
+		if _, err := path.Match(r.RPC, ""); err != nil {
+			return nil, fmt.Errorf("invalid RPC pattern %s: %w", r.RPC, err)
+		}
+		if r.Confirm == "" {
+			return nil, fmt.Errorf("missing confirmation for RPC pattern %s", r.RPC)
+		}
+		if err := r.Confirm.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return &Confirmations{rules: rules}, nil
+}
+
+// Required returns the confirmation required before running rpc. A nil Confirmations
+// only uses the metadata of rpc.
+func (c *Confirmations) Required(rpc arpicee.RemoteCall) arpicee.Confirmation {
+	if c != nil {
+		for _, r := range c.rules {
+			if ok, _ := path.Match(r.RPC, rpc.Name()); ok {
+				return r.Confirm
+			}
+		}
+	}
+	return arpicee.MetadataOf(rpc).Confirmation()
+}
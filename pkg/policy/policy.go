@@ -0,0 +1,50 @@
+package policy
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+)
+
+// Rule restricts the RPCs matching the glob pattern RPC to the listed users. Users are
+// qualified by frontend, such as "discord:" + user ID, see the User function of every
+// frontend, so that users of different frontends are not mistaken for each other.
+type Rule struct {
+	RPC   string
+	Users []string
+}
+
+// Policy authorizes users to run RPCs. RPCs not matched by any rule can be
+// run by anyone; when several rules match, being listed in one is enough.
+type Policy struct {
+	rules []Rule
+}
+
+func New(rules []Rule) (*Policy, error) {
+	for _, r := range rules {
+		if _, err := path.Match(r.RPC, ""); err != nil {
+			return nil, fmt.Errorf("invalid RPC pattern %s: %w", r.RPC, err)
+		}
+	}
+	return &Policy{rules: rules}, nil
+}
+
+func (p *Policy) Authorize(user string, rpc arpicee.RemoteCall) error {
+	matched := false
+	for _, r := range p.rules {
+		if ok, _ := path.Match(r.RPC, rpc.Name()); !ok {
+			continue
+		}
+		matched = true
+		for _, u := range r.Users {
+			if u == user || u == "*" {
+				return nil
+			}
+		}
+	}
+	if matched {
+		return fmt.Errorf("user %s is not allowed to run %s", user, rpc.Name())
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package policy
+
+import (
+	"testing"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/mock"
+)
+
+func TestAuthorize(t *testing.T) {
+	p, err := New([]Rule{
+		{RPC: "deploy-*", Users: []string{"alice"}},
+		{RPC: "deploy-staging", Users: []string{"bob"}},
+		{RPC: "public", Users: []string{"*"}},
+	})
+	if err != nil {
+		t.Fatalf("failed creating policy: %s", err)
+	}
+
+	for i, testCase := range []struct {
+		user    string
+		rpc     string
+		allowed bool
+	}{
+		{"alice", "deploy-production", true},
+		{"bob", "deploy-production", false},
+		{"bob", "deploy-staging", true},
+		{"carol", "public", true},
+		{"carol", "unrestricted", true},
+	} {
+		err := p.Authorize(testCase.user, mock.New(testCase.rpc, nil, nil))
+		if (err == nil) != testCase.allowed {
+			t.Errorf("test %d - expected %s allowed to run %s: %t, got %s", i, testCase.user, testCase.rpc, testCase.allowed, err)
+		}
+	}
+
+	if _, err := New([]Rule{{RPC: "[", Users: []string{"alice"}}}); err == nil {
+		t.Errorf("expected an error for an invalid pattern")
+	}
+}
+
+func TestConfirmations(t *testing.T) {
+	c, err := NewConfirmations([]ConfirmRule{
+		{RPC: "deploy-production", Confirm: arpicee.ConfirmName},
+		{RPC: "deploy-*", Confirm: arpicee.ConfirmArguments},
+		{RPC: "restart", Confirm: arpicee.ConfirmNone},
+	})
+	if err != nil {
+		t.Fatalf("failed creating confirmations: %s", err)
+	}
+
+	for i, testCase := range []struct {
+		c      *Confirmations
+		rpc    arpicee.RemoteCall
+		expect arpicee.Confirmation
+	}{
+		{c, mock.New("deploy-production", nil, nil), arpicee.ConfirmName},
+		{c, mock.New("deploy-staging", nil, nil), arpicee.ConfirmArguments},
+		{c, mock.New("restart", nil, nil).WithMetadata(arpicee.Metadata{Risk: arpicee.RiskHigh}), arpicee.ConfirmNone},
+		{c, mock.New("rollback", nil, nil).WithMetadata(arpicee.Metadata{Risk: arpicee.RiskHigh}), arpicee.ConfirmArguments},
+		{c, mock.New("refund", nil, nil).WithMetadata(arpicee.Metadata{Confirm: arpicee.ConfirmName}), arpicee.ConfirmName},
+		{c, mock.New("status", nil, nil), arpicee.ConfirmNone},
+		{nil, mock.New("deploy-production", nil, nil), arpicee.ConfirmNone},
+	} {
+		if got := testCase.c.Required(testCase.rpc); got != testCase.expect {
+			t.Errorf("test %d - expected confirmation %s for %s, got %s", i, testCase.expect, testCase.rpc.Name(), got)
+		}
+	}
+
+	for _, rules := range [][]ConfirmRule{
+		{{RPC: "[", Confirm: arpicee.ConfirmName}},
+		{{RPC: "deploy", Confirm: "twice"}},
+		{{RPC: "deploy"}},
+	} {
+		if _, err := NewConfirmations(rules); err == nil {
+			t.Errorf("expected an error for the rules %+v", rules)
+		}
+	}
+}
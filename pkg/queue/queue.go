@@ -0,0 +1,347 @@
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/execution"
+	"github.com/yannh/arpicee/pkg/registry"
+)
+
+// DefaultWorkers is the number of jobs run in parallel by default
+const DefaultWorkers = 4
+
+// ErrInterrupted is the error of the jobs that were running when the server stopped,
+// and that can not be resumed
+var ErrInterrupted = errors.New("interrupted by a restart of the server, the remote procedure may or may not have completed")
+
+type State string
+
+const (
+	StateQueued  State = "queued"
+	StateRunning State = "running"
+)
+
+// Job is an RPC invocation waiting in the queue or running. Frontends use Meta to
+// keep track of the messages reporting on the job, for example to update them once
+// the job is finished, even after a restart.
+type Job struct {
+	ID         string                 `json:"id"`
+	RPC        string                 `json:"rpc"`
+	Arguments  map[string]interface{} `json:"arguments"`
+	User       string                 `json:"user"`
+	State      State                  `json:"state"`
+	ExternalID string                 `json:"externalId,omitempty"`
+	Meta       map[string]string      `json:"meta,omitempty"`
+	EnqueuedAt time.Time              `json:"enqueuedAt"`
+}
+
+func (j Job) copy() Job {
+	meta := map[string]string{}
+	for k, v := range j.Meta {
+		meta[k] = v
+	}
+	j.Meta = meta
+	return j
+}
+
+// Listener is called when the execution of a job starts and when it finishes
+type Listener func(j Job, e execution.Execution)
+
+// Queue runs jobs with a pool of workers. Jobs are persisted in a Store until they
+// finish: on restart, queued jobs are run, and running jobs are resumed if their RPC
+// supports it, or reported as failed otherwise.
+type Queue struct {
+	registry   *registry.Registry
+	executions *execution.Manager
+	store      Store
+	workers    int
+
+	mu        sync.Mutex
+	jobs      map[string]*Job
+	pending   []string
+	wake      chan struct{}
+	listeners []Listener
+}
+
+// New returns a Queue running up to workers jobs in parallel, with the jobs left in
+// store by a previous run queued again
+func New(reg *registry.Registry, executions *execution.Manager, store Store, workers int) (*Queue, error) {
+	if workers <= 0 {
+		workers = DefaultWorkers
+	}
+	q := &Queue{
+		registry:   reg,
+		executions: executions,
+		store:      store,
+		workers:    workers,
+		jobs:       map[string]*Job{},
+		wake:       make(chan struct{}, 1),
+	}
+
+	jobs, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	for i := range jobs {
+		j := jobs[i].copy()
+		log.Printf("recovered %s job %s of RPC %s", j.State, j.ID, j.RPC)
+		q.jobs[j.ID] = &j
+		q.pending = append(q.pending, j.ID)
+	}
+	return q, nil
+}
+
+// AddListener registers l to be called on the start and end of every job
+func (q *Queue) AddListener(l Listener) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.listeners = append(q.listeners, l)
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+func (q *Queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Submit checks user is authorized to run rpc, validates the arguments and queues
+// the job. meta is stored with the job, see Job.
+func (q *Queue) Submit(rpc arpicee.RemoteCall, args []arpicee.Argument, user string, meta map[string]string) (Job, error) {
+	if err := q.executions.Authorize(user, rpc); err != nil {
+		return Job{}, err
+	}
+	if err := arpicee.ValidateArguments(args, rpc.Params()); err != nil {
+		return Job{}, err
+	}
+
+	j := Job{
+		ID:         newID(),
+		RPC:        rpc.Name(),
+		Arguments:  arpicee.ArgsToMap(args),
+		User:       user,
+		State:      StateQueued,
+		Meta:       meta,
+		EnqueuedAt: time.Now(),
+	}.copy()
+	if err := q.store.Save(j); err != nil {
+		return Job{}, err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.jobs[j.ID] = &j
+	q.pending = append(q.pending, j.ID)
+	q.notify()
+	return j.copy(), nil
+}
+
+// SetMeta sets the metadata key of the job id to value
+func (q *Queue) SetMeta(id, key, value string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return fmt.Errorf("no job %s", id)
+	}
+	j.Meta[key] = value
+	return q.store.Save(*j)
+}
+
+// Jobs returns the queued and running jobs
+func (q *Queue) Jobs() []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	res := []Job{}
+	for _, j := range q.jobs {
+		res = append(res, j.copy())
+	}
+	return res
+}
+
+// update changes the job id with f, persists it, and returns a copy
+func (q *Queue) update(id string, f func(j *Job)) Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j := q.jobs[id]
+	f(j)
+	if err := q.store.Save(*j); err != nil {
+		log.Printf("job %s: %s", id, err)
+	}
+	return j.copy()
+}
+
+func (q *Queue) report(j Job, e execution.Execution) {
+	q.mu.Lock()
+	listeners := append([]Listener{}, q.listeners...)
+	q.mu.Unlock()
+	for _, l := range listeners {
+		l(j, e)
+	}
+}
+
+// failed returns an execution of j that failed with msg, for the jobs that could not start
+func failed(j Job, msg string) execution.Execution {
+	now := time.Now()
+	return execution.Execution{
+		RPC:        j.RPC,
+		User:       j.User,
+		Arguments:  j.Arguments,
+		Status:     execution.StatusFailed,
+		Error:      msg,
+		CreatedAt:  now,
+		FinishedAt: &now,
+	}
+}
+
+// resumed is a RemoteCall resuming the remote execution externalID of rpc
+type resumed struct {
+	arpicee.ResumableRemoteCall
+	externalID string
+}
+
+func (r resumed) Run(args []arpicee.Argument) (map[string]interface{}, error) {
+	return r.RunContext(context.Background(), args)
+}
+
+func (r resumed) RunContext(ctx context.Context, args []arpicee.Argument) (map[string]interface{}, error) {
+	arpicee.ReportExternalID(ctx, r.externalID)
+	arpicee.ReportProgress(ctx, fmt.Sprintf("resumed remote execution %s", r.externalID))
+	return r.Resume(ctx, r.externalID)
+}
+
+// start starts the execution of the job j; resume is set if the job was running
+// when the server stopped
+func (q *Queue) start(j Job, resume bool) (execution.Execution, error) {
+	rpc := q.registry.RPC(j.RPC)
+	if rpc == nil {
+		return execution.Execution{}, fmt.Errorf("no RPC named %s", j.RPC)
+	}
+	args, err := arpicee.ArgsFromMap(rpc.Params(), j.Arguments)
+	if err != nil {
+		return execution.Execution{}, err
+	}
+
+	if resume {
+		r, ok := rpc.(arpicee.ResumableRemoteCall)
+		if !ok || j.ExternalID == "" {
+			return execution.Execution{}, ErrInterrupted
+		}
+		log.Printf("job %s: resuming remote execution %s of RPC %s", j.ID, j.ExternalID, j.RPC)
+		rpc = resumed{ResumableRemoteCall: r, externalID: j.ExternalID}
+	}
+	return q.executions.Start(rpc, args, j.User)
+}
+
+// process runs the job id until it finishes
+func (q *Queue) process(id string) {
+	// The job is marked as running before it starts, so that it is never started
+	// twice if the server stops
+	var resume bool
+	j := q.update(id, func(j *Job) {
+		resume = j.State == StateRunning
+		j.State = StateRunning
+	})
+	e, err := q.start(j, resume)
+	if err != nil {
+		log.Printf("job %s of RPC %s failed: %s", id, j.RPC, err)
+		q.finish(j, failed(j, err.Error()))
+		return
+	}
+	q.report(j, e)
+
+	// Wait for the execution, persisting the ID of the remote execution once reported
+	for {
+		e, changed, err := q.executions.Watch(e.ID)
+		if err != nil {
+			q.finish(j, failed(j, err.Error()))
+			return
+		}
+		if e.ExternalID != j.ExternalID {
+			j = q.update(id, func(j *Job) { j.ExternalID = e.ExternalID })
+		}
+		if e.Done() {
+			q.finish(j, e)
+			return
+		}
+		<-changed
+	}
+}
+
+// finish reports the final execution of j, with the metadata set by listeners since
+// it started, and removes the job
+func (q *Queue) finish(j Job, e execution.Execution) {
+	q.mu.Lock()
+	if current, ok := q.jobs[j.ID]; ok {
+		j = current.copy()
+	}
+	q.mu.Unlock()
+	q.report(j, e)
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	delete(q.jobs, j.ID)
+	if err := q.store.Delete(j.ID); err != nil {
+		log.Printf("job %s: %s", j.ID, err)
+	}
+}
+
+// next returns the ID of the next pending job, waiting for one until ctx is done
+func (q *Queue) next(ctx context.Context) (string, bool) {
+	for {
+		q.mu.Lock()
+		if len(q.pending) > 0 {
+			id := q.pending[0]
+			q.pending = q.pending[1:]
+			if len(q.pending) > 0 {
+				q.notify()
+			}
+			q.mu.Unlock()
+			return id, true
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.wake:
+		case <-ctx.Done():
+			return "", false
+		}
+	}
+}
+
+// Run runs the jobs with the pool of workers until ctx is done. Running jobs are
+// not interrupted, and resumed on the next start if possible.
+func (q *Queue) Run(ctx context.Context) error {
+	var wg sync.WaitGroup
+	for i := 0; i < q.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				id, ok := q.next(ctx)
+				if !ok {
+					return
+				}
+				q.process(id)
+			}
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
@@ -0,0 +1,201 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/execution"
+	"github.com/yannh/arpicee/pkg/mock"
+	"github.com/yannh/arpicee/pkg/registry/registrytest"
+)
+
+// automation is a resumable RPC: its remote executions complete when release is closed
+type automation struct {
+	*mock.Mock
+	release chan struct{}
+}
+
+func (a *automation) RunContext(ctx context.Context, args []arpicee.Argument) (map[string]interface{}, error) {
+	arpicee.ReportExternalID(ctx, "exec-1")
+	return a.Resume(ctx, "exec-1")
+}
+
+func (a *automation) Resume(ctx context.Context, id string) (map[string]interface{}, error) {
+	<-a.release
+	return map[string]interface{}{"formatString": "completed " + id}, nil
+}
+
+// recorder records the executions reported to listeners, by job ID
+type recorder struct {
+	mu       sync.Mutex
+	reported map[string][]execution.Execution
+	meta     []map[string]string
+	finished chan string
+	// q, if set, has the metadata "ts" set when jobs start
+	q *Queue
+}
+
+func (r *recorder) listen(j Job, e execution.Execution) {
+	r.mu.Lock()
+	r.reported[j.ID] = append(r.reported[j.ID], e)
+	r.meta = append(r.meta, j.Meta)
+	q := r.q
+	r.mu.Unlock()
+	if q != nil && !e.Done() {
+		q.SetMeta(j.ID, "ts", "1")
+	}
+	if e.Done() {
+		r.finished <- j.ID
+	}
+}
+
+func (r *recorder) wait(t *testing.T, n int) {
+	for i := 0; i < n; i++ {
+		select {
+		case <-r.finished:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for jobs to finish")
+		}
+	}
+}
+
+func newTestQueue(t *testing.T, store Store, release chan struct{}) (*Queue, *recorder) {
+	reg := registrytest.New(t,
+		mock.New("restart", []arpicee.Parameter{{Name: "replicas", Type: arpicee.TypeInt, Required: true}}, nil),
+		&automation{Mock: mock.New("automation", nil, nil), release: release},
+	)
+	q, err := New(reg, execution.NewManager(100), store, 2)
+	if err != nil {
+		t.Fatalf("failed creating queue: %s", err)
+	}
+	r := &recorder{reported: map[string][]execution.Execution{}, finished: make(chan string, 10)}
+	q.AddListener(r.listen)
+	return q, r
+}
+
+func TestSubmit(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, r := newTestQueue(t, store, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx)
+
+	r.mu.Lock()
+	r.q = q
+	r.mu.Unlock()
+
+	rpc := q.registry.RPC("restart")
+	if _, err := q.Submit(rpc, nil, "U1", nil); err == nil {
+		t.Errorf("expected an error for a missing argument")
+	}
+	j, err := q.Submit(rpc, []arpicee.Argument{&arpicee.ArgumentInt{Name: "replicas", Val: 3}}, "U1", map[string]string{"channel": "C1"})
+	if err != nil {
+		t.Fatalf("failed submitting job: %s", err)
+	}
+	r.wait(t, 1)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	reported := r.reported[j.ID]
+	if len(reported) != 2 || reported[0].Status != execution.StatusRunning || reported[1].Status != execution.StatusSucceeded {
+		t.Errorf("expected a running and a succeeded execution, got %+v", reported)
+	}
+	if len(r.meta) != 2 || r.meta[1]["ts"] != "1" || r.meta[1]["channel"] != "C1" {
+		t.Errorf("expected the metadata set on start to be reported when the job finishes, got %+v", r.meta)
+	}
+	if out := reported[1].Result["output"]; out != "Running restart --replicas 3" {
+		t.Errorf("unexpected output %v", out)
+	}
+	if jobs, _ := store.List(); len(jobs) != 0 {
+		t.Errorf("expected finished jobs to be deleted, got %+v", jobs)
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	for _, j := range []Job{
+		{ID: "queued", RPC: "restart", Arguments: map[string]interface{}{"replicas": 2}, User: "U1", State: StateQueued, EnqueuedAt: now},
+		{ID: "interrupted", RPC: "restart", Arguments: map[string]interface{}{"replicas": 2}, User: "U1", State: StateRunning, EnqueuedAt: now},
+		{ID: "resumable", RPC: "automation", User: "U1", State: StateRunning, ExternalID: "exec-42", Meta: map[string]string{"ts": "123.456"}, EnqueuedAt: now},
+	} {
+		if err := store.Save(j); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	release := make(chan struct{})
+	close(release)
+	q, r := newTestQueue(t, store, release)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx)
+	r.wait(t, 3)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, testCase := range []struct {
+		id           string
+		expectStatus execution.Status
+		expectOutput string
+	}{
+		{"queued", execution.StatusSucceeded, "Running restart --replicas 2"},
+		{"interrupted", execution.StatusFailed, ErrInterrupted.Error()},
+		{"resumable", execution.StatusSucceeded, "completed exec-42"},
+	} {
+		reported := r.reported[testCase.id]
+		if len(reported) == 0 {
+			t.Errorf("test %d - no execution reported for job %s", i, testCase.id)
+			continue
+		}
+		e := reported[len(reported)-1]
+		out, _ := arpicee.Output(e.Result, "text")
+		if e.Status == execution.StatusFailed {
+			out = e.Error
+		}
+		if e.Status != testCase.expectStatus || out != testCase.expectOutput {
+			t.Errorf("test %d - expected %s with %q, got %s with %q", i, testCase.expectStatus, testCase.expectOutput, e.Status, out)
+		}
+	}
+	if jobs, _ := store.List(); len(jobs) != 0 {
+		t.Errorf("expected finished jobs to be deleted, got %+v", jobs)
+	}
+}
+
+func TestExternalIDPersisted(t *testing.T) {
+	store, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	q, r := newTestQueue(t, store, release)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx)
+
+	j, err := q.Submit(q.registry.RPC("automation"), nil, "U1", nil)
+	if err != nil {
+		t.Fatalf("failed submitting job: %s", err)
+	}
+	var stored []Job
+	for i := 0; i < 500; i++ {
+		if stored, _ = store.List(); len(stored) == 1 && stored[0].ExternalID != "" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(stored) != 1 || stored[0].ID != j.ID || stored[0].State != StateRunning || stored[0].ExternalID != "exec-1" {
+		t.Errorf("expected the running job to be stored with its external ID, got %+v", stored)
+	}
+	close(release)
+	r.wait(t, 1)
+}
@@ -0,0 +1,85 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/yannh/arpicee/pkg/atomicfile"
+)
+
+// Store persists the jobs of the queue until they are finished
+type Store interface {
+	Save(j Job) error
+	Delete(id string) error
+	List() ([]Job, error)
+}
+
+// FileStore stores every job in a JSON file of a directory. The arguments of the
+// jobs, including secret ones, are kept in the files until the jobs finish, so the
+// directory should only be readable by arpicee.
+type FileStore struct {
+	dir string
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed creating queue directory %s: %w", dir, err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// Save writes the job atomically, so a job is never left half written
+func (s *FileStore) Save(j Job) error {
+	b, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := atomicfile.WriteFile(s.path(j.ID), b, 0o600); err != nil {
+		return fmt.Errorf("failed saving job %s: %w", j.ID, err)
+	}
+	return nil
+}
+
+func (s *FileStore) Delete(id string) error {
+	if err := os.Remove(s.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed deleting job %s: %w", id, err)
+	}
+	return nil
+}
+
+// List returns the stored jobs, oldest first. Unreadable files are logged and skipped.
+func (s *FileStore) List() ([]Job, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed listing queue directory %s: %w", s.dir, err)
+	}
+	jobs := []Job{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(s.dir, entry.Name()))
+		if err != nil {
+			log.Printf("ignoring queued job %s: %s", entry.Name(), err)
+			continue
+		}
+		var j Job
+		if err := json.Unmarshal(b, &j); err != nil {
+			log.Printf("ignoring queued job %s: %s", entry.Name(), err)
+			continue
+		}
+		jobs = append(jobs, j)
+	}
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].EnqueuedAt.Before(jobs[k].EnqueuedAt) })
+	return jobs, nil
+}
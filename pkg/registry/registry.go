@@ -0,0 +1,307 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/cache"
+)
+
+// Registry holds the RPCs found by the discovery sources, shared by all frontends.
+// Sources are isolated from each other: when a source fails, the RPCs of its last
+// successful discovery are kept, see SourceStatus.
+type Registry struct {
+	mu        sync.RWMutex
+	rpcs      []arpicee.RemoteCall
+	sources   []*source
+	listeners []func(Diff)
+	cache     *cache.Cache
+	cacheTTL  time.Duration
+
+	// reloadMu serializes reloads, so that an older discovery never replaces a newer one
+	reloadMu sync.Mutex
+}
+
+// Diff lists the names of the RPCs added, removed, and changed by a reload. RPCs
+// change when their description or parameters change.
+type Diff struct {
+	Added   []string
+	Removed []string
+	Changed []string
+}
+
+func (d Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+func (d Diff) String() string {
+	var parts []string
+	for _, c := range []struct {
+		verb  string
+		names []string
+	}{{"added", d.Added}, {"removed", d.Removed}, {"changed", d.Changed}} {
+		if len(c.names) > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s (%s)", len(c.names), c.verb, strings.Join(c.names, ", ")))
+		}
+	}
+	if len(parts) == 0 {
+		return "no changes"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// diff returns the differences between the RPCs before and after, with sorted names
+func diff(before, after []arpicee.RemoteCall) Diff {
+	old := map[string]arpicee.RemoteCall{}
+	for _, rpc := range before {
+		old[rpc.Name()] = rpc
+	}
+	var d Diff
+	seen := map[string]bool{}
+	for _, rpc := range after {
+		name := rpc.Name()
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		prev, ok := old[name]
+		switch {
+		case !ok:
+			d.Added = append(d.Added, name)
+		case prev.Description() != rpc.Description() || !reflect.DeepEqual(prev.Params(), rpc.Params()):
+			d.Changed = append(d.Changed, name)
+		}
+	}
+	for name := range old {
+		if !seen[name] {
+			d.Removed = append(d.Removed, name)
+		}
+	}
+	sort.Strings(d.Added)
+	sort.Strings(d.Removed)
+	sort.Strings(d.Changed)
+	return d
+}
+
+func New() *Registry {
+	return &Registry{}
+}
+
+// AddDiscoveryFunction adds an unnamed discovery source
+func (r *Registry) AddDiscoveryFunction(f func() ([]arpicee.RemoteCall, error)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sources = append(r.sources, &source{Source: Source{Name: fmt.Sprintf("source %d", len(r.sources)+1), Discover: f}})
+}
+
+// SetSources replaces the discovery sources, for example when the configuration
+// changed, and discovers their RPCs. Sources keep the RPCs and status of the previous
+// source with the same name, so that a failing source keeps its last good RPCs.
+func (r *Registry) SetSources(sources []Source) error {
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+
+	r.mu.Lock()
+	previous := map[string]*source{}
+	for _, src := range r.sources {
+		previous[src.Name] = src
+	}
+	r.sources = nil
+	for _, s := range sources {
+		src := &source{Source: s}
+		if p, ok := previous[s.Name]; ok {
+			src.rpcs, src.status = p.rpcs, p.status
+		}
+		r.sources = append(r.sources, src)
+	}
+	r.mu.Unlock()
+	return r.reload()
+}
+
+// SetCache caches the RPCs of the cacheable sources in c after every discovery, see
+// Source. Restore uses the entries of c discovered less than ttl ago, or of any age if
+// ttl is 0.
+func (r *Registry) SetCache(c *cache.Cache, ttl time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.cache = c
+	r.cacheTTL = ttl
+}
+
+// Restore replaces the discovery sources without discovering them: the RPCs of the
+// sources are restored from the cache, so that they are available right away. It
+// returns the number of sources restored; the others have no RPCs until they are
+// discovered, see ReloadMissing.
+func (r *Registry) Restore(sources []Source) int {
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+
+	r.mu.Lock()
+	n := 0
+	r.sources = nil
+	var rpcs []arpicee.RemoteCall
+	for _, s := range sources {
+		src := &source{Source: s}
+		if r.cache != nil && src.restore(r.cache, r.cacheTTL) {
+			n++
+		}
+		r.sources = append(r.sources, src)
+		rpcs = append(rpcs, src.rpcs...)
+	}
+	r.mu.Unlock()
+
+	r.swap(rpcs)
+	return n
+}
+
+// OnChange registers f to be called with the differences every time a reload changes
+// the RPCs
+func (r *Registry) OnChange(f func(Diff)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.listeners = append(r.listeners, f)
+}
+
+// swap replaces the RPCs and notifies the listeners of the differences
+func (r *Registry) swap(rpcs []arpicee.RemoteCall) {
+	r.mu.Lock()
+	d := diff(r.rpcs, rpcs)
+	r.rpcs = rpcs
+	listeners := append([]func(Diff){}, r.listeners...)
+	r.mu.Unlock()
+
+	if d.Empty() {
+		return
+	}
+	for _, f := range listeners {
+		f(d)
+	}
+}
+
+// RPCs returns a copy of the list of discovered RPCs
+func (r *Registry) RPCs() []arpicee.RemoteCall {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]arpicee.RemoteCall{}, r.rpcs...)
+}
+
+// RPC returns the RPC with the given name, or nil
+func (r *Registry) RPC(name string) arpicee.RemoteCall {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, rpc := range r.rpcs {
+		if rpc.Name() == name {
+			return rpc
+		}
+	}
+	return nil
+}
+
+// Reload runs all discovery sources concurrently and replaces the list of RPCs. The
+// returned error lists the sources that failed and the items that could not be
+// discovered; the RPCs of the other sources and items are loaded anyway.
+func (r *Registry) Reload() error {
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+	return r.reload()
+}
+
+// ReloadMissing discovers the sources that were neither discovered nor restored from
+// the cache yet, see Restore. Errors are returned as for Reload.
+func (r *Registry) ReloadMissing() error {
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+	return r.reloadSources(func(src *source) bool {
+		return src.status.LastAttempt.IsZero()
+	})
+}
+
+func (r *Registry) reload() error {
+	return r.reloadSources(func(*source) bool { return true })
+}
+
+// reloadSources discovers the sources matching selected, and replaces the list of
+// RPCs. The cache is updated with the RPCs of the sources discovered.
+func (r *Registry) reloadSources(selected func(*source) bool) error {
+	var sources []*source
+	r.mu.RLock()
+	for _, src := range r.sources {
+		if selected(src) {
+			sources = append(sources, src)
+		}
+	}
+	r.mu.RUnlock()
+
+	type result struct {
+		rpcs []arpicee.RemoteCall
+		err  error
+	}
+	results := make([]result, len(sources))
+	var wg sync.WaitGroup
+	for i, src := range sources {
+		wg.Add(1)
+		go func(i int, src *source) {
+			defer wg.Done()
+			rpcs, err := src.Discover()
+			results[i] = result{rpcs, err}
+		}(i, src)
+	}
+	wg.Wait()
+
+	now := time.Now()
+	var errs []string
+	var rpcs []arpicee.RemoteCall
+	cached := false
+	r.mu.Lock()
+	for i, src := range sources {
+		err := src.update(results[i].rpcs, results[i].err, now)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", src.Name, err))
+		}
+		if r.cache != nil && src.cacheable() && src.status.LastSuccess != nil && src.status.LastSuccess.Equal(now) {
+			if entries, ok := cache.Entries(src.rpcs); ok {
+				r.cache.Put(src.Name, cache.Source{Fingerprint: src.Fingerprint, DiscoveredAt: now, Entries: entries})
+				cached = true
+			}
+		}
+	}
+	for _, src := range r.sources {
+		rpcs = append(rpcs, src.rpcs...)
+	}
+	c := r.cache
+	r.mu.Unlock()
+
+	if cached {
+		if err := c.Save(); err != nil {
+			log.Printf("failed saving the discovery cache: %s", err)
+		}
+	}
+	r.swap(rpcs)
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// ReloadEvery reloads the RPCs every interval until ctx is done. Errors are logged.
+func (r *Registry) ReloadEvery(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if err := r.Reload(); err != nil {
+				log.Printf("failed reloading RPCs: %s", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package registry
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/cache"
+	"github.com/yannh/arpicee/pkg/githubrpc"
+	"github.com/yannh/arpicee/pkg/mock"
+)
+
+func TestReload(t *testing.T) {
+	mockDiscoverNil := func() ([]arpicee.RemoteCall, error) {
+		return nil, nil
+	}
+	mockDiscoverOne := func() ([]arpicee.RemoteCall, error) {
+		return []arpicee.RemoteCall{&githubrpc.GithubRPC{}}, nil
+	}
+	mockDiscoverTwo := func() ([]arpicee.RemoteCall, error) {
+		return []arpicee.RemoteCall{&githubrpc.GithubRPC{}, &githubrpc.GithubRPC{}}, nil
+	}
+
+	for i, testCase := range []struct {
+		discoverFuncs []func() ([]arpicee.RemoteCall, error)
+		expectRPCs    []arpicee.RemoteCall
+		expectErr     error
+	}{
+		{
+			[]func() ([]arpicee.RemoteCall, error){mockDiscoverNil},
+			nil,
+			nil,
+		},
+		{
+			[]func() ([]arpicee.RemoteCall, error){mockDiscoverNil, mockDiscoverTwo, mockDiscoverOne},
+			[]arpicee.RemoteCall{&githubrpc.GithubRPC{}, &githubrpc.GithubRPC{}, &githubrpc.GithubRPC{}},
+			nil,
+		},
+	} {
+		r := New()
+		for _, f := range testCase.discoverFuncs {
+			r.AddDiscoveryFunction(f)
+		}
+		err := r.Reload()
+		if (err == nil && testCase.expectErr != nil) || (err != nil && testCase.expectErr == nil) {
+			t.Errorf("test %d, expected err to be %s, was %s", i, testCase.expectErr, err)
+		} else if err != nil && err.Error() != testCase.expectErr.Error() {
+			t.Errorf("test %d, expected err to be %s, was %s", i, testCase.expectErr, err)
+		}
+
+		for i, _ := range testCase.expectRPCs {
+			if !reflect.DeepEqual(r.rpcs[i], testCase.expectRPCs[i]) {
+				t.Errorf("test %d, expected rpcs to be %s, was %s", i, testCase.expectRPCs, r.rpcs)
+			}
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	param := []arpicee.Parameter{{Name: "env", Type: arpicee.TypeString}}
+	for i, testCase := range []struct {
+		before, after []arpicee.RemoteCall
+		expect        Diff
+		expectString  string
+	}{
+		{
+			[]arpicee.RemoteCall{mock.New("a", nil, nil)},
+			[]arpicee.RemoteCall{mock.New("a", nil, nil)},
+			Diff{},
+			"no changes",
+		},
+		{
+			[]arpicee.RemoteCall{mock.New("a", nil, nil), mock.New("b", nil, nil), mock.New("c", nil, nil)},
+			[]arpicee.RemoteCall{mock.New("d", nil, nil), mock.New("b", param, nil), mock.New("a", nil, nil), mock.New("e", nil, nil)},
+			Diff{Added: []string{"d", "e"}, Removed: []string{"c"}, Changed: []string{"b"}},
+			"2 added (d, e), 1 removed (c), 1 changed (b)",
+		},
+	} {
+		d := diff(testCase.before, testCase.after)
+		if !reflect.DeepEqual(d, testCase.expect) {
+			t.Errorf("test %d - expected %+v, got %+v", i, testCase.expect, d)
+		}
+		if d.String() != testCase.expectString {
+			t.Errorf("test %d - expected %s, got %s", i, testCase.expectString, d)
+		}
+	}
+}
+
+func TestSetSources(t *testing.T) {
+	r := New()
+	var diffs []Diff
+	r.OnChange(func(d Diff) { diffs = append(diffs, d) })
+
+	discover := func(names ...string) func() ([]arpicee.RemoteCall, error) {
+		return func() ([]arpicee.RemoteCall, error) {
+			var rpcs []arpicee.RemoteCall
+			for _, name := range names {
+				rpcs = append(rpcs, mock.New(name, nil, nil))
+			}
+			return rpcs, nil
+		}
+	}
+	failing := func() ([]arpicee.RemoteCall, error) {
+		return nil, fmt.Errorf("access denied")
+	}
+
+	if err := r.SetSources([]Source{{Name: "lambda", Discover: discover("a", "b")}, {Name: "github", Discover: discover("c")}}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// A failing source keeps the RPCs of its last successful discovery
+	if err := r.SetSources([]Source{{Name: "lambda", Discover: failing}, {Name: "github", Discover: discover("d")}}); err == nil || err.Error() != "lambda: access denied" {
+		t.Errorf("expected the error of the failing source, got %v", err)
+	}
+	if r.RPC("a") == nil || r.RPC("c") != nil || r.RPC("d") == nil {
+		t.Errorf("expected the RPCs of the failing source to be kept, got %v", r.RPCs())
+	}
+	// Sources that never succeeded have no RPCs
+	if err := r.SetSources([]Source{{Name: "ssm", Discover: failing}, {Name: "github", Discover: discover("d")}}); err == nil {
+		t.Errorf("expected an error")
+	}
+
+	expect := []Diff{{Added: []string{"a", "b", "c"}}, {Added: []string{"d"}, Removed: []string{"c"}}, {Removed: []string{"a", "b"}}}
+	if !reflect.DeepEqual(diffs, expect) {
+		t.Errorf("expected diffs %+v, got %+v", expect, diffs)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	fail := false
+	r := New()
+	r.SetSources([]Source{
+		{Name: "lambda", Discover: func() ([]arpicee.RemoteCall, error) {
+			if fail {
+				return nil, fmt.Errorf("access denied")
+			}
+			return []arpicee.RemoteCall{mock.New("a", nil, nil)}, nil
+		}},
+		{Name: "github", Discover: func() ([]arpicee.RemoteCall, error) {
+			return []arpicee.RemoteCall{mock.New("b", nil, nil)}, arpicee.DiscoveryErrors{{Item: "broken.yml", Err: fmt.Errorf("invalid YAML")}}
+		}},
+	})
+	fail = true
+	if err := r.Reload(); err == nil || err.Error() != "lambda: access denied, github: broken.yml: invalid YAML" {
+		t.Errorf("unexpected error %v", err)
+	}
+	if len(r.RPCs()) != 2 {
+		t.Errorf("expected 2 RPCs, got %v", r.RPCs())
+	}
+
+	for i, testCase := range []struct {
+		name        string
+		rpcs        int
+		err         string
+		stale       bool
+		itemErrors  []ItemStatus
+		expectOK    bool
+		lastSuccess bool
+	}{
+		{"lambda", 1, "access denied", true, nil, false, true},
+		{"github", 1, "", false, []ItemStatus{{Item: "broken.yml", Error: "invalid YAML"}}, false, true},
+	} {
+		st := r.Status()[i]
+		if st.Name != testCase.name || st.RPCs != testCase.rpcs || st.Error != testCase.err || st.Stale != testCase.stale || st.OK() != testCase.expectOK || (st.LastSuccess != nil) != testCase.lastSuccess {
+			t.Errorf("test %d - unexpected status %+v", i, st)
+		}
+		if len(st.ItemErrors) != len(testCase.itemErrors) || (len(st.ItemErrors) > 0 && !reflect.DeepEqual(st.ItemErrors, testCase.itemErrors)) {
+			t.Errorf("test %d - expected item errors %+v, got %+v", i, testCase.itemErrors, st.ItemErrors)
+		}
+	}
+}
+
+// referenced is a mock RPC that can be cached
+type referenced struct {
+	*mock.Mock
+}
+
+func (r referenced) Ref() string {
+	return r.Name()
+}
+
+func TestCache(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "discovery.json")
+	c, err := cache.Load(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	discovered := 0
+	source := func(fp string) Source {
+		return Source{
+			Name: "lambda",
+			Discover: func() ([]arpicee.RemoteCall, error) {
+				discovered++
+				return []arpicee.RemoteCall{referenced{mock.New("a", nil, nil)}}, nil
+			},
+			Fingerprint: fp,
+			Restore: func(entries []cache.Entry) ([]arpicee.RemoteCall, error) {
+				var rpcs []arpicee.RemoteCall
+				for _, e := range entries {
+					rpcs = append(rpcs, referenced{mock.New(e.Ref, e.Params, nil)})
+				}
+				return rpcs, nil
+			},
+		}
+	}
+	pipelines := Source{Name: "pipelines", Discover: func() ([]arpicee.RemoteCall, error) {
+		return []arpicee.RemoteCall{mock.New("p", nil, nil)}, nil
+	}}
+
+	r := New()
+	r.SetCache(c, time.Hour)
+	if n := r.Restore([]Source{source("v1"), pipelines}); n != 0 {
+		t.Errorf("expected no source restored from an empty cache, got %d", n)
+	}
+	if err := r.ReloadMissing(); err != nil || discovered != 1 || r.RPC("a") == nil || r.RPC("p") == nil {
+		t.Fatalf("expected the sources to be discovered, got %v, %v", err, r.RPCs())
+	}
+
+	for i, testCase := range []struct {
+		fingerprint    string
+		ttl            time.Duration
+		expectRestored int
+	}{
+		{"v1", time.Hour, 1},
+		{"v1", 0, 1},
+		{"v2", time.Hour, 0},
+		{"v1", time.Nanosecond, 0},
+	} {
+		// The cache is read back from disk
+		c, err := cache.Load(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		discovered = 0
+		r := New()
+		r.SetCache(c, testCase.ttl)
+		if n := r.Restore([]Source{source(testCase.fingerprint), pipelines}); n != testCase.expectRestored {
+			t.Errorf("test %d - expected %d sources restored, got %d", i, testCase.expectRestored, n)
+		}
+		if (r.RPC("a") != nil) != (testCase.expectRestored == 1) || r.RPC("p") != nil {
+			t.Errorf("test %d - unexpected RPCs %v", i, r.RPCs())
+		}
+		if st := r.Status()[0]; st.Cached != (testCase.expectRestored == 1) {
+			t.Errorf("test %d - unexpected status %+v", i, st)
+		}
+		if err := r.ReloadMissing(); err != nil || r.RPC("a") == nil || r.RPC("p") == nil {
+			t.Errorf("test %d - expected all RPCs after discovering the missing sources, got %v, %v", i, err, r.RPCs())
+		}
+		if expect := 1 - testCase.expectRestored; discovered != expect {
+			t.Errorf("test %d - expected %d discoveries, got %d", i, expect, discovered)
+		}
+	}
+}
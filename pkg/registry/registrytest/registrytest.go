@@ -0,0 +1,22 @@
+// Package registrytest provides registries of RPCs for tests
+package registrytest
+
+import (
+	"testing"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/registry"
+)
+
+// New returns a registry of the RPCs rpcs, failing t if they can not be loaded
+func New(t testing.TB, rpcs ...arpicee.RemoteCall) *registry.Registry {
+	t.Helper()
+	reg := registry.New()
+	reg.AddDiscoveryFunction(func() ([]arpicee.RemoteCall, error) {
+		return rpcs, nil
+	})
+	if err := reg.Reload(); err != nil {
+		t.Fatalf("failed loading RPCs: %s", err)
+	}
+	return reg
+}
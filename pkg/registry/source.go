@@ -0,0 +1,136 @@
+package registry
+
+import (
+	"errors"
+	"time"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/cache"
+)
+
+// Source is a named discovery function, such as the discovery of the Lambda functions
+// of a region. Discover may return arpicee.DiscoveryErrors along with the RPCs it
+// found, when some items of the source could not be discovered.
+//
+// Sources with a Fingerprint, identifying their settings, and a Restore function are
+// cached when a cache is set, see SetCache. Restore returns the RPCs of the cached
+// entries without network access.
+type Source struct {
+	Name     string
+	Discover func() ([]arpicee.RemoteCall, error)
+
+	Fingerprint string
+	Restore     func([]cache.Entry) ([]arpicee.RemoteCall, error)
+}
+
+// cacheable returns true if the RPCs of the source can be cached
+func (s Source) cacheable() bool {
+	return s.Fingerprint != "" && s.Restore != nil
+}
+
+// ItemStatus is the error discovering an item of a source
+type ItemStatus struct {
+	Item  string `json:"item"`
+	Error string `json:"error"`
+}
+
+// SourceStatus is the result of the last discovery of a source. When it failed,
+// Error is set, and the RPCs of the last successful discovery are kept and marked as
+// Stale. Items of the source that could not be discovered are listed in ItemErrors.
+// Cached is set when the RPCs were restored from the cache, and not discovered since.
+type SourceStatus struct {
+	Name        string       `json:"name"`
+	RPCs        int          `json:"rpcs"`
+	LastAttempt time.Time    `json:"lastAttempt"`
+	LastSuccess *time.Time   `json:"lastSuccess,omitempty"`
+	Error       string       `json:"error,omitempty"`
+	Stale       bool         `json:"stale,omitempty"`
+	ItemErrors  []ItemStatus `json:"itemErrors,omitempty"`
+	Cached      bool         `json:"cached,omitempty"`
+}
+
+// OK returns true if the last discovery of the source fully succeeded
+func (s SourceStatus) OK() bool {
+	return s.Error == "" && len(s.ItemErrors) == 0
+}
+
+// source is a Source with the RPCs of its last successful discovery
+type source struct {
+	Source
+	rpcs   []arpicee.RemoteCall
+	status SourceStatus
+}
+
+// update records the result of a discovery at time now, and returns err
+func (s *source) update(rpcs []arpicee.RemoteCall, err error, now time.Time) error {
+	s.status.Name = s.Name
+	s.status.LastAttempt = now
+	s.status.Cached = false
+	s.status.Error = ""
+	s.status.ItemErrors = nil
+
+	var itemErrs arpicee.DiscoveryErrors
+	switch {
+	case err == nil:
+	case errors.As(err, &itemErrs):
+		for _, ie := range itemErrs {
+			s.status.ItemErrors = append(s.status.ItemErrors, ItemStatus{Item: ie.Item, Error: ie.Err.Error()})
+		}
+	default:
+		s.status.Error = err.Error()
+		s.status.Stale = s.status.LastSuccess != nil
+		return err
+	}
+
+	var valid []arpicee.RemoteCall
+	for _, rpc := range rpcs {
+		if rpc != nil {
+			valid = append(valid, rpc)
+		}
+	}
+	s.rpcs = valid
+	s.status.RPCs = len(valid)
+	s.status.LastSuccess = &now
+	s.status.Stale = false
+	return err
+}
+
+// restore restores the RPCs of the source from c, if it holds entries with the same
+// fingerprint discovered less than maxAge ago
+func (s *source) restore(c *cache.Cache, maxAge time.Duration) bool {
+	if !s.cacheable() {
+		return false
+	}
+	cached, ok := c.Get(s.Name, s.Fingerprint, maxAge)
+	if !ok {
+		return false
+	}
+	rpcs, err := s.Restore(cached.Entries)
+	if err != nil {
+		return false
+	}
+	at := cached.DiscoveredAt
+	s.rpcs = rpcs
+	s.status = SourceStatus{
+		Name:        s.Name,
+		RPCs:        len(rpcs),
+		LastAttempt: at,
+		LastSuccess: &at,
+		Cached:      true,
+	}
+	return true
+}
+
+// Status returns the status of every source, in the order they were added
+func (r *Registry) Status() []SourceStatus {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	res := []SourceStatus{}
+	for _, src := range r.sources {
+		st := src.status
+		st.Name = src.Name
+		st.ItemErrors = append([]ItemStatus{}, st.ItemErrors...)
+		res = append(res, st)
+	}
+	return res
+}
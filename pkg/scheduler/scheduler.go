@@ -0,0 +1,550 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+	// Embed the time zone database, the container image has none
+	_ "time/tzdata"
+
+	"github.com/robfig/cron/v3"
+	"github.com/yannh/arpicee/pkg/atomicfile"
+	"github.com/yannh/arpicee/pkg/chat"
+	"github.com/yannh/arpicee/pkg/execution"
+	"github.com/yannh/arpicee/pkg/registry"
+)
+
+// Overlap decides what happens when a job is due while its previous execution is still running
+type Overlap string
+
+const (
+	// OverlapSkip skips the run
+	OverlapSkip Overlap = "skip"
+	// OverlapQueue starts the run when the previous execution finishes; at most one run is queued
+	OverlapQueue Overlap = "queue"
+	// OverlapAllow starts the run anyway
+	OverlapAllow Overlap = "allow"
+)
+
+const (
+	// maxHistory is the number of runs kept per job
+	maxHistory = 20
+	// missedAfter is the delay after which a run is considered missed, for example if
+	// the server was down or suspended at the time it was due
+	missedAfter = time.Minute
+)
+
+var ErrNotFound = errors.New("scheduled job not found")
+
+// Job runs RPC with Arguments on Schedule, a cron expression evaluated in the time zone
+// TimeZone (default UTC). Runs are delayed by a random duration up to Jitter. If CatchUp
+// is set, a run missed while the server was down is started once when it is back.
+// Executions are attributed to User, "schedule:" + Name by default, and their results
+// posted to SlackChannel, if set.
+type Job struct {
+	Name         string            `json:"name"`
+	Schedule     string            `json:"schedule"`
+	TimeZone     string            `json:"timeZone,omitempty"`
+	RPC          string            `json:"rpc"`
+	Arguments    map[string]string `json:"arguments,omitempty"`
+	Jitter       time.Duration     `json:"jitter,omitempty"`
+	Overlap      Overlap           `json:"overlap,omitempty"`
+	CatchUp      bool              `json:"catchUp,omitempty"`
+	SlackChannel string            `json:"slackChannel,omitempty"`
+	User         string            `json:"user,omitempty"`
+}
+
+// Run is a run of a job, recorded in the history
+type Run struct {
+	Job         string           `json:"job"`
+	ScheduledAt time.Time        `json:"scheduledAt"`
+	ExecutionID string           `json:"executionId,omitempty"`
+	Status      execution.Status `json:"status,omitempty"`
+	Skipped     string           `json:"skipped,omitempty"`
+	Error       string           `json:"error,omitempty"`
+}
+
+// JobStatus describes a job and its next run
+type JobStatus struct {
+	Job
+	Paused  bool      `json:"paused"`
+	Dynamic bool      `json:"dynamic"`
+	Next    time.Time `json:"next"`
+	LastRun *Run      `json:"lastRun,omitempty"`
+}
+
+// ResultPoster posts the results of scheduled executions to Slack
+type ResultPoster interface {
+	PostResult(channelID string, e execution.Execution) error
+}
+
+type job struct {
+	Job
+	schedule cron.Schedule
+	location *time.Location
+	dynamic  bool
+	paused   bool
+
+	// next is the next time the job is scheduled, due adds the jitter
+	next time.Time
+	due  time.Time
+
+	lastScheduled time.Time
+	lastExecution string
+	queued        bool
+}
+
+// state is persisted to the state file: the jobs added with Add, and the
+// progress and history of every job
+type state struct {
+	Jobs          []Job                `json:"jobs"`
+	Paused        map[string]bool      `json:"paused"`
+	LastScheduled map[string]time.Time `json:"lastScheduled"`
+	History       map[string][]Run     `json:"history"`
+}
+
+// Scheduler starts the executions of jobs when they are due
+type Scheduler struct {
+	registry   *registry.Registry
+	executions *execution.Manager
+	poster     ResultPoster
+	stateFile  string
+
+	mu      sync.Mutex
+	jobs    map[string]*job
+	history map[string][]Run
+	wake    chan struct{}
+}
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+func compile(j Job) (*job, error) {
+	if !validName.MatchString(j.Name) {
+		return nil, fmt.Errorf("invalid job name %q", j.Name)
+	}
+	if j.RPC == "" {
+		return nil, fmt.Errorf("job %s: missing RPC", j.Name)
+	}
+	switch j.Overlap {
+	case "":
+		j.Overlap = OverlapSkip
+	case OverlapSkip, OverlapQueue, OverlapAllow:
+	default:
+		return nil, fmt.Errorf("job %s: invalid overlap policy %s, expected skip, queue or allow", j.Name, j.Overlap)
+	}
+	if j.Jitter < 0 {
+		return nil, fmt.Errorf("job %s: negative jitter", j.Name)
+	}
+	loc, err := time.LoadLocation(j.TimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("job %s: invalid time zone: %w", j.Name, err)
+	}
+	sched, err := cron.ParseStandard(j.Schedule)
+	if err != nil {
+		return nil, fmt.Errorf("job %s: invalid schedule: %w", j.Name, err)
+	}
+	if j.User == "" {
+		j.User = User(j.Name)
+	}
+	return &job{Job: j, schedule: sched, location: loc}, nil
+}
+
+// User returns the user executions of the job name are attributed to by default
+func User(name string) string {
+	return "schedule:" + name
+}
+
+// New returns a Scheduler for jobs, restoring the jobs added with Add, and the history
+// from stateFile if set. poster can be nil if no job posts to Slack.
+func New(reg *registry.Registry, executions *execution.Manager, jobs []Job, stateFile string, poster ResultPoster) (*Scheduler, error) {
+	s := &Scheduler{
+		registry:   reg,
+		executions: executions,
+		poster:     poster,
+		stateFile:  stateFile,
+		jobs:       map[string]*job{},
+		history:    map[string][]Run{},
+		wake:       make(chan struct{}, 1),
+	}
+	for _, j := range jobs {
+		if err := s.add(j, false); err != nil {
+			return nil, err
+		}
+	}
+
+	st, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	for _, j := range st.Jobs {
+		if err := s.add(j, true); err != nil {
+			log.Printf("ignoring scheduled job from %s: %s", stateFile, err)
+		}
+	}
+	for name, j := range s.jobs {
+		j.paused = st.Paused[name]
+		j.lastScheduled = st.LastScheduled[name]
+	}
+	s.history = st.History
+	if s.history == nil {
+		s.history = map[string][]Run{}
+	}
+	return s, nil
+}
+
+func (s *Scheduler) add(j Job, dynamic bool) error {
+	if _, ok := s.jobs[j.Name]; ok {
+		return fmt.Errorf("duplicate job %s", j.Name)
+	}
+	if j.SlackChannel != "" && s.poster == nil {
+		return fmt.Errorf("job %s posts to Slack, but Slack is not configured", j.Name)
+	}
+	c, err := compile(j)
+	if err != nil {
+		return err
+	}
+	c.dynamic = dynamic
+	s.jobs[j.Name] = c
+	return nil
+}
+
+func (s *Scheduler) load() (state, error) {
+	var st state
+	if s.stateFile == "" {
+		return st, nil
+	}
+	b, err := os.ReadFile(s.stateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return st, fmt.Errorf("failed reading scheduler state: %w", err)
+	}
+	if err := json.Unmarshal(b, &st); err != nil {
+		return st, fmt.Errorf("failed parsing scheduler state %s: %w", s.stateFile, err)
+	}
+	return st, nil
+}
+
+// save persists the state; s.mu must be held
+func (s *Scheduler) save() {
+	if s.stateFile == "" {
+		return
+	}
+	st := state{
+		Jobs:          []Job{},
+		Paused:        map[string]bool{},
+		LastScheduled: map[string]time.Time{},
+		History:       s.history,
+	}
+	for name, j := range s.jobs {
+		if j.dynamic {
+			st.Jobs = append(st.Jobs, j.Job)
+		}
+		if j.paused {
+			st.Paused[name] = true
+		}
+		if !j.lastScheduled.IsZero() {
+			st.LastScheduled[name] = j.lastScheduled
+		}
+	}
+	sort.Slice(st.Jobs, func(i, k int) bool { return st.Jobs[i].Name < st.Jobs[k].Name })
+
+	b, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		log.Printf("failed saving scheduler state: %s", err)
+		return
+	}
+	// The state is written atomically, so it is never left half written
+	if err := atomicfile.WriteFile(s.stateFile, b, 0o600); err != nil {
+		log.Printf("failed saving scheduler state: %s", err)
+	}
+}
+
+// plan computes the next run of j after t; s.mu must be held
+func (j *job) plan(t time.Time) {
+	j.next = j.schedule.Next(t.In(j.location))
+	j.due = j.next
+	if j.Jitter > 0 {
+		j.due = j.next.Add(time.Duration(rand.Int63n(int64(j.Jitter))))
+	}
+}
+
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Add schedules a new job, persisted in the state file
+func (s *Scheduler) Add(j Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.add(j, true); err != nil {
+		return err
+	}
+	s.jobs[j.Name].plan(time.Now())
+	s.save()
+	s.notify()
+	return nil
+}
+
+// Remove deletes a job added with Add; jobs from the configuration can only be paused
+func (s *Scheduler) Remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[name]
+	if !ok {
+		return ErrNotFound
+	}
+	if !j.dynamic {
+		return fmt.Errorf("job %s is defined in the configuration and can only be paused", name)
+	}
+	delete(s.jobs, name)
+	delete(s.history, name)
+	s.save()
+	s.notify()
+	return nil
+}
+
+// SetPaused pauses or resumes the job name
+func (s *Scheduler) SetPaused(name string, paused bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[name]
+	if !ok {
+		return ErrNotFound
+	}
+	j.paused = paused
+	if !paused {
+		j.plan(time.Now())
+	}
+	s.save()
+	s.notify()
+	return nil
+}
+
+// Get returns the job name
+func (s *Scheduler) Get(name string) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[name]
+	if !ok {
+		return Job{}, false
+	}
+	return j.Job, true
+}
+
+// Jobs returns the jobs sorted by name
+func (s *Scheduler) Jobs() []JobStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := []JobStatus{}
+	for name, j := range s.jobs {
+		st := JobStatus{Job: j.Job, Paused: j.paused, Dynamic: j.dynamic, Next: j.due}
+		if runs := s.history[name]; len(runs) > 0 {
+			last := runs[len(runs)-1]
+			st.LastRun = &last
+		}
+		res = append(res, st)
+	}
+	sort.Slice(res, func(i, k int) bool { return res[i].Name < res[k].Name })
+	return res
+}
+
+// History returns the last runs of the job name, most recent last
+func (s *Scheduler) History(name string) []Run {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Run{}, s.history[name]...)
+}
+
+// record adds r to the history; s.mu must be held
+func (s *Scheduler) record(r Run) {
+	runs := append(s.history[r.Job], r)
+	if len(runs) > maxHistory {
+		runs = runs[len(runs)-maxHistory:]
+	}
+	s.history[r.Job] = runs
+}
+
+// updateRun sets the status of the run of the execution id; s.mu must be held
+func (s *Scheduler) updateRun(name string, e execution.Execution) {
+	runs := s.history[name]
+	for i := len(runs) - 1; i >= 0; i-- {
+		if runs[i].ExecutionID == e.ID {
+			runs[i].Status = e.Status
+			runs[i].Error = e.Error
+			return
+		}
+	}
+}
+
+// Trigger starts the job name immediately, ignoring its overlap policy
+func (s *Scheduler) Trigger(name string) (execution.Execution, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[name]
+	if !ok {
+		return execution.Execution{}, ErrNotFound
+	}
+	return s.start(j, time.Now())
+}
+
+// start starts the execution of j; s.mu must be held
+func (s *Scheduler) start(j *job, scheduledAt time.Time) (execution.Execution, error) {
+	run := Run{Job: j.Name, ScheduledAt: scheduledAt}
+	e, err := s.startExecution(j)
+	if err != nil {
+		run.Status = execution.StatusFailed
+		run.Error = err.Error()
+		s.record(run)
+		s.save()
+		log.Printf("scheduled job %s: %s", j.Name, err)
+		return e, err
+	}
+	run.ExecutionID = e.ID
+	run.Status = e.Status
+	s.record(run)
+	s.save()
+	j.lastExecution = e.ID
+	log.Printf("scheduled job %s: started execution %s of RPC %s", j.Name, e.ID, j.RPC)
+	go s.finish(j.Name, j.SlackChannel, e.ID)
+	return e, nil
+}
+
+func (s *Scheduler) startExecution(j *job) (execution.Execution, error) {
+	rpc := s.registry.RPC(j.RPC)
+	if rpc == nil {
+		return execution.Execution{}, fmt.Errorf("no RPC named %s", j.RPC)
+	}
+	args, err := chat.ArgsFromValues(rpc.Params(), j.Arguments)
+	if err != nil {
+		return execution.Execution{}, err
+	}
+	return s.executions.Start(rpc, args, j.User)
+}
+
+// finish records the result of the execution id and posts it to Slack
+func (s *Scheduler) finish(name, channel, id string) {
+	e, err := s.executions.Wait(context.Background(), id)
+	if err != nil {
+		log.Printf("scheduled job %s: failed waiting for execution %s: %s", name, id, err)
+		return
+	}
+	s.mu.Lock()
+	s.updateRun(name, e)
+	s.save()
+	s.mu.Unlock()
+
+	if channel != "" {
+		if err := s.poster.PostResult(channel, e); err != nil {
+			log.Printf("scheduled job %s: error posting results to Slack: %s", name, err)
+		}
+	}
+}
+
+// running returns if the last execution of j is still running
+func (s *Scheduler) running(j *job) bool {
+	if j.lastExecution == "" {
+		return false
+	}
+	e, ok := s.executions.Get(j.lastExecution)
+	return ok && !e.Done()
+}
+
+// fire runs j, due at scheduledAt, following its overlap policy; s.mu must be held
+func (s *Scheduler) fire(j *job, scheduledAt time.Time) {
+	if !s.running(j) || j.Overlap == OverlapAllow {
+		s.start(j, scheduledAt)
+		return
+	}
+
+	if j.Overlap == OverlapQueue && !j.queued {
+		j.queued = true
+		previous := j.lastExecution
+		go func() {
+			s.executions.Wait(context.Background(), previous)
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			j.queued = false
+			if current, ok := s.jobs[j.Name]; ok && current == j && !j.paused {
+				s.start(j, scheduledAt)
+			}
+		}()
+		return
+	}
+
+	s.record(Run{Job: j.Name, ScheduledAt: scheduledAt, Skipped: "previous execution still running"})
+	s.save()
+}
+
+// tick starts the jobs due at now, and returns when the next job is due
+func (s *Scheduler) tick(now time.Time) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var next time.Time
+	for _, j := range s.jobs {
+		if j.next.IsZero() {
+			// First tick: resume from the last scheduled run, to catch up on missed runs
+			from := now
+			if !j.lastScheduled.IsZero() && j.lastScheduled.Before(now) {
+				from = j.lastScheduled
+			}
+			j.plan(from)
+		}
+		if j.paused {
+			continue
+		}
+
+		if !j.due.After(now) {
+			scheduledAt := j.next
+			switch {
+			case now.Sub(j.due) <= missedAfter:
+				s.fire(j, scheduledAt)
+			case j.CatchUp:
+				log.Printf("scheduled job %s: catching up on the run missed at %s", j.Name, scheduledAt)
+				s.fire(j, scheduledAt)
+			default:
+				s.record(Run{Job: j.Name, ScheduledAt: scheduledAt, Skipped: "missed"})
+			}
+			j.lastScheduled = scheduledAt
+			j.plan(now)
+			s.save()
+		}
+
+		if next.IsZero() || j.due.Before(next) {
+			next = j.due
+		}
+	}
+	return next
+}
+
+// Run starts the jobs when they are due, until ctx is done
+func (s *Scheduler) Run(ctx context.Context) error {
+	for {
+		d := time.Hour
+		if next := s.tick(time.Now()); !next.IsZero() {
+			d = time.Until(next)
+		}
+		t := time.NewTimer(d)
+		select {
+		case <-t.C:
+		case <-s.wake:
+			t.Stop()
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+	}
+}
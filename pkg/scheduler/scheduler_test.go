@@ -0,0 +1,232 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/execution"
+	"github.com/yannh/arpicee/pkg/mock"
+	"github.com/yannh/arpicee/pkg/registry/registrytest"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// newTestScheduler returns a scheduler for jobs running the RPC "backup", which blocks
+// until release is closed
+func newTestScheduler(t *testing.T, jobs []Job, stateFile string, release chan struct{}) (*Scheduler, *execution.Manager) {
+	reg := registrytest.New(t,
+		mock.New("backup", []arpicee.Parameter{
+			{Name: "db", Type: arpicee.TypeString, Required: true},
+		}, func(ctx context.Context, args []arpicee.Argument) (map[string]interface{}, error) {
+			select {
+			case <-release:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			return map[string]interface{}{"formatString": "done"}, nil
+		}),
+	)
+	mgr := execution.NewManager(100)
+	s, err := New(reg, mgr, jobs, stateFile, nil)
+	if err != nil {
+		t.Fatalf("failed creating scheduler: %s", err)
+	}
+	return s, mgr
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	for i := 0; i < 500; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("timed out waiting for condition")
+}
+
+func TestOverlap(t *testing.T) {
+	for i, testCase := range []struct {
+		overlap          Overlap
+		expectExecutions int
+		expectSkipped    int
+	}{
+		{OverlapSkip, 1, 1},
+		{OverlapQueue, 2, 0},
+		{OverlapAllow, 2, 0},
+	} {
+		release := make(chan struct{})
+		s, mgr := newTestScheduler(t, []Job{{
+			Name:      "nightly",
+			Schedule:  "*/5 * * * *",
+			RPC:       "backup",
+			Arguments: map[string]string{"db": "users"},
+			Overlap:   testCase.overlap,
+		}}, "", release)
+
+		if next := s.tick(date("2026-01-10T10:02:00Z")); !next.Equal(date("2026-01-10T10:05:00Z")) {
+			t.Errorf("test %d - expected next run at 10:05, got %s", i, next)
+		}
+		s.tick(date("2026-01-10T10:05:00Z"))
+		s.tick(date("2026-01-10T10:10:00Z"))
+		close(release)
+
+		waitFor(t, func() bool {
+			n := 0
+			for _, e := range mgr.List() {
+				if e.Done() {
+					n++
+				}
+			}
+			return n == testCase.expectExecutions
+		})
+		if n := len(mgr.List()); n != testCase.expectExecutions {
+			t.Errorf("test %d - expected %d executions, got %d", i, testCase.expectExecutions, n)
+		}
+		skipped := 0
+		for _, r := range s.History("nightly") {
+			if r.Skipped != "" {
+				skipped++
+			}
+		}
+		if skipped != testCase.expectSkipped {
+			t.Errorf("test %d - expected %d skipped runs, got %d", i, testCase.expectSkipped, skipped)
+		}
+		if e := mgr.List()[0]; e.User != "schedule:nightly" || e.Arguments["db"] != "users" {
+			t.Errorf("test %d - unexpected execution %+v", i, e)
+		}
+	}
+}
+
+func TestCatchUp(t *testing.T) {
+	for i, testCase := range []struct {
+		catchUp       bool
+		expectSkipped string
+	}{
+		{true, ""},
+		{false, "missed"},
+	} {
+		stateFile := filepath.Join(t.TempDir(), "state.json")
+		b, _ := json.Marshal(state{LastScheduled: map[string]time.Time{"hourly": date("2026-01-10T09:00:00Z")}})
+		if err := os.WriteFile(stateFile, b, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		release := make(chan struct{})
+		close(release)
+		s, _ := newTestScheduler(t, []Job{{
+			Name:      "hourly",
+			Schedule:  "0 * * * *",
+			RPC:       "backup",
+			Arguments: map[string]string{"db": "users"},
+			CatchUp:   testCase.catchUp,
+		}}, stateFile, release)
+
+		next := s.tick(date("2026-01-10T12:30:00Z"))
+		if !next.Equal(date("2026-01-10T13:00:00Z")) {
+			t.Errorf("test %d - expected next run at 13:00, got %s", i, next)
+		}
+		runs := s.History("hourly")
+		if len(runs) != 1 || !runs[0].ScheduledAt.Equal(date("2026-01-10T10:00:00Z")) || runs[0].Skipped != testCase.expectSkipped {
+			t.Errorf("test %d - expected a single run scheduled at 10:00, skipped %q, got %+v", i, testCase.expectSkipped, runs)
+		}
+		// The state is saved when the run finishes, wait for it before the state
+		// file is removed
+		waitFor(t, func() bool {
+			runs := s.History("hourly")
+			return len(runs) == 1 && (runs[0].Skipped != "" || runs[0].Status == execution.StatusSucceeded)
+		})
+	}
+}
+
+func TestTimeZoneAndJitter(t *testing.T) {
+	s, _ := newTestScheduler(t, []Job{
+		{Name: "paris", Schedule: "0 9 * * *", TimeZone: "Europe/Paris", RPC: "backup"},
+		{Name: "jittered", Schedule: "0 12 * * *", Jitter: 10 * time.Minute, RPC: "backup"},
+	}, "", nil)
+	s.tick(date("2026-01-10T00:00:00Z"))
+
+	for _, j := range s.Jobs() {
+		switch j.Name {
+		case "paris":
+			if !j.Next.Equal(date("2026-01-10T08:00:00Z")) {
+				t.Errorf("expected paris to run at 08:00 UTC, got %s", j.Next)
+			}
+		case "jittered":
+			if j.Next.Before(date("2026-01-10T12:00:00Z")) || !j.Next.Before(date("2026-01-10T12:10:00Z")) {
+				t.Errorf("expected jittered to run between 12:00 and 12:10, got %s", j.Next)
+			}
+		}
+	}
+}
+
+func TestManageJobs(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	s, _ := newTestScheduler(t, []Job{{Name: "config", Schedule: "@daily", RPC: "backup"}}, stateFile, nil)
+
+	for i, testCase := range []struct {
+		f         func() error
+		expectErr string
+	}{
+		{func() error { return s.Add(Job{Name: "config", Schedule: "@daily", RPC: "backup"}) }, "duplicate job config"},
+		{func() error { return s.Add(Job{Name: "bad", Schedule: "61 * * * *", RPC: "backup"}) }, "invalid schedule"},
+		{func() error {
+			return s.Add(Job{Name: "bad", Schedule: "@daily", TimeZone: "Mars/Olympus", RPC: "backup"})
+		}, "invalid time zone"},
+		{func() error { return s.Add(Job{Name: "bad", Schedule: "@daily", RPC: "backup", Overlap: "never"}) }, "invalid overlap policy"},
+		{func() error { return s.Add(Job{Name: "bad", Schedule: "@daily", RPC: "backup", SlackChannel: "C1"}) }, "Slack is not configured"},
+		{func() error { return s.Add(Job{Name: "weekly", Schedule: "0 3 * * 1", RPC: "backup", User: "U123"}) }, ""},
+		{func() error { return s.Remove("config") }, "can only be paused"},
+		{func() error { return s.Remove("unknown") }, "not found"},
+		{func() error { return s.SetPaused("config", true) }, ""},
+	} {
+		err := testCase.f()
+		if testCase.expectErr == "" && err != nil {
+			t.Errorf("test %d - unexpected error: %s", i, err)
+		}
+		if testCase.expectErr != "" && (err == nil || !strings.Contains(err.Error(), testCase.expectErr)) {
+			t.Errorf("test %d - expected error containing %s, got %v", i, testCase.expectErr, err)
+		}
+	}
+
+	// The added job and paused state are restored from the state file
+	restored, _ := newTestScheduler(t, []Job{{Name: "config", Schedule: "@daily", RPC: "backup"}}, stateFile, nil)
+	jobs := restored.Jobs()
+	if len(jobs) != 2 || !jobs[0].Paused || jobs[1].Name != "weekly" || !jobs[1].Dynamic || jobs[1].User != "U123" {
+		t.Errorf("unexpected restored jobs %+v", jobs)
+	}
+	if err := restored.Remove("weekly"); err != nil {
+		t.Errorf("failed removing job: %s", err)
+	}
+}
+
+func TestTrigger(t *testing.T) {
+	release := make(chan struct{})
+	close(release)
+	s, mgr := newTestScheduler(t, []Job{{Name: "nightly", Schedule: "@daily", RPC: "backup", Arguments: map[string]string{"db": "users"}}}, "", release)
+
+	e, err := s.Trigger("nightly")
+	if err != nil {
+		t.Fatalf("failed triggering job: %s", err)
+	}
+	if _, err := mgr.Wait(context.Background(), e.ID); err != nil {
+		t.Fatalf("failed waiting for execution: %s", err)
+	}
+	waitFor(t, func() bool {
+		runs := s.History("nightly")
+		return len(runs) == 1 && runs[0].Status == execution.StatusSucceeded
+	})
+	if _, err := s.Trigger("unknown"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
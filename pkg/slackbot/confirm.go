@@ -0,0 +1,63 @@
+package slackbot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/chat"
+)
+
+// pendingTTL is how long confirmation dialogs can be submitted
+const pendingTTL = 15 * time.Minute
+
+// pendingRun is a run waiting for confirmation in a confirmation dialog. The arguments
+// are kept by the bot, they could exceed the size of the private metadata of views.
+type pendingRun struct {
+	rpc     string
+	userID  string
+	args    []arpicee.Argument
+	created time.Time
+}
+
+// addPending keeps the run waiting for confirmation in the dialog with the given
+// external ID, and forgets the expired ones
+func (sb *Slackbot) addPending(externalID string, p pendingRun) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	for id, p := range sb.pending {
+		if time.Since(p.created) > pendingTTL {
+			delete(sb.pending, id)
+		}
+	}
+	p.created = time.Now()
+	sb.pending[externalID] = p
+}
+
+// takePending removes and returns the run waiting for confirmation in the dialog with
+// the given external ID, if it was started by userID and has not expired
+func (sb *Slackbot) takePending(externalID, userID string) (pendingRun, bool) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	p, ok := sb.pending[externalID]
+	if !ok || p.userID != userID || time.Since(p.created) > pendingTTL {
+		return pendingRun{}, false
+	}
+	delete(sb.pending, externalID)
+	return p, true
+}
+
+// confirmCommand returns the reply asking to confirm running rpc from a command, or an
+// empty string if the command confirms it with yes, see chat.ConfirmOption
+func confirmCommand(core *chat.Core, rpc arpicee.RemoteCall, yes string, found bool) string {
+	option := chat.ConfirmOption(rpc, core.Confirmation(rpc), yes, found)
+	if option == "" {
+		return ""
+	}
+	reply := fmt.Sprintf("*%s* requires confirmation, run the command again with `%s`", rpc.Name(), option)
+	if summary := arpicee.MetadataOf(rpc).Summary(); len(summary) > 0 {
+		reply += "\n" + strings.Join(summary, "\n")
+	}
+	return reply
+}
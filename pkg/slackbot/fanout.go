@@ -0,0 +1,163 @@
+package slackbot
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/chat"
+	"github.com/yannh/arpicee/pkg/fanout"
+	"github.com/yannh/arpicee/pkg/views"
+)
+
+const fanoutUsage = "Usage: `/arpicee fanout RPC [param=value]... [--over=PARAM=VALUE1,VALUE2] [--targets=GROUP] [--concurrency=N] [--canary=N] [--max-failures=N] [--yes]`"
+
+// fanoutUpdateInterval limits the rate of the updates of the summary message
+const fanoutUpdateInterval = 2 * time.Second
+
+// fanoutRequest is a parsed "/arpicee fanout ..." command
+type fanoutRequest struct {
+	rpc     string
+	values  map[string]string
+	group   string
+	over    string
+	options fanout.Options
+	// yes confirms running the RPC, see confirmCommand
+	yes       string
+	confirmed bool
+}
+
+// parseFanout parses RPC [param=value]... [--option=value]...
+func parseFanout(fields []string) (*fanoutRequest, error) {
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("missing RPC")
+	}
+	fc := &fanoutRequest{rpc: fields[0]}
+
+	var values []string
+	var rest []string
+	rest, fc.yes, fc.confirmed = chat.TakeYes(fields[1:])
+	for _, f := range rest {
+		if !strings.HasPrefix(f, "--") {
+			values = append(values, f)
+			continue
+		}
+		opt, val, _ := strings.Cut(strings.TrimPrefix(f, "--"), "=")
+		var err error
+		switch opt {
+		case "over":
+			fc.over = val
+		case "targets":
+			fc.group = val
+		case "concurrency":
+			fc.options.Concurrency, err = strconv.Atoi(val)
+		case "canary":
+			fc.options.Canary, err = strconv.Atoi(val)
+		case "max-failures":
+			fc.options.MaxFailures, err = strconv.Atoi(val)
+		default:
+			return nil, fmt.Errorf("unknown option --%s", opt)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for --%s: %s", opt, val)
+		}
+	}
+
+	var err error
+	fc.values, err = chat.KeyValues(values)
+	return fc, err
+}
+
+// fanoutCommand handles "/arpicee fanout ...", and returns the reply shown to the user.
+// The summary of the executions is posted to channelID, and updated as targets finish.
+func (sb *Slackbot) fanoutCommand(userID, channelID string, fields []string) string {
+	fc, err := parseFanout(fields)
+	if err != nil {
+		return fmt.Sprintf("%s\n%s", err, fanoutUsage)
+	}
+	rpc := sb.core.RPCIn(channelID, fc.rpc)
+	if rpc == nil {
+		return fmt.Sprintf("Unknown remote procedure *%s*", fc.rpc)
+	}
+	if err := sb.core.Authorize(userID, rpc); err != nil {
+		return fmt.Sprintf("You are not allowed to run *%s*", rpc.Name())
+	}
+	sb.mu.Lock()
+	groups := sb.targets
+	sb.mu.Unlock()
+	targets, err := fanout.ResolveTargets(groups, fc.group, fc.over)
+	if err != nil {
+		return fmt.Sprintf("%s\n%s", err, fanoutUsage)
+	}
+	if reply := confirmCommand(sb.core, rpc, fc.yes, fc.confirmed); reply != "" {
+		return fmt.Sprintf("Running *%s* on %d targets. %s", rpc.Name(), len(targets), reply)
+	}
+
+	go sb.runFanout(userID, channelID, rpc, fc, targets)
+	return fmt.Sprintf("Running *%s* on %d targets", rpc.Name(), len(targets))
+}
+
+func (sb *Slackbot) runFanout(userID, channelID string, rpc arpicee.RemoteCall, fc *fanoutRequest, targets []fanout.Target) {
+	var mu sync.Mutex
+	var latest *fanout.Summary
+	var ts string
+	post := func() {
+		mu.Lock()
+		s := latest
+		latest = nil
+		mu.Unlock()
+		if s == nil {
+			return
+		}
+
+		var err error
+		msg := slack.MsgOptionAttachments(views.FanoutSummary(*s, userID))
+		if ts == "" {
+			_, ts, err = sb.slackClient.PostMessage(channelID, msg, slack.MsgOptionAsUser(true))
+		} else {
+			_, _, _, err = sb.slackClient.UpdateMessage(channelID, ts, msg, slack.MsgOptionAsUser(true))
+		}
+		if err != nil {
+			log.Printf("fan-out of RPC %s invoked by %s: error posting results to Slack: %s", rpc.Name(), userID, err)
+		}
+	}
+
+	// The summary is posted at regular intervals while targets run
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		t := time.NewTicker(fanoutUpdateInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				post()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	summary, err := fanout.Run(context.Background(), rpc, fc.values, targets, fc.options, fanout.CoreRunner(sb.core, rpc, userID), func(s fanout.Summary) {
+		mu.Lock()
+		latest = &s
+		mu.Unlock()
+	})
+	close(done)
+	<-stopped
+	if err != nil {
+		sb.slackClient.PostEphemeral(channelID, userID, slack.MsgOptionText(fmt.Sprintf("Failed running *%s*: %s", rpc.Name(), err), false))
+		return
+	}
+	mu.Lock()
+	latest = &summary
+	mu.Unlock()
+	post()
+}
@@ -0,0 +1,141 @@
+package slackbot
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/yannh/arpicee/pkg/chat"
+	"github.com/yannh/arpicee/pkg/scheduler"
+	"github.com/yannh/arpicee/pkg/views"
+)
+
+const scheduleUsage = "Usage:\n" +
+	"• `/arpicee schedule list`\n" +
+	"• `/arpicee schedule add NAME \"CRON\" RPC [param=value]... [--tz=ZONE] [--jitter=DURATION] [--overlap=skip|queue|allow] [--catch-up] [--yes]`\n" +
+	"• `/arpicee schedule run NAME [--yes]`\n" +
+	"• `/arpicee schedule history|pause|resume|remove NAME`"
+
+// scheduleCommand handles "/arpicee schedule ...", fields being the words following
+// "schedule", and returns the reply shown to user. Users can only manage the jobs
+// running RPCs they are allowed to run; the jobs they add run on their behalf, and post
+// their results to channelID. Adding or running jobs of RPCs requiring confirmation
+// must be confirmed with --yes, see confirmCommand.
+func scheduleCommand(core *chat.Core, sched *scheduler.Scheduler, user, channelID string, fields []string) string {
+	if sched == nil {
+		return "The scheduler is not enabled"
+	}
+	fields, yes, confirmed := chat.TakeYes(fields)
+	if len(fields) == 0 || fields[0] == "list" {
+		return views.ScheduleList(sched.Jobs())
+	}
+
+	action := fields[0]
+	if action == "add" {
+		j, err := jobFromCommand(fields[1:])
+		if err != nil {
+			return fmt.Sprintf("%s\n%s", err, scheduleUsage)
+		}
+		rpc := core.RPCIn(channelID, j.RPC)
+		if rpc == nil {
+			return fmt.Sprintf("Unknown remote procedure *%s*", j.RPC)
+		}
+		if err := core.Authorize(user, rpc); err != nil {
+			return fmt.Sprintf("You are not allowed to run *%s*", rpc.Name())
+		}
+		if _, err := chat.ArgsFromCommand(rpc.Params(), j.Arguments); err != nil {
+			return err.Error()
+		}
+		if reply := confirmCommand(core, rpc, yes, confirmed); reply != "" {
+			return reply
+		}
+		j.User = user
+		j.SlackChannel = channelID
+		if err := sched.Add(j); err != nil {
+			return err.Error()
+		}
+		return fmt.Sprintf("Job *%s* scheduled", j.Name)
+	}
+
+	if len(fields) != 2 {
+		return scheduleUsage
+	}
+	name := fields[1]
+	j, ok := sched.Get(name)
+	if !ok {
+		return fmt.Sprintf("Unknown job *%s*", name)
+	}
+	rpc := core.RPCIn(channelID, j.RPC)
+	if rpc == nil {
+		return fmt.Sprintf("Unknown remote procedure *%s*", j.RPC)
+	}
+	if err := core.Authorize(user, rpc); err != nil {
+		return fmt.Sprintf("You are not allowed to run *%s*", rpc.Name())
+	}
+
+	var err error
+	switch action {
+	case "history":
+		return views.ScheduleHistory(name, sched.History(name))
+	case "run":
+		if reply := confirmCommand(core, rpc, yes, confirmed); reply != "" {
+			return reply
+		}
+		if _, err = sched.Trigger(name); err == nil {
+			return fmt.Sprintf("Job *%s* started", name)
+		}
+	case "pause", "resume":
+		if err = sched.SetPaused(name, action == "pause"); err == nil {
+			return fmt.Sprintf("Job *%s* %sd", name, action)
+		}
+	case "remove":
+		if err = sched.Remove(name); err == nil {
+			return fmt.Sprintf("Job *%s* removed", name)
+		}
+	default:
+		return scheduleUsage
+	}
+	if errors.Is(err, scheduler.ErrNotFound) {
+		return fmt.Sprintf("Unknown job *%s*", name)
+	}
+	return err.Error()
+}
+
+// jobFromCommand parses NAME "CRON" RPC [param=value]... [--option=value]...
+func jobFromCommand(fields []string) (scheduler.Job, error) {
+	var j scheduler.Job
+	if len(fields) < 3 {
+		return j, fmt.Errorf("missing arguments")
+	}
+	j.Name, j.Schedule, j.RPC = fields[0], fields[1], fields[2]
+
+	var values []string
+	for _, f := range fields[3:] {
+		if !strings.HasPrefix(f, "--") {
+			values = append(values, f)
+			continue
+		}
+		opt, val, _ := strings.Cut(strings.TrimPrefix(f, "--"), "=")
+		switch opt {
+		case "tz":
+			j.TimeZone = val
+		case "jitter":
+			d, err := time.ParseDuration(val)
+			if err != nil {
+				return j, fmt.Errorf("invalid jitter %s", val)
+			}
+			j.Jitter = d
+		case "overlap":
+			j.Overlap = scheduler.Overlap(val)
+		case "catch-up":
+			j.CatchUp = true
+		default:
+			return j, fmt.Errorf("unknown option --%s", opt)
+		}
+	}
+
+	var err error
+	j.Arguments, err = chat.KeyValues(values)
+	return j, err
+}
@@ -1,6 +1,7 @@
 package slackbot
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -12,71 +13,35 @@ import (
 	"github.com/slack-go/slack/slackevents"
 	"github.com/slack-go/slack/socketmode"
 	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/chat"
+	"github.com/yannh/arpicee/pkg/execution"
+	"github.com/yannh/arpicee/pkg/fanout"
+	"github.com/yannh/arpicee/pkg/queue"
+	"github.com/yannh/arpicee/pkg/scheduler"
 	"github.com/yannh/arpicee/pkg/views"
 )
 
 type Slackbot struct {
-	slackClient   *slack.Client
-	socketClient  *socketmode.Client
-	rpcs          []arpicee.RemoteCall
-	discoverFuncs []func() ([]arpicee.RemoteCall, error)
-}
-
-func (s *Slackbot) AddDiscoveryFunction(f func() ([]arpicee.RemoteCall, error)) {
-	s.discoverFuncs = append(s.discoverFuncs, f)
-}
+	slackClient  *slack.Client
+	socketClient *socketmode.Client
+	core         *chat.Core
+	scheduler    *scheduler.Scheduler
+	queue        *queue.Queue
 
-func (s *Slackbot) rpcByName(name string) arpicee.RemoteCall {
-	for _, r := range s.rpcs {
-		if r.Name() == name {
-			return r
-		}
-	}
-	return nil
+	mu      sync.Mutex
+	targets map[string][]fanout.Target
+	pending map[string]pendingRun // confirmation dialog external ID to the run to confirm
 }
 
-func (s *Slackbot) reloadRPCs() error {
-	rpcs := []arpicee.RemoteCall{}
-	var wg sync.WaitGroup
-	var errwg sync.WaitGroup
-	var mu sync.Mutex
-
-	errsChan := make(chan error)
-	var resErr error = nil
-	errwg.Add(1)
-	go func() {
-		for e := range errsChan {
-			if resErr == nil {
-				resErr = e
-			} else {
-				resErr = fmt.Errorf("%s, %s", resErr.Error(), e.Error())
-			}
-		}
-		errwg.Done()
-	}()
-
-	for _, f := range s.discoverFuncs {
-		wg.Add(1)
-		go func(e chan<- error, df func() ([]arpicee.RemoteCall, error)) {
-			r, err := df()
-			if err != nil {
-				e <- err
-			}
-			mu.Lock()
-			rpcs = append(rpcs, r...)
-			mu.Unlock()
-			wg.Done()
-		}(errsChan, f)
-	}
-	wg.Wait() // All discovery is complete
-	close(errsChan)
-	errwg.Wait() // All error reading is done
-
-	s.rpcs = rpcs
-	return resErr
-}
+// Metadata of the queued jobs: the channel reporting on the job, and the timestamp
+// of the message, once posted
+const (
+	metaChannel   = "slackChannel"
+	metaTimestamp = "slackTimestamp"
+)
 
-func New(appToken, botToken string) (*Slackbot, error) {
+// New returns a Slackbot submitting the RPCs invoked through Slack to q
+func New(appToken, botToken string, core *chat.Core, q *queue.Queue) (*Slackbot, error) {
 	slackClient := slack.New(
 		botToken,
 		slack.OptionDebug(true),
@@ -90,57 +55,115 @@ func New(appToken, botToken string) (*Slackbot, error) {
 		socketmode.OptionLog(log.New(os.Stdout, "socketmode: ", log.Lshortfile|log.LstdFlags)),
 	)
 
-	return &Slackbot{
+	sb := &Slackbot{
 		slackClient:  slackClient,
 		socketClient: socketClient,
-	}, nil
+		core:         core,
+		queue:        q,
+		pending:      map[string]pendingRun{},
+	}
+	q.AddListener(sb.jobUpdated)
+	return sb, nil
 }
 
-func argsFromView(params []arpicee.Parameter, state *slack.ViewState) ([]arpicee.Argument, error) {
-	args := []arpicee.Argument{}
-	for key, val := range state.Values {
-		v := val[key].Value
+// jobUpdated posts the result of the jobs submitted through Slack, updating the
+// message posted when the job started
+func (sb *Slackbot) jobUpdated(j queue.Job, e execution.Execution) {
+	channelID := j.Meta[metaChannel]
+	if channelID == "" {
+		return
+	}
+	if e.Status == execution.StatusFailed {
+		log.Printf("failed invoking RPC %s: %s", j.RPC, e.Error)
+	}
 
-		if val[key].Type == "checkboxes" {
-			if len(val[key].SelectedOptions) > 0 {
-				v = "true"
-			} else {
-				v = "false"
-			}
+	msg := slack.MsgOptionAttachments(views.RPCResult(e, j.User))
+	if ts := j.Meta[metaTimestamp]; ts != "" {
+		if _, _, _, err := sb.slackClient.UpdateMessage(channelID, ts, msg, slack.MsgOptionAsUser(true)); err != nil {
+			log.Printf("RPC %s invoked by %s: error posting results to Slack: %s", j.RPC, j.User, err)
 		}
+		return
+	}
 
-		for _, param := range params {
-			if param.Name == key {
-				switch param.Type {
-				case arpicee.TypeString:
-					if param.Required && v == "" {
-						return nil, fmt.Errorf("parameter %s is required", param.Name)
-					}
-					args = append(args, &arpicee.ArgumentString{
-						Name: key,
-						Val:  v,
-					})
-				case arpicee.TypeInt:
-					i, err := strconv.ParseInt(v, 10, 32)
-					if err != nil {
-						return nil, fmt.Errorf("parameter %s should be a number, could not parse given value: %s", param.Name, v)
-					}
-					args = append(args, &arpicee.ArgumentInt{
-						Name: key,
-						Val:  int(i),
-					})
-				case arpicee.TypeBool:
-					b := v == "true"
-					args = append(args, &arpicee.ArgumentBool{
-						Name: key,
-						Val:  b,
-					})
-				}
-			}
+	_, ts, err := sb.slackClient.PostMessage(channelID, msg, slack.MsgOptionAsUser(true))
+	if err != nil {
+		log.Printf("RPC %s invoked by %s - error posting message to Slack: %s", j.RPC, j.User, err)
+		return
+	}
+	if !e.Done() {
+		if err := sb.queue.SetMeta(j.ID, metaTimestamp, ts); err != nil {
+			log.Printf("RPC %s invoked by %s: %s", j.RPC, j.User, err)
 		}
 	}
+}
+
+// SetScheduler enables the "/arpicee schedule" command
+func (sb *Slackbot) SetScheduler(s *scheduler.Scheduler) {
+	sb.scheduler = s
+}
 
-	return args, nil
+// SetTargets sets the groups of targets of the "/arpicee fanout" command
+func (sb *Slackbot) SetTargets(targets map[string][]fanout.Target) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	sb.targets = targets
+}
+
+// Notify posts the text message msg to channelID
+func (sb *Slackbot) Notify(channelID, msg string) error {
+	_, _, err := sb.slackClient.PostMessage(channelID, slack.MsgOptionText(msg, false), slack.MsgOptionAsUser(true))
+	return err
+}
+
+// reloadRPCs discovers the RPCs again, and refreshes the App Home of userID
+func (sb *Slackbot) reloadRPCs(userID string) {
+	// Errors are shown in the App Home
+	if err := sb.core.Reload(); err != nil {
+		log.Printf("RPCs reloaded by %s: %s", userID, err)
+	}
+	if res, err := sb.socketClient.PublishView(userID, *views.AppHome(sb.core.RPCs(), sb.core.Status()), ""); err != nil {
+		log.Printf("failed publishing App Home: %s %+v", err, res)
+	}
+}
+
+// PostResult posts the result of e to channelID
+func (sb *Slackbot) PostResult(channelID string, e execution.Execution) error {
+	_, _, err := sb.slackClient.PostMessage(
+		channelID,
+		slack.MsgOptionAttachments(views.ExecutionResult(e)),
+		slack.MsgOptionAsUser(true),
+	)
+	return err
+}
+
+func argsFromView(params []arpicee.Parameter, state *slack.ViewState) ([]arpicee.Argument, error) {
+	values := map[string]string{}
+	for key, val := range state.Values {
+		v := val[key].Value
+		switch val[key].Type {
+		case "checkboxes":
+			v = strconv.FormatBool(len(val[key].SelectedOptions) > 0)
+		case "static_select":
+			v = val[key].SelectedOption.Value
+		}
+		values[key] = v
+	}
+
+	return chat.ArgsFromValues(params, values)
+}
+
+// submit queues an execution of rpc for user, reported in the channel channelID by
+// jobUpdated
+func (sb *Slackbot) submit(rpc arpicee.RemoteCall, args []arpicee.Argument, user slack.User, channelID string) {
+	_, err := sb.queue.Submit(rpc, args, user.ID, map[string]string{metaChannel: channelID})
+	var forbidden *execution.ForbiddenError
+	if errors.As(err, &forbidden) {
+		log.Printf("RPC %s invoked by %s: %s", rpc.Name(), user.Name, err)
+		sb.socketClient.PostEphemeral(channelID, user.ID, slack.MsgOptionText(fmt.Sprintf("You are not allowed to run *%s*", rpc.Name()), false))
+	} else if err != nil {
+		log.Printf("failed invoking RPC %s: %s", rpc.Name(), err)
+		sb.socketClient.PostEphemeral(channelID, user.ID, slack.MsgOptionText(fmt.Sprintf("Failed running *%s*: %s", rpc.Name(), err), false))
+	}
 }
 
 func getSlackIDFromCallback(externalID string) string {
@@ -154,11 +177,6 @@ func getSlackIDFromCallback(externalID string) string {
 }
 
 func (sb *Slackbot) Run() error {
-	if err := sb.reloadRPCs(); err != nil {
-		// Exit early if we fail loading RPCs when starting up
-		return fmt.Errorf("failed loading RPCs: %w", err)
-	}
-
 	go func() {
 		for evt := range sb.socketClient.Events {
 			switch evt.Type {
@@ -182,7 +200,7 @@ func (sb *Slackbot) Run() error {
 							log.Printf("failed posting message: %v", err)
 						}
 					case *slackevents.AppHomeOpenedEvent:
-						res, err := sb.socketClient.PublishView(ev.User, *views.AppHome(sb.rpcs), "")
+						res, err := sb.socketClient.PublishView(ev.User, *views.AppHome(sb.core.RPCs(), sb.core.Status()), "")
 						if err != nil {
 							log.Printf("failed posting message: %s %+v", err, res)
 						}
@@ -196,6 +214,7 @@ func (sb *Slackbot) Run() error {
 					continue
 				}
 
+				var payload interface{}
 				switch callback.Type {
 				case slack.InteractionTypeBlockActions:
 					for _, action := range callback.ActionCallback.BlockActions {
@@ -203,7 +222,11 @@ func (sb *Slackbot) Run() error {
 						// An RPC has been selected
 						case views.SelectRPCActionID:
 							// Open the invocation dialog for the selected RPC
-							rpc := sb.rpcByName(action.SelectedOption.Text.Text)
+							rpc := sb.core.RPCIn(callback.Channel.ID, action.SelectedOption.Text.Text)
+							if rpc == nil {
+								sb.socketClient.PostEphemeral(callback.Channel.ID, callback.User.ID, slack.MsgOptionText(fmt.Sprintf("*%s* is not available in this channel", action.SelectedOption.Text.Text), false))
+								continue
+							}
 							view := views.RunRPCDialog(callback.Channel.ID, rpc)
 							v, err := sb.socketClient.OpenView(callback.TriggerID, view)
 							if err != nil {
@@ -220,6 +243,9 @@ func (sb *Slackbot) Run() error {
 								slack.MsgOptionDeleteOriginal(callback.ResponseURL),
 							)
 
+						case views.ReloadRPCsActionID:
+							go sb.reloadRPCs(callback.User.ID)
+
 						default:
 							log.Printf("unknown actionid %s", action.ActionID)
 						}
@@ -233,55 +259,88 @@ func (sb *Slackbot) Run() error {
 							continue
 						}
 
-						rpc := sb.rpcByName(callback.View.PrivateMetadata)
 						channelID := getSlackIDFromCallback(callback.View.ExternalID)
+						rpc := sb.core.RPCIn(channelID, callback.View.PrivateMetadata)
+						if rpc == nil {
+							sb.socketClient.PostEphemeral(channelID, callback.User.ID, slack.MsgOptionText(fmt.Sprintf("*%s* is not available in this channel", callback.View.PrivateMetadata), false))
+							break
+						}
 
-						go func() {
-							args, err := argsFromView(rpc.Params(), callback.View.State)
-							if err != nil {
-								log.Printf("failed parsing args from view: %s", err)
-								return
-							}
+						// Invalid values are shown next to the fields of the dialog
+						args, err := argsFromView(rpc.Params(), callback.View.State)
+						var fieldErrors chat.FieldErrors
+						if errors.As(err, &fieldErrors) {
+							payload = slack.NewErrorsViewSubmissionResponse(fieldErrors)
+							break
+						}
 
-							msg := fmt.Sprintf("RPC *%s* invoked by <@%s>, currently running...", rpc.Name(), callback.User.ID)
-							_, ts, err := sb.socketClient.PostMessage(
-								channelID,
-								slack.MsgOptionAttachments(slack.Attachment{
-									Pretext: msg,
-								}),
-								slack.MsgOptionAsUser(true),
-							)
-							if err != nil {
-								log.Printf("RPC %s invoked by %s - error posting message to Slack: %s, not invoking RPC", rpc.Name(), callback.User.Name, err)
-								return
-							}
+						// Dangerous RPCs are confirmed in a second dialog
+						if confirmation := sb.core.Confirmation(rpc); confirmation != arpicee.ConfirmNone {
+							view := views.ConfirmRPCDialog(channelID, rpc, args, confirmation)
+							sb.addPending(view.ExternalID, pendingRun{rpc: rpc.Name(), userID: callback.User.ID, args: args})
+							payload = slack.NewPushViewSubmissionResponse(&view)
+							break
+						}
+						sb.submit(rpc, args, callback.User, channelID)
+					}
 
-							rpcres, err := rpc.Run(args)
-							if err != nil {
-								log.Printf("failed invoking RPC %s: %s", rpc.Name(), err)
-								return
-							}
-							payload, _ := views.RPCResult(rpc, callback.User, rpcres, err)
-							_, _, _, err = sb.socketClient.UpdateMessage(
-								channelID,
-								ts,
-								slack.MsgOptionAttachments(*payload),
-								slack.MsgOptionAsUser(true),
-							)
-							if err != nil {
-								log.Printf("RPC %s invoked by %s: error posting results to Slack: %s\n", rpc.Name(), callback.User.Name, err)
-							}
-						}()
+					if callback.View.CallbackID == views.ConfirmRPCDialogCallbackID {
+						channelID := getSlackIDFromCallback(callback.View.ExternalID)
+						p, ok := sb.takePending(callback.View.ExternalID, callback.User.ID)
+						if !ok {
+							payload = slack.NewClearViewSubmissionResponse()
+							sb.socketClient.PostEphemeral(channelID, callback.User.ID, slack.MsgOptionText("This confirmation has expired, please run the automation again", false))
+							break
+						}
+						rpc := sb.core.RPCIn(channelID, p.rpc)
+						if rpc == nil {
+							sb.socketClient.PostEphemeral(channelID, callback.User.ID, slack.MsgOptionText(fmt.Sprintf("*%s* is not available in this channel", p.rpc), false))
+							payload = slack.NewClearViewSubmissionResponse()
+							break
+						}
+						if sb.core.Confirmation(rpc) == arpicee.ConfirmName && views.ConfirmedName(callback.View) != rpc.Name() {
+							sb.addPending(callback.View.ExternalID, p)
+							payload = slack.NewErrorsViewSubmissionResponse(map[string]string{
+								views.ConfirmNameBlockID: fmt.Sprintf("Type %s to confirm", rpc.Name()),
+							})
+							break
+						}
+
+						// Both dialogs are closed
+						payload = slack.NewClearViewSubmissionResponse()
+						sb.submit(rpc, p.args, callback.User, channelID)
 					}
 				}
-				var payload interface{}
 				sb.socketClient.Ack(*evt.Request, payload)
 
 			case socketmode.EventTypeSlashCommand:
 				cmd, _ := evt.Data.(slack.SlashCommand)
 				sb.socketClient.Debugf("Slash command received: %+v", cmd)
+				fields, err := chat.SplitFields(cmd.Text)
+				if err != nil {
+					sb.socketClient.Ack(*evt.Request, map[string]interface{}{"text": err.Error()})
+					continue
+				}
+				if len(fields) > 0 && fields[0] == "schedule" {
+					sb.socketClient.Ack(*evt.Request, map[string]interface{}{
+						"text": scheduleCommand(sb.core, sb.scheduler, cmd.UserID, cmd.ChannelID, fields[1:]),
+					})
+					continue
+				}
+				if len(fields) > 0 && fields[0] == "fanout" {
+					sb.socketClient.Ack(*evt.Request, map[string]interface{}{
+						"text": sb.fanoutCommand(cmd.UserID, cmd.ChannelID, fields[1:]),
+					})
+					continue
+				}
+				// Other text searches the RPCs
+				rpcs := arpicee.Search(sb.core.RPCsIn(cmd.ChannelID), cmd.Text)
+				if len(rpcs) == 0 {
+					sb.socketClient.Ack(*evt.Request, map[string]interface{}{"text": fmt.Sprintf("No automation matches %s", cmd.Text)})
+					continue
+				}
 				sb.socketClient.Ack(*evt.Request, map[string]interface{}{
-					"blocks": views.SelectRPCDialog(sb.rpcs),
+					"blocks": views.SelectRPCDialog(rpcs),
 				})
 			default:
 				log.Printf("Unexpected event type received: %s\n", evt.Type)
@@ -3,58 +3,22 @@ package slackbot
 import (
 	"encoding/json"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/slack-go/slack"
 	"github.com/yannh/arpicee/pkg/arpicee"
-	"github.com/yannh/arpicee/pkg/githubrpc"
+	"github.com/yannh/arpicee/pkg/chat"
+	"github.com/yannh/arpicee/pkg/execution"
+	"github.com/yannh/arpicee/pkg/fanout"
+	"github.com/yannh/arpicee/pkg/mock"
+	"github.com/yannh/arpicee/pkg/policy"
+	"github.com/yannh/arpicee/pkg/registry/registrytest"
+	"github.com/yannh/arpicee/pkg/scheduler"
+	"github.com/yannh/arpicee/pkg/views"
 )
 
-func TestReloadRPCs(t *testing.T) {
-	mockDiscoverNil := func() ([]arpicee.RemoteCall, error) {
-		return nil, nil
-	}
-	mockDiscoverOne := func() ([]arpicee.RemoteCall, error) {
-		return []arpicee.RemoteCall{&githubrpc.GithubRPC{}}, nil
-	}
-	mockDiscoverTwo := func() ([]arpicee.RemoteCall, error) {
-		return []arpicee.RemoteCall{&githubrpc.GithubRPC{}, &githubrpc.GithubRPC{}}, nil
-	}
-
-	for i, testCase := range []struct {
-		discoverFuncs []func() ([]arpicee.RemoteCall, error)
-		expectRPCs    []arpicee.RemoteCall
-		expectErr     error
-	}{
-		{
-			[]func() ([]arpicee.RemoteCall, error){mockDiscoverNil},
-			nil,
-			nil,
-		},
-		{
-			[]func() ([]arpicee.RemoteCall, error){mockDiscoverNil, mockDiscoverTwo, mockDiscoverOne},
-			[]arpicee.RemoteCall{&githubrpc.GithubRPC{}, &githubrpc.GithubRPC{}, &githubrpc.GithubRPC{}},
-			nil,
-		},
-	} {
-		b := Slackbot{
-			discoverFuncs: testCase.discoverFuncs,
-		}
-		err := b.reloadRPCs()
-		if (err == nil && testCase.expectErr != nil) || (err != nil && testCase.expectErr == nil) {
-			t.Errorf("test %d, expected err to be %s, was %s", i, testCase.expectErr, err)
-		} else if err != nil && err.Error() != testCase.expectErr.Error() {
-			t.Errorf("test %d, expected err to be %s, was %s", i, testCase.expectErr, err)
-		}
-
-		for i, _ := range testCase.expectRPCs {
-			if !reflect.DeepEqual(b.rpcs[i], testCase.expectRPCs[i]) {
-				t.Errorf("test %d, expected rpcs to be %s, was %s", i, testCase.expectRPCs, b.rpcs)
-			}
-		}
-	}
-}
-
 func TestArgsFromView(t *testing.T) {
 	for testN, testCase := range []struct {
 		viewStateJSON string
@@ -187,3 +151,200 @@ func TestArgsFromView(t *testing.T) {
 		}
 	}
 }
+
+type discardPoster struct{}
+
+func (discardPoster) PostResult(channelID string, e execution.Execution) error { return nil }
+
+func TestScheduleCommand(t *testing.T) {
+	reg := registrytest.New(t,
+		mock.New("backup", []arpicee.Parameter{{Name: "db", Type: arpicee.TypeString, Required: true}}, nil),
+		mock.New("destroy", nil, nil),
+		mock.New("purge", nil, nil).WithMetadata(arpicee.Metadata{Risk: arpicee.RiskHigh}),
+		mock.New("debug", nil, nil).WithChannels("COPS"),
+	)
+	pol, err := policy.New([]policy.Rule{{RPC: "destroy", Users: []string{"UADMIN"}}})
+	if err != nil {
+		t.Fatalf("failed creating policy: %s", err)
+	}
+	executions := execution.NewManager(100)
+	executions.SetAuthorizer(pol)
+	sched, err := scheduler.New(reg, executions, []scheduler.Job{{Name: "wipe", Schedule: "@daily", RPC: "destroy"}, {Name: "trace", Schedule: "@daily", RPC: "debug"}}, "", discardPoster{})
+	if err != nil {
+		t.Fatalf("failed creating scheduler: %s", err)
+	}
+	core := chat.NewCore(reg, executions)
+
+	for i, testCase := range []struct {
+		command      string
+		expectReply  string
+		expectPaused bool
+	}{
+		{`add nightly "0 2 * * *" backup`, "parameter db is required", false},
+		{`add nightly "0 2 * * *" backup db=users region=eu`, "unknown parameter region", false},
+		{`add nightly "0 2 * * *" backup db=users --overlap=never`, "invalid overlap policy", false},
+		{`add nightly "0 2 * * *" backup db=users --color=red`, "unknown option --color", false},
+		{`add nightly "0 2 * * *" destroy`, "not allowed to run *destroy*", false},
+		{`add nightly "0 2 * * *" backup db=users --tz=Europe/Paris --jitter=5m`, "Job *nightly* scheduled", false},
+		{`list`, "*nightly* `0 2 * * *` (Europe/Paris) runs *backup* with `db=users`", false},
+		{`pause wipe`, "not allowed to run *destroy*", false},
+		{`pause nightly`, "Job *nightly* paused", true},
+		{`history nightly`, "has not run yet", true},
+		{`remove unknown`, "Unknown job *unknown*", true},
+		{`remove nightly`, "Job *nightly* removed", false},
+		{`add weekly "@weekly" purge`, "*purge* requires confirmation, run the command again with `--yes`", false},
+		{`add weekly "@weekly" purge --yes`, "Job *weekly* scheduled", false},
+		{`run weekly`, "*purge* requires confirmation", false},
+		{`remove weekly`, "Job *weekly* removed", false},
+		{`add hourly "@hourly" debug`, "Unknown remote procedure *debug*", false},
+		{`run trace`, "Unknown remote procedure *debug*", false},
+	} {
+		fields, _ := chat.SplitFields(testCase.command)
+		if reply := scheduleCommand(core, sched, "U123", "C123", fields); !strings.Contains(reply, testCase.expectReply) {
+			t.Errorf("test %d - expected reply containing %q, got %q", i, testCase.expectReply, reply)
+		}
+		for _, j := range sched.Jobs() {
+			if j.Name == "nightly" && (j.Paused != testCase.expectPaused || j.User != "U123" || j.SlackChannel != "C123") {
+				t.Errorf("test %d - unexpected job %+v", i, j)
+			}
+		}
+	}
+}
+
+func TestParseFanout(t *testing.T) {
+	for i, testCase := range []struct {
+		command   string
+		expect    *fanoutRequest
+		expectErr bool
+	}{
+		{
+			`deploy version=v2 --over=region=eu-west-1,us-east-1 --concurrency=2 --canary=1`,
+			&fanoutRequest{rpc: "deploy", values: map[string]string{"version": "v2"}, over: "region=eu-west-1,us-east-1", options: fanout.Options{Concurrency: 2, Canary: 1}},
+			false,
+		},
+		{
+			`deploy --targets=prod --max-failures=3`,
+			&fanoutRequest{rpc: "deploy", values: map[string]string{}, group: "prod", options: fanout.Options{MaxFailures: 3}},
+			false,
+		},
+		{
+			`deploy --yes --targets=prod`,
+			&fanoutRequest{rpc: "deploy", values: map[string]string{}, group: "prod", confirmed: true},
+			false,
+		},
+		{``, nil, true},
+		{`deploy --canary=one`, nil, true},
+		{`deploy --dryrun`, nil, true},
+		{`deploy version`, nil, true},
+	} {
+		fields, _ := chat.SplitFields(testCase.command)
+		fc, err := parseFanout(fields)
+		if (err != nil) != testCase.expectErr {
+			t.Errorf("test %d - expected error %t, got %v", i, testCase.expectErr, err)
+		}
+		if err == nil && !reflect.DeepEqual(fc, testCase.expect) {
+			t.Errorf("test %d - expected %+v, got %+v", i, testCase.expect, fc)
+		}
+	}
+}
+
+func TestConfirmCommand(t *testing.T) {
+	reg := registrytest.New(t,
+		mock.New("status", nil, nil),
+		mock.New("deploy", nil, nil).WithMetadata(arpicee.Metadata{Risk: arpicee.RiskHigh}),
+		mock.New("destroy", nil, nil).WithMetadata(arpicee.Metadata{Confirm: arpicee.ConfirmName}),
+	)
+	core := chat.NewCore(reg, execution.NewManager(100))
+
+	for i, testCase := range []struct {
+		rpc         string
+		command     string
+		expectReply string
+	}{
+		{"status", ``, ""},
+		{"deploy", ``, "*deploy* requires confirmation, run the command again with `--yes`\nRisk: high"},
+		{"deploy", `--yes`, ""},
+		{"deploy", `--yes=deploy`, ""},
+		{"deploy", `--yes=status`, "*deploy* requires confirmation"},
+		{"destroy", `--yes`, "*destroy* requires confirmation, run the command again with `--yes=destroy`"},
+		{"destroy", `--yes=destroy`, ""},
+	} {
+		fields, _ := chat.SplitFields(testCase.command)
+		_, yes, found := chat.TakeYes(fields)
+		reply := confirmCommand(core, core.RPC(testCase.rpc), yes, found)
+		if (testCase.expectReply == "" && reply != "") || !strings.HasPrefix(reply, testCase.expectReply) {
+			t.Errorf("test %d - expected reply %q, got %q", i, testCase.expectReply, reply)
+		}
+	}
+}
+
+func TestPending(t *testing.T) {
+	sb := &Slackbot{pending: map[string]pendingRun{}}
+	args := []arpicee.Argument{&arpicee.ArgumentString{Name: "service", Val: "api"}}
+	sb.addPending("C0123_1", pendingRun{rpc: "deploy", userID: "U1", args: args})
+	sb.pending["C0123_2"] = pendingRun{rpc: "deploy", userID: "U1", created: time.Now().Add(-2 * pendingTTL)}
+
+	if _, ok := sb.takePending("C0123_1", "U2"); ok {
+		t.Errorf("expected the run of another user not to be returned")
+	}
+	if _, ok := sb.takePending("C0123_2", "U1"); ok {
+		t.Errorf("expected the expired run not to be returned")
+	}
+	if p, ok := sb.takePending("C0123_1", "U1"); !ok || p.rpc != "deploy" || !reflect.DeepEqual(p.args, args) {
+		t.Errorf("expected the run of deploy, got %+v", p)
+	}
+	if _, ok := sb.takePending("C0123_1", "U1"); ok {
+		t.Errorf("expected the run to be confirmed only once")
+	}
+}
+
+func TestConfirmRPCDialog(t *testing.T) {
+	params := []arpicee.Parameter{
+		{Name: "service", Type: arpicee.TypeString, Required: true},
+		{Name: "replicas", Type: arpicee.TypeInt},
+		{Name: "force", Type: arpicee.TypeBool},
+		{Name: "token", Type: arpicee.TypeString, Secret: true},
+	}
+	rpc := mock.New("deploy", params, nil).WithMetadata(arpicee.Metadata{Risk: arpicee.RiskHigh})
+	args := []arpicee.Argument{
+		&arpicee.ArgumentString{Name: "service", Val: "api"},
+		&arpicee.ArgumentInt{Name: "replicas", Val: 3},
+		&arpicee.ArgumentBool{Name: "force", Val: true},
+		&arpicee.ArgumentString{Name: "token", Val: "hunter2"},
+	}
+
+	for i, testCase := range []struct {
+		confirmation arpicee.Confirmation
+		expectInput  bool
+	}{
+		{arpicee.ConfirmArguments, false},
+		{arpicee.ConfirmName, true},
+	} {
+		req := views.ConfirmRPCDialog("C0123", rpc, args, testCase.confirmation)
+		b, err := json.Marshal(req.Blocks)
+		if err != nil {
+			t.Fatalf("test %d - failed encoding the dialog: %s", i, err)
+		}
+		if strings.Contains(string(b), "hunter2") || !strings.Contains(string(b), "replicas: `3`") || !strings.Contains(string(b), "Risk: high") {
+			t.Errorf("test %d - expected the arguments and metadata, with secrets masked, got %s", i, b)
+		}
+		if got := strings.Contains(string(b), views.ConfirmNameBlockID); got != testCase.expectInput {
+			t.Errorf("test %d - expected name input %t, got %t", i, testCase.expectInput, got)
+		}
+		if channelID := getSlackIDFromCallback(req.ExternalID); channelID != "C0123" {
+			t.Errorf("test %d - expected channel C0123, got %s", i, channelID)
+		}
+
+		// The arguments are kept by the bot, not in the dialog
+		if req.PrivateMetadata != "deploy" {
+			t.Errorf("test %d - expected the private metadata to be the name of the RPC, got %s", i, req.PrivateMetadata)
+		}
+		var view slack.View
+		if err := json.Unmarshal([]byte(`{"state": {"values": {"confirm_name": {"confirm_name": {"type": "plain_text_input", "value": " deploy "}}}}}`), &view); err != nil {
+			t.Fatalf("test %d - failed decoding view: %s", i, err)
+		}
+		if views.ConfirmedName(view) != "deploy" {
+			t.Errorf("test %d - expected the typed name deploy, got %s", i, views.ConfirmedName(view))
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package ssmrpc
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -10,19 +11,23 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ssm"
 	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/filter"
 )
 
 type SSMRPC struct {
 	sess        *ssm.SSM
 	name        string
+	qualifier   string
 	description string
 	params      []arpicee.Parameter
+	metadata    arpicee.Metadata
 }
 
 type ssmDocParameter struct {
-	Type        string
-	Description string
-	Default     *string
+	Type          string
+	Description   string
+	Default       *string
+	AllowedValues []string
 }
 
 type ssmDocContent struct {
@@ -31,7 +36,13 @@ type ssmDocContent struct {
 }
 
 func (sr *SSMRPC) Name() string {
-	return sr.name
+	return sr.qualifier + sr.name
+}
+
+// Qualify prefixes the name of the RPC with qualifier, such as "123456789012:eu-west-1:",
+// to tell apart documents with the same name in different accounts and regions
+func (sr *SSMRPC) Qualify(qualifier string) {
+	sr.qualifier = qualifier
 }
 
 func (sr *SSMRPC) Description() string {
@@ -42,7 +53,53 @@ func (sr *SSMRPC) Params() []arpicee.Parameter {
 	return sr.params
 }
 
+func (sr *SSMRPC) Provider() string {
+	return "ssm"
+}
+
+// Metadata returns the metadata set by the tags of the document, see
+// arpicee.ParseMetadata
+func (sr *SSMRPC) Metadata() arpicee.Metadata {
+	return sr.metadata
+}
+
+// Ref returns the name of the document, without qualifier
+func (sr *SSMRPC) Ref() string {
+	return sr.name
+}
+
+// Restore returns the RPC running the document name, from a previous discovery
+func Restore(s *ssm.SSM, name, description string, params []arpicee.Parameter, metadata arpicee.Metadata) *SSMRPC {
+	return &SSMRPC{
+		sess:        s,
+		name:        name,
+		description: description,
+		params:      params,
+		metadata:    metadata,
+	}
+}
+
+// New returns the RPC running the document name, with the metadata set by its tags
 func New(s *ssm.SSM, name string) (*SSMRPC, error) {
+	d, err := newDocument(s, name)
+	if err != nil {
+		return nil, err
+	}
+	out, err := s.ListTagsForResource(&ssm.ListTagsForResourceInput{
+		ResourceType: aws.String(ssm.ResourceTypeForTaggingDocument),
+		ResourceId:   aws.String(name),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed retrieving tags of ssm document \"%s\": %w", name, err)
+	}
+	if d.metadata, err = arpicee.ParseMetadata(tags(out.TagList)); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// newDocument returns the RPC running the document name, without metadata
+func newDocument(s *ssm.SSM, name string) (*SSMRPC, error) {
 	ssmDoc, err := s.GetDocument(&ssm.GetDocumentInput{
 		DocumentFormat:  aws.String("JSON"),
 		DocumentVersion: nil,
@@ -68,11 +125,18 @@ func New(s *ssm.SSM, name string) (*SSMRPC, error) {
 				required = true
 			}
 
+			def := ""
+			if param.Default != nil {
+				def = *param.Default
+			}
+
 			params = append(params, arpicee.Parameter{
-				Name:        paramName,
-				Type:        arpicee.TypeString,
-				Description: param.Description,
-				Required:    required,
+				Name:          paramName,
+				Type:          arpicee.TypeString,
+				Description:   param.Description,
+				Required:      required,
+				Default:       def,
+				AllowedValues: param.AllowedValues,
 			})
 		case "bool":
 			params = append(params, arpicee.Parameter{
@@ -92,23 +156,13 @@ func New(s *ssm.SSM, name string) (*SSMRPC, error) {
 	}, nil
 }
 
-func TagFilter(tagName, tagValue string) func(configuration *ssm.DocumentIdentifier) bool {
-	return func(ssm *ssm.DocumentIdentifier) bool {
-		if len(ssm.Tags) == 0 {
-			return false
-		}
-		for _, t := range ssm.Tags {
-			if *t.Key == tagName {
-				if *t.Value == tagValue {
-					return true
-				}
-			}
-		}
-		return false
-	}
+func (s *SSMRPC) Run(args []arpicee.Argument) (map[string]interface{}, error) {
+	return s.RunContext(context.Background(), args)
 }
 
-func (s *SSMRPC) Run(args []arpicee.Argument) (map[string]interface{}, error) {
+// RunContext starts the automation and waits for it to complete. If ctx is
+// cancelled, the automation execution is stopped.
+func (s *SSMRPC) RunContext(ctx context.Context, args []arpicee.Argument) (map[string]interface{}, error) {
 	sInputParams := map[string][]*string{}
 	for _, sa := range args {
 		switch sat := sa.(type) {
@@ -120,20 +174,46 @@ func (s *SSMRPC) Run(args []arpicee.Argument) (map[string]interface{}, error) {
 		DocumentName: aws.String(s.name),
 		Parameters:   sInputParams,
 	}
-	o, err := s.sess.StartAutomationExecution(&sInput)
+	o, err := s.sess.StartAutomationExecutionWithContext(ctx, &sInput)
 	if err != nil {
-		log.Fatalf("failed starting automation: %s", err)
+		return nil, fmt.Errorf("failed starting automation: %w", err)
 	}
 
 	id := o.AutomationExecutionId
+	arpicee.ReportExternalID(ctx, *id)
+	arpicee.ReportProgress(ctx, fmt.Sprintf("automation execution %s started", *id))
+	return s.wait(ctx, id)
+}
+
+// Resume waits for the automation execution with the ID id to complete
+func (s *SSMRPC) Resume(ctx context.Context, id string) (map[string]interface{}, error) {
+	return s.wait(ctx, aws.String(id))
+}
+
+// wait polls the automation execution id until it completes. If ctx is cancelled,
+// the automation execution is stopped.
+func (s *SSMRPC) wait(ctx context.Context, id *string) (map[string]interface{}, error) {
+	var err error
 	var execution *ssm.GetAutomationExecutionOutput
+	status := ""
 	for complete := false; complete == false; {
-		execution, err = s.sess.GetAutomationExecution(&ssm.GetAutomationExecutionInput{AutomationExecutionId: id})
+		execution, err = s.sess.GetAutomationExecutionWithContext(ctx, &ssm.GetAutomationExecutionInput{AutomationExecutionId: id})
 		if err != nil {
-			log.Fatalf("error getting Automation execution: %s", err)
+			if ctx.Err() != nil {
+				return nil, s.stop(id, ctx.Err())
+			}
+			return nil, fmt.Errorf("error getting Automation execution: %w", err)
 		}
-		if *execution.AutomationExecution.AutomationExecutionStatus == "InProgress" {
-			time.Sleep(1 * time.Second)
+		if newStatus := *execution.AutomationExecution.AutomationExecutionStatus; newStatus != status {
+			status = newStatus
+			arpicee.ReportProgress(ctx, fmt.Sprintf("automation execution %s: %s", *id, status))
+		}
+		if status == "InProgress" || status == "Pending" {
+			select {
+			case <-ctx.Done():
+				return nil, s.stop(id, ctx.Err())
+			case <-time.After(1 * time.Second):
+			}
 		} else {
 			complete = true
 		}
@@ -146,9 +226,29 @@ func (s *SSMRPC) Run(args []arpicee.Argument) (map[string]interface{}, error) {
 	return res, nil
 }
 
-func Discover(svc *ssm.SSM, filters []func(configuration *ssm.DocumentIdentifier) bool) ([]*SSMRPC, error) {
+func (s *SSMRPC) stop(id *string, cause error) error {
+	if _, err := s.sess.StopAutomationExecution(&ssm.StopAutomationExecutionInput{AutomationExecutionId: id}); err != nil {
+		log.Printf("failed stopping automation execution %s: %s", *id, err)
+	}
+	return cause
+}
+
+// tags returns the tags of a document as a map
+func tags(ts []*ssm.Tag) map[string]string {
+	res := map[string]string{}
+	for _, t := range ts {
+		res[aws.StringValue(t.Key)] = aws.StringValue(t.Value)
+	}
+	return res
+}
+
+// Discover returns the documents selected by match, or all documents if match is nil.
+// Documents that can not be retrieved are returned as arpicee.DiscoveryErrors, along
+// with the other documents.
+func Discover(svc *ssm.SSM, match filter.Matcher) ([]*SSMRPC, error) {
 	var err error
 	var ssmRPC []*SSMRPC
+	var itemErrs arpicee.DiscoveryErrors
 	result := &ssm.ListDocumentsOutput{}
 	nCall := 1
 
@@ -161,20 +261,22 @@ func Discover(svc *ssm.SSM, filters []func(configuration *ssm.DocumentIdentifier
 			return nil, err
 		}
 
-	OUTER:
 		for _, doc := range result.DocumentIdentifiers {
-			for _, filter := range filters {
-				if !filter(doc) {
-					continue OUTER
-				}
+			if match != nil && !match(filter.Item{Name: *doc.Name, Tags: tags(doc.Tags)}) {
+				continue
+			}
+			d, err := newDocument(svc, *doc.Name)
+			if err == nil {
+				// Documents do not have their tags, unlike their identifiers
+				d.metadata, err = arpicee.ParseMetadata(tags(doc.Tags))
 			}
-			d, err := New(svc, *doc.Name)
 			if err != nil {
-				return nil, fmt.Errorf("failed retrieving SSM Document: %w", err)
+				itemErrs = append(itemErrs, arpicee.ItemError{Item: *doc.Name, Err: err})
+				continue
 			}
 			ssmRPC = append(ssmRPC, d)
 		}
 	}
 
-	return ssmRPC, nil
+	return ssmRPC, itemErrs.Err()
 }
@@ -0,0 +1,252 @@
+package teams
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultOpenIDMetadataURL = "https://login.botframework.com/v1/.well-known/openidconfiguration"
+	botFrameworkIssuer       = "https://api.botframework.com"
+	botFrameworkScope        = "https://api.botframework.com/.default"
+)
+
+// Verifier validates the JWT the Bot Framework sends with every activity, signed
+// with the keys published in its OpenID metadata
+type Verifier struct {
+	metadataURL string
+	appID       string
+	httpClient  *http.Client
+
+	mu      sync.Mutex
+	keys    map[string]*rsa.PublicKey
+	fetched time.Time
+}
+
+func NewVerifier(metadataURL, appID string) *Verifier {
+	return &Verifier{
+		metadataURL: metadataURL,
+		appID:       appID,
+		httpClient:  &http.Client{Timeout: 30 * time.Second},
+		keys:        map[string]*rsa.PublicKey{},
+	}
+}
+
+type claims struct {
+	Issuer     string      `json:"iss"`
+	Audience   interface{} `json:"aud"`
+	Expiry     int64       `json:"exp"`
+	NotBefore  int64       `json:"nbf"`
+	ServiceURL string      `json:"serviceurl"`
+}
+
+func (c claims) hasAudience(aud string) bool {
+	switch a := c.Audience.(type) {
+	case string:
+		return a == aud
+	case []interface{}:
+		for _, v := range a {
+			if v == aud {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (v *Verifier) getJSON(u string, out interface{}) error {
+	resp, err := v.httpClient.Get(u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: unexpected status %d", u, resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (v *Verifier) refreshKeys() error {
+	var metadata struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	if err := v.getJSON(v.metadataURL, &metadata); err != nil {
+		return fmt.Errorf("failed fetching OpenID metadata: %w", err)
+	}
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := v.getJSON(metadata.JWKSURI, &jwks); err != nil {
+		return fmt.Errorf("failed fetching signing keys: %w", err)
+	}
+
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	v.keys = keys
+	v.fetched = time.Now()
+	return nil
+}
+
+// key returns the signing key kid; keys are refreshed daily, or when an unknown
+// key is used, at most every 5 minutes
+func (v *Verifier) key(kid string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	k, ok := v.keys[kid]
+	if (!ok && time.Since(v.fetched) > 5*time.Minute) || time.Since(v.fetched) > 24*time.Hour {
+		if err := v.refreshKeys(); err != nil {
+			return nil, err
+		}
+		k, ok = v.keys[kid]
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %s", kid)
+	}
+	return k, nil
+}
+
+// Verify checks the bearer token in the Authorization header of r was issued by the
+// Bot Framework for this bot and serviceURL
+func (v *Verifier) Verify(r *http.Request, serviceURL string) error {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New("missing or malformed bearer token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return err
+	}
+	if header.Alg != "RS256" {
+		return fmt.Errorf("unsupported token algorithm %s", header.Alg)
+	}
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return errors.New("invalid token signature")
+	}
+
+	var c claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return err
+	}
+	// Allow 5 minutes of clock skew
+	now := time.Now().Unix()
+	switch {
+	case c.Issuer != botFrameworkIssuer:
+		return fmt.Errorf("invalid token issuer %s", c.Issuer)
+	case !c.hasAudience(v.appID):
+		return errors.New("token not issued for this bot")
+	case c.Expiry < now-300:
+		return errors.New("token expired")
+	case c.NotBefore > now+300:
+		return errors.New("token not valid yet")
+	case c.ServiceURL != "" && c.ServiceURL != serviceURL:
+		return errors.New("token not issued for this service URL")
+	}
+	return nil
+}
+
+func decodeSegment(seg string, out interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return fmt.Errorf("malformed token: %w", err)
+	}
+	if err := json.Unmarshal(b, out); err != nil {
+		return fmt.Errorf("malformed token: %w", err)
+	}
+	return nil
+}
+
+// tokenSource gets the tokens the bot authenticates with against the Bot Connector
+// service, with the OAuth client credentials flow
+type tokenSource struct {
+	tokenURL    string
+	appID       string
+	appPassword string
+	httpClient  *http.Client
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// TokenURL returns the URL bot tokens are requested from; tenantID is only set for single tenant bots
+func TokenURL(tenantID string) string {
+	if tenantID == "" {
+		tenantID = "botframework.com"
+	}
+	return "https://login.microsoftonline.com/" + url.PathEscape(tenantID) + "/oauth2/v2.0/token"
+}
+
+func (ts *tokenSource) Token() (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.token != "" && time.Now().Before(ts.expiry) {
+		return ts.token, nil
+	}
+
+	resp, err := ts.httpClient.PostForm(ts.tokenURL, url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {ts.appID},
+		"client_secret": {ts.appPassword},
+		"scope":         {botFrameworkScope},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed getting a bot token: unexpected status %d", resp.StatusCode)
+	}
+	var res struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return "", err
+	}
+	ts.token = res.AccessToken
+	ts.expiry = time.Now().Add(time.Duration(res.ExpiresIn)*time.Second - 5*time.Minute)
+	return ts.token, nil
+}
@@ -0,0 +1,176 @@
+package teams
+
+import (
+	"fmt"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/chat"
+	"github.com/yannh/arpicee/pkg/execution"
+)
+
+const (
+	actionSelect = "select"
+	actionRun    = "run"
+)
+
+type card map[string]interface{}
+
+func adaptiveCard(body []card, actions []card) Attachment {
+	c := card{
+		"type":    "AdaptiveCard",
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"version": "1.4",
+		"body":    body,
+	}
+	if len(actions) > 0 {
+		c["actions"] = actions
+	}
+	return Attachment{ContentType: "application/vnd.microsoft.card.adaptive", Content: c}
+}
+
+func textBlock(text string) card {
+	return card{"type": "TextBlock", "text": text, "wrap": true}
+}
+
+// SelectRPCCard lets the user pick the RPC to run, in a list grouped by category that
+// can be filtered by typing
+func SelectRPCCard(rpcs []arpicee.RemoteCall) Attachment {
+	choices := []card{}
+	groups := arpicee.GroupByCategory(rpcs)
+	for _, g := range groups {
+		for _, rpc := range g.RPCs {
+			title := rpc.Name()
+			if len(groups) > 1 || g.Category != arpicee.Uncategorized {
+				title = g.Category + ": " + title
+			}
+			choices = append(choices, card{"title": title, "value": rpc.Name()})
+		}
+	}
+	return adaptiveCard(
+		[]card{
+			textBlock("Select an automation"),
+			{"type": "Input.ChoiceSet", "id": "rpc", "choices": choices, "style": "filtered", "isRequired": true},
+		},
+		[]card{
+			{"type": "Action.Submit", "title": "Select", "data": card{"action": actionSelect}},
+		},
+	)
+}
+
+func fieldInput(f chat.Field, value string) card {
+	label := f.Label
+	if f.Description != "" && f.Kind != chat.FieldCheckbox {
+		label = fmt.Sprintf("%s (%s)", f.Label, f.Description)
+	}
+	input := card{"id": f.Name, "label": label, "isRequired": f.Required}
+
+	switch f.Kind {
+	case chat.FieldCheckbox:
+		title := f.Description
+		if title == "" {
+			title = f.Name
+		}
+		input["type"] = "Input.Toggle"
+		input["title"] = title
+		input["valueOn"] = "true"
+		input["valueOff"] = "false"
+		input["isRequired"] = false
+		if value == "" {
+			value = "false"
+		}
+	case chat.FieldSelect:
+		choices := []card{}
+		for _, v := range f.AllowedValues {
+			choices = append(choices, card{"title": v, "value": v})
+		}
+		input["type"] = "Input.ChoiceSet"
+		input["choices"] = choices
+	case chat.FieldNumber:
+		input["type"] = "Input.Number"
+	case chat.FieldPassword:
+		input["type"] = "Input.Text"
+		input["style"] = "password"
+	default:
+		input["type"] = "Input.Text"
+	}
+	if value != "" {
+		input["value"] = value
+	}
+	return input
+}
+
+// RunRPCCard is the form asking for the parameters of rpc, and for the confirmation it
+// requires, see chat.FormFields. On errors, the form is shown again with the values
+// entered and the errors under the fields.
+func RunRPCCard(rpc arpicee.RemoteCall, confirmation arpicee.Confirmation, values map[string]string, errs chat.FieldErrors) Attachment {
+	body := []card{
+		{"type": "TextBlock", "text": rpc.Name(), "size": "Large", "weight": "Bolder", "wrap": true},
+	}
+	if rpc.Description() != "" {
+		body = append(body, textBlock(rpc.Description()))
+	}
+	for _, line := range arpicee.MetadataOf(rpc).Summary() {
+		metadata := textBlock(line)
+		metadata["isSubtle"] = true
+		metadata["spacing"] = "None"
+		body = append(body, metadata)
+	}
+
+	for _, f := range chat.FormFields(rpc, confirmation) {
+		value, ok := values[f.Name]
+		if !ok {
+			value = f.Default
+		}
+		if f.Kind == chat.FieldPassword || f.Name == chat.ConfirmField {
+			value = ""
+		}
+		body = append(body, fieldInput(f, value))
+		if msg, ok := errs[f.Name]; ok {
+			errBlock := textBlock(msg)
+			errBlock["color"] = "Attention"
+			errBlock["spacing"] = "None"
+			body = append(body, errBlock)
+		}
+	}
+
+	return adaptiveCard(body, []card{
+		{"type": "Action.Submit", "title": "Run", "data": card{"action": actionRun, "rpc": rpc.Name()}},
+	})
+}
+
+// containerStyle maps the status of an execution to a container style, Adaptive
+// Cards do not support arbitrary colours
+func containerStyle(status execution.Status) string {
+	switch status {
+	case execution.StatusSucceeded:
+		return "good"
+	case execution.StatusFailed:
+		return "attention"
+	case execution.StatusRunning:
+		return "accent"
+	default:
+		return "default"
+	}
+}
+
+// ResultCard reports on the execution e
+func ResultCard(e execution.Execution, userName string) Attachment {
+	body := []card{}
+	switch e.Status {
+	case execution.StatusRunning:
+		body = append(body, textBlock(fmt.Sprintf("RPC **%s** invoked by %s, currently running...", e.RPC, userName)))
+	case execution.StatusFailed:
+		body = append(body,
+			textBlock(fmt.Sprintf("Remote procedure **%s** invoked by %s", e.RPC, userName)),
+			card{"type": "TextBlock", "text": "Error Message", "weight": "Bolder"},
+			card{"type": "TextBlock", "text": chat.Output(e), "wrap": true, "fontType": "Monospace"},
+		)
+	default:
+		body = append(body,
+			textBlock(fmt.Sprintf("Remote procedure **%s** invoked by %s", e.RPC, userName)),
+			card{"type": "TextBlock", "text": "Result", "weight": "Bolder"},
+			card{"type": "TextBlock", "text": chat.Output(e), "wrap": true, "fontType": "Monospace"},
+		)
+	}
+	return adaptiveCard([]card{{"type": "Container", "style": containerStyle(e.Status), "items": body}}, nil)
+}
@@ -0,0 +1,110 @@
+package teams
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type ChannelAccount struct {
+	ID          string `json:"id,omitempty"`
+	Name        string `json:"name,omitempty"`
+	AADObjectID string `json:"aadObjectId,omitempty"`
+}
+
+type ConversationAccount struct {
+	ID string `json:"id"`
+}
+
+type Attachment struct {
+	ContentType string      `json:"contentType"`
+	Content     interface{} `json:"content"`
+}
+
+// Activity is a message exchanged with the Bot Framework
+type Activity struct {
+	Type         string                 `json:"type"`
+	ID           string                 `json:"id,omitempty"`
+	ServiceURL   string                 `json:"serviceUrl,omitempty"`
+	From         *ChannelAccount        `json:"from,omitempty"`
+	Conversation *ConversationAccount   `json:"conversation,omitempty"`
+	ReplyToID    string                 `json:"replyToId,omitempty"`
+	Text         string                 `json:"text,omitempty"`
+	Value        map[string]interface{} `json:"value,omitempty"`
+	Attachments  []Attachment           `json:"attachments,omitempty"`
+}
+
+// Client sends activities to the Bot Connector service of a conversation
+type Client struct {
+	tokens     *tokenSource
+	httpClient *http.Client
+}
+
+// NewClient returns a Client authenticating as the bot appID, with tokens from tokenURL
+func NewClient(tokenURL, appID, appPassword string) *Client {
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	return &Client{
+		tokens: &tokenSource{
+			tokenURL:    tokenURL,
+			appID:       appID,
+			appPassword: appPassword,
+			httpClient:  httpClient,
+		},
+		httpClient: httpClient,
+	}
+}
+
+func (c *Client) do(method, u string, body, out interface{}) error {
+	token, err := c.tokens.Token()
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, u, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		return fmt.Errorf("%s %s: unexpected status %d: %s", method, u, resp.StatusCode, msg)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func activitiesURL(serviceURL, conversationID string) string {
+	return strings.TrimSuffix(serviceURL, "/") + "/v3/conversations/" + url.PathEscape(conversationID) + "/activities"
+}
+
+// SendActivity posts a to the conversation and returns the ID of the new activity
+func (c *Client) SendActivity(serviceURL, conversationID string, a Activity) (string, error) {
+	var res struct {
+		ID string `json:"id"`
+	}
+	err := c.do(http.MethodPost, activitiesURL(serviceURL, conversationID), a, &res)
+	return res.ID, err
+}
+
+// UpdateActivity replaces the activity activityID, used to update cards
+func (c *Client) UpdateActivity(serviceURL, conversationID, activityID string, a Activity) error {
+	a.ID = activityID
+	return c.do(http.MethodPut, activitiesURL(serviceURL, conversationID)+"/"+url.PathEscape(activityID), a, nil)
+}
@@ -0,0 +1,191 @@
+package teams
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/chat"
+	"github.com/yannh/arpicee/pkg/execution"
+)
+
+const MessagesPath = "/teams/messages"
+
+// Bot is the Bot Framework messaging endpoint: users mention the bot with the name
+// of an RPC, fill in the Adaptive Card form, and the card is updated with the result.
+type Bot struct {
+	client   *Client
+	verifier *Verifier
+	core     *chat.Core
+}
+
+func New(client *Client, verifier *Verifier, core *chat.Core) *Bot {
+	return &Bot{
+		client:   client,
+		verifier: verifier,
+		core:     core,
+	}
+}
+
+// Register adds the messaging endpoint to mux
+func (b *Bot) Register(mux *http.ServeMux) {
+	mux.Handle(MessagesPath, b)
+}
+
+var mentions = regexp.MustCompile(`<at>[^<]*</at>`)
+
+// User returns the user executions are attributed to, in access rules, for the Teams
+// user with the given ID
+func User(id string) string {
+	return "teams:" + id
+}
+
+// user returns the identifier of the user in access rules, see User: their Azure AD
+// object ID
+func user(a Activity) string {
+	if a.From == nil {
+		return ""
+	}
+	if a.From.AADObjectID != "" {
+		return User(a.From.AADObjectID)
+	}
+	return User(a.From.ID)
+}
+
+func userName(a Activity) string {
+	if a.From == nil {
+		return ""
+	}
+	return a.From.Name
+}
+
+func (b *Bot) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var a Activity
+	if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := b.verifier.Verify(r, a.ServiceURL); err != nil {
+		log.Printf("refused Teams activity: %s", err)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if a.Type != "message" || a.Conversation == nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	var err error
+	if action, _ := a.Value["action"].(string); action != "" {
+		err = b.submit(a, action)
+	} else {
+		err = b.message(a)
+	}
+	if err != nil {
+		log.Printf("failed replying to Teams activity: %s", err)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (b *Bot) reply(a Activity, reply Activity) error {
+	reply.Type = "message"
+	reply.ReplyToID = a.ID
+	_, err := b.client.SendActivity(a.ServiceURL, a.Conversation.ID, reply)
+	return err
+}
+
+// updateCard replaces the card the action was submitted from
+func (b *Bot) updateCard(a Activity, card Attachment) error {
+	return b.client.UpdateActivity(a.ServiceURL, a.Conversation.ID, a.ReplyToID, Activity{
+		Type:        "message",
+		Attachments: []Attachment{card},
+	})
+}
+
+func (b *Bot) forbidden(a Activity, rpc arpicee.RemoteCall, err error) error {
+	log.Printf("RPC %s invoked by %s: %s", rpc.Name(), userName(a), err)
+	return b.reply(a, Activity{Text: fmt.Sprintf("You are not allowed to run **%s**", rpc.Name())})
+}
+
+// message handles the messages mentioning the bot, with the name of the RPC to run
+func (b *Bot) message(a Activity) error {
+	text := strings.TrimSpace(mentions.ReplaceAllString(a.Text, ""))
+	available := b.core.RPCsIn(a.Conversation.ID)
+	if text == "" || text == "help" {
+		return b.reply(a, Activity{Attachments: []Attachment{SelectRPCCard(available)}})
+	}
+
+	rpc := b.core.RPCIn(a.Conversation.ID, strings.Fields(text)[0])
+	if rpc == nil {
+		// Other text searches the RPCs
+		if rpcs := arpicee.Search(available, text); len(rpcs) > 0 {
+			return b.reply(a, Activity{Attachments: []Attachment{SelectRPCCard(rpcs)}})
+		}
+		return b.reply(a, Activity{
+			Text:        fmt.Sprintf("Unknown remote procedure **%s**", strings.Fields(text)[0]),
+			Attachments: []Attachment{SelectRPCCard(available)},
+		})
+	}
+	if err := b.core.Authorize(user(a), rpc); err != nil {
+		return b.forbidden(a, rpc, err)
+	}
+	return b.reply(a, Activity{Attachments: []Attachment{RunRPCCard(rpc, b.core.Confirmation(rpc), nil, nil)}})
+}
+
+// submit handles the actions submitted from the cards
+func (b *Bot) submit(a Activity, action string) error {
+	name, _ := a.Value["rpc"].(string)
+	rpc := b.core.RPCIn(a.Conversation.ID, name)
+	if rpc == nil {
+		return b.reply(a, Activity{Text: fmt.Sprintf("Unknown remote procedure **%s**", name)})
+	}
+	if err := b.core.Authorize(user(a), rpc); err != nil {
+		return b.forbidden(a, rpc, err)
+	}
+
+	switch action {
+	case actionSelect:
+		return b.updateCard(a, RunRPCCard(rpc, b.core.Confirmation(rpc), nil, nil))
+
+	case actionRun:
+		values := chat.StringValues(a.Value)
+		delete(values, "action")
+		delete(values, "rpc")
+		confirmation := b.core.Confirmation(rpc)
+		args, err := chat.ArgsFromForm(rpc, confirmation, values)
+		var fieldErrors chat.FieldErrors
+		if errors.As(err, &fieldErrors) {
+			return b.updateCard(a, RunRPCCard(rpc, confirmation, values, fieldErrors))
+		}
+		go b.run(a, rpc, args)
+		return nil
+	}
+	return fmt.Errorf("unknown card action %s", action)
+}
+
+func (b *Bot) run(a Activity, rpc arpicee.RemoteCall, args []arpicee.Argument) {
+	err := b.core.Run(context.Background(), rpc, args, user(a), func(e execution.Execution) {
+		if err := b.updateCard(a, ResultCard(e, userName(a))); err != nil {
+			log.Printf("RPC %s invoked by %s: error posting to Teams: %s", rpc.Name(), userName(a), err)
+		}
+	})
+
+	var forbidden *execution.ForbiddenError
+	if errors.As(err, &forbidden) {
+		log.Printf("RPC %s invoked by %s: %s", rpc.Name(), userName(a), err)
+		return
+	}
+	if err != nil {
+		log.Printf("failed invoking RPC %s: %s", rpc.Name(), err)
+	}
+}
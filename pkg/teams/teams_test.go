@@ -0,0 +1,239 @@
+package teams
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/chat"
+	"github.com/yannh/arpicee/pkg/execution"
+	"github.com/yannh/arpicee/pkg/mock"
+	"github.com/yannh/arpicee/pkg/policy"
+	"github.com/yannh/arpicee/pkg/registry/registrytest"
+)
+
+// fakeBotFramework is a local stand-in for the Bot Framework: it publishes the signing
+// keys, issues bot tokens, and records the activities sent to the connector service
+type fakeBotFramework struct {
+	srv *httptest.Server
+	key *rsa.PrivateKey
+
+	mu      sync.Mutex
+	sent    []Activity
+	updated map[string]Activity
+}
+
+func newFakeBotFramework(t *testing.T) *fakeBotFramework {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed generating key: %s", err)
+	}
+	f := &fakeBotFramework{key: key, updated: map[string]Activity{}}
+	f.srv = httptest.NewServer(f)
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+func (f *fakeBotFramework) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.URL.Path == "/openid":
+		json.NewEncoder(w).Encode(map[string]string{"jwks_uri": f.srv.URL + "/keys"})
+	case r.URL.Path == "/keys":
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kid": "key1",
+			"kty": "RSA",
+			"n":   base64.RawURLEncoding.EncodeToString(f.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(f.key.E)).Bytes()),
+		}}})
+	case r.URL.Path == "/token":
+		r.ParseForm()
+		if r.PostForm.Get("client_secret") != "app-password" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "bot-token", "expires_in": 3600})
+	case r.Header.Get("Authorization") != "Bearer bot-token":
+		w.WriteHeader(http.StatusUnauthorized)
+	case r.Method == http.MethodPost && r.URL.Path == "/v3/conversations/conv1/activities":
+		var a Activity
+		json.NewDecoder(r.Body).Decode(&a)
+		f.sent = append(f.sent, a)
+		json.NewEncoder(w).Encode(map[string]string{"id": "sent1"})
+	case r.Method == http.MethodPut && strings.HasPrefix(r.URL.Path, "/v3/conversations/conv1/activities/"):
+		var a Activity
+		json.NewDecoder(r.Body).Decode(&a)
+		f.updated[strings.TrimPrefix(r.URL.Path, "/v3/conversations/conv1/activities/")] = a
+		w.Write([]byte(`{}`))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeBotFramework) token(t *testing.T, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "key1", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, f.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("failed signing token: %s", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func newTestBot(t *testing.T) (*fakeBotFramework, *Bot) {
+	f := newFakeBotFramework(t)
+
+	reg := registrytest.New(t,
+		mock.New("deploy", []arpicee.Parameter{
+			{Name: "env", Type: arpicee.TypeString, Required: true, AllowedValues: []string{"staging", "production"}},
+			{Name: "dryrun", Type: arpicee.TypeBool},
+		}, nil),
+		mock.New("restricted", nil, nil),
+		mock.New("purge", nil, nil).WithMetadata(arpicee.Metadata{Risk: arpicee.RiskHigh}),
+		mock.New("debug", nil, nil).WithChannels("conv2"),
+	)
+	mgr := execution.NewManager(10)
+	p, err := policy.New([]policy.Rule{{RPC: "restricted", Users: []string{"admin"}}})
+	if err != nil {
+		t.Fatalf("failed creating policy: %s", err)
+	}
+	mgr.SetAuthorizer(p)
+
+	b := New(NewClient(f.srv.URL+"/token", "app1", "app-password"), NewVerifier(f.srv.URL+"/openid", "app1"), chat.NewCore(reg, mgr))
+	return f, b
+}
+
+func TestVerify(t *testing.T) {
+	f, b := newTestBot(t)
+	now := time.Now().Unix()
+	valid := map[string]interface{}{"iss": botFrameworkIssuer, "aud": "app1", "exp": now + 600, "nbf": now - 60, "serviceurl": f.srv.URL}
+
+	for i, testCase := range []struct {
+		token     string
+		expectErr bool
+	}{
+		{f.token(t, valid), false},
+		{"", true},
+		{f.token(t, map[string]interface{}{"iss": botFrameworkIssuer, "aud": "other-app", "exp": now + 600}), true},
+		{f.token(t, map[string]interface{}{"iss": "https://example.com", "aud": "app1", "exp": now + 600}), true},
+		{f.token(t, map[string]interface{}{"iss": botFrameworkIssuer, "aud": "app1", "exp": now - 3600}), true},
+		{f.token(t, map[string]interface{}{"iss": botFrameworkIssuer, "aud": "app1", "exp": now + 600, "serviceurl": "https://evil.example.com"}), true},
+		{f.token(t, valid)[:len(f.token(t, valid))-4] + "AAAA", true},
+	} {
+		r := httptest.NewRequest(http.MethodPost, MessagesPath, nil)
+		r.Header.Set("Authorization", "Bearer "+testCase.token)
+		if err := b.verifier.Verify(r, f.srv.URL); (err != nil) != testCase.expectErr {
+			t.Errorf("test %d - expected error %t, got %v", i, testCase.expectErr, err)
+		}
+	}
+}
+
+func TestActivities(t *testing.T) {
+	f, b := newTestBot(t)
+	now := time.Now().Unix()
+	token := f.token(t, map[string]interface{}{"iss": botFrameworkIssuer, "aud": "app1", "exp": now + 600, "serviceurl": f.srv.URL})
+
+	send := func(a Activity) int {
+		a.Type = "message"
+		a.ServiceURL = f.srv.URL
+		a.Conversation = &ConversationAccount{ID: "conv1"}
+		a.From = &ChannelAccount{ID: "29:1", Name: "Alice", AADObjectID: "alice-oid"}
+		body, _ := json.Marshal(a)
+		r := httptest.NewRequest(http.MethodPost, MessagesPath, bytes.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		b.ServeHTTP(w, r)
+		return w.Code
+	}
+	cardJSON := func(a Activity) string {
+		b, _ := json.Marshal(a.Attachments)
+		return string(b)
+	}
+
+	for i, testCase := range []struct {
+		activity     Activity
+		expectSent   string
+		expectUpdate string
+	}{
+		{Activity{ID: "m1", Text: "<at>arpicee</at> help"}, `"id":"rpc","isRequired":true,"style":"filtered","type":"Input.ChoiceSet"`, ""},
+		{Activity{ID: "m3", Text: "<at>arpicee</at> deploy"}, `"data":{"action":"run","rpc":"deploy"}`, ""},
+		{Activity{ID: "m4", ReplyToID: "card1", Value: map[string]interface{}{"action": "select", "rpc": "deploy"}}, "", `"id":"env"`},
+		{Activity{ID: "m5", ReplyToID: "card2", Value: map[string]interface{}{"action": "run", "rpc": "deploy", "env": "dev"}}, "", `invalid value for parameter env`},
+		{Activity{ID: "m8", ReplyToID: "card4", Value: map[string]interface{}{"action": "select", "rpc": "purge"}}, "", `"id":"arpicee_confirm","isRequired":false,"label":"Confirm","title":"I confirm running purge","type":"Input.Toggle"`},
+		{Activity{ID: "m9", ReplyToID: "card5", Value: map[string]interface{}{"action": "run", "rpc": "purge", "arpicee_confirm": "false"}}, "", `check the box to confirm running purge`},
+	} {
+		f.mu.Lock()
+		f.sent, f.updated = nil, map[string]Activity{}
+		f.mu.Unlock()
+
+		if status := send(testCase.activity); status != http.StatusOK {
+			t.Errorf("test %d - expected status 200, got %d", i, status)
+		}
+
+		f.mu.Lock()
+		if testCase.expectSent != "" && (len(f.sent) != 1 || !strings.Contains(cardJSON(f.sent[0]), testCase.expectSent)) {
+			t.Errorf("test %d - expected a card containing %s to be sent, got %+v", i, testCase.expectSent, f.sent)
+		}
+		if testCase.expectUpdate != "" && !strings.Contains(cardJSON(f.updated[testCase.activity.ReplyToID]), testCase.expectUpdate) {
+			t.Errorf("test %d - expected card %s to be updated with %s, got %+v", i, testCase.activity.ReplyToID, testCase.expectUpdate, f.updated)
+		}
+		f.mu.Unlock()
+	}
+
+	// The restricted RPC is refused
+	f.mu.Lock()
+	f.sent = nil
+	f.mu.Unlock()
+	send(Activity{ID: "m6", Text: "restricted"})
+	f.mu.Lock()
+	if len(f.sent) != 1 || !strings.Contains(f.sent[0].Text, "You are not allowed to run **restricted**") {
+		t.Errorf("expected the restricted RPC to be refused, got %+v", f.sent)
+	}
+	f.mu.Unlock()
+
+	// debug is only available in another conversation
+	for _, a := range []Activity{
+		{ID: "m10", Text: "<at>arpicee</at> debug"},
+		{ID: "m11", ReplyToID: "card6", Value: map[string]interface{}{"action": "run", "rpc": "debug"}},
+	} {
+		f.mu.Lock()
+		f.sent = nil
+		f.mu.Unlock()
+		send(a)
+		f.mu.Lock()
+		if len(f.sent) != 1 || !strings.Contains(f.sent[0].Text, "Unknown remote procedure **debug**") {
+			t.Errorf("expected debug to be unknown in activity %s, got %+v", a.ID, f.sent)
+		}
+		f.mu.Unlock()
+	}
+
+	send(Activity{ID: "m7", ReplyToID: "card3", Value: map[string]interface{}{"action": "run", "rpc": "deploy", "env": "staging", "dryrun": "true"}})
+	var result string
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		f.mu.Lock()
+		result = cardJSON(f.updated["card3"])
+		f.mu.Unlock()
+		if strings.Contains(result, `"style":"good"`) {
+			break
+		}
+	}
+	if !strings.Contains(result, `"style":"good"`) || !strings.Contains(result, "Running deploy --env staging --dryrun=true") {
+		t.Errorf("expected the card to be updated with the result, got %s", result)
+	}
+}
@@ -1,13 +1,20 @@
 package views
 
 import (
+	"fmt"
 	"sort"
+	"strings"
 
 	"github.com/slack-go/slack"
 	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/registry"
 )
 
-func AppHome(rpcs []arpicee.RemoteCall) *slack.HomeTabViewRequest {
+// ReloadRPCsActionID is the action of the button reloading the RPCs
+const ReloadRPCsActionID = "reload_jobs_id"
+
+// AppHome lists the RPCs, and the status of the discovery sources
+func AppHome(rpcs []arpicee.RemoteCall, sources []registry.SourceStatus) *slack.HomeTabViewRequest {
 	view := &slack.HomeTabViewRequest{
 		Type: "home",
 		Blocks: slack.Blocks{
@@ -54,20 +61,21 @@ func AppHome(rpcs []arpicee.RemoteCall) *slack.HomeTabViewRequest {
 	sort.Slice(rpcs, func(i, j int) bool {
 		return rpcs[i].Name() < rpcs[j].Name()
 	})
-	for _, rpc := range rpcs {
-		text := "•\t" + rpc.Name()
-		if rpc.Description() != "" {
-			text += ": " + rpc.Description()
+	groups := arpicee.GroupByCategory(rpcs)
+	for _, g := range groups {
+		// Categories are only shown when some RPCs have one
+		if len(groups) > 1 || g.Category != arpicee.Uncategorized {
+			view.Blocks.BlockSet = append(view.Blocks.BlockSet, &slack.SectionBlock{
+				Type: slack.MBTSection,
+				Text: &slack.TextBlockObject{Type: slack.MarkdownType, Text: "*" + g.Category + "*"},
+			})
+		}
+		for _, rpc := range g.RPCs {
+			view.Blocks.BlockSet = append(view.Blocks.BlockSet, rpcBlocks(rpc)...)
 		}
-		view.Blocks.BlockSet = append(view.Blocks.BlockSet, &slack.SectionBlock{
-			Type: slack.MBTSection,
-			Text: &slack.TextBlockObject{
-				Type: slack.PlainTextType,
-				Text: text,
-			},
-		})
 	}
 
+	view.Blocks.BlockSet = append(view.Blocks.BlockSet, discoveryStatus(sources)...)
 	view.Blocks.BlockSet = append(view.Blocks.BlockSet, []slack.Block{
 		&slack.ActionBlock{
 			Type: slack.MBTAction,
@@ -79,7 +87,7 @@ func AppHome(rpcs []arpicee.RemoteCall) *slack.HomeTabViewRequest {
 							Type: slack.PlainTextType,
 							Text: "Reload jobs",
 						},
-						ActionID: "reload_jobs_id",
+						ActionID: ReloadRPCsActionID,
 						Value:    "reload-jobs",
 					},
 				},
@@ -89,3 +97,70 @@ func AppHome(rpcs []arpicee.RemoteCall) *slack.HomeTabViewRequest {
 
 	return view
 }
+
+// rpcBlocks returns the blocks listing an RPC, with the summary of its metadata
+func rpcBlocks(rpc arpicee.RemoteCall) []slack.Block {
+	text := "•\t" + rpc.Name()
+	if rpc.Description() != "" {
+		text += ": " + rpc.Description()
+	}
+	blocks := []slack.Block{&slack.SectionBlock{
+		Type: slack.MBTSection,
+		Text: &slack.TextBlockObject{
+			Type: slack.PlainTextType,
+			Text: text,
+		},
+	}}
+	if summary := arpicee.MetadataOf(rpc).Summary(); len(summary) > 0 {
+		blocks = append(blocks, metadataContext(summary))
+	}
+	return blocks
+}
+
+// metadataContext returns the context block showing the summary of the metadata of an RPC
+func metadataContext(summary []string) *slack.ContextBlock {
+	return &slack.ContextBlock{
+		Type: slack.MBTContext,
+		ContextElements: slack.ContextElements{
+			Elements: []slack.MixedElement{
+				&slack.TextBlockObject{Type: slack.PlainTextType, Text: strings.Join(summary, " · ")},
+			},
+		},
+	}
+}
+
+// discoveryStatus returns one section per discovery source, with its errors
+func discoveryStatus(sources []registry.SourceStatus) []slack.Block {
+	if len(sources) == 0 {
+		return nil
+	}
+	blocks := []slack.Block{
+		&slack.DividerBlock{Type: slack.MBTDivider},
+		&slack.HeaderBlock{
+			Type: slack.MBTHeader,
+			Text: &slack.TextBlockObject{Type: slack.PlainTextType, Text: "Discovery sources"},
+		},
+	}
+	for _, src := range sources {
+		var text string
+		switch {
+		case src.Error != "":
+			text = fmt.Sprintf(":warning: *%s*: discovery failed %s: %s", src.Name, slackDate(src.LastAttempt), src.Error)
+			if src.Stale {
+				text += fmt.Sprintf("\n_Showing the %d RPCs discovered %s_", src.RPCs, slackDate(*src.LastSuccess))
+			}
+		case len(src.ItemErrors) > 0:
+			text = fmt.Sprintf(":warning: *%s*: %d RPCs, %d items could not be discovered %s", src.Name, src.RPCs, len(src.ItemErrors), slackDate(src.LastAttempt))
+		default:
+			text = fmt.Sprintf(":white_check_mark: *%s*: %d RPCs", src.Name, src.RPCs)
+		}
+		for _, ie := range src.ItemErrors {
+			text += fmt.Sprintf("\n•\t%s: %s", ie.Item, ie.Error)
+		}
+		blocks = append(blocks, &slack.SectionBlock{
+			Type: slack.MBTSection,
+			Text: &slack.TextBlockObject{Type: slack.MarkdownType, Text: text},
+		})
+	}
+	return blocks
+}
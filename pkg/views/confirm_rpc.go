@@ -0,0 +1,87 @@
+package views
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+	"github.com/yannh/arpicee/pkg/arpicee"
+)
+
+const (
+	ConfirmRPCDialogCallbackID = "confirm_rpc_dialog"
+	ConfirmNameBlockID         = "confirm_name"
+)
+
+// argValue returns the value of arg as shown in the confirmation dialog
+func argValue(param arpicee.Parameter, arg arpicee.Argument) string {
+	if param.Secret {
+		return "********"
+	}
+	switch a := arg.(type) {
+	case *arpicee.ArgumentString:
+		return a.Val
+	case *arpicee.ArgumentInt:
+		return fmt.Sprintf("%d", a.Val)
+	case *arpicee.ArgumentBool:
+		return fmt.Sprintf("%t", a.Val)
+	}
+	return ""
+}
+
+// ConfirmRPCDialog asks to confirm running rpc with args, pushed on top of the
+// invocation dialog. When confirmation is arpicee.ConfirmName, the name of the RPC must
+// be typed. Values of secret parameters are masked. The arguments are not part of the
+// dialog: runs waiting for confirmation are kept by the bot, by view external ID.
+func ConfirmRPCDialog(channelID string, rpc arpicee.RemoteCall, args []arpicee.Argument, confirmation arpicee.Confirmation) slack.ModalViewRequest {
+	text := fmt.Sprintf("You are about to run *%s*", rpc.Name())
+	var lines []string
+	for _, p := range rpc.Params() {
+		if arg := arpicee.GetArg(args, p.Name); arg != nil {
+			lines = append(lines, fmt.Sprintf("• %s: `%s`", p.Name, argValue(p, arg)))
+		}
+	}
+	if len(lines) > 0 {
+		text += " with:\n" + strings.Join(lines, "\n")
+	}
+
+	blocks := slack.Blocks{
+		BlockSet: []slack.Block{
+			slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil),
+		},
+	}
+	if summary := arpicee.MetadataOf(rpc).Summary(); len(summary) > 0 {
+		blocks.BlockSet = append(blocks.BlockSet, metadataContext(summary))
+	}
+	if confirmation == arpicee.ConfirmName {
+		blocks.BlockSet = append(blocks.BlockSet, slack.InputBlock{
+			Type:    slack.MBTInput,
+			BlockID: ConfirmNameBlockID,
+			Label:   slack.NewTextBlockObject(slack.PlainTextType, fmt.Sprintf("Type %s to confirm", rpc.Name()), false, false),
+			Element: slack.PlainTextInputBlockElement{
+				Type:     slack.METPlainTextInput,
+				ActionID: ConfirmNameBlockID,
+			},
+		})
+	}
+
+	return slack.ModalViewRequest{
+		Type:            slack.VTModal,
+		ExternalID:      strings.Join([]string{channelID, fmt.Sprintf("%d", time.Now().UnixNano())}, "_"),
+		PrivateMetadata: rpc.Name(),
+		Title:           slack.NewTextBlockObject(slack.PlainTextType, "Confirm", false, false),
+		Close:           slack.NewTextBlockObject(slack.PlainTextType, "Back", false, false),
+		Submit:          slack.NewTextBlockObject(slack.PlainTextType, "Run", false, false),
+		Blocks:          blocks,
+		CallbackID:      ConfirmRPCDialogCallbackID,
+	}
+}
+
+// ConfirmedName returns the name typed in the confirmation dialog view
+func ConfirmedName(view slack.View) string {
+	if view.State == nil {
+		return ""
+	}
+	return strings.TrimSpace(view.State.Values[ConfirmNameBlockID][ConfirmNameBlockID].Value)
+}
@@ -0,0 +1,35 @@
+package views
+
+import (
+	"fmt"
+
+	"github.com/slack-go/slack"
+	"github.com/yannh/arpicee/pkg/chat"
+	"github.com/yannh/arpicee/pkg/execution"
+	"github.com/yannh/arpicee/pkg/fanout"
+)
+
+// FanoutSummary reports on a fan-out execution invoked by userID, as a table of the
+// results of every target
+func FanoutSummary(s fanout.Summary, userID string) slack.Attachment {
+	pretext := fmt.Sprintf("Remote procedure *%s* invoked by <@%s> on %d targets", s.RPC, userID, len(s.Results))
+	status := execution.StatusSucceeded
+	switch s.Status() {
+	case fanout.StatusRunning:
+		pretext += ", currently running..."
+		status = execution.StatusRunning
+	case fanout.StatusFailed:
+		status = execution.StatusFailed
+	}
+
+	return slack.Attachment{
+		Pretext: pretext,
+		Color:   chat.Color(status),
+		Fields: []slack.AttachmentField{
+			{
+				Title: "Summary",
+				Value: "```\n" + s.Table() + "```",
+			},
+		},
+	}
+}
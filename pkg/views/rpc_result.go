@@ -1,52 +1,46 @@
 package views
 
 import (
-	"bytes"
 	"fmt"
-	"text/template"
+	"strings"
 
 	"github.com/slack-go/slack"
-	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/chat"
+	"github.com/yannh/arpicee/pkg/execution"
 )
 
-func RPCResult(rpc arpicee.RemoteCall, user slack.User, rpcOutput map[string]interface{}, err error) (*slack.Attachment, error) {
-	if rpcOutput["formatString"] == "" {
-		rpcOutput["formatString"] = "the remote procedure you are invoking is missing a formatString property in the return value"
-	}
-
-	var attachmentFields []slack.AttachmentField
+func RPCResult(e execution.Execution, userID string) slack.Attachment {
+	return rpcResult(e, fmt.Sprintf("<@%s>", userID))
+}
 
-	color := "00CB53"
-	if err != nil {
-		color = "e5345e"
+// ExecutionResult reports on e, invoked by a Slack user, a webhook or a schedule
+func ExecutionResult(e execution.Execution) slack.Attachment {
+	if kind, name, ok := strings.Cut(e.User, ":"); ok && (kind == "webhook" || kind == "schedule") {
+		return rpcResult(e, fmt.Sprintf("%s *%s*", kind, name))
+	}
+	return RPCResult(e, e.User)
+}
 
-		attachmentFields = append(
-			attachmentFields,
-			slack.AttachmentField{
-				Title: "Error Message",
-				Value: err.Error(),
-			},
-		)
-	} else {
-		t := template.Must(template.New("").Parse(fmt.Sprintf("%s", rpcOutput["formatString"])))
-		b := bytes.Buffer{}
-		if err := t.Execute(&b, rpcOutput); err != nil {
-			return nil, err
+func rpcResult(e execution.Execution, invokedBy string) slack.Attachment {
+	title := "Result"
+	switch e.Status {
+	case execution.StatusRunning:
+		return slack.Attachment{
+			Pretext: fmt.Sprintf("RPC *%s* invoked by %s, currently running...", e.RPC, invokedBy),
+			Color:   chat.Color(e.Status),
 		}
-		output := b.String()
+	case execution.StatusFailed:
+		title = "Error Message"
+	}
 
-		attachmentFields = append(
-			attachmentFields,
-			slack.AttachmentField{
-				Title: "Result",
-				Value: fmt.Sprintf("%s", output),
+	return slack.Attachment{
+		Pretext: fmt.Sprintf("Remote procedure *%s* invoked by %s", e.RPC, invokedBy),
+		Color:   chat.Color(e.Status),
+		Fields: []slack.AttachmentField{
+			{
+				Title: title,
+				Value: chat.Output(e),
 			},
-		)
+		},
 	}
-
-	return &slack.Attachment{
-		Pretext: fmt.Sprintf("Remote procedure *%s* invoked by <@%s>", rpc.Name(), user.ID),
-		Color:   color,
-		Fields:  attachmentFields,
-	}, nil
 }
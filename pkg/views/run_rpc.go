@@ -2,65 +2,95 @@ package views
 
 import (
 	"fmt"
-	"sort"
 	"strings"
 	"time"
 
 	"github.com/slack-go/slack"
 	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/chat"
 )
 
 const (
 	RunRPCDialogCallbackID = "run_lambda_dialog"
 )
 
-func parameterTypeToBlock(param arpicee.Parameter) slack.Block {
-	switch param.Type {
-	case arpicee.TypeString,
-		arpicee.TypeInt:
-		return slack.InputBlock{
-			Type:    slack.MBTInput,
-			BlockID: param.Name,
-			Label: &slack.TextBlockObject{
-				Type: slack.PlainTextType,
-				Text: param.Name,
-			},
-			Element: slack.PlainTextInputBlockElement{
-				Type:     slack.METPlainTextInput,
-				ActionID: param.Name,
-				Placeholder: &slack.TextBlockObject{
-					Type: slack.PlainTextType,
-					Text: param.Description,
+func fieldToBlock(f chat.Field) slack.Block {
+	placeholder := &slack.TextBlockObject{
+		Type: slack.PlainTextType,
+		Text: f.Description,
+	}
+	if f.Description == "" {
+		placeholder = nil
+	}
+
+	switch f.Kind {
+	case chat.FieldCheckbox:
+		label := f.Description
+		if label == "" {
+			label = f.Name
+		}
+		checkbox := slack.CheckboxGroupsBlockElement{
+			Type:     slack.METCheckboxGroups,
+			ActionID: f.Name,
+			Options: []*slack.OptionBlockObject{
+				{
+					Text: &slack.TextBlockObject{
+						Type: "plain_text",
+						Text: label,
+					},
+					Value: f.Name,
 				},
 			},
-			Optional: !param.Required,
 		}
-
-	case arpicee.TypeBool:
+		if f.Default == "true" {
+			checkbox.InitialOptions = checkbox.Options
+		}
 		return slack.ActionBlock{
 			Type:    "actions",
-			BlockID: param.Name,
+			BlockID: f.Name,
 			Elements: &slack.BlockElements{
-				ElementSet: []slack.BlockElement{
-					slack.CheckboxGroupsBlockElement{
-						Type:     slack.METCheckboxGroups,
-						ActionID: param.Name,
-						Options: []*slack.OptionBlockObject{
-							{
-								Text: &slack.TextBlockObject{
-									Type: "plain_text",
-									Text: param.Description,
-								},
-								Value: param.Name,
-							},
-						},
-					},
-				},
+				ElementSet: []slack.BlockElement{checkbox},
 			},
 		}
+
+	case chat.FieldSelect:
+		sel := &slack.SelectBlockElement{
+			Type:        slack.OptTypeStatic,
+			ActionID:    f.Name,
+			Placeholder: placeholder,
+		}
+		for _, v := range f.AllowedValues {
+			opt := slack.NewOptionBlockObject(v, slack.NewTextBlockObject(slack.PlainTextType, v, false, false), nil)
+			sel.Options = append(sel.Options, opt)
+			if v == f.Default {
+				sel.InitialOption = opt
+			}
+		}
+		return slack.InputBlock{
+			Type:     slack.MBTInput,
+			BlockID:  f.Name,
+			Label:    slack.NewTextBlockObject(slack.PlainTextType, f.Name, false, false),
+			Element:  sel,
+			Optional: !f.Required,
+		}
 	}
 
-	return nil
+	// Slack has no password inputs: secrets are entered as plain text
+	return slack.InputBlock{
+		Type:    slack.MBTInput,
+		BlockID: f.Name,
+		Label: &slack.TextBlockObject{
+			Type: slack.PlainTextType,
+			Text: f.Name,
+		},
+		Element: slack.PlainTextInputBlockElement{
+			Type:         slack.METPlainTextInput,
+			ActionID:     f.Name,
+			Placeholder:  placeholder,
+			InitialValue: f.Default,
+		},
+		Optional: !f.Required,
+	}
 }
 
 func RunRPCDialog(channelID string, rpc arpicee.RemoteCall) slack.ModalViewRequest {
@@ -68,28 +98,23 @@ func RunRPCDialog(channelID string, rpc arpicee.RemoteCall) slack.ModalViewReque
 		BlockSet: []slack.Block{},
 	}
 
+	summary := arpicee.MetadataOf(rpc).Summary()
 	if rpc.Description() != "" {
 		blocks.BlockSet = append(blocks.BlockSet, slack.NewSectionBlock(
 			slack.NewTextBlockObject(slack.PlainTextType, rpc.Description(), false, false),
 			nil,
 			nil,
 		))
-
+	}
+	if len(summary) > 0 {
+		blocks.BlockSet = append(blocks.BlockSet, metadataContext(summary))
+	}
+	if rpc.Description() != "" || len(summary) > 0 {
 		blocks.BlockSet = append(blocks.BlockSet, slack.DividerBlock{Type: "divider"})
 	}
 
-	params := rpc.Params()
-	sort.Slice(params, func(i, j int) bool {
-		return params[i].Name < params[j].Name
-	})
-	for _, p := range params {
-		block := parameterTypeToBlock(p)
-		if block != nil {
-			blocks.BlockSet = append(
-				blocks.BlockSet,
-				block,
-			)
-		}
+	for _, f := range chat.Fields(rpc.Params()) {
+		blocks.BlockSet = append(blocks.BlockSet, fieldToBlock(f))
 	}
 
 	title := fmt.Sprintf("%s", rpc.Name())
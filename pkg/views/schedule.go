@@ -0,0 +1,78 @@
+package views
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yannh/arpicee/pkg/scheduler"
+)
+
+// ScheduleList describes the scheduled jobs, in reply to "/arpicee schedule list"
+func ScheduleList(jobs []scheduler.JobStatus) string {
+	if len(jobs) == 0 {
+		return "No scheduled jobs. Add one with `/arpicee schedule add NAME \"CRON\" RPC [param=value]...`"
+	}
+
+	var sb strings.Builder
+	sb.WriteString("*Scheduled jobs*\n")
+	for _, j := range jobs {
+		tz := j.TimeZone
+		if tz == "" {
+			tz = "UTC"
+		}
+		sb.WriteString(fmt.Sprintf("• *%s* `%s` (%s) runs *%s*%s", j.Name, j.Schedule, tz, j.RPC, arguments(j.Arguments)))
+		if j.Paused {
+			sb.WriteString(", paused")
+		} else {
+			sb.WriteString(fmt.Sprintf(", next run %s", slackDate(j.Next)))
+		}
+		if j.LastRun != nil {
+			sb.WriteString(fmt.Sprintf(", last run %s", runStatus(*j.LastRun)))
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// ScheduleHistory describes the last runs of the job name
+func ScheduleHistory(name string, runs []scheduler.Run) string {
+	if len(runs) == 0 {
+		return fmt.Sprintf("Job *%s* has not run yet", name)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("*Last runs of %s*\n", name))
+	for i := len(runs) - 1; i >= 0; i-- {
+		sb.WriteString(fmt.Sprintf("• %s: %s\n", slackDate(runs[i].ScheduledAt), runStatus(runs[i])))
+	}
+	return sb.String()
+}
+
+func runStatus(r scheduler.Run) string {
+	switch {
+	case r.Skipped != "":
+		return "skipped, " + r.Skipped
+	case r.Error != "":
+		return fmt.Sprintf("%s, %s", r.Status, r.Error)
+	}
+	return string(r.Status)
+}
+
+func arguments(args map[string]string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	var kv []string
+	for k, v := range args {
+		kv = append(kv, k+"="+v)
+	}
+	sort.Strings(kv)
+	return " with `" + strings.Join(kv, " ") + "`"
+}
+
+// slackDate is shown in the time zone of the reader
+func slackDate(t time.Time) string {
+	return fmt.Sprintf("<!date^%d^{date_short_pretty} {time}|%s>", t.Unix(), t.UTC().Format(time.RFC3339))
+}
@@ -9,16 +9,32 @@ const (
 	SelectRPCActionID = "select_rpc"
 )
 
+// SelectRPCDialog lists the RPCs in a menu, grouped by category if some RPCs have one
 func SelectRPCDialog(rpcs []arpicee.RemoteCall) []slack.Block {
-	var opts []*slack.OptionBlockObject
-	for _, rpc := range rpcs {
-		opts = append(opts, &slack.OptionBlockObject{
-			Text: &slack.TextBlockObject{
-				Type: "plain_text",
-				Text: rpc.Name(),
-			},
-			Value: rpc.Name(),
-		})
+	sel := &slack.SelectBlockElement{
+		Type:     "static_select",
+		ActionID: SelectRPCActionID,
+	}
+	groups := arpicee.GroupByCategory(rpcs)
+	for _, g := range groups {
+		var opts []*slack.OptionBlockObject
+		for _, rpc := range g.RPCs {
+			opts = append(opts, &slack.OptionBlockObject{
+				Text: &slack.TextBlockObject{
+					Type: "plain_text",
+					Text: rpc.Name(),
+				},
+				Value: rpc.Name(),
+			})
+		}
+		if len(groups) == 1 && g.Category == arpicee.Uncategorized {
+			sel.Options = opts
+			break
+		}
+		sel.OptionGroups = append(sel.OptionGroups, slack.NewOptionGroupBlockElement(
+			slack.NewTextBlockObject(slack.PlainTextType, g.Category, false, false),
+			opts...,
+		))
 	}
 
 	return []slack.Block{
@@ -28,11 +44,7 @@ func SelectRPCDialog(rpcs []arpicee.RemoteCall) []slack.Block {
 				Text: "Select an automation",
 			},
 			nil,
-			&slack.Accessory{SelectElement: &slack.SelectBlockElement{
-				Type:     "static_select",
-				ActionID: SelectRPCActionID,
-				Options:  opts,
-			}},
+			&slack.Accessory{SelectElement: sel},
 		),
 	}
 }
@@ -0,0 +1,348 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/jmespath/go-jmespath"
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/execution"
+	"github.com/yannh/arpicee/pkg/registry"
+)
+
+const (
+	PathPrefix = "/webhooks/"
+
+	maxPayloadSize = 1 << 20
+)
+
+// Hook triggers the RPC when a JSON payload is posted to PathPrefix + Name.
+//
+// Requests are authenticated with Secret: if SignatureHeader is set, the header must
+// hold the hex encoded HMAC-SHA256 of the body, optionally prefixed with "sha256=" or
+// "v1=". Otherwise the secret must be passed as a bearer token or in the "token" query
+// parameter.
+//
+// Arguments maps parameter names to a JMESPath expression evaluated against the payload,
+// or to a Go template if the value contains "{{". The RPC is only triggered if the
+// JMESPath expression Filter, when set, evaluates to a truthy value. The result of the
+// execution is posted to SlackChannel, if set.
+type Hook struct {
+	Name            string
+	RPC             string
+	Secret          string
+	SignatureHeader string
+	Filter          string
+	Arguments       map[string]string
+	SlackChannel    string
+}
+
+// ResultPoster posts the results of the executions triggered by webhooks to Slack
+type ResultPoster interface {
+	PostResult(channelID string, e execution.Execution) error
+}
+
+type mapping struct {
+	path *jmespath.JMESPath
+	tmpl *template.Template
+}
+
+type hook struct {
+	Hook
+	filter    *jmespath.JMESPath
+	arguments map[string]mapping
+}
+
+// Server receives the webhooks and starts the executions of their RPCs
+type Server struct {
+	registry   *registry.Registry
+	executions *execution.Manager
+	hooks      map[string]*hook
+	poster     ResultPoster
+}
+
+var validName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+func compile(h Hook) (*hook, error) {
+	switch {
+	case !validName.MatchString(h.Name):
+		return nil, fmt.Errorf("invalid webhook name %q", h.Name)
+	case h.RPC == "":
+		return nil, fmt.Errorf("webhook %s: missing RPC", h.Name)
+	case h.Secret == "":
+		return nil, fmt.Errorf("webhook %s: missing secret", h.Name)
+	}
+
+	c := &hook{Hook: h, arguments: map[string]mapping{}}
+	if h.Filter != "" {
+		f, err := jmespath.Compile(h.Filter)
+		if err != nil {
+			return nil, fmt.Errorf("webhook %s: invalid filter: %w", h.Name, err)
+		}
+		c.filter = f
+	}
+	for name, expr := range h.Arguments {
+		if strings.Contains(expr, "{{") {
+			t, err := template.New(name).Option("missingkey=error").Parse(expr)
+			if err != nil {
+				return nil, fmt.Errorf("webhook %s: invalid template for argument %s: %w", h.Name, name, err)
+			}
+			c.arguments[name] = mapping{tmpl: t}
+			continue
+		}
+		p, err := jmespath.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("webhook %s: invalid expression for argument %s: %w", h.Name, name, err)
+		}
+		c.arguments[name] = mapping{path: p}
+	}
+	return c, nil
+}
+
+// New returns a Server for hooks; poster can be nil if no hook posts to Slack
+func New(reg *registry.Registry, executions *execution.Manager, hooks []Hook, poster ResultPoster) (*Server, error) {
+	s := &Server{
+		registry:   reg,
+		executions: executions,
+		hooks:      map[string]*hook{},
+		poster:     poster,
+	}
+	for _, h := range hooks {
+		if _, ok := s.hooks[h.Name]; ok {
+			return nil, fmt.Errorf("duplicate webhook %s", h.Name)
+		}
+		if h.SlackChannel != "" && poster == nil {
+			return nil, fmt.Errorf("webhook %s posts to Slack, but Slack is not configured", h.Name)
+		}
+		c, err := compile(h)
+		if err != nil {
+			return nil, err
+		}
+		s.hooks[h.Name] = c
+	}
+	return s, nil
+}
+
+// Register adds the webhook endpoints to mux
+func (s *Server) Register(mux *http.ServeMux) {
+	mux.Handle(PathPrefix, s)
+}
+
+// User returns the user executions triggered by the webhook name are attributed to
+func User(name string) string {
+	return "webhook:" + name
+}
+
+func (h *hook) verify(r *http.Request, body []byte) bool {
+	if h.SignatureHeader == "" {
+		provided := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if provided == "" {
+			provided = r.URL.Query().Get("token")
+		}
+		return subtle.ConstantTimeCompare([]byte(provided), []byte(h.Secret)) == 1
+	}
+
+	mac := hmac.New(sha256.New, []byte(h.Secret))
+	mac.Write(body)
+	expected := mac.Sum(nil)
+	// Some senders, like PagerDuty, send several comma-separated signatures while rotating secrets
+	for _, sig := range strings.Split(r.Header.Get(h.SignatureHeader), ",") {
+		sig = strings.TrimSpace(sig)
+		if i := strings.Index(sig, "="); i >= 0 {
+			sig = sig[i+1:]
+		}
+		if provided, err := hex.DecodeString(sig); err == nil && hmac.Equal(provided, expected) {
+			return true
+		}
+	}
+	return false
+}
+
+// truthy follows the JMESPath definition of false values
+func truthy(v interface{}) bool {
+	switch val := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return val
+	case string:
+		return val != ""
+	case []interface{}:
+		return len(val) > 0
+	case map[string]interface{}:
+		return len(val) > 0
+	}
+	return true
+}
+
+// coerce converts strings to the type of param, payloads often hold numbers and booleans as strings
+func coerce(param arpicee.Parameter, v interface{}) (interface{}, error) {
+	s, ok := v.(string)
+	if !ok {
+		return v, nil
+	}
+	switch param.Type {
+	case arpicee.TypeInt:
+		i, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s should be an integer, got %q", param.Name, s)
+		}
+		return i, nil
+	case arpicee.TypeBool:
+		b, err := strconv.ParseBool(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("parameter %s should be a boolean, got %q", param.Name, s)
+		}
+		return b, nil
+	}
+	return s, nil
+}
+
+// args evaluates the mappings of the hook against payload
+func (h *hook) args(params []arpicee.Parameter, payload interface{}) ([]arpicee.Argument, error) {
+	values := map[string]interface{}{}
+	for name, m := range h.arguments {
+		var v interface{}
+		if m.tmpl != nil {
+			var buf bytes.Buffer
+			if err := m.tmpl.Execute(&buf, payload); err != nil {
+				return nil, fmt.Errorf("failed evaluating argument %s: %w", name, err)
+			}
+			v = buf.String()
+		} else {
+			var err error
+			if v, err = m.path.Search(payload); err != nil {
+				return nil, fmt.Errorf("failed evaluating argument %s: %w", name, err)
+			}
+		}
+
+		for _, p := range params {
+			if p.Name != name {
+				continue
+			}
+			var err error
+			if v, err = coerce(p, v); err != nil {
+				return nil, err
+			}
+		}
+		values[name] = v
+	}
+	return arpicee.ArgsFromMap(params, values)
+}
+
+type triggerResponse struct {
+	Triggered bool                 `json:"triggered"`
+	Execution *execution.Execution `json:"execution,omitempty"`
+	Error     string               `json:"error,omitempty"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v triggerResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("failed writing HTTP response: %s", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, triggerResponse{Error: err.Error()})
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h, ok := s.hooks[strings.TrimPrefix(r.URL.Path, PathPrefix)]
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("unknown webhook"))
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", r.Method))
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPayloadSize+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(body) > maxPayloadSize {
+		writeError(w, http.StatusRequestEntityTooLarge, errors.New("payload too large"))
+		return
+	}
+	if !h.verify(r, body) {
+		log.Printf("webhook %s: refused request with an invalid secret or signature", h.Name)
+		writeError(w, http.StatusUnauthorized, errors.New("invalid secret or signature"))
+		return
+	}
+
+	var payload interface{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("failed parsing payload: %w", err))
+		return
+	}
+
+	if h.filter != nil {
+		match, err := h.filter.Search(payload)
+		if err != nil {
+			writeError(w, http.StatusUnprocessableEntity, fmt.Errorf("failed evaluating filter: %w", err))
+			return
+		}
+		if !truthy(match) {
+			writeJSON(w, http.StatusOK, triggerResponse{Triggered: false})
+			return
+		}
+	}
+
+	rpc := s.registry.RPC(h.RPC)
+	if rpc == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no RPC named %s", h.RPC))
+		return
+	}
+	args, err := h.args(rpc.Params(), payload)
+	if err != nil {
+		log.Printf("webhook %s: %s", h.Name, err)
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	e, err := s.executions.Start(rpc, args, User(h.Name))
+	var forbidden *execution.ForbiddenError
+	if errors.As(err, &forbidden) {
+		writeError(w, http.StatusForbidden, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	log.Printf("webhook %s: started execution %s of RPC %s", h.Name, e.ID, rpc.Name())
+
+	if h.SlackChannel != "" {
+		go s.postResult(h, e.ID)
+	}
+	writeJSON(w, http.StatusAccepted, triggerResponse{Triggered: true, Execution: &e})
+}
+
+func (s *Server) postResult(h *hook, id string) {
+	e, err := s.executions.Wait(context.Background(), id)
+	if err != nil {
+		log.Printf("webhook %s: failed waiting for execution %s: %s", h.Name, id, err)
+		return
+	}
+	if err := s.poster.PostResult(h.SlackChannel, e); err != nil {
+		log.Printf("webhook %s: error posting results to Slack: %s", h.Name, err)
+	}
+}
@@ -0,0 +1,177 @@
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/execution"
+	"github.com/yannh/arpicee/pkg/mock"
+	"github.com/yannh/arpicee/pkg/registry"
+	"github.com/yannh/arpicee/pkg/registry/registrytest"
+)
+
+type fakePoster struct {
+	mu      sync.Mutex
+	posted  chan struct{}
+	channel string
+	result  execution.Execution
+}
+
+func (p *fakePoster) PostResult(channelID string, e execution.Execution) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.channel = channelID
+	p.result = e
+	close(p.posted)
+	return nil
+}
+
+func sign(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+const alert = `{"status": "firing", "alerts": [{"labels": {"service": "api", "replicas": "3"}}], "commonLabels": {"env": "production"}}`
+
+func newTestServer(t *testing.T, poster ResultPoster) *Server {
+	reg := registrytest.New(t,
+		mock.New("restart", []arpicee.Parameter{
+			{Name: "service", Type: arpicee.TypeString, Required: true},
+			{Name: "replicas", Type: arpicee.TypeInt},
+			{Name: "reason", Type: arpicee.TypeString},
+		}, func(ctx context.Context, args []arpicee.Argument) (map[string]interface{}, error) {
+			return map[string]interface{}{"formatString": "restarted"}, nil
+		}),
+	)
+
+	s, err := New(reg, execution.NewManager(100), []Hook{
+		{
+			Name:            "alertmanager",
+			RPC:             "restart",
+			Secret:          "s3cr3t",
+			SignatureHeader: "X-Signature",
+			Filter:          "status == 'firing'",
+			Arguments: map[string]string{
+				"service":  "alerts[0].labels.service",
+				"replicas": "alerts[0].labels.replicas",
+				"reason":   "{{ .status }} in {{ .commonLabels.env }}",
+			},
+			SlackChannel: "C123",
+		},
+		{
+			Name:      "pagerduty",
+			RPC:       "restart",
+			Secret:    "token",
+			Arguments: map[string]string{"service": "event.service"},
+		},
+	}, poster)
+	if err != nil {
+		t.Fatalf("failed creating webhook server: %s", err)
+	}
+	return s
+}
+
+func TestServeHTTP(t *testing.T) {
+	s := newTestServer(t, &fakePoster{posted: make(chan struct{})})
+
+	for i, testCase := range []struct {
+		path         string
+		headers      map[string]string
+		body         string
+		expectStatus int
+		expectBody   string
+	}{
+		{"/webhooks/unknown", nil, "{}", http.StatusNotFound, "unknown webhook"},
+		{"/webhooks/alertmanager", map[string]string{"X-Signature": "sha256=" + sign("wrong", alert)}, alert, http.StatusUnauthorized, "invalid secret or signature"},
+		{"/webhooks/alertmanager", nil, alert, http.StatusUnauthorized, "invalid secret or signature"},
+		{
+			"/webhooks/alertmanager",
+			map[string]string{"X-Signature": "sha256=" + sign("s3cr3t", `{"status": "resolved"}`)},
+			`{"status": "resolved"}`,
+			http.StatusOK,
+			`"triggered":false`,
+		},
+		{
+			"/webhooks/alertmanager",
+			map[string]string{"X-Signature": "v1=0000, v1=" + sign("s3cr3t", alert)},
+			alert,
+			http.StatusAccepted,
+			`"arguments":{"reason":"firing in production","replicas":3,"service":"api"}`,
+		},
+		{"/webhooks/pagerduty", nil, `{"event": {"service": "api"}}`, http.StatusUnauthorized, "invalid secret or signature"},
+		{"/webhooks/pagerduty?token=token", nil, `{"event": {}}`, http.StatusUnprocessableEntity, "parameter service is required"},
+		{"/webhooks/pagerduty", map[string]string{"Authorization": "Bearer token"}, `not json`, http.StatusBadRequest, "failed parsing payload"},
+		{"/webhooks/pagerduty", map[string]string{"Authorization": "Bearer token"}, `{"event": {"service": "api"}}`, http.StatusAccepted, `"user":"webhook:pagerduty"`},
+	} {
+		req := httptest.NewRequest(http.MethodPost, testCase.path, strings.NewReader(testCase.body))
+		for k, v := range testCase.headers {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, req)
+
+		if w.Code != testCase.expectStatus {
+			t.Errorf("test %d - expected status %d, got %d: %s", i, testCase.expectStatus, w.Code, w.Body.String())
+		}
+		if !strings.Contains(w.Body.String(), testCase.expectBody) {
+			t.Errorf("test %d - expected body to contain %s, got %s", i, testCase.expectBody, w.Body.String())
+		}
+	}
+}
+
+func TestPostResult(t *testing.T) {
+	poster := &fakePoster{posted: make(chan struct{})}
+	s := newTestServer(t, poster)
+
+	req := httptest.NewRequest(http.MethodPost, "/webhooks/alertmanager", strings.NewReader(alert))
+	req.Header.Set("X-Signature", sign("s3cr3t", alert))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, req)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusAccepted, w.Code, w.Body.String())
+	}
+
+	select {
+	case <-poster.posted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the result to be posted")
+	}
+	poster.mu.Lock()
+	defer poster.mu.Unlock()
+	if poster.channel != "C123" || poster.result.Status != execution.StatusSucceeded {
+		t.Errorf("expected succeeded execution posted to C123, got %s to %s", poster.result.Status, poster.channel)
+	}
+}
+
+func TestNew(t *testing.T) {
+	for i, testCase := range []struct {
+		hooks     []Hook
+		expectErr string
+	}{
+		{[]Hook{{Name: "a b", RPC: "restart", Secret: "s"}}, "invalid webhook name"},
+		{[]Hook{{Name: "a", RPC: "restart"}}, "missing secret"},
+		{[]Hook{{Name: "a", RPC: "restart", Secret: "s", Filter: "status =="}}, "invalid filter"},
+		{[]Hook{{Name: "a", RPC: "restart", Secret: "s", Arguments: map[string]string{"service": "{{ .foo "}}}, "invalid template"},
+		{[]Hook{{Name: "a", RPC: "restart", Secret: "s", SlackChannel: "C1"}}, "Slack is not configured"},
+		{[]Hook{{Name: "a", RPC: "restart", Secret: "s"}, {Name: "a", RPC: "restart", Secret: "s"}}, "duplicate webhook a"},
+		{[]Hook{{Name: "a", RPC: "restart", Secret: "s", Arguments: map[string]string{"service": "event.service"}}}, ""},
+	} {
+		_, err := New(registry.New(), execution.NewManager(10), testCase.hooks, nil)
+		if testCase.expectErr == "" && err != nil {
+			t.Errorf("test %d - unexpected error: %s", i, err)
+		}
+		if testCase.expectErr != "" && (err == nil || !strings.Contains(err.Error(), testCase.expectErr)) {
+			t.Errorf("test %d - expected error containing %s, got %v", i, testCase.expectErr, err)
+		}
+	}
+}
@@ -0,0 +1,360 @@
+package webui
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/chat"
+	"github.com/yannh/arpicee/pkg/execution"
+	"github.com/yannh/arpicee/pkg/policy"
+	"github.com/yannh/arpicee/pkg/registry"
+)
+
+//go:embed templates/*.html
+var templatesFS embed.FS
+
+// Prefix is the path the web UI is served under
+const Prefix = "/ui/"
+
+// Auth configures how users are identified. The web UI does not authenticate
+// users itself: it is meant to run behind a reverse proxy (eg oauth2-proxy)
+// setting UserHeader. DevUser is a stand-in identity for local development.
+type Auth struct {
+	UserHeader string
+	DevUser    string
+}
+
+type UI struct {
+	registry   *registry.Registry
+	executions *execution.Manager
+	auth       Auth
+	templates  map[string]*template.Template
+
+	mu            sync.Mutex
+	confirmations *policy.Confirmations
+}
+
+// group is a group of RPCs listed in the index, by category or provider
+type group struct {
+	Name string
+	RPCs []arpicee.RemoteCall
+}
+
+type field struct {
+	Param     arpicee.Parameter
+	Label     string
+	InputType string
+}
+
+// User returns the user executions started from the web UI by name are attributed to,
+// in access rules
+func User(name string) string {
+	return "web:" + name
+}
+
+func New(reg *registry.Registry, executions *execution.Manager, auth Auth) (*UI, error) {
+	if auth.UserHeader == "" && auth.DevUser == "" {
+		return nil, fmt.Errorf("the web UI requires either a user header or a development user")
+	}
+
+	funcs := template.FuncMap{
+		"prefix": func() string { return Prefix },
+		"path":   url.PathEscape,
+		"output": output,
+		"summary": func(rpc arpicee.RemoteCall) []string {
+			return arpicee.MetadataOf(rpc).Summary()
+		},
+		"json": func(v interface{}) string {
+			b, _ := json.MarshalIndent(v, "", "  ")
+			return string(b)
+		},
+	}
+	ui := &UI{
+		registry:   reg,
+		executions: executions,
+		auth:       auth,
+		templates:  map[string]*template.Template{},
+	}
+	for _, page := range []string{"index", "rpc", "executions", "execution"} {
+		t, err := template.New("layout.html").Funcs(funcs).ParseFS(templatesFS, "templates/layout.html", "templates/"+page+".html")
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing template %s: %w", page, err)
+		}
+		ui.templates[page] = t
+	}
+	return ui, nil
+}
+
+// SetConfirmations sets the confirmations required before running RPCs from the web UI:
+// their form has a confirmation field, see chat.FormFields
+func (ui *UI) SetConfirmations(confirmations *policy.Confirmations) {
+	ui.mu.Lock()
+	defer ui.mu.Unlock()
+	ui.confirmations = confirmations
+}
+
+func (ui *UI) confirmation(rpc arpicee.RemoteCall) arpicee.Confirmation {
+	ui.mu.Lock()
+	confirmations := ui.confirmations
+	ui.mu.Unlock()
+	return confirmations.Required(rpc)
+}
+
+// Register adds the web UI routes to mux
+func (ui *UI) Register(mux *http.ServeMux) {
+	mux.Handle(Prefix, ui)
+}
+
+func (ui *UI) user(r *http.Request) string {
+	if ui.auth.UserHeader != "" {
+		return r.Header.Get(ui.auth.UserHeader)
+	}
+	return ui.auth.DevUser
+}
+
+// sameOrigin protects form submissions against cross-site request forgery
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		origin = r.Header.Get("Referer")
+	}
+	if origin == "" {
+		return r.Header.Get("Sec-Fetch-Site") == "" || r.Header.Get("Sec-Fetch-Site") == "same-origin"
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+func (ui *UI) render(w http.ResponseWriter, page string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := ui.templates[page].Execute(w, data); err != nil {
+		log.Printf("failed rendering page %s: %s", page, err)
+	}
+}
+
+func (ui *UI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user := ui.user(r)
+	if user == "" {
+		http.Error(w, "unauthenticated", http.StatusUnauthorized)
+		return
+	}
+	if r.Method == http.MethodPost && !sameOrigin(r) {
+		http.Error(w, "cross-origin request refused", http.StatusForbidden)
+		return
+	}
+
+	p := strings.Trim(strings.TrimPrefix(r.URL.EscapedPath(), Prefix), "/")
+	segments := []string{}
+	if p != "" {
+		segments = strings.Split(p, "/")
+	}
+	for i, seg := range segments {
+		s, err := url.PathUnescape(seg)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		segments[i] = s
+	}
+
+	switch {
+	case len(segments) == 0 && r.Method == http.MethodGet:
+		ui.index(w, user, r.URL.Query().Get("q"))
+	case len(segments) == 2 && segments[0] == "rpcs":
+		ui.rpc(w, r, user, segments[1])
+	case len(segments) == 1 && segments[0] == "executions" && r.Method == http.MethodGet:
+		ui.render(w, "executions", map[string]interface{}{
+			"User":       user,
+			"Executions": ui.executions.ListFor(User(user), ui.registry.RPC),
+		})
+	case len(segments) == 2 && segments[0] == "executions" && r.Method == http.MethodGet:
+		ui.execution(w, r, user, segments[1])
+	case len(segments) == 3 && segments[0] == "executions" && segments[2] == "cancel" && r.Method == http.MethodPost:
+		if _, ok := ui.get(w, r, user, segments[1]); !ok {
+			return
+		}
+		if _, err := ui.executions.Cancel(segments[1]); err != nil && !errors.Is(err, execution.ErrFinished) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		log.Printf("execution %s cancelled by %s", segments[1], user)
+		http.Redirect(w, r, Prefix+"executions/"+url.PathEscape(segments[1]), http.StatusSeeOther)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// groupRPCs groups the RPCs by category if some RPCs have one, or else by provider
+func groupRPCs(rpcs []arpicee.RemoteCall) []group {
+	sort.Slice(rpcs, func(i, j int) bool {
+		return rpcs[i].Name() < rpcs[j].Name()
+	})
+	res := []group{}
+	categories := arpicee.GroupByCategory(rpcs)
+	if len(categories) > 1 || (len(categories) == 1 && categories[0].Category != arpicee.Uncategorized) {
+		for _, g := range categories {
+			res = append(res, group{Name: g.Category, RPCs: g.RPCs})
+		}
+		return res
+	}
+
+	index := map[string]int{}
+	for _, rpc := range rpcs {
+		p := arpicee.Provider(rpc)
+		i, ok := index[p]
+		if !ok {
+			i = len(res)
+			index[p] = i
+			res = append(res, group{Name: p})
+		}
+		res[i].RPCs = append(res[i].RPCs, rpc)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// index lists the RPCs matching query, see arpicee.Search
+func (ui *UI) index(w http.ResponseWriter, user, query string) {
+	ui.render(w, "index", map[string]interface{}{
+		"User":   user,
+		"Query":  query,
+		"Groups": groupRPCs(arpicee.Search(ui.registry.RPCs(), query)),
+	})
+}
+
+// fields maps the fields of the form running rpc to form inputs, the same way
+// views.RunRPCDialog maps them to Slack blocks: strings and ints are text inputs, bools
+// checkboxes
+func fields(rpc arpicee.RemoteCall, confirmation arpicee.Confirmation) []field {
+	res := []field{}
+	for _, f := range chat.FormFields(rpc, confirmation) {
+		res = append(res, field{Param: f.Parameter, Label: f.Label, InputType: string(f.Kind)})
+	}
+	return res
+}
+
+func argsFromForm(params []arpicee.Parameter, form url.Values) ([]arpicee.Argument, error) {
+	args := []arpicee.Argument{}
+	for _, p := range params {
+		v := strings.TrimSpace(form.Get(p.Name))
+		switch p.Type {
+		case arpicee.TypeBool:
+			// Unchecked checkboxes are not submitted
+			args = append(args, &arpicee.ArgumentBool{Name: p.Name, Val: v != ""})
+		default:
+			if v == "" {
+				continue
+			}
+			arg, err := arpicee.ParseArgument(p, v)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+		}
+	}
+	return args, nil
+}
+
+func (ui *UI) rpc(w http.ResponseWriter, r *http.Request, user, name string) {
+	rpc := ui.registry.RPC(name)
+	if rpc == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	confirmation := ui.confirmation(rpc)
+	data := map[string]interface{}{
+		"User":   user,
+		"RPC":    rpc,
+		"Fields": fields(rpc, confirmation),
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		ui.render(w, "rpc", data)
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		args, err := argsFromForm(rpc.Params(), r.PostForm)
+		if err == nil {
+			err = chat.CheckConfirmation(rpc, confirmation, r.PostForm.Get(chat.ConfirmField))
+		}
+		var e execution.Execution
+		if err == nil {
+			e, err = ui.executions.Start(rpc, args, User(user))
+		}
+		if err != nil {
+			data["Error"] = err.Error()
+			data["Values"] = r.PostForm
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			ui.render(w, "rpc", data)
+			return
+		}
+		log.Printf("RPC %s invoked by %s from the web UI, execution %s", rpc.Name(), user, e.ID)
+		http.Redirect(w, r, Prefix+"executions/"+url.PathEscape(e.ID), http.StatusSeeOther)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// get returns the execution id if user is allowed to see it, or writes an error
+func (ui *UI) get(w http.ResponseWriter, r *http.Request, user, id string) (execution.Execution, bool) {
+	e, ok := ui.executions.Get(id)
+	if !ok {
+		http.NotFound(w, r)
+		return e, false
+	}
+	if err := ui.executions.AuthorizeExecution(User(user), e, ui.registry.RPC(e.RPC)); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return e, false
+	}
+	return e, true
+}
+
+func (ui *UI) execution(w http.ResponseWriter, r *http.Request, user, id string) {
+	e, ok := ui.get(w, r, user, id)
+	if !ok {
+		return
+	}
+
+	// Polled by the execution page to display progress live
+	if r.URL.Query().Get("format") == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"execution": e,
+			"output":    output(e.Result),
+		})
+		return
+	}
+
+	ui.render(w, "execution", map[string]interface{}{
+		"User":      user,
+		"Execution": e,
+	})
+}
+
+// output renders the result using its formatString, or as JSON
+func output(res map[string]interface{}) string {
+	if res == nil {
+		return ""
+	}
+	if o, err := arpicee.Output(res, "text"); err == nil {
+		return o
+	}
+	o, _ := arpicee.Output(res, "json")
+	return o
+}
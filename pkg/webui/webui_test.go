@@ -0,0 +1,163 @@
+package webui
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yannh/arpicee/pkg/arpicee"
+	"github.com/yannh/arpicee/pkg/execution"
+	"github.com/yannh/arpicee/pkg/mock"
+	"github.com/yannh/arpicee/pkg/policy"
+	"github.com/yannh/arpicee/pkg/registry/registrytest"
+)
+
+func TestArgsFromForm(t *testing.T) {
+	params := []arpicee.Parameter{
+		{Name: "name", Type: arpicee.TypeString, Required: true},
+		{Name: "count", Type: arpicee.TypeInt},
+		{Name: "dryrun", Type: arpicee.TypeBool},
+	}
+
+	for i, testCase := range []struct {
+		form      url.Values
+		expect    []arpicee.Argument
+		expectErr bool
+	}{
+		{
+			url.Values{"name": {"foo"}, "count": {"3"}, "dryrun": {"on"}},
+			[]arpicee.Argument{
+				&arpicee.ArgumentString{Name: "name", Val: "foo"},
+				&arpicee.ArgumentInt{Name: "count", Val: 3},
+				&arpicee.ArgumentBool{Name: "dryrun", Val: true},
+			},
+			false,
+		},
+		{
+			url.Values{"name": {"foo"}, "count": {""}},
+			[]arpicee.Argument{
+				&arpicee.ArgumentString{Name: "name", Val: "foo"},
+				&arpicee.ArgumentBool{Name: "dryrun", Val: false},
+			},
+			false,
+		},
+		{
+			url.Values{"name": {"foo"}, "count": {"three"}},
+			nil,
+			true,
+		},
+	} {
+		got, err := argsFromForm(params, testCase.form)
+		if (err != nil) != testCase.expectErr {
+			t.Errorf("test %d - expected error: %t, got %s", i, testCase.expectErr, err)
+		}
+		if !reflect.DeepEqual(got, testCase.expect) {
+			t.Errorf("test %d - expected %+v, got %+v", i, testCase.expect, got)
+		}
+	}
+}
+
+func TestUI(t *testing.T) {
+	reg := registrytest.New(t,
+		mock.New("deploy", []arpicee.Parameter{
+			{Name: "env", Type: arpicee.TypeString, Required: true, AllowedValues: []string{"staging", "production"}},
+			{Name: "dryrun", Type: arpicee.TypeBool, Description: "do not deploy"},
+		}, nil),
+		mock.New("rollback", nil, nil).WithMetadata(arpicee.Metadata{Category: "Releases", Risk: arpicee.RiskHigh}),
+	)
+	mgr := execution.NewManager(10)
+
+	if _, err := New(reg, mgr, Auth{}); err == nil {
+		t.Errorf("expected an error when no authentication is configured")
+	}
+	ui, err := New(reg, mgr, Auth{UserHeader: "X-Forwarded-User"})
+	if err != nil {
+		t.Fatalf("failed creating web UI: %s", err)
+	}
+	mux := http.NewServeMux()
+	ui.Register(mux)
+
+	do := func(method, path, user string, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if user != "" {
+			req.Header.Set("X-Forwarded-User", user)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := do("GET", "/ui/", "", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected unauthenticated request to be refused, got %d", w.Code)
+	}
+	if w := do("GET", "/ui/", "alice", nil); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "/ui/rpcs/deploy") {
+		t.Errorf("expected index to list RPC deploy, got %d %s", w.Code, w.Body.String())
+	}
+	if w := do("GET", "/ui/?q=releases", "alice", nil); w.Code != http.StatusOK || strings.Contains(w.Body.String(), "/ui/rpcs/deploy") ||
+		!strings.Contains(w.Body.String(), "<h2>Releases</h2>") || !strings.Contains(w.Body.String(), "Risk: high") {
+		t.Errorf("expected the search to only list RPC rollback, in its category, got %d %s", w.Code, w.Body.String())
+	}
+	if w := do("GET", "/ui/rpcs/deploy", "alice", nil); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `<option >production</option>`) {
+		t.Errorf("expected form with a select for env, got %d %s", w.Code, w.Body.String())
+	}
+	if w := do("POST", "/ui/rpcs/deploy", "alice", url.Values{"env": {"dev"}}); w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "invalid value for parameter env") {
+		t.Errorf("expected validation error, got %d %s", w.Code, w.Body.String())
+	}
+	// High risk RPCs require confirmation
+	if w := do("GET", "/ui/rpcs/rollback", "alice", nil); !strings.Contains(w.Body.String(), `name="arpicee_confirm"`) {
+		t.Errorf("expected a confirmation checkbox, got %s", w.Body.String())
+	}
+	if w := do("POST", "/ui/rpcs/rollback", "alice", url.Values{}); w.Code != http.StatusUnprocessableEntity || !strings.Contains(w.Body.String(), "check the box to confirm running rollback") {
+		t.Errorf("expected confirmation error, got %d %s", w.Code, w.Body.String())
+	}
+	if w := do("POST", "/ui/rpcs/rollback", "alice", url.Values{"arpicee_confirm": {"on"}}); w.Code != http.StatusSeeOther {
+		t.Errorf("expected confirmed submission to succeed, got %d %s", w.Code, w.Body.String())
+	}
+
+	w := do("POST", "/ui/rpcs/deploy", "alice", url.Values{"env": {"staging"}})
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("expected redirect to the execution, got %d %s", w.Code, w.Body.String())
+	}
+	loc := w.Header().Get("Location")
+	id := strings.TrimPrefix(loc, "/ui/executions/")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if e, err := mgr.Wait(ctx, id); err != nil || e.User != "web:alice" {
+		t.Fatalf("expected an execution by web:alice, got %+v, %s", e, err)
+	}
+	if w := do("GET", loc, "alice", nil); !strings.Contains(w.Body.String(), "Running deploy --env staging --dryrun=false") {
+		t.Errorf("expected execution page to contain the result, got %s", w.Body.String())
+	}
+	if w := do("GET", "/ui/executions", "alice", nil); !strings.Contains(w.Body.String(), loc) {
+		t.Errorf("expected history to link to the execution, got %s", w.Body.String())
+	}
+	pol, err := policy.New([]policy.Rule{{RPC: "deploy", Users: []string{"web:alice"}}})
+	if err != nil {
+		t.Fatalf("failed creating policy: %s", err)
+	}
+	mgr.SetAuthorizer(pol)
+	if w := do("GET", "/ui/executions", "bob", nil); strings.Contains(w.Body.String(), loc) {
+		t.Errorf("expected history of another user not to link to the execution, got %s", w.Body.String())
+	}
+	if w := do("GET", loc, "bob", nil); w.Code != http.StatusForbidden {
+		t.Errorf("expected execution page to be refused to another user, got %d", w.Code)
+	}
+	if w := do("POST", loc+"/cancel", "bob", nil); w.Code != http.StatusForbidden {
+		t.Errorf("expected cancellation to be refused to another user, got %d", w.Code)
+	}
+
+	req := httptest.NewRequest("POST", "/ui/rpcs/deploy", strings.NewReader("env=staging"))
+	req.Header.Set("X-Forwarded-User", "alice")
+	req.Header.Set("Origin", "https://evil.example.com")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("expected cross-origin submission to be refused, got %d", rec.Code)
+	}
+}